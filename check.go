@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/algoboyz/garm/pkg/mapper"
+)
+
+// checkReport is the JSON output of garm check
+type checkReport struct {
+	Total     int              `json:"total"`
+	Supported int              `json:"supported"`
+	Functions []mapper.Support `json:"functions"`
+}
+
+// check runs garm check, the packages matching the pattern are dry run
+// through the mapper and what it supports of each function is printed
+func check(cmd command, args []string) error {
+	o := newOptions(cmd)
+	asJSON := o.fs.Bool("json", false, "print the report as JSON")
+	target, err := o.parse(args)
+	if err != nil {
+		return err
+	}
+	functions, err := o.compiler().Check(target)
+	if err != nil {
+		return err
+	}
+	report := checkReport{Total: len(functions), Functions: functions}
+	for _, fn := range functions {
+		if fn.Supported {
+			report.Supported++
+		}
+	}
+	if o.output == "" {
+		o.output = "-"
+	}
+	out, err := o.create(target, "")
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = printCheck(out, report)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// printCheck writes a row per function with the instructions that compiled
+// out of all and the instructions and types that did not
+func printCheck(w io.Writer, report checkReport) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNCTION\tSTATUS\tINSTRUCTIONS\tUNSUPPORTED")
+	for _, fn := range report.Functions {
+		status, supported, total := "ok", 0, 0
+		if !fn.Supported {
+			status = "unsupported"
+		}
+		var missing []string
+		for _, kind := range sortedNames(fn.Instructions) {
+			count := fn.Instructions[kind]
+			supported += count.Supported
+			total += count.Supported + count.Unsupported
+			if count.Unsupported > 0 {
+				missing = append(missing, fmt.Sprintf("%s(%d)", kind, count.Unsupported))
+			}
+		}
+		for _, typ := range sortedNames(fn.Types) {
+			if !fn.Types[typ] {
+				missing = append(missing, "type "+typ)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%s\n", fn.Function, status, supported, total, strings.Join(missing, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d of %d functions supported\n", report.Supported, report.Total)
+	return err
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,32 +1,381 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/algoboyz/garm/pkg/compile"
 	"github.com/algoboyz/garm/pkg/dbg"
+	"github.com/algoboyz/garm/pkg/mapper"
 )
 
-var (
-	debug  bool
-	target string
+// command is a garm subcommand, run gets the arguments following its name
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(cmd command, args []string) error
+}
+
+var commands = []command{
+	{"build", "[flags] [package]", "compile a program to an executable, or to an object file with -o file.o", build},
+	{"asm", "[flags] [package]", "compile a program to ARM64 assembly", assemble},
+	{"run", "[flags] [package] [arguments]", "compile and run a program, under qemu on hosts other than arm64 linux", run},
+	{"check", "[flags] [packages]", "report which functions of packages garm supports", check},
+	{"ssa", "[flags] [packages]", "print the SSA form garm compiles", printSSA},
+}
+
+// Exit statuses, a program run by garm run exits garm with its own status
+const (
+	exitError = 1
+	exitUsage = 2
 )
 
-func init() {
-	flag.BoolVar(&debug, "v", false, "debug mode")
-	flag.StringVar(&target, "in", "test/add_simple.go", "src file for compilation")
+// usageError is a mistake on the command line
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// exitStatus ends garm with the status of a program it ran
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
 }
 
 func main() {
-	flag.Parse()
-	compiler := compile.New(dbg.NewDebugger(debug))
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			exit(cmd.run(cmd, args))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "garm: unknown command %s\n\n", name)
+	usage(os.Stderr)
+	os.Exit(exitUsage)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: garm <command> [flags] [package]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-6s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "\nRun garm <command> -h for the flags of a command. The package defaults to the")
+	fmt.Fprintln(w, "current directory and may be a Go file or a package path.")
+}
+
+// exit ends garm with the status err calls for
+func exit(err error) {
+	var status exitStatus
+	var usage usageError
+	switch {
+	case err == nil:
+		os.Exit(0)
+	case errors.As(err, &status):
+		os.Exit(int(status))
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.As(err, &usage):
+		fmt.Fprintln(os.Stderr, "garm:", err)
+		os.Exit(exitUsage)
+	default:
+		fmt.Fprintln(os.Stderr, "garm:", err)
+		os.Exit(exitError)
+	}
+}
+
+// options are the flags shared by the commands
+type options struct {
+	fs      *flag.FlagSet
+	goos    string
+	output  string
+	level   int
+	gc      string
+	debug   bool
+	threads int
+	lse     bool
+	deps    bool
+	report  bool
+	allow   bool
+}
+
+// newOptions registers the flags every command takes
+func newOptions(cmd command) *options {
+	o := &options{fs: flag.NewFlagSet("garm "+cmd.name, flag.ContinueOnError)}
+	o.fs.StringVar(&o.goos, "os", "linux", "target operating system")
+	o.fs.StringVar(&o.output, "o", "", "output file, - for standard output")
+	o.fs.IntVar(&o.level, "O", 0, "optimization level, only 0 for code as mapped")
+	o.fs.StringVar(&o.gc, "gc", "marksweep", "garbage collector: marksweep, none or generational, which never collects the tenured generation and rejects channels and maps")
+	o.fs.BoolVar(&o.debug, "v", false, "debug mode, comments the assembly and renders it to the terminal")
+	o.fs.Usage = func() {
+		fmt.Fprintf(o.fs.Output(), "usage: garm %s %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		o.fs.PrintDefaults()
+	}
+	return o
+}
+
+// compileFlags registers the flags of the commands compiling a program
+func (o *options) compileFlags() {
+	o.fs.IntVar(&o.threads, "threads", 1, "OS threads running goroutines")
+	o.fs.BoolVar(&o.lse, "lse", true, "use ARMv8.1 atomic instructions, false targets baseline ARMv8.0")
+	o.fs.BoolVar(&o.deps, "deps", false, "compile the imported packages of the module and the supported standard library")
+	o.fs.BoolVar(&o.report, "report", false, "list the functions compiled, substituted and unsupported")
+	o.fs.BoolVar(&o.allow, "allow-unsupported", false, "build functions using unsupported constructs as stubs that panic")
+}
+
+// parse parses the arguments and validates the shared flags, the package
+// defaults to the current directory
+func (o *options) parse(args []string) (target string, err error) {
+	if err = o.fs.Parse(o.levelFlag(args)); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return "", err
+		}
+		return "", usageError(err.Error())
+	}
+	if err = compile.CheckOS(o.goos); err != nil {
+		return "", usageError(err.Error())
+	}
+	if o.level != 0 {
+		return "", usageError(fmt.Sprintf("unknown optimization level %d, only -O0 is supported", o.level))
+	}
+	collector, err := mapper.ParseGC(o.gc)
+	if err != nil {
+		return "", usageError(err.Error())
+	}
+	// The collectors do not stop the other threads yet
+	if o.threads > 1 && collector != mapper.GCNone {
+		return "", usageError(fmt.Sprintf("-threads=%d needs -gc=none, the %s collector only runs on a single thread", o.threads, o.gc))
+	}
+	if o.fs.NArg() == 0 {
+		return ".", nil
+	}
+	return o.fs.Arg(0), nil
+}
+
+// levelFlag rewrites -O0 like C compilers take it to -O=0, the flag package
+// does not split a value off a flag name. Flags end at the package, the
+// arguments of the program are left alone
+func (o *options) levelFlag(args []string) []string {
+	args = slices.Clone(args)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-" || arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		if level, ok := strings.CutPrefix(arg, "-O"); ok {
+			if _, err := strconv.Atoi(level); err == nil {
+				args[i] = "-O=" + level
+			}
+			continue
+		}
+		name, _, value := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if f := o.fs.Lookup(name); f != nil && !value {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++ // The value is the next argument
+			}
+		}
+	}
+	return args
+}
+
+// compiler returns a compiler configured by the flags
+func (o *options) compiler() *compile.Compiler {
+	collector, _ := mapper.ParseGC(o.gc)
+	compiler := compile.New(dbg.NewDebugger(o.debug))
+	compiler.SetGC(collector)
+	compiler.SetThreads(o.threads)
+	compiler.SetLSE(o.lse)
+	compiler.SetDependencies(o.deps)
+	compiler.SetAllowUnsupported(o.allow)
+	return compiler
+}
+
+// compile compiles the program of target to assembly, diagnostics are
+// written to stderr
+func (o *options) compile(target string) (string, error) {
+	if strings.Contains(target, "...") {
+		return "", usageError("a program is a single package, " + target + " matches several")
+	}
+	compiler := o.compiler()
+	_, err := compiler.Parse(target, o.debug)
+	compiler.Diagnostics().Print(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if o.report {
+		printReport(compiler.Report())
+	}
+	return compiler.Generate()
+}
+
+// create opens the output file, the default name is that of the program
+// with ext
+func (o *options) create(target, ext string) (io.WriteCloser, error) {
+	path := o.output
+	if path == "" {
+		path = programName(target) + ext
+	}
+	if path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// programName names the outputs of target after its file or directory
+func programName(target string) string {
+	if strings.HasSuffix(target, ".go") {
+		return strings.TrimSuffix(filepath.Base(target), ".go")
+	}
+	if abs, err := filepath.Abs(target); err == nil {
+		target = abs
+	}
+	return filepath.Base(target)
+}
+
+func assemble(cmd command, args []string) error {
+	o := newOptions(cmd)
+	o.compileFlags()
+	target, err := o.parse(args)
+	if err != nil {
+		return err
+	}
+	code, err := o.compile(target)
+	if err != nil {
+		return err
+	}
+	out, err := o.create(target, ".s")
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(out, code); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func build(cmd command, args []string) error {
+	o := newOptions(cmd)
+	o.compileFlags()
+	target, err := o.parse(args)
+	if err != nil {
+		return err
+	}
+	output := o.output
+	if output == "" {
+		output = programName(target)
+	}
+	return o.build(target, output)
+}
 
-	_, err := compiler.Parse(target, debug)
+// build compiles target into an executable or an object file at output
+func (o *options) build(target, output string) error {
+	toolchain, err := compile.FindToolchain(o.goos)
+	if err != nil {
+		return err
+	}
+	code, err := o.compile(target)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	_, err = compiler.Generate()
+	dir, err := os.MkdirTemp("", "garm")
 	if err != nil {
-		panic(err)
+		return err
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, programName(target)+".s")
+	if err = os.WriteFile(src, []byte(code), 0o644); err != nil {
+		return err
+	}
+	return toolchain.Build(src, output, strings.HasSuffix(output, ".o"))
+}
+
+func run(cmd command, args []string) error {
+	o := newOptions(cmd)
+	o.compileFlags()
+	target, err := o.parse(args)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "garm")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	binary := filepath.Join(dir, programName(target))
+	if err = o.build(target, binary); err != nil {
+		return err
+	}
+	var programArgs []string
+	if o.fs.NArg() > 1 {
+		programArgs = o.fs.Args()[1:]
+	}
+	program, err := compile.Command(binary, programArgs...)
+	if err != nil {
+		return err
+	}
+	program.Stdin, program.Stdout, program.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = program.Run()
+	var exited *exec.ExitError
+	if errors.As(err, &exited) && exited.ExitCode() > 0 {
+		return exitStatus(exited.ExitCode())
+	}
+	return err
+}
+
+func printSSA(cmd command, args []string) error {
+	o := newOptions(cmd)
+	fn := o.fs.String("func", "", "print only the function of this name eg main or main.(*T).String")
+	target, err := o.parse(args)
+	if err != nil {
+		return err
+	}
+	if o.output == "" {
+		o.output = "-"
+	}
+	out, err := o.create(target, "")
+	if err != nil {
+		return err
+	}
+	if err = o.compiler().WriteSSA(out, target, *fn); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// printReport writes a line per function to stderr prefixed by what became of it
+func printReport(r mapper.Report) {
+	for _, section := range []struct {
+		name  string
+		names []string
+	}{{"compiled", r.Compiled}, {"substituted", r.Substituted}, {"unsupported", r.Unsupported}} {
+		for _, name := range section.names {
+			fmt.Fprintf(os.Stderr, "%-12s %s\n", section.name, name)
+		}
 	}
 }
@@ -2,6 +2,7 @@ package alloc
 
 import (
 	"errors"
+	"slices"
 	"sync"
 
 	"github.com/algoboyz/garm/pkg/reg"
@@ -14,6 +15,7 @@ type ARM64Type interface {
 	Size() int                   // size in bytes
 	Alignment() int              // required alignment
 	Register() reg.RegisterClass // register class
+	Primitive() Primitive        // underlying machine primitive
 }
 
 // MemoryLayout handles memory allocation concerns
@@ -37,6 +39,8 @@ type Allocator interface {
 	AllocateRegister(ARM64Type) (Location, error)
 	AllocateStack(MemoryLocation) (Location, error)
 	Free(Location)
+	Allocated() []reg.Register
+	CurrentStackOffset() int
 }
 
 // SimpleAllocator provides a basic implementation of the Allocator interface
@@ -61,8 +65,9 @@ func NewAllocator() *SimpleAllocator {
 	}
 
 	// Initialize register pools
-	// GPRs: x0-x18 (x19-x28 are callee-saved, x29-x31 special purpose)
-	for i := uint8(0); i < 19; i++ {
+	// GPRs: x0-x15 (x16-x17 are scratch for calls, x18 is the platform register,
+	// x19-x28 are callee-saved, x29-x31 special purpose)
+	for i := uint8(0); i < 16; i++ {
 		a.gprPool = append(a.gprPool, reg.Register{ID: i, Class: reg.RegisterClassGPR})
 	}
 
@@ -131,6 +136,7 @@ func (a *SimpleAllocator) AllocateStack(t MemoryLocation) (Location, error) {
 	}
 
 	mem := &MemoryLocation{
+		Name:      t.Name,
 		Offset:    a.stackOffset,
 		Size:      size,
 		Alignment: alignment,
@@ -160,6 +166,26 @@ func (a *SimpleAllocator) Free(loc Location) {
 	// They'll be cleaned up when the stack frame is destroyed
 }
 
+// Allocated returns the registers currently handed out, these are the caller
+// saved registers that have to survive a call
+func (a *SimpleAllocator) Allocated() (regs []reg.Register) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fresh := NewAllocator()
+	for _, pool := range []struct{ all, free []reg.Register }{
+		{fresh.gprPool, a.gprPool},
+		{fresh.fprPool, a.fprPool},
+		{fresh.vecPool, a.vecPool},
+	} {
+		for _, r := range pool.all {
+			if !slices.ContainsFunc(pool.free, func(f reg.Register) bool { return f.ID == r.ID }) {
+				regs = append(regs, r)
+			}
+		}
+	}
+	return regs
+}
+
 // Helper function to get the current stack offset
 func (a *SimpleAllocator) CurrentStackOffset() int {
 	a.mu.Lock()
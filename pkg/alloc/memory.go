@@ -20,6 +20,16 @@ type locationImpl struct {
 	memory   *MemoryLocation
 }
 
+// NewRegisterLocation wraps a register so it can be handed back to an Allocator
+func NewRegisterLocation(r *reg.Register) Location {
+	return &locationImpl{register: r}
+}
+
+// NewMemoryLocation wraps a memory location eg a stack slot
+func NewMemoryLocation(mem *MemoryLocation) Location {
+	return &locationImpl{memory: mem}
+}
+
 func (l *locationImpl) GetRegister() *reg.Register {
 	return l.register
 }
@@ -46,6 +46,7 @@ const (
 	String
 	Bool
 	Pointer
+	Aggregate // multi word values addressed through memory
 )
 
 func NewPrimitive(v string) Primitive {
@@ -95,6 +96,10 @@ func (t *baseARM64Type) Register() reg.RegisterClass {
 	return t.reg
 }
 
+func (t *baseARM64Type) Primitive() Primitive {
+	return t.primitive
+}
+
 func (t *baseARM64Type) String() string {
 	return fmt.Sprintf("%s(%s)", t.name, t.goType)
 }
@@ -116,7 +121,7 @@ func NewType(name, goType string, prim Primitive, size int) ARM64Type {
 	case Float32, Float64:
 		t.reg = reg.RegisterClassFPR
 		t.align = (size + 7) / 8
-	case String:
+	case String, Aggregate:
 		t.reg = reg.RegisterClassGPR
 		t.align = 8 // Strings are 8-byte aligned in Go
 	default:
@@ -182,6 +187,11 @@ var TypeSet = struct {
 	Pointer: NewType("ptr", "unsafe.Pointer", Pointer, PtrSize),
 }
 
+// IsAggregate reports whether values of t live in memory rather than a register
+func IsAggregate(t ARM64Type) bool {
+	return t.Primitive() == Aggregate || t.Size() > WordSize
+}
+
 // AlignSize aligns the given size to the specified alignment
 func AlignSize(size, align int) int {
 	return (size + align - 1) & ^(align - 1)
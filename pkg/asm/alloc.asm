@@ -0,0 +1,98 @@
+// Heap allocation entry point called by compiled code. Objects are bump
+// allocated from arenas mapped on demand and are never freed
+
+// Object header, allocations return the address following it. Kept in sync
+// with the obj_ fields of runtime.asm
+.equ obj_header,       16
+.equ hdr_type,         0    // Type descriptor
+.equ hdr_size,         8    // Allocation size including the header, 32 bits
+
+.equ ARENA_SIZE,       64 * 1024 * 1024
+.equ SYS_mmap,         222
+.equ PROT_RW,          3    // PROT_READ | PROT_WRITE
+.equ MAP_PRIVATE_ANON, 0x22 // MAP_PRIVATE | MAP_ANONYMOUS
+
+.data
+    .balign 8
+runtime.arena:
+    .quad   0                       // Next free byte
+    .quad   0                       // End of the arena
+
+.text
+
+// Allocate zeroed memory for an object, fresh arenas come zeroed from mmap.
+// Threads bump the arena with an exclusive pair so they never get the same
+// memory, a thread finding it full maps an arena and installs it
+// Input:
+//   X0 = size in bytes
+//   X1 = type descriptor
+// Output:
+//   X0 = object
+// Clobbers X1-X9
+runtime.alloc:
+runtime.rawalloc:                   // Allocation of the runtime, nothing collects
+    ADD     X2, X0, #obj_header + 7
+    AND     X2, X2, #~7             // Header and object rounded to words
+    ADRP    X3, runtime.arena
+    ADD     X3, X3, :lo12:runtime.arena
+.Lalloc_retry:
+    LDXP    X4, X5, [X3]
+    ADD     X6, X4, X2
+    CMP     X6, X5
+    B.HI    .Lalloc_grow
+    STXP    W7, X6, X5, [X3]
+    CBNZ    W7, .Lalloc_retry
+.Lalloc_header:
+    STR     X1, [X4, #hdr_type]
+    STR     W2, [X4, #hdr_size]
+    ADD     X0, X4, #obj_header
+    RET
+
+.Lalloc_grow:
+    CLREX
+    MOV     X6, X2                  // Allocation size
+    MOV     X7, X1                  // Type descriptor
+    MOV     X1, #ARENA_SIZE
+    CMP     X6, X1
+    CSEL    X1, X6, X1, HI          // Large objects get an arena of their own
+    MOV     X9, X1
+    MOV     X0, #0
+    MOV     X2, #PROT_RW
+    MOV     X3, #MAP_PRIVATE_ANON
+    MOV     X4, #-1
+    MOV     X5, #0
+    MOV     X8, #SYS_mmap
+    SVC     #0
+    CMN     X0, #4095               // -4095..-1 are errors
+    B.HS    .Lalloc_oom
+    ADRP    X3, runtime.arena
+    ADD     X3, X3, :lo12:runtime.arena
+    MOV     X4, X0
+    MOV     X1, X7
+    MOV     X2, X6
+    ADD     X6, X4, X2
+    ADD     X5, X4, X9
+    // Replaces an arena another thread installed meanwhile, the rest of
+    // it is left unused
+1:  LDXP    X7, X8, [X3]
+    STXP    W7, X6, X5, [X3]
+    CBNZ    W7, 1b
+    B       .Lalloc_header
+
+.Lalloc_oom:
+    ADR     X0, .Lalloc_oom_msg
+    MOV     X1, #(.Lalloc_oom_end - .Lalloc_oom_msg)
+    BL      runtime.printstderr
+    MOV     X0, #2
+    MOV     X8, #94                 // exit_group
+    SVC     #0
+
+.Lalloc_oom_msg:
+    .ascii  "fatal error: runtime: out of memory\n"
+.Lalloc_oom_end:
+    .balign 4
+
+// Write barrier of values the runtime copies into the heap, nothing to
+// record without a collector
+runtime.typedbarrier:
+    RET
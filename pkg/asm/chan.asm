@@ -0,0 +1,696 @@
+// Channel layout, kept in sync with pkg/mapper/chan.go. Every channel
+// operation runs under runtime.chanlock, a single lock lets a select wait on
+// several channels at once without ordering their locks
+.equ c_buf,        0    // Ring buffer of c_cap elements, 0 when unbuffered
+.equ c_cap,        8
+.equ c_count,      16   // Buffered elements
+.equ c_head,       24   // Index of the oldest buffered element
+.equ c_closed,     32
+.equ c_elemtype,   40   // Element type descriptor
+.equ c_recvq,      48   // Blocked receivers, head and tail
+.equ c_sendq,      64   // Blocked senders, head and tail
+.equ hchan_size,   80
+
+// Goroutine blocked on a channel, lives on its stack while it is parked
+.equ sg_g,         0
+.equ sg_next,      8
+.equ sg_elem,      16   // Value sent or destination of a receive, 0 to drop it
+.equ sg_ok,        24   // 1 once a value was passed, 0 when woken by close
+.equ sg_sel,       32   // Selection word of a blocked select, 0 otherwise
+.equ sg_case,      40   // Case of the select
+.equ sg_queue,     48   // Queue holding the waiter of a select case
+.equ sg_size,      64
+
+// Select case, kept in sync with pkg/mapper/chan.go
+.equ sc_chan,      0
+.equ sc_dir,       8
+.equ sc_elem,      16   // Value sent or destination of the receive
+.equ sc_size,      24
+.equ CASE_SEND,    1
+.equ CASE_RECV,    2
+
+// Call runtime.lock, runtime.unlock or runtime.gopark with runtime.chanlock
+.macro CHANLOCK routine
+    adrp    x0, runtime.chanlock
+    add     x0, x0, :lo12:runtime.chanlock
+    bl      \routine
+.endm
+
+// Address of buffer element idx of channel c
+.macro CHANSLOT dst, c, idx, tmp
+    ldr     \tmp, [\c, #c_elemtype]
+    ldr     \tmp, [\tmp, #type_size]
+    ldr     \dst, [\c, #c_buf]
+    madd    \dst, \idx, \tmp, \dst
+.endm
+
+// Advance the head of the ring buffer of c
+.macro CHANNEXT c, tmp, cap
+    ldr     \tmp, [\c, #c_head]
+    add     \tmp, \tmp, #1
+    ldr     \cap, [\c, #c_cap]
+    cmp     \tmp, \cap
+    csel    \tmp, xzr, \tmp, hs
+    str     \tmp, [\c, #c_head]
+.endm
+
+.data
+    .balign 8
+runtime.chanlock:
+    .quad   0
+
+    .balign 8
+runtime.selectseed:
+    .quad   0                       // Rotates the first case a select polls
+
+.section .rodata
+    .balign 8
+runtime.hchantype:
+    .quad   hchan_size
+    .word   0
+    .byte   25, 8                   // reflect.Struct, alignment
+    .balign 8
+    .quad   .Lhchan_name, 13
+    .quad   8                       // Pointer data, the buffer
+    .quad   .Lhchan_gcbits
+    .quad   0                       // Not comparable
+.Lhchan_gcbits:
+    .byte   1
+.Lhchan_name:
+    .ascii  "runtime.hchan"
+
+.text
+
+// Create a channel, its buffer is an array of the element type
+//   panic: makechan: size out of range
+// Input:
+//   X0 = element type descriptor
+//   X1 = buffer size
+// Output:
+//   X0 = channel
+// Clobbers caller saved registers
+runtime.makechan:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X20, X1
+    CMP     X20, #0
+    B.LT    .Lmakechan_size
+    MOV     X0, #hchan_size
+    ADRP    X1, runtime.hchantype
+    ADD     X1, X1, :lo12:runtime.hchantype
+    BL      runtime.alloc
+    MOV     X21, X0
+    STR     X19, [X21, #c_elemtype]
+    STR     X20, [X21, #c_cap]
+    LDR     X0, [X19, #type_size]
+    MUL     X0, X0, X20
+    CBZ     X0, 1f                  // Unbuffered or zero sized elements
+    MOV     X1, X19
+    BL      runtime.rawalloc        // The channel is only held in X21
+    STR     X0, [X21, #c_buf]
+1:  MOV     X0, X21
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+.Lmakechan_size:
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    ADR     X1, .Lmakechan_msg
+    B       runtime.panicstring
+
+// Send a value, blocks until a receiver takes it or the buffer has room.
+// Sending on a nil channel blocks forever
+//   panic: send on closed channel
+// Input:
+//   X0 = channel
+//   X1 = value
+// Clobbers caller saved registers
+runtime.chansend:
+    CBZ     X0, runtime.block
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    SUB     sp, sp, #sg_size
+    MOV     X19, X0
+    MOV     X20, X1
+    CHANLOCK runtime.lock
+    MOV     X0, X19
+    MOV     X1, X20
+    BL      runtime.chantrysend
+    CBNZ    X0, .Lchansend_done
+    // Park on the send queue until a receiver or close wakes us
+    MRS     X0, TPIDR_EL0
+    LDR     X0, [X0, #m_curg]
+    STR     X0, [sp, #sg_g]
+    STR     X20, [sp, #sg_elem]
+    STR     XZR, [sp, #sg_ok]
+    STR     XZR, [sp, #sg_sel]
+    ADD     X0, X19, #c_sendq
+    MOV     X1, sp
+    BL      runtime.enqueuesg
+    CHANLOCK runtime.gopark
+    LDR     X0, [sp, #sg_ok]
+    CBZ     X0, .Lchansend_closed
+    B       .Lchansend_return
+.Lchansend_done:
+    MOV     X19, X0
+    CHANLOCK runtime.unlock
+    CMP     X19, #1
+    B.NE    .Lchansend_closed
+.Lchansend_return:
+    ADD     sp, sp, #sg_size
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+.Lchansend_closed:
+    ADD     sp, sp, #sg_size
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    ADR     X1, .Lsend_closed_msg
+    B       runtime.panicstring
+
+// Receive a value, blocks until a sender provides one or the channel is
+// closed. Receiving from a nil channel blocks forever
+// Input:
+//   X0 = channel
+//   X1 = destination, 0 to drop the value
+// Output:
+//   X0 = 1 for a value sent, 0 for the zero value of a closed channel
+// Clobbers caller saved registers
+runtime.chanrecv:
+    CBZ     X0, runtime.block
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    SUB     sp, sp, #sg_size
+    MOV     X19, X0
+    MOV     X20, X1
+    CHANLOCK runtime.lock
+    MOV     X0, X19
+    MOV     X1, X20
+    BL      runtime.chantryrecv
+    CBNZ    X0, .Lchanrecv_done
+    // Park on the receive queue until a sender or close wakes us
+    MRS     X0, TPIDR_EL0
+    LDR     X0, [X0, #m_curg]
+    STR     X0, [sp, #sg_g]
+    STR     X20, [sp, #sg_elem]
+    STR     XZR, [sp, #sg_ok]
+    STR     XZR, [sp, #sg_sel]
+    ADD     X0, X19, #c_recvq
+    MOV     X1, sp
+    BL      runtime.enqueuesg
+    CHANLOCK runtime.gopark
+    LDR     X0, [sp, #sg_ok]
+    B       .Lchanrecv_return
+.Lchanrecv_done:
+    MOV     X19, X0
+    CHANLOCK runtime.unlock
+    CMP     X19, #1
+    CSET    X0, EQ
+.Lchanrecv_return:
+    ADD     sp, sp, #sg_size
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Close a channel, blocked receivers get the zero value and blocked senders
+// panic
+//   panic: close of nil channel
+//   panic: close of closed channel
+// Input:
+//   X0 = channel
+// Clobbers caller saved registers
+runtime.closechan:
+    CBZ     X0, .Lclosechan_nil
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    MOV     X19, X0
+    CHANLOCK runtime.lock
+    LDR     X0, [X19, #c_closed]
+    CBNZ    X0, .Lclosechan_closed
+    MOV     X0, #1
+    STR     X0, [X19, #c_closed]
+1:  ADD     X0, X19, #c_recvq
+    BL      runtime.dequeuesg
+    CBZ     X0, 2f
+    MOV     X20, X0
+    LDR     X0, [X20, #sg_elem]
+    CBZ     X0, 3f
+    LDR     X1, [X19, #c_elemtype]
+    LDR     X1, [X1, #type_size]
+    BL      runtime.memclr
+3:  STR     XZR, [X20, #sg_ok]
+    LDR     X0, [X20, #sg_g]
+    BL      runtime.goready
+    B       1b
+2:  ADD     X0, X19, #c_sendq
+    BL      runtime.dequeuesg
+    CBZ     X0, 4f
+    STR     XZR, [X0, #sg_ok]
+    LDR     X0, [X0, #sg_g]
+    BL      runtime.goready
+    B       2b
+4:  CHANLOCK runtime.unlock
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+.Lclosechan_closed:
+    CHANLOCK runtime.unlock
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    ADR     X1, .Lclose_closed_msg
+    B       runtime.panicstring
+.Lclosechan_nil:
+    ADR     X1, .Lclose_nil_msg
+    B       runtime.panicstring
+
+// Run one of the cases of a select. Ready cases are polled starting at a
+// rotating case so none of them starves, when none is ready the goroutine
+// waits on every channel at once unless the select has a default case
+// Input:
+//   X0 = cases, sc_size bytes each
+//   X1 = number of cases
+//   X2 = 1 when the select blocks, 0 when it has a default case
+// Output:
+//   X0 = index of the case run, -1 for the default case
+//   X1 = 1 when a receive got a value, 0 for a closed channel
+// Clobbers caller saved registers
+runtime.selectgo:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    STP     X23, X24, [sp, #-16]!
+    STP     X25, X26, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X20, X1
+    MOV     X21, X2
+    CHANLOCK runtime.lock
+    ADRP    X0, runtime.selectseed
+    ADD     X0, X0, :lo12:runtime.selectseed
+    LDR     X22, [X0]
+    ADD     X1, X22, #1
+    STR     X1, [X0]
+    CBZ     X20, .Lselectgo_wait
+    UDIV    X1, X22, X20
+    MSUB    X22, X1, X20, X22       // First case polled
+    MOV     X23, #0                 // Cases polled
+.Lselectgo_poll:
+    CMP     X23, X20
+    B.HS    .Lselectgo_wait
+    ADD     X24, X22, X23
+    CMP     X24, X20
+    B.LO    1f
+    SUB     X24, X24, X20
+1:  MOV     X0, #sc_size
+    MADD    X25, X24, X0, X19
+    ADD     X23, X23, #1
+    LDR     X0, [X25, #sc_chan]
+    CBZ     X0, .Lselectgo_poll     // Nil channels are never ready
+    LDR     X1, [X25, #sc_elem]
+    LDR     X2, [X25, #sc_dir]
+    CMP     X2, #CASE_SEND
+    B.NE    2f
+    BL      runtime.chantrysend
+    CBZ     X0, .Lselectgo_poll
+    CMP     X0, #1
+    B.NE    .Lselectgo_sendclosed
+    MOV     X25, #0
+    B       .Lselectgo_chosen
+2:  BL      runtime.chantryrecv
+    CBZ     X0, .Lselectgo_poll
+    CMP     X0, #1
+    CSET    X25, EQ
+.Lselectgo_chosen:
+    CHANLOCK runtime.unlock
+    MOV     X0, X24
+    MOV     X1, X25
+    B       .Lselectgo_return
+.Lselectgo_wait:
+    CBNZ    X21, .Lselectgo_park
+    CHANLOCK runtime.unlock
+    MOV     X0, #-1
+    MOV     X1, #0
+    B       .Lselectgo_return
+.Lselectgo_park:
+    // A waiter per case sharing the selection word, which the first case
+    // to proceed sets to its waiter
+    MOV     X0, #sg_size
+    MUL     X0, X0, X20
+    ADD     X0, X0, #16
+    SUB     sp, sp, X0
+    STR     XZR, [sp]
+    MRS     X26, TPIDR_EL0
+    LDR     X26, [X26, #m_curg]
+    MOV     X24, #0
+    ADD     X23, sp, #16
+3:  CMP     X24, X20
+    B.HS    5f
+    MOV     X0, #sc_size
+    MADD    X25, X24, X0, X19
+    STR     X26, [X23, #sg_g]
+    LDR     X0, [X25, #sc_elem]
+    STR     X0, [X23, #sg_elem]
+    STR     XZR, [X23, #sg_ok]
+    MOV     X0, sp
+    STR     X0, [X23, #sg_sel]
+    STR     X24, [X23, #sg_case]
+    STR     XZR, [X23, #sg_queue]
+    LDR     X0, [X25, #sc_chan]
+    CBZ     X0, 4f
+    ADD     X0, X0, #c_recvq
+    LDR     X2, [X25, #sc_dir]
+    CMP     X2, #CASE_SEND
+    B.NE    6f
+    ADD     X0, X0, #(c_sendq - c_recvq)
+6:  STR     X0, [X23, #sg_queue]
+    MOV     X1, X23
+    BL      runtime.enqueuesg
+4:  ADD     X23, X23, #sg_size
+    ADD     X24, X24, #1
+    B       3b
+5:  CHANLOCK runtime.gopark
+    // Take the waiters of the other cases off their queues
+    CHANLOCK runtime.lock
+    MOV     X24, #0
+    ADD     X23, sp, #16
+7:  CMP     X24, X20
+    B.HS    8f
+    LDR     X0, [X23, #sg_queue]
+    CBZ     X0, 9f
+    MOV     X1, X23
+    BL      runtime.removesg
+9:  ADD     X23, X23, #sg_size
+    ADD     X24, X24, #1
+    B       7b
+8:  CHANLOCK runtime.unlock
+    LDR     X2, [sp]                // Waiter of the case run
+    LDR     X0, [X2, #sg_case]
+    LDR     X1, [X2, #sg_ok]
+    MOV     X3, #sc_size
+    MADD    X3, X0, X3, X19
+    LDR     X3, [X3, #sc_dir]
+    CMP     X3, #CASE_SEND
+    B.NE    .Lselectgo_return
+    CBZ     X1, .Lselectgo_panic    // Woken by close
+    MOV     X1, #0
+.Lselectgo_return:
+    SUB     sp, X29, #64
+    LDP     X25, X26, [sp], #16
+    LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+.Lselectgo_sendclosed:
+    CHANLOCK runtime.unlock
+.Lselectgo_panic:
+    SUB     sp, X29, #64
+    LDP     X25, X26, [sp], #16
+    LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    ADR     X1, .Lsend_closed_msg
+    B       runtime.panicstring
+
+// Send without blocking, runtime.chanlock is held
+// Input:
+//   X0 = channel
+//   X1 = value
+// Output:
+//   X0 = 1 when sent, 0 when the send has to block, 2 when the channel is
+//        closed
+// Clobbers caller saved registers
+runtime.chantrysend:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X20, X1
+    LDR     X0, [X19, #c_closed]
+    CBNZ    X0, .Ltrysend_closed
+    ADD     X0, X19, #c_recvq
+    BL      runtime.dequeuesg
+    CBZ     X0, .Ltrysend_buffer
+    // Hand the value straight to a blocked receiver
+    MOV     X21, X0
+    MOV     X0, X19
+    LDR     X1, [X21, #sg_elem]
+    MOV     X2, X20
+    BL      runtime.chancopy
+    MOV     X0, #1
+    STR     X0, [X21, #sg_ok]
+    LDR     X0, [X21, #sg_g]
+    BL      runtime.goready
+    MOV     X0, #1
+    B       .Ltrysend_done
+.Ltrysend_buffer:
+    LDR     X21, [X19, #c_count]
+    LDR     X2, [X19, #c_cap]
+    CMP     X21, X2
+    B.HS    .Ltrysend_block         // Full, or unbuffered without a receiver
+    LDR     X3, [X19, #c_head]
+    ADD     X3, X3, X21
+    CMP     X3, X2
+    B.LO    1f
+    SUB     X3, X3, X2
+1:  CHANSLOT X22, X19, X3, X4
+    ADD     X21, X21, #1
+    STR     X21, [X19, #c_count]
+    MOV     X0, X19
+    MOV     X1, X22
+    MOV     X2, X20
+    BL      runtime.chancopy
+    MOV     X0, X22
+    LDR     X1, [X19, #c_elemtype]
+    BL      runtime.typedbarrier
+    MOV     X0, #1
+    B       .Ltrysend_done
+.Ltrysend_block:
+    MOV     X0, #0
+    B       .Ltrysend_done
+.Ltrysend_closed:
+    MOV     X0, #2
+.Ltrysend_done:
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Receive without blocking, runtime.chanlock is held
+// Input:
+//   X0 = channel
+//   X1 = destination, 0 to drop the value
+// Output:
+//   X0 = 1 when received, 0 when the receive has to block, 2 when the
+//        channel is closed and drained, the destination is then zeroed
+// Clobbers caller saved registers
+runtime.chantryrecv:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X20, X1
+    ADD     X0, X19, #c_sendq
+    BL      runtime.dequeuesg
+    CBZ     X0, .Ltryrecv_buffer
+    MOV     X21, X0
+    LDR     X0, [X19, #c_cap]
+    CBNZ    X0, 1f
+    // Unbuffered, take the value of the sender
+    MOV     X0, X19
+    MOV     X1, X20
+    LDR     X2, [X21, #sg_elem]
+    BL      runtime.chancopy
+    B       2f
+1:  // Full buffer, take the oldest element and queue the sender's value in
+    // its place
+    LDR     X3, [X19, #c_head]
+    CHANSLOT X22, X19, X3, X4
+    MOV     X0, X19
+    MOV     X1, X20
+    MOV     X2, X22
+    BL      runtime.chancopy
+    MOV     X0, X19
+    MOV     X1, X22
+    LDR     X2, [X21, #sg_elem]
+    BL      runtime.chancopy
+    MOV     X0, X22
+    LDR     X1, [X19, #c_elemtype]
+    BL      runtime.typedbarrier
+    CHANNEXT X19, X3, X4
+2:  MOV     X0, #1
+    STR     X0, [X21, #sg_ok]
+    LDR     X0, [X21, #sg_g]
+    BL      runtime.goready
+    MOV     X0, #1
+    B       .Ltryrecv_done
+.Ltryrecv_buffer:
+    LDR     X21, [X19, #c_count]
+    CBZ     X21, .Ltryrecv_empty
+    LDR     X3, [X19, #c_head]
+    CHANSLOT X22, X19, X3, X4
+    MOV     X0, X19
+    MOV     X1, X20
+    MOV     X2, X22
+    BL      runtime.chancopy
+    // The buffer must not keep the value alive
+    MOV     X0, X22
+    LDR     X1, [X19, #c_elemtype]
+    LDR     X1, [X1, #type_size]
+    BL      runtime.memclr
+    SUB     X21, X21, #1
+    STR     X21, [X19, #c_count]
+    CHANNEXT X19, X3, X4
+    MOV     X0, #1
+    B       .Ltryrecv_done
+.Ltryrecv_empty:
+    LDR     X0, [X19, #c_closed]
+    CBZ     X0, .Ltryrecv_done
+    CBZ     X20, 3f
+    MOV     X0, X20
+    LDR     X1, [X19, #c_elemtype]
+    LDR     X1, [X1, #type_size]
+    BL      runtime.memclr
+3:  MOV     X0, #2
+.Ltryrecv_done:
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Take the first waiter of a queue that can still be woken, waiters of a
+// select that already ran a case are dropped. runtime.chanlock is held
+// Input:
+//   X0 = queue, head and tail
+// Output:
+//   X0 = waiter, 0 for none
+// Clobbers X1-X3
+runtime.dequeuesg:
+    LDR     X1, [X0]
+    CBZ     X1, 2f
+    LDR     X2, [X1, #sg_next]
+    STR     X2, [X0]
+    CBNZ    X2, 1f
+    STR     XZR, [X0, #8]
+1:  LDR     X2, [X1, #sg_sel]
+    CBZ     X2, 2f
+    LDR     X3, [X2]
+    CBNZ    X3, runtime.dequeuesg   // Another case of the select ran
+    STR     X1, [X2]
+2:  MOV     X0, X1
+    RET
+
+// Append a waiter to a queue, runtime.chanlock is held
+// Input:
+//   X0 = queue, head and tail
+//   X1 = waiter
+// Clobbers X2
+runtime.enqueuesg:
+    STR     XZR, [X1, #sg_next]
+    LDR     X2, [X0, #8]
+    CBZ     X2, 1f
+    STR     X1, [X2, #sg_next]
+    B       2f
+1:  STR     X1, [X0]
+2:  STR     X1, [X0, #8]
+    RET
+
+// Unlink a waiter from a queue if it is still on it, runtime.chanlock is held
+// Input:
+//   X0 = queue, head and tail
+//   X1 = waiter
+// Clobbers X2, X3
+runtime.removesg:
+    MOV     X2, #0                  // Previous waiter
+    LDR     X3, [X0]
+1:  CBZ     X3, 4f
+    CMP     X3, X1
+    B.EQ    2f
+    MOV     X2, X3
+    LDR     X3, [X3, #sg_next]
+    B       1b
+2:  LDR     X3, [X1, #sg_next]
+    CBNZ    X2, 3f
+    STR     X3, [X0]
+    B       5f
+3:  STR     X3, [X2, #sg_next]
+5:  CBNZ    X3, 4f
+    STR     X2, [X0, #8]
+4:  RET
+
+// Copy an element of a channel
+// Input:
+//   X0 = channel
+//   X1 = destination, 0 to drop the element
+//   X2 = source
+// Clobbers X0-X3
+runtime.chancopy:
+    CBZ     X1, 1f
+    LDR     X3, [X0, #c_elemtype]
+    MOV     X0, X1
+    MOV     X1, X2
+    LDR     X2, [X3, #type_size]
+    B       runtime.memcopy
+1:  RET
+
+// Park the goroutine for good, operations on nil channels never complete
+runtime.block:
+    MOV     X0, #0
+    B       runtime.gopark
+
+    .balign 8
+.Lmakechan_msg:
+    .quad   .Lmakechan_str, .Lsend_closed_str - .Lmakechan_str
+.Lsend_closed_msg:
+    .quad   .Lsend_closed_str, .Lclose_nil_str - .Lsend_closed_str
+.Lclose_nil_msg:
+    .quad   .Lclose_nil_str, .Lclose_closed_str - .Lclose_nil_str
+.Lclose_closed_msg:
+    .quad   .Lclose_closed_str, .Lchan_str_end - .Lclose_closed_str
+.Lmakechan_str:
+    .ascii  "makechan: size out of range"
+.Lsend_closed_str:
+    .ascii  "send on closed channel"
+.Lclose_nil_str:
+    .ascii  "close of nil channel"
+.Lclose_closed_str:
+    .ascii  "close of closed channel"
+.Lchan_str_end:
+    .balign 4
+
+// Copy memory front to back
+// Input:
+//   X0 = destination
+//   X1 = source
+//   X2 = size in bytes
+// Clobbers X0-X3
+runtime.memcopy:
+    CBZ     X2, 2f
+1:  LDRB    W3, [X1], #1
+    STRB    W3, [X0], #1
+    SUBS    X2, X2, #1
+    B.NE    1b
+2:  RET
+
+// Clear memory
+// Input:
+//   X0 = address
+//   X1 = size in bytes
+// Clobbers X0, X1
+runtime.memclr:
+    CBZ     X1, 2f
+1:  STRB    WZR, [X0], #1
+    SUBS    X1, X1, #1
+    B.NE    1b
+2:  RET
@@ -1,21 +1,373 @@
-.macro DEFER func, args:vararg
-    // Save current stack pointer
-    mov     x19, sp
-
-    // Allocate defer record (16 bytes for function + args + 16 bytes for link/alignment)
-    sub     sp, sp, #32
-
-    // Store function pointer
-    adr     x0, \func
-    str     x0, [sp]
-
-    // Store arguments if any
-    .ifnb \args
-        stp     \args, [sp, #16]    // Example pair of values.
-    .endif
-
-    // Link to previous defer iF any
-    ldr     x0, [x29, #-8]        // Adjust offset if alignment is diff?
-    str     x0, [sp, #8]          // store prev linked.
-    str     sp, [x29, #-8]        // Update current defer pos.
-.endm
\ No newline at end of file
+// Defer record layout, kept in sync with pkg/mapper/defer.go. Records are
+// pushed on the stack of the deferring function and linked from its frame
+.equ frame_defer,    -8   // Head of the defer list, [x29, #-8]
+.equ defer_fn,       0    // Code pointer
+.equ defer_link,     8    // Previously deferred record of the frame
+.equ defer_ctx,      16   // Closure context passed in x26
+.equ defer_resume,   24   // Recovery point of the deferring function, 0 if none
+.equ defer_args,     32   // x0-x7
+.equ defer_fargs,    96   // d0-d7
+.equ defer_size,     160
+
+// Panic state of a goroutine, kept at g_panic of its G and found by
+// runtime.getpanic
+.equ panic_active,    0
+.equ panic_recovered, 8
+.equ panic_value,     16  // Interface value (type, data)
+.equ panic_size,      32
+
+// Push a defer record for the current frame, the arguments of the deferred
+// call are expected in x0-x7 and d0-d7. Clobbers x16
+.macro DEFER code, ctx=xzr, resume=xzr
+    sub     sp, sp, #defer_size
+    str     \code, [sp, #defer_fn]
+    str     \ctx, [sp, #defer_ctx]
+    str     \resume, [sp, #defer_resume]
+    stp     x0, x1, [sp, #defer_args]
+    stp     x2, x3, [sp, #defer_args + 16]
+    stp     x4, x5, [sp, #defer_args + 32]
+    stp     x6, x7, [sp, #defer_args + 48]
+    stp     d0, d1, [sp, #defer_fargs]
+    stp     d2, d3, [sp, #defer_fargs + 16]
+    stp     d4, d5, [sp, #defer_fargs + 32]
+    stp     d6, d7, [sp, #defer_fargs + 48]
+
+    // Link to the previous record of the frame
+    ldr     x16, [x29, #frame_defer]
+    str     x16, [sp, #defer_link]
+    mov     x16, sp
+    str     x16, [x29, #frame_defer]
+.endm
+
+.data
+    .balign 8
+runtime.panicstate:
+    .space  panic_size              // Main goroutine without a scheduler
+
+.text
+
+// Run the deferred calls of the calling function in LIFO order. The frame
+// record keeps the frame chain intact for stack scanning, its empty defer
+// list keeps panics from reading a saved register as one
+// Input:
+//   X29 = frame of the calling function
+// Clobbers all caller saved registers
+runtime.rundefers:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, XZR, [sp, #-16]!   // No deferred calls at frame_defer
+    LDR     X19, [X29]              // Frame of the calling function
+.Lrundefers_loop:
+    LDR     X0, [X19, #frame_defer]
+    CBZ     X0, .Lrundefers_done
+    // Pop the record first, the deferred call may panic
+    LDR     X1, [X0, #defer_link]
+    STR     X1, [X19, #frame_defer]
+    BL      .Lcalldefer
+    B       .Lrundefers_loop
+.Lrundefers_done:
+    LDR     X19, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Call the function of a defer record, it returns to the caller
+// Input:
+//   X0 = defer record
+.Lcalldefer:
+    LDR     X16, [X0, #defer_fn]
+    LDR     X26, [X0, #defer_ctx]
+    LDP     D0, D1, [X0, #defer_fargs]
+    LDP     D2, D3, [X0, #defer_fargs + 16]
+    LDP     D4, D5, [X0, #defer_fargs + 32]
+    LDP     D6, D7, [X0, #defer_fargs + 48]
+    LDP     X2, X3, [X0, #defer_args + 16]
+    LDP     X4, X5, [X0, #defer_args + 32]
+    LDP     X6, X7, [X0, #defer_args + 48]
+    LDP     X0, X1, [X0, #defer_args]
+    BR      X16
+
+// Panic with an interface value. Deferred calls run from the innermost frame
+// outwards, the frame chain ends at a zero frame pointer. When a deferred call
+// recovers, the remaining deferred calls of its frame run and execution
+// continues at the recovery point of the deferring function. Deferred calls
+// return to .Lgopanic_deferreturn, recover checks for it
+// Input:
+//   X0, X1 = panic value
+//   X29 = frame of the panicking function
+// Does not return
+runtime.gopanic:
+    STP     X29, X30, [sp, #-16]!   // Keeps the panicking frame on the chain
+    MOV     X29, sp
+    STP     XZR, XZR, [sp, #-16]!   // No deferred calls at frame_defer
+    BL      runtime.getpanic
+    MOV     X3, #1
+    STR     X3, [X2, #panic_active]
+    STR     XZR, [X2, #panic_recovered]
+    STP     X0, X1, [X2, #panic_value]
+    LDR     X19, [X29]              // Frame being unwound
+.Lgopanic_frame:
+    CBZ     X19, .Lgopanic_fatal
+.Lgopanic_loop:
+    LDR     X20, [X19, #frame_defer]
+    CBZ     X20, .Lgopanic_up
+    LDR     X1, [X20, #defer_link]
+    STR     X1, [X19, #frame_defer]
+    MOV     X0, X20
+    BL      .Lcalldefer
+.Lgopanic_deferreturn:
+    BL      runtime.getpanic
+    LDR     X3, [X2, #panic_recovered]
+    CBNZ    X3, .Lgopanic_recovered
+    B       .Lgopanic_loop
+.Lgopanic_up:
+    LDR     X19, [X19]              // Frame of the caller
+    B       .Lgopanic_frame
+.Lgopanic_recovered:
+    STR     XZR, [X2, #panic_active]
+    STR     XZR, [X2, #panic_recovered]
+    LDR     X21, [X20, #defer_resume]
+    CBZ     X21, .Lgopanic_fatal
+    // Back on the stack of the deferring function, the record marks its sp
+    MOV     X29, X19
+    MOV     sp, X20
+    BL      runtime.rundefers
+    BR      X21
+.Lgopanic_fatal:
+    ADR     X0, .Lpanic_msg
+    MOV     X1, #7
+    BL      runtime.printstderr
+    BL      runtime.getpanic
+    LDP     X0, X1, [X2, #panic_value]
+    BL      runtime.printpanicval
+    ADR     X0, .Lpanic_newline
+    MOV     X1, #1
+    BL      runtime.printstderr
+    MOV     X0, #2                  // Exit code of an uncaught panic
+    MOV     X8, #94                 // exit_group
+    SVC     #0
+
+// Panic with a string, the message of a runtime routine. The routine pops
+// its frame first so X29 and X30 are those of the faulting call
+// Input:
+//   X1 = string header of the message
+// Does not return
+runtime.panicstring:
+    ADRP    X0, type.string
+    ADD     X0, X0, :lo12:type.string
+    B       runtime.gopanic
+
+// Panic on an index out of range, called by the bounds checks of the
+// faulting function
+// Does not return
+runtime.panicindex:
+    ADR     X1, .Lpanicindex_msg
+    B       runtime.panicstring
+    .balign 8
+.Lpanicindex_msg:
+    .quad   .Lpanicindex_str, .Lpanicindex_end - .Lpanicindex_str
+.Lpanicindex_str:
+    .ascii  "runtime error: index out of range"
+.Lpanicindex_end:
+    .balign 4
+
+// Panic on slice bounds that are out of range or not ordered
+// Does not return
+runtime.panicslice:
+    ADR     X1, .Lpanicslice_msg
+    B       runtime.panicstring
+    .balign 8
+.Lpanicslice_msg:
+    .quad   .Lpanicslice_str, .Lpanicslice_end - .Lpanicslice_str
+.Lpanicslice_str:
+    .ascii  "runtime error: slice bounds out of range"
+.Lpanicslice_end:
+    .balign 4
+
+// Panic on a nil pointer dereference
+// Does not return
+runtime.panicnil:
+    ADR     X1, .Lpanicnil_msg
+    B       runtime.panicstring
+    .balign 8
+.Lpanicnil_msg:
+    .quad   .Lpanicnil_str, .Lpanicnil_end - .Lpanicnil_str
+.Lpanicnil_str:
+    .ascii  "runtime error: invalid memory address or nil pointer dereference"
+.Lpanicnil_end:
+    .balign 4
+
+// Panic in a function garm did not compile, built with -allow-unsupported.
+// The stub of the function branches here
+// Does not return
+runtime.panicunsupported:
+    ADR     X1, .Lpanicunsupported_msg
+    B       runtime.panicstring
+    .balign 8
+.Lpanicunsupported_msg:
+    .quad   .Lpanicunsupported_str, .Lpanicunsupported_end - .Lpanicunsupported_str
+.Lpanicunsupported_str:
+    .ascii  "garm: function uses unsupported constructs"
+.Lpanicunsupported_end:
+    .balign 4
+
+// Stop panicking and return the panic value, nil when not panicking or when
+// the caller is not a deferred call run by the panic
+// Input:
+//   X29 = frame of the caller
+// Output:
+//   X0, X1 = panic value
+runtime.gorecover:
+    LDR     X3, [X29, #8]           // Return address of the caller
+    ADR     X4, .Lgopanic_deferreturn
+    CMP     X3, X4
+    B.NE    .Lgorecover_nil
+    MOV     X5, X30
+    BL      runtime.getpanic
+    MOV     X30, X5
+    LDR     X3, [X2, #panic_active]
+    CBZ     X3, .Lgorecover_nil
+    LDR     X3, [X2, #panic_recovered]
+    CBNZ    X3, .Lgorecover_nil
+    MOV     X3, #1
+    STR     X3, [X2, #panic_recovered]
+    LDP     X0, X1, [X2, #panic_value]
+    RET
+.Lgorecover_nil:
+    MOV     X0, #0
+    MOV     X1, #0
+    RET
+
+// Print a panic value, strings, booleans and integers print their value and
+// any other type prints its name in parentheses
+// Input:
+//   X0 = type descriptor, 0 for nil
+//   X1 = data word
+runtime.printpanicval:
+    CBZ     X0, .Lpanicval_nil
+    STP     X29, X30, [sp, #-16]!
+    LDRB    W2, [X0, #type_kind]
+    LDR     X3, [X0, #type_size]
+    CMP     W2, #24                 // String
+    B.EQ    .Lpanicval_string
+    CMP     W2, #1                  // Bool
+    B.EQ    .Lpanicval_bool
+    SUB     W4, W2, #2              // Int, Int8 ... Int64
+    CMP     W4, #4
+    B.LS    .Lpanicval_int
+    SUB     W4, W2, #7              // Uint, Uint8 ... Uintptr
+    CMP     W4, #5
+    B.LS    .Lpanicval_uint
+    MOV     X19, X0
+    ADR     X0, .Lpanic_lparen
+    MOV     X1, #1
+    BL      runtime.printstderr
+    MOV     X0, X19
+    BL      runtime.printtypename
+    ADR     X0, .Lpanic_rparen
+    MOV     X1, #1
+    BL      runtime.printstderr
+    B       .Lpanicval_done
+.Lpanicval_string:
+    LDP     X0, X1, [X1]
+    BL      runtime.printstderr
+    B       .Lpanicval_done
+.Lpanicval_bool:
+    LDRB    W2, [X1]
+    ADR     X0, .Lpanic_false
+    MOV     X1, #5
+    CBZ     W2, .Lpanicval_print
+    ADR     X0, .Lpanic_true
+    MOV     X1, #4
+.Lpanicval_print:
+    BL      runtime.printstderr
+    B       .Lpanicval_done
+.Lpanicval_int:
+    CMP     X3, #1
+    B.EQ    1f
+    CMP     X3, #2
+    B.EQ    2f
+    CMP     X3, #4
+    B.EQ    4f
+    LDR     X0, [X1]
+    B       8f
+1:  LDRSB   X0, [X1]
+    B       8f
+2:  LDRSH   X0, [X1]
+    B       8f
+4:  LDRSW   X0, [X1]
+8:  BL      runtime.printint
+    B       .Lpanicval_done
+.Lpanicval_uint:
+    CMP     X3, #1
+    B.EQ    1f
+    CMP     X3, #2
+    B.EQ    2f
+    CMP     X3, #4
+    B.EQ    4f
+    LDR     X0, [X1]
+    B       8f
+1:  LDRB    W0, [X1]
+    B       8f
+2:  LDRH    W0, [X1]
+    B       8f
+4:  LDR     W0, [X1]
+8:  BL      runtime.printuint
+.Lpanicval_done:
+    LDP     X29, X30, [sp], #16
+    RET
+.Lpanicval_nil:
+    ADR     X0, .Lpanic_nil
+    MOV     X1, #(.Lpanic_nil_end - .Lpanic_nil)
+    B       runtime.printstderr
+
+// Print a 64 bit integer in decimal
+// Input:
+//   X0 = value
+runtime.printint:
+    MOV     X4, X0                  // Sign
+    CMP     X0, #0
+    CNEG    X0, X0, LT
+    B       .Lprintint_digits
+runtime.printuint:
+    MOV     X4, #0
+.Lprintint_digits:
+    SUB     sp, sp, #32
+    STR     X30, [sp]
+    ADD     X3, sp, #32             // Digits are written backwards
+    MOV     X5, #10
+.Lprintint_loop:
+    UDIV    X6, X0, X5
+    MSUB    X7, X6, X5, X0
+    ADD     X7, X7, #'0'
+    STRB    W7, [X3, #-1]!
+    MOV     X0, X6
+    CBNZ    X0, .Lprintint_loop
+    CMP     X4, #0
+    B.GE    .Lprintint_write
+    MOV     X7, #'-'
+    STRB    W7, [X3, #-1]!
+.Lprintint_write:
+    MOV     X0, X3
+    ADD     X1, sp, #32
+    SUB     X1, X1, X3
+    BL      runtime.printstderr
+    LDR     X30, [sp]
+    ADD     sp, sp, #32
+    RET
+
+.Lpanic_msg:
+    .ascii  "panic: "
+.Lpanic_nil:
+    .ascii  "panic called with nil argument"
+.Lpanic_nil_end:
+.Lpanic_true:
+    .ascii  "true"
+.Lpanic_false:
+    .ascii  "false"
+.Lpanic_lparen:
+    .ascii  "("
+.Lpanic_rparen:
+    .ascii  ")"
+.Lpanic_newline:
+    .ascii  "\n"
+    .balign 4
@@ -1,4 +1,5 @@
-.include "defer.s"
+.include "interface.asm"
+.include "defer.asm"
 .section .text
 .global defer_test
 defer_test:
@@ -6,20 +7,19 @@ defer_test:
     stp     x29, x30, [sp, #-16]!    // Save frame pointer and link register
     mov     x29, sp                  // Set frame pointer
 
-    sub     sp, sp, #16              // Allocate space for local variables
+    sub     sp, sp, #16              // Reserved for the runtime
+    str     xzr, [x29, #frame_defer] // No deferred calls yet
 
     // Defer cleanup function with argument 42
     ldr     x0, =42                  // Load argument into x0
-    DEFER cleanup, x0                // Call defer for cleanup(42)
+    adr     x9, cleanup
+    DEFER   x9                       // Call defer for cleanup(42)
 
     // Simulate some work
     bl      foo             // Call some function
 
-    // Epilogue: defer execution point
-    // Normally this `cleanup` or DEFER unwind would trigger just before return
-
-    ldr     x0, [x29, #-8]           // Restore Defer Handler
-    blr     x0                       // Executes cleanup(42)
+    // Epilogue: run the deferred calls before returning
+    bl      runtime.rundefers        // Executes cleanup(42)
 
     mov     sp, x29
     ldp     x29, x30, [sp], #16
@@ -31,4 +31,4 @@ cleanup:
 
 foo:
     // Placeholder for some operation
-    ret
\ No newline at end of file
+    ret
@@ -0,0 +1,344 @@
+// Subset of the fmt and os packages on top of the Linux write and exit_group
+// system calls. A file of the os package is the word holding its descriptor
+.equ FMT_BUFFER,    256
+.equ fmt_saved,     80      // x30 of the buffer helpers
+.equ fmt_temp,      96      // Formatted numbers
+.equ fmt_buffer,    128
+.equ FMT_FRAME,     (fmt_buffer + FMT_BUFFER)
+
+.data
+    .balign 8
+runtime.stdin:
+    .quad   runtime.files
+runtime.stdout:
+    .quad   runtime.files + 8
+runtime.stderr:
+    .quad   runtime.files + 16
+runtime.files:
+    .quad   0, 1, 2
+
+.text
+
+// Write a buffer to a file descriptor, short writes are resumed and
+// interrupted ones retried
+// Input:
+//   X0 = file descriptor
+//   X1 = pointer
+//   X2 = length
+// Output:
+//   X0 = bytes written
+//   X1 = 0 or the negated errno of the failed write
+// Clobbers X2-X4, X8
+runtime.write:
+    MOV     X3, X0
+    MOV     X4, #0
+.Lwrite_loop:
+    CBZ     X2, .Lwrite_done
+    MOV     X0, X3
+    MOV     X8, #64                 // write
+    SVC     #0
+    CMN     X0, #4                  // EINTR
+    B.EQ    .Lwrite_loop
+    CMP     X0, #0
+    B.LE    .Lwrite_failed
+    ADD     X1, X1, X0
+    SUB     X2, X2, X0
+    ADD     X4, X4, X0
+    B       .Lwrite_loop
+.Lwrite_failed:
+    MOV     X1, X0
+    MOV     X0, X4
+    RET
+.Lwrite_done:
+    MOV     X0, X4
+    MOV     X1, #0
+    RET
+
+// os.Exit, the process ends without running deferred calls
+// Input:
+//   X0 = exit code
+// Does not return
+runtime.exit:
+    MOV     X8, #94                 // exit_group
+    SVC     #0
+
+// (*os.File).Write, the slice is passed by address
+// Input:
+//   X0 = file
+//   X1 = address of the slice
+// Output:
+//   X0 = bytes written
+//   X1, X2 = nil error
+runtime.filewritebytes:
+    LDR     X2, [X1, #8]
+    LDR     X1, [X1]
+// (*os.File).WriteString, errors are not reported
+// Input:
+//   X0 = file
+//   X1, X2 = data and length
+// Output:
+//   X0 = bytes written
+//   X1, X2 = nil error
+runtime.filewrite:
+    STR     X30, [sp, #-16]!
+    LDR     X0, [X0]
+    BL      runtime.write
+    MOV     X1, #0
+    MOV     X2, #0
+    LDR     X30, [sp], #16
+    RET
+
+// fmt.Println and fmt.Print writing to stdout, operands are formatted by
+// kind like %v: booleans, integers, floats and strings print their value,
+// pointers, channels and functions their address, nil interfaces <nil> and
+// anything else %!v(type). Error and String methods are not called. Println
+// separates operands by spaces, Print only operands that are not strings
+// Input:
+//   X0 = address of the operands, a slice of empty interfaces
+// Output:
+//   X0 = bytes written
+//   X1, X2 = nil error
+runtime.fmtprintln:
+    MOV     X3, #1
+    B       .Lfmtprint
+runtime.fmtprint:
+    MOV     X3, #0
+.Lfmtprint:
+    LDR     X1, [X0, #8]
+    LDR     X0, [X0]
+    SUB     sp, sp, #FMT_FRAME
+    STP     X29, X30, [sp]
+    STP     X19, X20, [sp, #16]
+    STP     X21, X22, [sp, #32]
+    STP     X23, X24, [sp, #48]
+    STP     X25, X27, [sp, #64]
+    MOV     X19, X0                 // Next operand
+    MOV     X20, X1                 // Operands left
+    MOV     X21, X3                 // Println
+    MOV     X22, #0                 // Bytes buffered
+    MOV     X23, #0                 // Bytes written
+    MOV     X24, #0                 // Previous operand, 1 string, 2 other
+.Lfmtprint_loop:
+    CBZ     X20, .Lfmtprint_end
+    LDP     X25, X27, [X19], #16    // Type and data word
+    SUB     X20, X20, #1
+    MOV     X11, #2
+    CBZ     X25, .Lfmtprint_separate
+    LDRB    W12, [X25, #type_kind]
+    CMP     W12, #24                // String
+    B.NE    .Lfmtprint_separate
+    MOV     X11, #1
+.Lfmtprint_separate:
+    CBZ     X24, .Lfmtprint_value
+    CBNZ    X21, .Lfmtprint_space
+    CMP     X11, #2
+    B.NE    .Lfmtprint_value
+    CMP     X24, #2
+    B.NE    .Lfmtprint_value
+.Lfmtprint_space:
+    ADR     X0, .Lfmtprint_chars
+    MOV     X1, #1
+    BL      .Lfmtprint_put
+.Lfmtprint_value:
+    MOV     X24, X11
+    CBZ     X25, .Lfmtprint_nil
+    LDRB    W12, [X25, #type_kind]
+    LDR     X13, [X25, #type_size]
+    CMP     W12, #24
+    B.EQ    .Lfmtprint_string
+    CMP     W12, #1                 // Bool
+    B.EQ    .Lfmtprint_bool
+    SUB     W14, W12, #2            // Int, Int8 ... Int64
+    CMP     W14, #4
+    B.LS    .Lfmtprint_int
+    SUB     W14, W12, #7            // Uint, Uint8 ... Uintptr
+    CMP     W14, #5
+    B.LS    .Lfmtprint_uint
+    CMP     W12, #13                // Float32
+    B.EQ    .Lfmtprint_float32
+    CMP     W12, #14                // Float64
+    B.EQ    .Lfmtprint_float64
+    CMP     W12, #18                // Chan
+    B.EQ    .Lfmtprint_pointer
+    CMP     W12, #19                // Func
+    B.EQ    .Lfmtprint_pointer
+    CMP     W12, #22                // Pointer
+    B.EQ    .Lfmtprint_pointer
+    CMP     W12, #26                // UnsafePointer
+    B.EQ    .Lfmtprint_pointer
+    ADR     X0, .Lfmtprint_bad
+    MOV     X1, #4
+    BL      .Lfmtprint_put
+    LDP     X0, X1, [X25, #type_name]
+    BL      .Lfmtprint_put
+    ADR     X0, .Lfmtprint_chars + 2
+    MOV     X1, #1
+    BL      .Lfmtprint_put
+    B       .Lfmtprint_loop
+.Lfmtprint_nil:
+    ADR     X0, .Lfmtprint_nilmsg
+    MOV     X1, #5
+    BL      .Lfmtprint_put
+    B       .Lfmtprint_loop
+.Lfmtprint_string:
+    LDP     X0, X1, [X27]
+    BL      .Lfmtprint_put
+    B       .Lfmtprint_loop
+.Lfmtprint_bool:
+    LDRB    W2, [X27]
+    ADR     X0, .Lfmtprint_false
+    MOV     X1, #5
+    CBZ     W2, .Lfmtprint_text
+    ADR     X0, .Lfmtprint_true
+    MOV     X1, #4
+.Lfmtprint_text:
+    BL      .Lfmtprint_put
+    B       .Lfmtprint_loop
+.Lfmtprint_int:
+    CMP     X13, #1
+    B.EQ    1f
+    CMP     X13, #2
+    B.EQ    2f
+    CMP     X13, #4
+    B.EQ    4f
+    LDR     X0, [X27]
+    B       8f
+1:  LDRSB   X0, [X27]
+    B       8f
+2:  LDRSH   X0, [X27]
+    B       8f
+4:  LDRSW   X0, [X27]
+8:  MOV     X4, X0                  // Sign
+    CMP     X0, #0
+    CNEG    X0, X0, LT
+    B       .Lfmtprint_decimal
+.Lfmtprint_uint:
+    CMP     X13, #1
+    B.EQ    1f
+    CMP     X13, #2
+    B.EQ    2f
+    CMP     X13, #4
+    B.EQ    4f
+    LDR     X0, [X27]
+    B       8f
+1:  LDRB    W0, [X27]
+    B       8f
+2:  LDRH    W0, [X27]
+    B       8f
+4:  LDR     W0, [X27]
+8:  MOV     X4, #0
+.Lfmtprint_decimal:
+    ADD     X3, sp, #fmt_buffer     // Digits are written backwards
+    MOV     X5, #10
+.Lfmtprint_digit:
+    UDIV    X6, X0, X5
+    MSUB    X7, X6, X5, X0
+    ADD     X7, X7, #'0'
+    STRB    W7, [X3, #-1]!
+    MOV     X0, X6
+    CBNZ    X0, .Lfmtprint_digit
+    CMP     X4, #0
+    B.GE    .Lfmtprint_number
+    MOV     X7, #'-'
+    STRB    W7, [X3, #-1]!
+.Lfmtprint_number:
+    MOV     X0, X3
+    ADD     X1, sp, #fmt_buffer
+    SUB     X1, X1, X3
+    BL      .Lfmtprint_put
+    B       .Lfmtprint_loop
+.Lfmtprint_pointer:
+    ADD     X3, sp, #fmt_buffer
+    ADR     X5, .Lfmtprint_hex
+.Lfmtprint_nibble:
+    AND     X6, X27, #0xf
+    LDRB    W6, [X5, X6]
+    STRB    W6, [X3, #-1]!
+    LSR     X27, X27, #4
+    CBNZ    X27, .Lfmtprint_nibble
+    MOV     W6, #'x'
+    STRB    W6, [X3, #-1]!
+    MOV     W6, #'0'
+    STRB    W6, [X3, #-1]!
+    B       .Lfmtprint_number
+.Lfmtprint_float32:
+    LDR     S0, [X27]
+    FCVT    D0, S0
+    MOV     X1, #32
+    B       .Lfmtprint_float
+.Lfmtprint_float64:
+    LDR     D0, [X27]
+    MOV     X1, #64
+.Lfmtprint_float:
+    ADD     X0, sp, #fmt_temp
+    BL      runtime.formatfloat
+    MOV     X1, X0
+    ADD     X0, sp, #fmt_temp
+    BL      .Lfmtprint_put
+    B       .Lfmtprint_loop
+.Lfmtprint_end:
+    CBZ     X21, .Lfmtprint_flush
+    ADR     X0, .Lfmtprint_chars + 1
+    MOV     X1, #1
+    BL      .Lfmtprint_put
+.Lfmtprint_flush:
+    BL      .Lfmtprint_write
+    MOV     X0, X23
+    MOV     X1, #0
+    MOV     X2, #0
+    LDP     X25, X27, [sp, #64]
+    LDP     X23, X24, [sp, #48]
+    LDP     X21, X22, [sp, #32]
+    LDP     X19, X20, [sp, #16]
+    LDP     X29, X30, [sp]
+    ADD     sp, sp, #FMT_FRAME
+    RET
+
+// Append X1 bytes at X0 to the buffer of fmtprint, writing it out when full
+// Clobbers X0-X8
+.Lfmtprint_put:
+    STR     X30, [sp, #fmt_saved]
+    MOV     X5, X0
+    MOV     X6, X1
+.Lfmtprint_put_loop:
+    CBZ     X6, .Lfmtprint_put_done
+    CMP     X22, #FMT_BUFFER
+    B.LO    .Lfmtprint_put_byte
+    BL      .Lfmtprint_write
+.Lfmtprint_put_byte:
+    ADD     X7, sp, #fmt_buffer
+    LDRB    W8, [X5], #1
+    STRB    W8, [X7, X22]
+    ADD     X22, X22, #1
+    SUB     X6, X6, #1
+    B       .Lfmtprint_put_loop
+.Lfmtprint_put_done:
+    LDR     X30, [sp, #fmt_saved]
+    RET
+
+// Write out the buffer of fmtprint to stdout
+// Clobbers X0-X4, X8
+.Lfmtprint_write:
+    STR     X30, [sp, #fmt_saved + 8]
+    MOV     X0, #1                  // stdout
+    ADD     X1, sp, #fmt_buffer
+    MOV     X2, X22
+    BL      runtime.write
+    ADD     X23, X23, X0
+    MOV     X22, #0
+    LDR     X30, [sp, #fmt_saved + 8]
+    RET
+
+.Lfmtprint_chars:
+    .ascii  " \n)"
+.Lfmtprint_bad:
+    .ascii  "%!v("
+.Lfmtprint_nilmsg:
+    .ascii  "<nil>"
+.Lfmtprint_true:
+    .ascii  "true"
+.Lfmtprint_false:
+    .ascii  "false"
+.Lfmtprint_hex:
+    .ascii  "0123456789abcdef"
+    .balign 4
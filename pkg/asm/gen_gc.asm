@@ -1,175 +1,381 @@
-// Generational GC Constants
-.equ GEN0_SIZE,     4 * 1024 * 1024  // 4MB Eden/Young space
-.equ GEN1_SIZE,     16 * 1024 * 1024 // 16MB Survivor space  
-.equ GEN2_SIZE,     44 * 1024 * 1024 // 44MB Tenured/Old space
+// Generational collector. Objects start in eden, minor collections copy the
+// reachable young objects into a survivor space and promote the ones that
+// survived AGE_THRESHOLD collections, or do not fit, into the tenured space.
+// Tenured objects are never collected. Linked after runtime.asm
+.equ EDEN_SIZE,      4 * 1024 * 1024
+.equ SURVIVOR_SIZE,  4 * 1024 * 1024  // Each of the two survivor spaces
+.equ LARGE_OBJECT,   512 * 1024       // Larger objects are allocated tenured
 .equ AGE_THRESHOLD,  15               // Objects survive this many GCs before promotion
 
-// Generation Headers (32 bytes each)
-.struct 0
-gen_start:    .space 8     // Start address
-gen_end:      .space 8     // End address
-gen_top:      .space 8     // Allocation pointer
-gen_survived: .space 8     // Bytes survived last GC
-.end
+// Generations, eden, the survivor spaces and tenured follow each other in
+// the heap
+.equ gen_eden,       0    // Eden start
+.equ gen_edentop,    8    // Allocation pointer
+.equ gen_edenend,    16
+.equ gen_from,       24   // Survivor space holding the survivors
+.equ gen_fromtop,    32
+.equ gen_to,         40   // Empty survivor space the next minor collection copies to
+.equ gen_totop,      48
+.equ gen_old,        56   // Tenured start
+.equ gen_oldtop,     64   // Allocation pointer
+.equ gen_oldscan,    72   // Objects promoted by a minor collection are scanned from here
+.equ gen_state_size, 80
 
-// Object Header Extensions (4 bytes)
-.struct 0 
-obj_age:     .space 1      // Survival count
-obj_gen:     .space 1      // Current generation
-obj_forward: .space 2      // Forwarding pointer offset
-.end
+.bss
+    .balign 8
+gen_state:  .space gen_state_size
 
-// GC State Extensions
-.struct 0
-gc_gen0:     .space 32     // Eden space
-gc_gen1:     .space 32     // Survivor space
-gc_gen2:     .space 32     // Tenured space
-gc_alc_gen:  .space 8      // Current allocation generation
-.end
+.text
 
-// Fast Path Allocation
-.macro GEN_ALLOC size, type
-    // Try eden allocation first
-    adr     x19, gc_state
-    ldr     x20, [x19, #gc_gen0 + gen_top]
-    add     x21, x20, \size
-    ldr     x22, [x19, #gc_gen0 + gen_end]
-    cmp     x21, x22
-    b.gt    1f              // Eden full - trigger minor GC
-    
-    // Fast path eden allocation
-    str     x21, [x19, #gc_gen0 + gen_top]
-    mov     x0, x20
-    
-    // Initialize header
-    str     \size, [x0, #obj_size]
-    str     \type, [x0, #obj_type] 
-    mov     w1, #0
-    strb    w1, [x0, #obj_age]    // Age 0
-    strb    w1, [x0, #obj_gen]    // Generation 0
-    b       2f
+// Set up the heap, called by the entry point before package initializers
+runtime.gcinit:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    BL      gc_mapheap
+    ADRP    X1, gen_state
+    ADD     X1, X1, :lo12:gen_state
+    STR     X0, [X1, #gen_eden]
+    STR     X0, [X1, #gen_edentop]
+    MOV     X2, #EDEN_SIZE
+    ADD     X0, X0, X2
+    STR     X0, [X1, #gen_edenend]
+    STR     X0, [X1, #gen_from]
+    STR     X0, [X1, #gen_fromtop]
+    MOV     X2, #SURVIVOR_SIZE
+    ADD     X0, X0, X2
+    STR     X0, [X1, #gen_to]
+    STR     X0, [X1, #gen_totop]
+    ADD     X0, X0, X2
+    STR     X0, [X1, #gen_old]
+    STR     X0, [X1, #gen_oldtop]
+    LDP     X29, X30, [sp], #16
+    RET
 
-1:  // Minor collection
-    bl      minor_gc
-    
-    // Retry allocation
-    GEN_ALLOC \size, \type
+// Allocation entry point of compiled code, runs a minor collection when eden
+// is full. Same calling convention as runtime.alloc in alloc.asm
+// Input:
+//   X0 = size in bytes
+//   X1 = type descriptor
+// Output:
+//   X0 = zeroed object, following its header
+// Clobbers all caller saved registers
+runtime.alloc:
+    MOV     X2, #1                  // May collect
+    B       gen_new
 
-2:  // Done
-.endm
+// Allocation of the runtime, which holds heap pointers in registers the
+// collector does not know about. Never collects
+// Input:
+//   X0 = size in bytes
+//   X1 = type descriptor
+// Output:
+//   X0 = zeroed object, following its header
+// Clobbers X1-X9, X16, X17
+runtime.rawalloc:
+    MOV     X2, #0
+gen_new:
+    STP     X29, X30, [sp, #-16]!   // Frame record for runtime.scanstack
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    ADD     X19, X0, #obj_header + 7
+    AND     X19, X19, #~7           // Header and object rounded to words
+    MOV     X20, X1
+    MOV     X21, X2
+    ADRP    X22, gen_state
+    ADD     X22, X22, :lo12:gen_state
+    MOV     X0, #LARGE_OBJECT
+    CMP     X19, X0
+    B.HI    .Lgen_new_old
+.Lgen_new_eden:
+    LDR     X0, [X22, #gen_edentop]
+    LDR     X1, [X22, #gen_edenend]
+    ADD     X2, X0, X19
+    CMP     X2, X1
+    B.HI    .Lgen_new_full
+    STR     X2, [X22, #gen_edentop]
+    B       .Lgen_new_init
+.Lgen_new_full:
+    CBZ     X21, .Lgen_new_old
+    MOV     X21, #0                 // Eden is empty after the collection
+    BL      gen_collect
+    B       .Lgen_new_eden
+.Lgen_new_old:
+    LDR     X0, [X22, #gen_oldtop]
+    ADD     X2, X0, X19
+    ADRP    X1, gc_state
+    ADD     X1, X1, :lo12:gc_state
+    LDR     X1, [X1, #gc_hi]
+    CMP     X2, X1
+    B.HI    gc_outofmemory
+    STR     X2, [X22, #gen_oldtop]
+    // Compiled code skips the barrier of stores into objects it just
+    // allocated, their cards start dirty
+    LSR     X3, X0, #CARD_SIZE_SHIFT
+    SUB     X2, X2, #1
+    LSR     X2, X2, #CARD_SIZE_SHIFT
+    ADRP    X4, runtime.cardtable
+    ADD     X4, X4, :lo12:runtime.cardtable
+    MOV     W5, #CARD_DIRTY
+1:  AND     X6, X3, #(CARD_COUNT - 1)
+    STRB    W5, [X4, X6]
+    ADD     X3, X3, #1
+    CMP     X3, X2
+    B.LS    1b
+.Lgen_new_init:                     // X0 = header
+    STR     X20, [X0, #obj_type]
+    STR     W19, [X0, #obj_size]
+    STR     WZR, [X0, #obj_color]   // Color, flags and age
+    STARTBIT X0, X1, X2, X3
+    ADD     X1, X0, #obj_header
+    ADD     X2, X0, X19
+2:  CMP     X1, X2                  // Eden is reused after minor collections
+    B.HS    3f
+    STR     XZR, [X1], #8
+    B       2b
+3:  ADD     X0, X0, #obj_header
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
 
-// Minor (Young Generation) Collection
-minor_gc:
-    INIT_FRAME 6
-    
-    // Save registers
-    stp     x19, x20, [sp, #-16]!
-    stp     x21, x22, [sp, #-16]!
-    stp     x23, x24, [sp, #-16]!
-    
-    // Copy survivors from eden to survivor space
-    adr     x19, gc_state
-    ldr     x20, [x19, #gc_gen0 + gen_start]
-    ldr     x21, [x19, #gc_gen0 + gen_top]
-    
-1:  cmp     x20, x21
-    b.ge    2f                      // Done with eden
-    
-    // Check if object survives
-    bl      is_live_object
-    cbz     x0, 3f                  // Dead object
-    
-    // Copy to survivor space
-    bl      copy_to_survivor
-    
-3:  // Next object
-    ldr     x22, [x20, #obj_size]
-    add     x20, x20, x22
-    b       1b
-    
-2:  // Reset eden
-    ldr     x20, [x19, #gc_gen0 + gen_start]
-    str     x20, [x19, #gc_gen0 + gen_top]
-    
-    // Process survivors
-    bl      age_survivors
-    
-    // Restore registers
-    ldp     x23, x24, [sp], #16
-    ldp     x21, x22, [sp], #16
-    ldp     x19, x20, [sp], #16
-    
-    SAFE_STACK_RET 6
+// Minor collection. Roots, tenured objects on dirty cards and the copies
+// themselves are scanned for young pointers, the objects they point to are
+// copied breadth first and the pointers updated to the copies
+// Clobbers all caller saved registers
+gen_collect:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    ADRP    X19, gen_state
+    ADD     X19, X19, :lo12:gen_state
+    LDR     X0, [X19, #gen_oldtop]
+    STR     X0, [X19, #gen_oldscan]
+    BL      gen_scancards
+    ADR     X0, gen_forward
+    BL      runtime.scanroots
+    LDR     X20, [X19, #gen_to]     // Copies not scanned yet
+.Lgen_collect_scan:
+    LDR     X0, [X19, #gen_totop]
+    CMP     X20, X0
+    B.HS    .Lgen_collect_promoted
+    MOV     X0, X20
+    LDR     W1, [X20, #obj_size]
+    ADD     X20, X20, X1
+    ADR     X1, gen_forward
+    BL      gc_scanobject
+    B       .Lgen_collect_scan
+.Lgen_collect_promoted:
+    LDR     X0, [X19, #gen_oldscan]
+    LDR     X1, [X19, #gen_oldtop]
+    CMP     X0, X1
+    B.HS    .Lgen_collect_done
+    LDR     W1, [X0, #obj_size]
+    ADD     X1, X0, X1
+    STR     X1, [X19, #gen_oldscan]
+    ADR     X1, gen_forward
+    BL      gc_scanobject
+    B       .Lgen_collect_scan      // Promoted objects may copy more survivors
+.Lgen_collect_done:
+    // Eden and the old survivor space only hold forwarded objects now
+    LDR     X0, [X19, #gen_eden]
+    STR     X0, [X19, #gen_edentop]
+    LDR     X1, [X19, #gen_edenend]
+    BL      gc_clearstarts
+    LDR     X0, [X19, #gen_from]
+    MOV     X1, #SURVIVOR_SIZE
+    ADD     X1, X0, X1
+    BL      gc_clearstarts
+    LDR     X0, [X19, #gen_from]
+    LDP     X1, X2, [X19, #gen_to]
+    STP     X1, X2, [X19, #gen_from]
+    STP     X0, X0, [X19, #gen_to]
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
 
-// Copy Object to Survivor Space
-copy_to_survivor:
-    // Get survivor space allocation point
-    ldr     x22, [x19, #gc_gen1 + gen_top]
-    
-    // Copy object
-    ldr     x23, [x20, #obj_size]
-    mov     x0, x22                 // Destination
-    mov     x1, x20                 // Source
-    mov     x2, x23                 // Size
-    bl      memcpy
-    
-    // Update age
-    ldrb    w23, [x20, #obj_age]
-    add     w23, w23, #1
-    strb    w23, [x22, #obj_age]
-    
-    // Set forwarding pointer
-    sub     x23, x22, x20          // Offset
-    strh    w23, [x20, #obj_forward]
-    
-    // Update survivor top
-    add     x22, x22, x23
-    str     x22, [x19, #gc_gen1 + gen_top]
-    
-    ret
+// Scan the tenured objects on dirty cards. Objects are remembered before any
+// card is cleaned as a card may span several of them, forwarding dirties the
+// cards of the pointers that still point into the young generation
+// Clobbers all caller saved registers
+gen_scancards:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    ADRP    X19, gen_state
+    ADD     X19, X19, :lo12:gen_state
+    LDR     X20, [X19, #gen_oldtop]
+    ADRP    X21, runtime.cardtable
+    ADD     X21, X21, :lo12:runtime.cardtable
+    LDR     X0, [X19, #gen_old]
+1:  CMP     X0, X20
+    B.HS    4f
+    LDR     W1, [X0, #obj_size]
+    LSR     X2, X0, #CARD_SIZE_SHIFT  // First card
+    ADD     X3, X0, X1
+    SUB     X3, X3, #1
+    LSR     X3, X3, #CARD_SIZE_SHIFT  // Last card
+2:  AND     X4, X2, #(CARD_COUNT - 1)
+    LDRB    W4, [X21, X4]
+    CBNZ    W4, 3f
+    ADD     X2, X2, #1
+    CMP     X2, X3
+    B.LS    2b
+    ADD     X0, X0, X1
+    B       1b
+3:  LDRB    W4, [X0, #obj_flags]
+    ORR     W4, W4, #(1 << FLAG_REMEMBERED)
+    STRB    W4, [X0, #obj_flags]
+    ADD     X0, X0, X1
+    B       1b
+4:  // Clean the cards of the tenured space
+    LDR     X0, [X19, #gen_old]
+    LSR     X2, X0, #CARD_SIZE_SHIFT
+    SUB     X3, X20, #1
+    LSR     X3, X3, #CARD_SIZE_SHIFT
+    CMP     X0, X20
+    B.HS    6f
+5:  AND     X4, X2, #(CARD_COUNT - 1)
+    STRB    WZR, [X21, X4]
+    ADD     X2, X2, #1
+    CMP     X2, X3
+    B.LS    5b
+6:  LDR     X22, [X19, #gen_old]
+7:  CMP     X22, X20
+    B.HS    9f
+    MOV     X0, X22
+    LDR     W1, [X22, #obj_size]
+    ADD     X22, X22, X1
+    LDRB    W1, [X0, #obj_flags]
+    TBZ     W1, #FLAG_REMEMBERED, 7b
+    AND     W1, W1, #~(1 << FLAG_REMEMBERED)
+    STRB    W1, [X0, #obj_flags]
+    ADR     X1, gen_forward
+    BL      gc_scanobject
+    B       7b
+9:  LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
 
-// Age and Promote Survivors
-age_survivors:
-    // For each object in survivor space
-    ldr     x20, [x19, #gc_gen1 + gen_start]
-    ldr     x21, [x19, #gc_gen1 + gen_top]
-    
-1:  cmp     x20, x21
-    b.ge    2f                      // Done
-    
-    // Check age
-    ldrb    w22, [x20, #obj_age]
-    cmp     w22, #AGE_THRESHOLD
-    b.lt    3f                      // Not old enough
-    
-    // Promote to tenured
-    bl      promote_to_tenured
-    
-3:  // Next object
-    ldr     x22, [x20, #obj_size]
-    add     x20, x20, x22
-    b       1b
-    
-2:  ret
+// Update a pointer into eden or the old survivor space to the copy of its
+// object, copying the object first if needed. Pointers stored in tenured
+// objects that keep pointing into the young generation dirty their card
+// Input:
+//   X0 = pointer
+//   X1 = slot holding it
+// Clobbers X0-X15
+gen_forward:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    MOV     X6, X1                  // Slot
+    MOV     X7, X0                  // Pointer
+    ADRP    X8, gen_state
+    ADD     X8, X8, :lo12:gen_state
+    SUB     X9, X7, #obj_header
+    LDP     X10, X11, [X8, #gen_eden]
+    CMP     X9, X10
+    B.LO    .Lgen_forward_done
+    CMP     X9, X11
+    B.LO    .Lgen_forward_young
+    LDR     X10, [X8, #gen_from]
+    LDR     X11, [X8, #gen_fromtop]
+    CMP     X9, X10
+    B.LO    .Lgen_forward_done
+    CMP     X9, X11
+    B.HS    .Lgen_forward_done
+.Lgen_forward_young:
+    MOV     X0, X7
+    BL      gc_findobject
+    MOV     X12, X0                 // Header of the object
+    LDRB    W1, [X12, #obj_flags]
+    LDR     X13, [X12, #obj_type]   // Header of the copy once forwarded
+    TBNZ    W1, #FLAG_FORWARDED, .Lgen_forward_update
+    // Copy to the survivor space, or promote it
+    LDR     W1, [X12, #obj_size]
+    LDRB    W2, [X12, #obj_age]
+    ADD     W2, W2, #1
+    CMP     W2, #AGE_THRESHOLD
+    B.HS    .Lgen_forward_promote
+    LDR     X13, [X8, #gen_totop]
+    LDR     X3, [X8, #gen_to]
+    MOV     X4, #SURVIVOR_SIZE
+    ADD     X3, X3, X4
+    ADD     X4, X13, X1
+    CMP     X4, X3
+    B.HI    .Lgen_forward_promote   // Survivor space full
+    STR     X4, [X8, #gen_totop]
+    B       .Lgen_forward_copy
+.Lgen_forward_promote:
+    LDR     X13, [X8, #gen_oldtop]
+    ADD     X4, X13, X1
+    ADRP    X3, gc_state
+    ADD     X3, X3, :lo12:gc_state
+    LDR     X3, [X3, #gc_hi]
+    CMP     X4, X3
+    B.HI    gc_outofmemory
+    STR     X4, [X8, #gen_oldtop]
+.Lgen_forward_copy:
+    MOV     X3, #0
+1:  LDR     X4, [X12, X3]
+    STR     X4, [X13, X3]
+    ADD     X3, X3, #8
+    CMP     X3, X1
+    B.LO    1b
+    STRB    W2, [X13, #obj_age]
+    STARTBIT X13, X3, X4, X5
+    STR     X13, [X12, #obj_type]
+    MOV     W1, #(1 << FLAG_FORWARDED)
+    STRB    W1, [X12, #obj_flags]
+.Lgen_forward_update:
+    SUB     X7, X7, X12
+    ADD     X7, X13, X7
+    STR     X7, [X6]
+    // Remember tenured slots pointing to the survivor space
+    LDR     X10, [X8, #gen_old]
+    CMP     X6, X10
+    B.LO    .Lgen_forward_done
+    ADRP    X10, gc_state
+    ADD     X10, X10, :lo12:gc_state
+    LDR     X10, [X10, #gc_hi]
+    CMP     X6, X10
+    B.HS    .Lgen_forward_done
+    LDR     X10, [X8, #gen_to]
+    CMP     X13, X10
+    B.LO    .Lgen_forward_done
+    LDR     X10, [X8, #gen_old]
+    CMP     X13, X10
+    B.HS    .Lgen_forward_done
+    LSR     X10, X6, #CARD_SIZE_SHIFT
+    AND     X10, X10, #(CARD_COUNT - 1)
+    ADRP    X11, runtime.cardtable
+    ADD     X11, X11, :lo12:runtime.cardtable
+    MOV     W1, #CARD_DIRTY
+    STRB    W1, [X11, X10]
+.Lgen_forward_done:
+    LDP     X29, X30, [sp], #16
+    RET
 
-// Promote Object to Tenured Space  
-promote_to_tenured:
-    // Get tenured allocation point
-    ldr     x22, [x19, #gc_gen2 + gen_top]
-    
-    // Copy object
-    ldr     x23, [x20, #obj_size]
-    mov     x0, x22
-    mov     x1, x20
-    mov     x2, x23
-    bl      memcpy
-    
-    // Update generation
-    mov     w23, #2                 // Tenured generation
-    strb    w23, [x22, #obj_gen]
-    
-    // Update tenured top
-    add     x22, x22, x23
-    str     x22, [x19, #gc_gen2 + gen_top]
-    
-    ret
\ No newline at end of file
+// Write barrier of values the runtime copies into the heap eg channel
+// buffers, dirties every card spanned by the pointer words
+// Input:
+//   X0 = address of the value
+//   X1 = type descriptor
+// Clobbers X2-X4, X16, X17
+runtime.typedbarrier:
+    LDR     X16, [X1, #type_ptrdata]
+    CBZ     X16, 2f
+    ADD     X16, X0, X16
+    SUB     X16, X16, #1
+    LSR     X16, X16, #CARD_SIZE_SHIFT  // Last card
+    LSR     X17, X0, #CARD_SIZE_SHIFT   // First card
+    ADRP    X2, runtime.cardtable
+    ADD     X2, X2, :lo12:runtime.cardtable
+    MOV     W3, #CARD_DIRTY
+1:  AND     X4, X17, #(CARD_COUNT - 1)
+    STRB    W3, [X2, X4]
+    ADD     X17, X17, #1
+    CMP     X17, X16
+    B.LS    1b
+2:  RET
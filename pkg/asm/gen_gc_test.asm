@@ -2,149 +2,117 @@
 .text
 .global main
 
-// Initialize Generational GC
-init_gen_gc:
-    // Allocate generation spaces
-    mov     x0, #0
-    ldr     x1, =GEN0_SIZE
-    mov     x2, #(PROT_READ | PROT_WRITE)
-    mov     x3, #(MAP_PRIVATE | MAP_ANONYMOUS)
-    mov     x8, #SYS_mmap
-    svc     #0
-    
-    // Initialize eden space (gen0)
-    adr     x19, gc_state
-    str     x0, [x19, #gc_gen0 + gen_start]
-    add     x1, x0, #GEN0_SIZE
-    str     x1, [x19, #gc_gen0 + gen_end]
-    str     x0, [x19, #gc_gen0 + gen_top]
-    
-    // Allocate and init survivor space (gen1)
-    add     x0, x1, #16            // Align to 16 bytes
-    ldr     x1, =GEN1_SIZE
-    mov     x8, #SYS_mmap
-    svc     #0
-    
-    str     x0, [x19, #gc_gen1 + gen_start]
-    add     x1, x0, #GEN1_SIZE
-    str     x1, [x19, #gc_gen1 + gen_end]
-    str     x0, [x19, #gc_gen1 + gen_top]
-    
-    // Allocate and init tenured space (gen2)
-    add     x0, x1, #16
-    ldr     x1, =GEN2_SIZE
-    mov     x8, #SYS_mmap
-    svc     #0
-    
-    str     x0, [x19, #gc_gen2 + gen_start]
-    add     x1, x0, #GEN2_SIZE
-    str     x1, [x19, #gc_gen2 + gen_end]
-    str     x0, [x19, #gc_gen2 + gen_top]
-    ret
-
-// Example: Allocating Different Types of Objects
+// Example: Allocating Different Types of Objects. The macros end with the
+// call so a label following them is its return address, the stack maps
+// below are looked up by it
 .macro ALLOC_STRING size
-    // Calculate total size including header
-    add     x0, \size, #16         // Add header size
-    mov     x1, #TYPE_STRING       // String type ID
-    GEN_ALLOC x0, x1              // Allocate in eden
+    mov     x0, \size              // Size without the header
+    adr     x1, type.string_bytes  // Type descriptor
+    bl      runtime.alloc          // Allocate in eden
 .endm
 
 .macro ALLOC_ARRAY length, elem_size
     // Calculate array size
     mov     x0, \length
-    mul     x0, x0, \elem_size
-    add     x0, x0, #24           // Header + length field
-    mov     x1, #TYPE_ARRAY       // Array type ID
-    GEN_ALLOC x0, x1             // Allocate in eden
-    
-    // Initialize length field
-    str     \length, [x0, #16]    // Store length after header
+    mov     x1, \elem_size
+    mul     x0, x0, x1
+    add     x0, x0, #8            // Length field
+    adr     x1, type.int_array    // Type descriptor
+    bl      runtime.alloc         // Allocate in eden
 .endm
 
-// Example Usage in Application Code
+// Example Usage in Application Code. Pointers live across calls are kept
+// in frame words described by go.stackmaps, a minor collection moving the
+// objects updates them
 example_allocation:
     // Save frame
     stp     x29, x30, [sp, #-16]!
     mov     x29, sp
-    
+    stp     xzr, xzr, [sp, #-16]!   // Frame words of the pointers
+    str     x19, [sp, #-16]!
+
     // Allocate a string
-    ALLOC_STRING 32               // 32-byte string
-    mov     x20, x0               // Save string pointer
-    
+    ALLOC_STRING #32              // 32-byte string
+    str     x0, [x29, #-8]        // Save string pointer
+
     // Allocate an array
-    ALLOC_ARRAY 100, 4           // Array of 100 integers
-    mov     x21, x0               // Save array pointer
-    
+    ALLOC_ARRAY #100, #4          // Array of 100 integers
+.Lexample_array:
+    mov     x1, #100
+    str     x1, [x0]              // Initialize length field
+    str     x0, [x29, #-16]       // Save array pointer
+
     // Create some garbage
-    mov     x22, #0
-1:  ALLOC_STRING 16              // Temporary strings
-    add     x22, x22, #1
-    cmp     x22, #1000
+    mov     x19, #0
+1:  ALLOC_STRING #16              // Temporary strings
+.Lexample_garbage:
+    add     x19, x19, #1
+    cmp     x19, #1000
     b.lt    1b
-    
+
     // Minor GC will happen automatically when eden fills
-    
-    // Access survivors (they'll be in gen1)
-    ldr     x0, [x20]            // Load from string
-    str     w0, [x21, #24]       // Store to array
-    
+
+    // Access survivors (they'll be in a survivor space)
+    ldr     x0, [x29, #-8]
+    ldr     x0, [x0]             // Load from string
+    ldr     x1, [x29, #-16]
+    str     w0, [x1, #8]         // Store to array
+
     // Restore frame and return
+    ldr     x19, [sp], #32
     ldp     x29, x30, [sp], #16
     ret
 
-// Example Root Registration
-register_thread_roots:
-    // Register stack roots
-    mov     x0, sp               // Stack pointer
-    mov     x1, x29              // Frame pointer
-    bl      scan_stack_roots
-    
-    // Register global roots
-    adr     x0, global_roots
-    mov     x1, #GLOBAL_ROOT_COUNT
-    bl      register_root_range
-    ret
-
 // Main Entry Point
 main:
     // Initialize GC
-    bl      init_gen_gc
-    
-    // Register roots
-    bl      register_thread_roots
-    
+    bl      runtime.gcinit
+
     // Run application code
     bl      example_allocation
-    
+
     // Exit
     mov     x0, #0
-    mov     x8, #SYS_exit
+    mov     x8, #93              // exit
     svc     #0
 
-// Data Section
-.data
-// Type descriptors
-.align 3
-type_table:
-    .quad TYPE_STRING_DESC
-    .quad TYPE_ARRAY_DESC
-    
-// Global roots
-global_roots:
-    .space 64                    // Space for global root pointers
+// Stack maps of the calls, see pkg/mapper/stackmap.go. The string sits in
+// the first frame word below x29 and the array in the second
+.section .rodata
+.balign 8
+go.stackmaps:
+    .quad 2                      // Entries
+    .quad .Lexample_array, 1, gcbits.01
+    .quad .Lexample_garbage, 2, gcbits.03
+go.gcroots:
+    .quad 0                      // No globals hold pointers
+gcbits.01:
+    .byte 0x01
+gcbits.03:
+    .byte 0x03
+
+// Type descriptors in the layout the compiler emits for every allocated
+// type, see pkg/mapper/typedesc.go. Neither example type holds pointers so
+// their bitmaps are empty
+.section .rodata
+.balign 8
+type.string_bytes:
+    .quad 32                     // Size
+    .word 0                      // Hash
+    .byte 17                     // Kind, array
+    .byte 1                      // Alignment
+    .zero 2
+    .quad 0, 0                   // Name
+    .quad 0                      // No pointer words
+    .quad 0                      // No pointer bitmap
 
-// String type descriptor
-TYPE_STRING_DESC:
-    .quad 0                      // No fields to scan
-    .word 0                      // No field count
-    .word TYPE_STRING           // Type ID
-    
-// Array type descriptor    
-TYPE_ARRAY_DESC:
-    .quad array_elem_offsets    // Field offset table
-    .word 1                     // One field (length)
-    .word TYPE_ARRAY           // Type ID
-    
-array_elem_offsets:
-    .quad 24                    // Offset to first element
\ No newline at end of file
+.balign 8
+type.int_array:
+    .quad 408                    // Size, length word and 100 int32s
+    .word 0
+    .byte 25                     // Kind, struct
+    .byte 8
+    .zero 2
+    .quad 0, 0
+    .quad 0
+    .quad 0
\ No newline at end of file
@@ -1,3 +1,21 @@
+// Itab layout, kept in sync with pkg/mapper/itab.go
+.equ itab_inter,     0    // Interface type descriptor
+.equ itab_type,      8    // Concrete type descriptor
+.equ itab_hash,      16   // Hash of the concrete type
+.equ offset_vtable,  24   // Method table
+
+// Type descriptor layout, kept in sync with pkg/mapper/typedesc.go
+.equ type_size,      0
+.equ type_hash,      8
+.equ type_kind,      12
+.equ type_align,     13
+.equ type_name,      16   // Name string (ptr, len)
+.equ type_ptrdata,   32   // Bytes of the prefix holding pointers
+.equ type_gcdata,    40   // Pointer bitmap, one bit per word
+.equ type_keyalg,    48   // Key algorithm of map keys, 0 when not comparable
+
+.text
+
 // Input:
 //   X0 = interface value
 //   X1 = method index
@@ -7,18 +25,187 @@
 interface_dispatch:
     // Load itab pointer from interface
     LDR     X0, [X0]
-    
+
     // Check if itab is nil
     CBZ     X0, .Lnil_panic
-    
+
     // Load method address from vtable
     ADD     X0, X0, #offset_vtable
     LDR     X0, [X0, X1, LSL #3]    // Scale index by 8 (pointer size)
-    
+
     // Return method address
     RET
 
 .Lnil_panic:
-    // Handle nil interface panic
-    // This will be replaced with a call to the arGo runtime
-    B       runtime.nilPanic
\ No newline at end of file
+    // Method call on a nil interface
+    B       runtime.panicnil
+
+// Find the itab of a concrete type for an interface. The compiler emits every
+// itab a type assertion can need and lists them in go.itablinks
+// Input:
+//   X0 = interface type descriptor
+//   X1 = concrete type descriptor, 0 for a nil interface
+// Output:
+//   X0 = itab, 0 if the type does not implement the interface
+//   Clobbers X2-X5
+runtime.getitab:
+    ADRP    X2, go.itablinks
+    ADD     X2, X2, :lo12:go.itablinks
+    LDR     X3, [X2], #8            // Number of itabs
+.Lgetitab_loop:
+    CBZ     X3, .Lgetitab_miss
+    LDR     X4, [X2], #8
+    SUB     X3, X3, #1
+    LDR     X5, [X4, #itab_inter]
+    CMP     X5, X0
+    B.NE    .Lgetitab_loop
+    LDR     X5, [X4, #itab_type]
+    CMP     X5, X1
+    B.NE    .Lgetitab_loop
+    MOV     X0, X4
+    RET
+.Lgetitab_miss:
+    MOV     X0, #0
+    RET
+
+// Panic for a failed type assertion x.(T) with a concrete T
+//   panic: interface conversion: I is H, not T
+// Input:
+//   X0 = dynamic type descriptor of x, 0 for a nil interface
+//   X1 = asserted type descriptor
+//   X2 = static type descriptor of x
+// Does not return
+runtime.panicdottype:
+    MOV     X19, X0
+    MOV     X20, X1
+    MOV     X21, X2
+    ADR     X0, .Lconversion_msg
+    MOV     X1, #(.Lconversion_end - .Lconversion_msg)
+    BL      runtime.printstderr
+    MOV     X0, X21
+    BL      runtime.printtypename
+    ADR     X0, .Lis_msg
+    MOV     X1, #4
+    BL      runtime.printstderr
+    MOV     X0, X19
+    BL      runtime.printtypename
+    ADR     X0, .Lnot_msg
+    MOV     X1, #6
+    BL      runtime.printstderr
+    MOV     X0, X20
+    BL      runtime.printtypename
+    B       .Lpanic_exit
+
+// Panic for a failed type assertion x.(I) with an interface I
+//   panic: interface conversion: H is not I
+// Input:
+//   X0 = dynamic type descriptor of x, 0 for a nil interface
+//   X1 = asserted interface type descriptor
+// Does not return
+runtime.panicdottypeI:
+    MOV     X19, X0
+    MOV     X20, X1
+    ADR     X0, .Lconversion_msg
+    MOV     X1, #(.Lconversion_end - .Lconversion_msg)
+    BL      runtime.printstderr
+    CBZ     X19, .Lnil_conversion
+    MOV     X0, X19
+    BL      runtime.printtypename
+    ADR     X0, .Lisnot_msg
+    MOV     X1, #8
+    BL      runtime.printstderr
+    MOV     X0, X20
+    BL      runtime.printtypename
+    B       .Lpanic_exit
+.Lnil_conversion:
+    ADR     X0, .Lnil_iface_msg
+    MOV     X1, #(.Lnil_iface_end - .Lnil_iface_msg)
+    BL      runtime.printstderr
+    MOV     X0, X20
+    BL      runtime.printtypename
+.Lpanic_exit:
+    ADR     X0, .Lnewline
+    MOV     X1, #1
+    BL      runtime.printstderr
+    MOV     X0, #2                  // Exit code of an uncaught panic
+    MOV     X8, #94                 // exit_group
+    SVC     #0
+
+// Panic for a value method called through a nil pointer
+//   panic: value method T.M called using nil pointer
+// Input:
+//   X0, X1 = type name
+//   X2, X3 = method name
+// Does not return
+runtime.panicwrap:
+    MOV     X19, X0
+    MOV     X20, X1
+    MOV     X21, X2
+    MOV     X22, X3
+    ADR     X0, .Lvalue_method_msg
+    MOV     X1, #(.Lvalue_method_end - .Lvalue_method_msg)
+    BL      runtime.printstderr
+    MOV     X0, X19
+    MOV     X1, X20
+    BL      runtime.printstderr
+    ADR     X0, .Ldot_msg
+    MOV     X1, #1
+    BL      runtime.printstderr
+    MOV     X0, X21
+    MOV     X1, X22
+    BL      runtime.printstderr
+    ADR     X0, .Lnil_pointer_msg
+    MOV     X1, #(.Lnil_pointer_end - .Lnil_pointer_msg)
+    BL      runtime.printstderr
+    B       .Lpanic_exit
+
+// Print the name of a type descriptor, nil for 0
+// Input:
+//   X0 = type descriptor
+runtime.printtypename:
+    CBZ     X0, .Lprint_nil
+    LDR     X1, [X0, #type_name + 8]
+    LDR     X0, [X0, #type_name]
+    B       runtime.printstderr
+.Lprint_nil:
+    ADR     X0, .Lnil_msg
+    MOV     X1, #3
+    B       runtime.printstderr
+
+// Write a string to stderr
+// Input:
+//   X0 = pointer
+//   X1 = length
+runtime.printstderr:
+    MOV     X2, X1
+    MOV     X1, X0
+    MOV     X0, #2                  // stderr
+    MOV     X8, #64                 // write
+    SVC     #0
+    RET
+
+.Lconversion_msg:
+    .ascii  "panic: interface conversion: "
+.Lconversion_end:
+.Lnil_iface_msg:
+    .ascii  "interface is nil, not "
+.Lnil_iface_end:
+.Lis_msg:
+    .ascii  " is "
+.Lnot_msg:
+    .ascii  ", not "
+.Lisnot_msg:
+    .ascii  " is not "
+.Lnil_msg:
+    .ascii  "nil"
+.Lvalue_method_msg:
+    .ascii  "panic: value method "
+.Lvalue_method_end:
+.Ldot_msg:
+    .ascii  "."
+.Lnil_pointer_msg:
+    .ascii  " called using nil pointer"
+.Lnil_pointer_end:
+.Lnewline:
+    .ascii  "\n"
+    .balign 4
@@ -0,0 +1,338 @@
+package asm
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Common lists the runtime files every program links against, the files of
+// the selected collector follow them
+var Common = []string{"interface.asm", "defer.asm", "sched.asm", "chan.asm", "map.asm", "string.asm", "print.asm", "fmt.asm"}
+
+// unit is a piece of a runtime file linked as a whole. Definitions are
+// constants, structures and macros, routines are code or data starting at a
+// label and running up to the next routine
+type unit struct {
+	file    string
+	def     bool
+	section string // section of a routine
+	defines []string
+	names   []string // mnemonics, directives and macros
+	refs    []string // symbols of the operands
+	lines   []string
+	next    *unit // routine reached by falling off the end of this one
+}
+
+var (
+	labelDef   = regexp.MustCompile(`^("[^"]+"|[A-Za-z_.$][\w.$]*):`)
+	leadLabel  = regexp.MustCompile(`^\s*("[^"]+"|[A-Za-z_.$][\w.$]*|\d+):`)
+	token      = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'\\?.'?|\\\w+|:\w+:|[%@]?[A-Za-z_.$][\w.$]*|\d[\w.]*`)
+	register   = regexp.MustCompile(`(?i)^([xwbhsdqv]([12]?\d|3[01])(\.\w+)?|w?sp|[xw]zr|lr|fp|p(ld|st|li)l[123](keep|strm))$`)
+	terminator = regexp.MustCompile(`(?i)^(b|br|ret|eret)\b`)
+)
+
+// keywords are the operands of instructions that are not symbols: shifts,
+// extensions, conditions, system registers and barrier options
+var keywords = map[string]bool{
+	"lsl": true, "lsr": true, "asr": true, "ror": true, "msl": true,
+	"uxtb": true, "uxth": true, "uxtw": true, "uxtx": true,
+	"sxtb": true, "sxth": true, "sxtw": true, "sxtx": true,
+	"eq": true, "ne": true, "cs": true, "hs": true, "cc": true, "lo": true, "mi": true, "pl": true,
+	"vs": true, "vc": true, "hi": true, "ls": true, "ge": true, "lt": true, "gt": true, "le": true,
+	"al": true, "nv": true,
+	"tpidr_el0": true, "nzcv": true, "fpcr": true, "fpsr": true, "cntvct_el0": true, "cntfrq_el0": true,
+	"sy": true, "ish": true, "ishld": true, "ishst": true, "osh": true, "oshld": true, "oshst": true,
+	"nsh": true, "nshld": true, "nshst": true, "ld": true, "st": true,
+}
+
+// expressions lists the directives whose operands are expressions that may
+// refer to symbols, the operands of the others are names, strings or flags
+var expressions = map[string]bool{
+	".quad": true, ".xword": true, ".dword": true, ".8byte": true, ".word": true, ".long": true,
+	".int": true, ".4byte": true, ".hword": true, ".short": true, ".2byte": true, ".byte": true,
+	".space": true, ".skip": true, ".zero": true, ".fill": true, ".balign": true, ".align": true,
+	".p2align": true, ".equ": true, ".set": true, ".org": true, ".inst": true,
+}
+
+// Link returns the runtime routines referenced by the program, and the ones
+// they reference in turn, taken from the embedded files. Every file is
+// emitted once behind an include guard. Referencing a symbol that neither the
+// program nor the linked runtime defines is an error, the runtime does not
+// depend on any library
+func Link(program string, files []string) (string, error) {
+	defined := make(map[string]*unit)
+	var units []*unit
+	for _, name := range files {
+		src, err := Macros.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("reading runtime %s: %w", name, err)
+		}
+		for _, u := range parseUnits(name, string(src)) {
+			for _, sym := range u.defines {
+				if prev, ok := defined[sym]; ok {
+					return "", fmt.Errorf("runtime symbol %s defined in %s and %s", sym, prev.file, u.file)
+				}
+				defined[sym] = u
+			}
+			units = append(units, u)
+		}
+	}
+
+	own := make(map[string]bool)
+	for _, line := range strings.Split(program, "\n") {
+		if m := labelDef.FindStringSubmatch(line); m != nil {
+			own[strings.Trim(m[1], `"`)] = true
+		}
+		if name, ok := assignment(line); ok {
+			own[name] = true
+		}
+	}
+
+	used := make(map[*unit]bool)
+	var missing []string
+	var resolve func(names, refs []string, from string)
+	resolve = func(names, refs []string, from string) {
+		for i, sym := range append(names, refs...) {
+			if own[sym] {
+				continue
+			}
+			u, ok := defined[sym]
+			if !ok {
+				// Mnemonics are names nothing defines, operands have to resolve
+				if i >= len(names) {
+					missing = append(missing, fmt.Sprintf("%s (referenced by %s)", sym, from))
+				}
+				continue
+			}
+			for ; u != nil && !used[u]; u = u.next {
+				used[u] = true
+				resolve(u.names, u.refs, u.file)
+			}
+		}
+	}
+	names, refs := references(program)
+	resolve(names, refs, "program")
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("undefined runtime symbols: %s", strings.Join(slices.Compact(missing), ", "))
+	}
+
+	var sb strings.Builder
+	for _, name := range files {
+		var defs, routines []*unit
+		for _, u := range units {
+			if u.file != name || !used[u] {
+				continue
+			}
+			if u.def {
+				defs = append(defs, u)
+			} else {
+				routines = append(routines, u)
+			}
+		}
+		if len(defs)+len(routines) == 0 {
+			continue
+		}
+		guard := "garm.runtime." + strings.TrimSuffix(path.Base(name), ".asm")
+		sb.WriteString(fmt.Sprintf("\n// runtime %s\n.ifndef %s\n.set %s, 1\n", name, guard, guard))
+		for _, u := range defs {
+			sb.WriteString(strings.Join(u.lines, "\n") + "\n")
+		}
+		section := ""
+		for _, u := range routines {
+			if u.section != section {
+				section = u.section
+				sb.WriteString(section + "\n")
+			}
+			sb.WriteString(strings.Join(u.lines, "\n") + "\n")
+		}
+		sb.WriteString(".endif\n")
+	}
+	return sb.String(), nil
+}
+
+// parseUnits splits a runtime file into definitions and routines. A label
+// following a blank line starts a routine, labels directly after code are
+// entry points sharing the routine
+func parseUnits(file, src string) (units []*unit) {
+	section := ".text"
+	var cur, block *unit
+	var pending []string // comments and alignment of the next routine
+	boundary := true
+	for _, line := range strings.Split(src, "\n") {
+		code := strings.TrimSpace(stripComment(line))
+		directive := ""
+		if fields := strings.Fields(code); len(fields) > 0 {
+			directive = fields[0]
+		}
+		switch {
+		case block != nil:
+			block.lines = append(block.lines, line)
+			if m := labelDef.FindStringSubmatch(line); m != nil && directive != ".endm" {
+				block.defines = append(block.defines, m[1])
+			}
+			if directive == ".endm" || directive == ".end" {
+				block = nil
+			}
+			continue
+		case directive == ".macro" || directive == ".struct":
+			block = &unit{file: file, def: true, lines: []string{line}}
+			if directive == ".macro" {
+				block.defines = []string{strings.Trim(strings.Fields(code)[1], ",")}
+			}
+			units = append(units, block)
+			continue
+		case directive == ".equ" || directive == ".set":
+			name, _ := assignment(code)
+			units = append(units, &unit{file: file, def: true, defines: []string{name}, lines: []string{line}})
+			continue
+		case directive == ".text" || directive == ".data" || directive == ".bss" || directive == ".section":
+			section = code
+			boundary = true
+			continue
+		case directive == ".include" || directive == ".global" || directive == ".globl":
+			continue
+		case code == "":
+			if strings.TrimSpace(line) == "" {
+				boundary = true
+				pending = pending[:0]
+			} else {
+				pending = append(pending, line)
+			}
+			continue
+		case boundary && (directive == ".balign" || directive == ".align" || directive == ".p2align"):
+			pending = append(pending, line)
+			continue
+		}
+
+		m := labelDef.FindStringSubmatch(code)
+		if m != nil && (boundary || cur == nil) {
+			u := &unit{file: file, section: section, lines: append([]string(nil), pending...)}
+			if cur != nil && !cur.terminated() {
+				cur.next = u
+			}
+			cur = u
+			units = append(units, cur)
+		} else if cur == nil {
+			continue // stray code outside of any routine
+		} else {
+			cur.lines = append(cur.lines, pending...)
+		}
+		pending = pending[:0]
+		boundary = false
+		if m != nil {
+			cur.defines = append(cur.defines, m[1])
+		}
+		cur.lines = append(cur.lines, line)
+	}
+	for _, u := range units {
+		u.names, u.refs = references(strings.Join(u.lines, "\n"))
+	}
+	return units
+}
+
+// terminated reports whether the routine ends in an unconditional branch or
+// data, so execution never falls through into the next one
+func (u *unit) terminated() bool {
+	for i := len(u.lines) - 1; i >= 0; i-- {
+		code := strings.TrimSpace(stripComment(u.lines[i]))
+		if m := labelDef.FindStringIndex(code); m != nil {
+			code = strings.TrimSpace(code[m[1]:])
+		}
+		if code == "" {
+			continue
+		}
+		return strings.HasPrefix(code, ".") || terminator.MatchString(code)
+	}
+	return false
+}
+
+// references returns the names a piece of assembly source uses: the first
+// word of every statement, which is either a mnemonic, a directive or a
+// macro, and the symbols of the operands. Registers, shift and condition
+// keywords, numbers and macro parameters are no symbols, neither are the
+// operands of directives taking names or strings
+func references(src string) (names, refs []string) {
+	for _, line := range strings.Split(src, "\n") {
+		code := statement(line)
+		for m := leadLabel.FindStringIndex(code); m != nil; m = leadLabel.FindStringIndex(code) {
+			code = code[m[1]:]
+		}
+		tokens := token.FindAllString(code, -1)
+		if len(tokens) == 0 {
+			continue
+		}
+		first, operands := tokens[0], tokens[1:]
+		names = append(names, first)
+		if strings.HasPrefix(first, ".") {
+			if !expressions[strings.ToLower(first)] {
+				continue
+			}
+			if first == ".equ" || first == ".set" {
+				operands = operands[min(1, len(operands)):] // the name assigned
+			}
+		}
+		for _, t := range operands {
+			switch {
+			case strings.HasPrefix(t, `"`):
+				refs = append(refs, strings.Trim(t, `"`))
+			case strings.ContainsAny(t[:1], `'\:%@0123456789`), t == ".":
+			case register.MatchString(t), keywords[strings.ToLower(t)]:
+			default:
+				refs = append(refs, t)
+			}
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(refs)
+	return slices.Compact(names), slices.Compact(refs)
+}
+
+// assignment returns the symbol an .equ or .set statement assigns
+func assignment(line string) (string, bool) {
+	fields := strings.Fields(statement(line))
+	if len(fields) < 2 || fields[0] != ".equ" && fields[0] != ".set" {
+		return "", false
+	}
+	return strings.TrimSuffix(fields[1], ","), true
+}
+
+// statement removes a // comment from a line, string literals are kept
+func statement(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == '/' && i+1 < len(line) && line[i+1] == '/':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// stripComment removes a // comment and the contents of string literals
+func stripComment(line string) string {
+	var sb strings.Builder
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '/' && i+1 < len(line) && line[i+1] == '/':
+			return sb.String()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
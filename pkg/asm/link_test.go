@@ -0,0 +1,103 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLink(t *testing.T) {
+	files := append(append([]string(nil), Common...), "alloc.asm")
+
+	t.Run("pulls referenced routines once", func(t *testing.T) {
+		out, err := Link("main:\n\tBL runtime.gopanic\n\tBL runtime.printstderr\n\t.section .rodata\ntype.string:\n\t.quad 16\n", files)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(out, "\nruntime.gopanic:"))
+		assert.Equal(t, 1, strings.Count(out, "\nruntime.printstderr:"))
+		assert.Contains(t, out, "\nruntime.rundefers:", "gopanic runs the deferred calls")
+		assert.Contains(t, out, ".ifndef garm.runtime.defer\n.set garm.runtime.defer, 1\n")
+		assert.NotContains(t, out, "runtime.alloc:", "unreferenced files are left out")
+	})
+
+	t.Run("follows fall through entry points", func(t *testing.T) {
+		out, err := Link("main:\n\tBL runtime.printint\n", files)
+		require.NoError(t, err)
+		assert.Contains(t, out, "\nruntime.printuint:")
+	})
+
+	t.Run("program symbols win", func(t *testing.T) {
+		out, err := Link("runtime.alloc:\n\tRET\nmain:\n\tBL runtime.alloc\n", files)
+		require.NoError(t, err)
+		assert.Empty(t, out)
+	})
+
+	t.Run("missing runtime symbol", func(t *testing.T) {
+		_, err := Link("main:\n\tBL runtime.missing\n", files)
+		assert.ErrorContains(t, err, "undefined runtime symbols: runtime.missing (referenced by program)")
+	})
+
+	t.Run("undefined operands", func(t *testing.T) {
+		_, err := Link("main:\n\tADRP x0, counter\n\tLDR x1, [x0, :lo12:counter]\n\tBL helper\n\tB.EQ 1f\n1:\tRET\n", files)
+		assert.ErrorContains(t, err, "undefined runtime symbols: counter (referenced by program), helper (referenced by program)")
+	})
+
+	t.Run("every runtime routine resolves with each collector", func(t *testing.T) {
+		// Symbols the compiler emits into every program the runtime uses
+		const emitted = "\t.section .rodata\ngo.maxprocs:\ngo.itablinks:\ngo.stackmaps:\ngo.gcroots:\ntype.string:\ntype.uint8:\n\t.quad 0\n"
+		for _, gc := range [][]string{
+			{"runtime.asm", "gen_gc.asm", "stackmap.asm"},
+			{"runtime.asm", "marksweep.asm", "stackmap.asm"},
+			{"alloc.asm"},
+		} {
+			files := append(append([]string(nil), Common...), gc...)
+			var program strings.Builder
+			program.WriteString("main:\n")
+			for _, name := range files {
+				src, err := Macros.ReadFile(name)
+				require.NoError(t, err)
+				for _, u := range parseUnits(name, string(src)) {
+					for _, sym := range u.defines {
+						if !u.def && !strings.HasPrefix(sym, ".L") {
+							program.WriteString("\t.quad " + sym + "\n")
+						}
+					}
+				}
+			}
+			_, err := Link(program.String()+emitted, files)
+			require.NoError(t, err, gc)
+		}
+	})
+
+	t.Run("scheduler and channels link with every collector", func(t *testing.T) {
+		program := "main:\n\tBL runtime.schedinit\n\tBL runtime.newproc\n\tBL runtime.gcsafepoint\n" +
+			"\tBL runtime.chansend\n\tBL runtime.selectgo\n" +
+			"\t.section .rodata\ngo.maxprocs:\n\t.quad 1\ntype.string:\n\t.quad 16\n"
+		for _, gc := range [][]string{
+			{"runtime.asm", "gen_gc.asm", "stackmap.asm"},
+			{"runtime.asm", "marksweep.asm", "stackmap.asm"},
+			{"alloc.asm"},
+		} {
+			out, err := Link(program, append(append([]string(nil), Common...), gc...))
+			require.NoError(t, err, gc)
+			assert.Contains(t, out, "\nruntime.schedule:")
+			assert.Contains(t, out, "\nruntime.typedbarrier:", "channel copies go through the barrier")
+		}
+	})
+
+	t.Run("maps pull hashing and panics", func(t *testing.T) {
+		out, err := Link("main:\n\tBL runtime.mapassign\n\t.section .rodata\ntype.string:\n\t.quad 16\n", files)
+		require.NoError(t, err)
+		assert.Contains(t, out, "\nruntime.typehash:")
+		assert.Contains(t, out, "\nruntime.mapresize:", "inserts grow the entries")
+		assert.Contains(t, out, "\nruntime.panicstring:")
+	})
+	t.Run("printing floats pulls the formatter", func(t *testing.T) {
+		out, err := Link("main:\n\tBL runtime.printfloat64\n\tBL runtime.fmtprintln\n", files)
+		require.NoError(t, err)
+		assert.Contains(t, out, "\nruntime.formatfloat:")
+		assert.Contains(t, out, "\nruntime.write:")
+		assert.Equal(t, 1, strings.Count(out, "\nruntime.formatfloat:"))
+	})
+}
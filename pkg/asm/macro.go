@@ -3,6 +3,11 @@ package asm
 import (
 	"embed"
 	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Macro instructions
@@ -12,10 +17,317 @@ type Macro string
 var Macros embed.FS
 
 func LoadMacro(name string) (string, error) {
-	path := fmt.Sprintf("macros/%s.asm", name)
+	path := fmt.Sprintf("%s.asm", name)
 	data, err := Macros.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
 	return string(data), nil
 }
+
+// maxDepth bounds nested macro invocations, like gas a macro calling itself
+// is only an error once it nests too deeply
+const maxDepth = 32
+
+// Definition is a .macro ... .endm block of the embedded runtime files
+type Definition struct {
+	Name   Macro
+	File   string
+	Params []Param
+	Body   []string
+	scope  map[Macro]*Definition // macros its body can invoke
+}
+
+// Param is a macro parameter written name, name=default, name:req or
+// name:vararg. A vararg parameter takes the remaining arguments
+type Param struct {
+	Name     string
+	Default  string
+	Required bool
+	Vararg   bool
+}
+
+var (
+	definitions map[Macro]*Definition
+	parseOnce   sync.Once
+	parseErr    error
+	expansions  atomic.Int64 // value of \@, counts expansions like gas
+)
+
+// Lookup returns the definition of a macro, test files are not searched
+func Lookup(name Macro) (*Definition, error) {
+	parseOnce.Do(func() {
+		definitions, parseErr = loadDefinitions()
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	def, ok := definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined macro %s", name)
+	}
+	return def, nil
+}
+
+func loadDefinitions() (map[Macro]*Definition, error) {
+	files, err := fs.Glob(Macros, "*.asm")
+	if err != nil {
+		return nil, err
+	}
+	defs := make(map[Macro]*Definition)
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.asm") {
+			continue
+		}
+		src, err := Macros.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		parsed, err := parseMacros(name, string(src))
+		if err != nil {
+			return nil, err
+		}
+		for _, def := range parsed {
+			if prev, ok := defs[def.Name]; ok {
+				return nil, fmt.Errorf("macro %s defined in %s and %s", def.Name, prev.File, def.File)
+			}
+			defs[def.Name] = def
+			def.scope = defs
+		}
+	}
+	return defs, nil
+}
+
+// parseMacros returns the macros defined by an assembly file
+func parseMacros(file, src string) (defs []*Definition, err error) {
+	var cur *Definition
+	for n, line := range strings.Split(src, "\n") {
+		fields := strings.Fields(stripComment(line))
+		switch {
+		case len(fields) > 0 && fields[0] == ".macro":
+			if cur != nil {
+				return nil, fmt.Errorf("%s:%d: nested .macro in %s", file, n+1, cur.Name)
+			}
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s:%d: .macro without a name", file, n+1)
+			}
+			cur = &Definition{Name: Macro(strings.TrimSuffix(fields[1], ",")), File: file}
+			params := strings.Join(fields[2:], " ")
+			for _, p := range strings.FieldsFunc(params, func(r rune) bool { return r == ',' || r == ' ' }) {
+				param, err := parseParam(p)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", file, n+1, err)
+				}
+				cur.Params = append(cur.Params, param)
+			}
+		case len(fields) > 0 && fields[0] == ".endm":
+			if cur == nil {
+				return nil, fmt.Errorf("%s:%d: .endm without .macro", file, n+1)
+			}
+			defs = append(defs, cur)
+			cur = nil
+		case cur != nil:
+			cur.Body = append(cur.Body, line)
+		}
+	}
+	if cur != nil {
+		return nil, fmt.Errorf("%s: macro %s misses .endm", file, cur.Name)
+	}
+	scope := make(map[Macro]*Definition)
+	for _, def := range defs {
+		scope[def.Name] = def
+		def.scope = scope
+	}
+	return defs, nil
+}
+
+func parseParam(p string) (Param, error) {
+	param := Param{Name: p}
+	if name, def, ok := strings.Cut(p, "="); ok {
+		param.Name, param.Default = name, def
+	}
+	if name, qualifier, ok := strings.Cut(param.Name, ":"); ok {
+		param.Name = name
+		switch qualifier {
+		case "req":
+			param.Required = true
+		case "vararg":
+			param.Vararg = true
+		default:
+			return param, fmt.Errorf("unknown qualifier %s of macro parameter %s", qualifier, name)
+		}
+	}
+	return param, nil
+}
+
+// Expand returns the body of the macro with the arguments substituted,
+// conditional blocks resolved and nested macros expanded. Blank and comment
+// only lines are dropped
+func (d *Definition) Expand(args []string) ([]string, error) {
+	return d.expand(args, 0)
+}
+
+func (d *Definition) expand(args []string, depth int) ([]string, error) {
+	if depth >= maxDepth {
+		return nil, fmt.Errorf("macro %s nested too deeply", d.Name)
+	}
+	values, err := d.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	values["@"] = fmt.Sprint(expansions.Add(1) - 1)
+
+	var lines []string
+	var cond []bool // enclosing .ifb/.ifnb blocks, true while emitting
+	emitting := func() bool {
+		for _, c := range cond {
+			if !c {
+				return false
+			}
+		}
+		return true
+	}
+	for _, line := range d.Body {
+		line = substitute(line, values)
+		code := strings.TrimSpace(stripComment(line))
+		directive, operand := code, ""
+		if i := strings.IndexAny(code, " \t"); i >= 0 {
+			directive, operand = code[:i], code[i+1:]
+		}
+		switch directive {
+		case ".ifb", ".ifnb":
+			blank := strings.TrimSpace(operand) == ""
+			cond = append(cond, blank == (directive == ".ifb"))
+			continue
+		case ".else":
+			if len(cond) == 0 {
+				return nil, fmt.Errorf("macro %s: .else without .if", d.Name)
+			}
+			cond[len(cond)-1] = !cond[len(cond)-1]
+			continue
+		case ".endif":
+			if len(cond) == 0 {
+				return nil, fmt.Errorf("macro %s: .endif without .if", d.Name)
+			}
+			cond = cond[:len(cond)-1]
+			continue
+		}
+		if !emitting() {
+			continue
+		}
+		if strings.HasPrefix(directive, ".if") {
+			return nil, fmt.Errorf("macro %s: unsupported conditional %s", d.Name, directive)
+		}
+		if code == "" {
+			continue
+		}
+		if nested, ok := d.scope[Macro(directive)]; ok {
+			body, err := nested.expand(SplitOperands(operand), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, body...)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(cond) > 0 {
+		return nil, fmt.Errorf("macro %s: .if without .endif", d.Name)
+	}
+	return lines, nil
+}
+
+// bind maps the parameters to their arguments or defaults
+func (d *Definition) bind(args []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for i, p := range d.Params {
+		switch {
+		case p.Vararg:
+			values[p.Name] = p.Default
+			if i < len(args) {
+				values[p.Name] = strings.Join(args[i:], ", ")
+				args = args[:i]
+			}
+		case i < len(args) && strings.TrimSpace(args[i]) != "":
+			values[p.Name] = strings.TrimSpace(args[i])
+		default:
+			values[p.Name] = p.Default
+		}
+		if p.Required && values[p.Name] == "" {
+			return nil, fmt.Errorf("macro %s: missing argument %s", d.Name, p.Name)
+		}
+	}
+	if len(args) > len(d.Params) {
+		return nil, fmt.Errorf("macro %s: %d arguments, want at most %d", d.Name, len(args), len(d.Params))
+	}
+	return values, nil
+}
+
+// substitute replaces \name with the value of a parameter, \@ with the
+// expansion count and drops the \() separator
+func substitute(line string, values map[string]string) string {
+	if !strings.Contains(line, `\`) {
+		return line
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	// Longest first so \size is not taken for \s
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	var sb strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] != '\\' {
+			sb.WriteByte(line[i])
+			continue
+		}
+		rest := line[i+1:]
+		if strings.HasPrefix(rest, "()") {
+			i += 2
+			continue
+		}
+		matched := false
+		for _, name := range names {
+			end := len(name)
+			if strings.HasPrefix(rest, name) && (end == len(rest) || !isIdent(rest[end]) || name == "@") {
+				sb.WriteString(values[name])
+				i += end
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			sb.WriteByte('\\')
+		}
+	}
+	return sb.String()
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '.' || c == '$' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// SplitOperands splits an operand list at the commas outside of brackets,
+// braces and string literals
+func SplitOperands(s string) (operands []string) {
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[' || c == '{' || c == '(':
+			depth++
+		case c == ']' || c == '}' || c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			operands = append(operands, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" || len(operands) > 0 {
+		operands = append(operands, last)
+	}
+	return operands
+}
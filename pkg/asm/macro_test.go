@@ -0,0 +1,59 @@
+package asm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMacroExpand(t *testing.T) {
+	defs, err := parseMacros("test.asm", `
+.macro PUSH first:req, rest:vararg
+    str     \first, [sp, #-16]!   // push \first
+.ifnb \rest
+    PUSH    \rest
+.endif
+.endm
+
+.macro LOAD dst, base=x0, off
+.ifb \off
+    ldr     \dst, [\base]
+.else
+    ldr     \dst, [\base, #\off]
+.endif
+.endm
+`)
+	require.NoError(t, err)
+	require.Len(t, defs, 2)
+	push, load := defs[0], defs[1]
+	assert.Equal(t, []Param{{Name: "first", Required: true}, {Name: "rest", Vararg: true}}, push.Params)
+
+	lines, err := load.Expand([]string{"x1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"    ldr     x1, [x0]"}, lines)
+
+	lines, err = load.Expand([]string{"x1", "x2", "8"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"    ldr     x1, [x2, #8]"}, lines)
+
+	lines, err = push.Expand([]string{"x1", "x2", "x3"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"    str     x1, [sp, #-16]!   // push x1",
+		"    str     x2, [sp, #-16]!   // push x2",
+		"    str     x3, [sp, #-16]!   // push x3",
+	}, lines)
+
+	_, err = push.Expand(nil)
+	assert.ErrorContains(t, err, "macro PUSH: missing argument first")
+}
+
+func TestMacroLookup(t *testing.T) {
+	def, err := Lookup(DEFER)
+	require.NoError(t, err)
+	assert.Equal(t, "defer.asm", def.File)
+
+	_, err = Lookup("ALLOC_STRING")
+	assert.ErrorContains(t, err, "undefined macro ALLOC_STRING", "test files are not searched")
+}
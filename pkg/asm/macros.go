@@ -1,6 +1,6 @@
 package asm
 
+// Macros of the runtime files, expanded by ir.ExpandMacros
 var (
-	DEFER     Macro = "defer"
-	INTERFACE Macro = "interface"
+	DEFER Macro = "DEFER" // defer.asm, pushes a defer record
 )
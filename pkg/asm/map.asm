@@ -0,0 +1,750 @@
+// Map layout, kept in sync with pkg/mapper/maps.go. Entries live in a single
+// array probed linearly from the hash of the key, deleted entries stay as
+// tombstones until the array is resized
+.equ h_count,      0    // Live entries, read by len
+.equ h_entries,    8    // Array of h_cap entries, 0 until the first insert
+.equ h_cap,        16   // Power of two
+.equ h_used,       24   // Live and deleted entries
+.equ h_type,       32   // Map type descriptor
+.equ hmap_size,    40
+
+// Map type descriptor emitted by the compiler for every map type
+.equ mt_key,       0    // Key type descriptor
+.equ mt_elem,      8    // Element type descriptor
+.equ mt_entry,     16   // Entry type descriptor struct{ctrl; key; elem}
+.equ mt_entrysize, 24
+.equ mt_elemoff,   32   // Offset of the element in an entry
+
+// An entry starts with its control word: empty, deleted or the hash of the
+// key with CTRL_FULL set
+.equ e_key,        8
+.equ CTRL_EMPTY,   0
+.equ CTRL_DELETED, 1
+.equ CTRL_FULL,    2
+.equ MIN_CAP,      8
+
+// Iterator, lives in the frame of the ranging function
+.equ it_map,       0
+.equ it_entries,   8    // Entries when the iteration started
+.equ it_cap,       16
+.equ it_index,     24
+
+// Key algorithm referenced by type_keyalg: a count of segments, each
+// hashed and compared according to its class
+.equ ka_segs,      8
+.equ seg_off,      0
+.equ seg_size,     8
+.equ seg_class,    16
+.equ seg_len,      24
+.equ KEY_MEM,      0
+.equ KEY_STRING,   1
+.equ KEY_EFACE,    2    // any, the first word is a type descriptor
+.equ KEY_IFACE,    3    // the first word is an itab
+.equ KEY_F32,      4
+.equ KEY_F64,      5
+
+// FNV-1a 64 offset basis and prime
+.macro FNVBASIS reg
+    movz    \reg, #0x2325
+    movk    \reg, #0x8422, lsl #16
+    movk    \reg, #0x9ce4, lsl #32
+    movk    \reg, #0xcbf2, lsl #48
+.endm
+
+.macro FNVPRIME reg
+    movz    \reg, #0x01b3
+    movk    \reg, #0x0100, lsl #32
+.endm
+
+// Branch to label when interfaces hold values of type t in their data word:
+// channels, funcs, maps, pointers and unsafe.Pointer
+.macro POINTERSHAPED t, kind, mask, label
+    ldrb    \kind, [\t, #type_kind]
+    movz    \mask, #0x046c, lsl #16
+    lsr     \mask, \mask, \kind
+    tbnz    \mask, #0, \label
+.endm
+
+.section .rodata
+    .balign 8
+runtime.hmaptype:
+    .quad   hmap_size
+    .word   0
+    .byte   25, 8                   // reflect.Struct, alignment
+    .balign 8
+    .quad   .Lhmap_name, 12
+    .quad   16                      // Pointer data, the entries
+    .quad   .Lhmap_gcbits
+    .quad   0                       // Not comparable
+.Lhmap_gcbits:
+    .byte   2
+.Lhmap_name:
+    .ascii  "runtime.hmap"
+
+.text
+
+// Create a map with room for hint entries
+//   panic: makemap: size out of range
+// Input:
+//   X0 = map type descriptor
+//   X1 = size hint
+// Output:
+//   X0 = map
+// Clobbers caller saved registers
+runtime.makemap:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X20, X1
+    CMP     X20, #0
+    B.LT    .Lmakemap_size
+    MOV     X0, #hmap_size
+    ADRP    X1, runtime.hmaptype
+    ADD     X1, X1, :lo12:runtime.hmaptype
+    BL      runtime.alloc
+    STR     X19, [X0, #h_type]
+    MOV     X19, X0
+    CBZ     X20, 2f
+    MOV     X1, #MIN_CAP
+1:  ADD     X2, X1, X1, LSL #1      // Smallest capacity keeping hint under 3/4
+    LSR     X2, X2, #2
+    CMP     X2, X20
+    B.HS    .Lmakemap_resize
+    LSL     X1, X1, #1
+    TBZ     X1, #48, 1b
+    B       .Lmakemap_size
+.Lmakemap_resize:
+    MOV     X0, X19
+    BL      runtime.mapresize
+2:  MOV     X0, X19
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+.Lmakemap_size:
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    ADR     X1, .Lmakemap_msg
+    B       runtime.panicstring
+
+// Look up a key, the element is copied out or cleared when it is missing.
+// A nil map holds no keys
+//   panic: hash of unhashable type
+// Input:
+//   X0 = map type descriptor
+//   X1 = map
+//   X2 = key
+//   X3 = destination of the element
+// Output:
+//   X0 = 1 when the key was found
+// Clobbers caller saved registers
+runtime.mapaccess:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X21, X3
+    MOV     X0, #0
+    CBZ     X1, 1f
+    MOV     X0, X1
+    MOV     X1, X2
+    BL      runtime.mapfind
+    CBNZ    X2, .Lmapaccess_panic
+1:  LDR     X2, [X19, #mt_elem]
+    LDR     X2, [X2, #type_size]
+    CBZ     X0, 2f
+    LDR     X1, [X19, #mt_elemoff]
+    ADD     X1, X0, X1
+    MOV     X0, X21
+    BL      runtime.memcopy
+    MOV     X0, #1
+    B       3f
+2:  MOV     X0, X21
+    MOV     X1, X2
+    BL      runtime.memclr
+    MOV     X0, #0
+3:  LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+.Lmapaccess_panic:
+    MOV     X1, X2
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    B       runtime.panicstring
+
+// Insert or update an entry, the array grows once it is 3/4 full
+//   panic: assignment to entry in nil map
+//   panic: hash of unhashable type
+// Input:
+//   X0 = map
+//   X1 = key
+//   X2 = element
+// Clobbers caller saved registers
+runtime.mapassign:
+    CBZ     X0, .Lmapassign_nil
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    STP     X23, X24, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X20, X1
+    MOV     X21, X2
+    LDR     X22, [X19, #h_type]
+    BL      runtime.mapfind
+    CBNZ    X2, .Lmapassign_panic
+    MOV     X23, X0
+    MOV     X24, X1                 // Control word of the key
+    CBNZ    X23, .Lmapassign_elem
+    LDR     X0, [X19, #h_used]
+    ADD     X0, X0, #1
+    LSL     X0, X0, #2
+    LDR     X1, [X19, #h_cap]
+    ADD     X2, X1, X1, LSL #1
+    CMP     X0, X2
+    B.LS    .Lmapassign_insert
+    // Grow, or only drop the tombstones when they are half of the entries
+    LDR     X2, [X19, #h_count]
+    LSL     X2, X2, #1
+    LDR     X3, [X19, #h_used]
+    LSL     X4, X1, #1
+    CMP     X2, X3
+    CSEL    X1, X1, X4, LO
+    CMP     X1, #MIN_CAP
+    MOV     X4, #MIN_CAP
+    CSEL    X1, X4, X1, LO
+    MOV     X0, X19
+    BL      runtime.mapresize
+.Lmapassign_insert:
+    LDR     X1, [X19, #h_cap]
+    SUB     X1, X1, #1              // Mask
+    LSR     X2, X24, #2
+    AND     X2, X2, X1
+    LDR     X3, [X22, #mt_entrysize]
+    LDR     X4, [X19, #h_entries]
+1:  MADD    X23, X2, X3, X4
+    LDR     X5, [X23]
+    CMP     X5, #CTRL_FULL
+    B.LO    2f
+    ADD     X2, X2, #1
+    AND     X2, X2, X1
+    B       1b
+2:  CBNZ    X5, 3f                  // Reuses a tombstone
+    LDR     X6, [X19, #h_used]
+    ADD     X6, X6, #1
+    STR     X6, [X19, #h_used]
+3:  LDR     X6, [X19, #h_count]
+    ADD     X6, X6, #1
+    STR     X6, [X19, #h_count]
+    STR     X24, [X23]
+    ADD     X0, X23, #e_key
+    MOV     X1, X20
+    LDR     X2, [X22, #mt_key]
+    LDR     X2, [X2, #type_size]
+    BL      runtime.memcopy
+.Lmapassign_elem:
+    LDR     X1, [X22, #mt_elemoff]
+    ADD     X0, X23, X1
+    MOV     X1, X21
+    LDR     X2, [X22, #mt_elem]
+    LDR     X2, [X2, #type_size]
+    BL      runtime.memcopy
+    MOV     X0, X23
+    LDR     X1, [X22, #mt_entry]
+    BL      runtime.typedbarrier
+    LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+.Lmapassign_panic:
+    MOV     X1, X2
+    LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    B       runtime.panicstring
+.Lmapassign_nil:
+    ADR     X1, .Lmapassign_nil_msg
+    B       runtime.panicstring
+
+// Delete an entry, deleting from a nil map or a missing key does nothing
+//   panic: hash of unhashable type
+// Input:
+//   X0 = map
+//   X1 = key
+// Clobbers caller saved registers
+runtime.mapdelete:
+    CBZ     X0, 2f
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    MOV     X19, X0
+    BL      runtime.mapfind
+    CBNZ    X2, .Lmapdelete_panic
+    CBZ     X0, 1f
+    MOV     X1, #CTRL_DELETED
+    STR     X1, [X0]
+    LDR     X1, [X19, #h_count]
+    SUB     X1, X1, #1
+    STR     X1, [X19, #h_count]
+    LDR     X2, [X19, #h_type]
+    LDR     X1, [X2, #mt_entrysize]
+    SUB     X1, X1, #e_key
+    ADD     X0, X0, #e_key
+    BL      runtime.memclr          // Drops the references of the entry
+1:  LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+2:  RET
+.Lmapdelete_panic:
+    MOV     X1, X2
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    B       runtime.panicstring
+
+// Start iterating over a map, the iterator keeps the entries it started
+// with so resizing the map neither skips nor repeats entries
+// Input:
+//   X0 = iterator
+//   X1 = map
+runtime.mapiterinit:
+    STR     X1, [X0, #it_map]
+    STR     XZR, [X0, #it_index]
+    STP     XZR, XZR, [X0, #it_entries]
+    CBZ     X1, 1f
+    LDR     X2, [X1, #h_entries]
+    LDR     X3, [X1, #h_cap]
+    STP     X2, X3, [X0, #it_entries]
+1:  RET
+
+// Advance an iterator, entries of a map resized since the iteration started
+// are looked up again so deleted ones are skipped and updates are seen
+// Input:
+//   X0 = iterator
+//   X1 = destination of the key, 0 to drop it
+//   X2 = destination of the element, 0 to drop it
+// Output:
+//   X0 = 1 until the iteration is over
+// Clobbers caller saved registers
+runtime.mapnext:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    STP     X23, X24, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X20, X1
+    MOV     X21, X2
+    LDR     X22, [X19, #it_map]
+    CBZ     X22, .Lmapnext_done
+    LDR     X23, [X22, #h_type]
+1:  LDR     X0, [X19, #it_index]
+    LDR     X1, [X19, #it_cap]
+    CMP     X0, X1
+    B.HS    .Lmapnext_done
+    ADD     X1, X0, #1
+    STR     X1, [X19, #it_index]
+    LDR     X1, [X23, #mt_entrysize]
+    LDR     X2, [X19, #it_entries]
+    MADD    X24, X0, X1, X2
+    LDR     X0, [X24]
+    CMP     X0, #CTRL_FULL
+    B.LO    1b
+    LDR     X0, [X22, #h_entries]
+    CMP     X0, X2
+    B.EQ    2f
+    MOV     X0, X22
+    ADD     X1, X24, #e_key
+    BL      runtime.mapfind
+    CBZ     X0, 1b
+    MOV     X24, X0
+2:  CBZ     X20, 3f
+    MOV     X0, X20
+    ADD     X1, X24, #e_key
+    LDR     X2, [X23, #mt_key]
+    LDR     X2, [X2, #type_size]
+    BL      runtime.memcopy
+3:  CBZ     X21, 4f
+    MOV     X0, X21
+    LDR     X1, [X23, #mt_elemoff]
+    ADD     X1, X24, X1
+    LDR     X2, [X23, #mt_elem]
+    LDR     X2, [X2, #type_size]
+    BL      runtime.memcopy
+4:  MOV     X0, #1
+    B       5f
+.Lmapnext_done:
+    MOV     X0, #0
+5:  LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Find the entry of a key
+// Input:
+//   X0 = map, not nil
+//   X1 = key
+// Output:
+//   X0 = entry, 0 when the key is missing
+//   X1 = control word of the key
+//   X2 = string header of a panic message, 0 when the key is hashable
+// Clobbers caller saved registers
+runtime.mapfind:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    STP     X23, X24, [sp, #-16]!
+    STP     X25, X26, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X20, X1
+    LDR     X21, [X19, #h_type]
+    LDR     X0, [X21, #mt_key]
+    FNVBASIS X2
+    BL      runtime.typehash
+    CBNZ    X1, .Lmapfind_fail
+    ORR     X22, X0, #CTRL_FULL
+    LDR     X23, [X19, #h_cap]
+    CBZ     X23, .Lmapfind_missing
+    SUB     X23, X23, #1            // Mask
+    LSR     X24, X22, #2
+    AND     X24, X24, X23
+1:  LDR     X0, [X21, #mt_entrysize]
+    LDR     X1, [X19, #h_entries]
+    MADD    X25, X24, X0, X1
+    LDR     X0, [X25]
+    CBZ     X0, .Lmapfind_missing
+    CMP     X0, X22
+    B.NE    2f
+    LDR     X0, [X21, #mt_key]
+    ADD     X1, X25, #e_key
+    MOV     X2, X20
+    BL      runtime.typeequal
+    CBNZ    X1, .Lmapfind_fail
+    CBNZ    X0, 3f
+2:  ADD     X24, X24, #1
+    AND     X24, X24, X23
+    B       1b
+3:  MOV     X0, X25
+    B       4f
+.Lmapfind_missing:
+    MOV     X0, #0
+4:  MOV     X1, X22
+    MOV     X2, #0
+    B       5f
+.Lmapfind_fail:
+    MOV     X2, X1
+    MOV     X0, #0
+5:  LDP     X25, X26, [sp], #16
+    LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Move the entries of a map to a new array, dropping the tombstones
+// Input:
+//   X0 = map
+//   X1 = capacity, a power of two
+// Clobbers caller saved registers
+runtime.mapresize:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    STP     X23, X24, [sp, #-16]!
+    STP     X25, X26, [sp, #-16]!
+    MOV     X19, X0
+    MOV     X20, X1
+    LDR     X21, [X19, #h_type]
+    LDR     X25, [X21, #mt_entrysize]
+    MUL     X0, X25, X20
+    LDR     X1, [X21, #mt_entry]
+    BL      runtime.rawalloc        // The map is only held in X19
+    MOV     X22, X0
+    LDR     X23, [X19, #h_entries]
+    LDR     X24, [X19, #h_cap]
+    STR     X22, [X19, #h_entries]
+    STR     X20, [X19, #h_cap]
+    LDR     X0, [X19, #h_count]
+    STR     X0, [X19, #h_used]
+1:  CBZ     X24, 4f
+    SUB     X24, X24, #1
+    LDR     X0, [X23]
+    CMP     X0, #CTRL_FULL
+    B.LO    3f
+    SUB     X1, X20, #1
+    LSR     X2, X0, #2
+    AND     X2, X2, X1
+2:  MADD    X26, X2, X25, X22
+    LDR     X3, [X26]
+    ADD     X2, X2, #1
+    AND     X2, X2, X1
+    CBNZ    X3, 2b
+    MOV     X0, X26
+    MOV     X1, X23
+    MOV     X2, X25
+    BL      runtime.memcopy
+    MOV     X0, X26
+    LDR     X1, [X21, #mt_entry]
+    BL      runtime.typedbarrier
+3:  ADD     X23, X23, X25
+    B       1b
+4:  LDP     X25, X26, [sp], #16
+    LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Hash a value with FNV-1a following the key algorithm of its type. Floats
+// hash -0 like +0, interfaces hash their dynamic value
+// Input:
+//   X0 = type descriptor
+//   X1 = value
+//   X2 = hash so far
+// Output:
+//   X0 = hash
+//   X1 = string header of a panic message, 0 when the value is hashable
+// Clobbers caller saved registers
+runtime.typehash:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    LDR     X19, [X0, #type_keyalg]
+    CBZ     X19, .Ltypehash_unhashable
+    MOV     X20, X1
+    MOV     X0, X2
+    LDR     X21, [X19], #ka_segs    // Segment count
+.Ltypehash_next:
+    CBZ     X21, .Ltypehash_done
+    SUB     X21, X21, #1
+    LDP     X1, X2, [X19, #seg_off]
+    LDR     X3, [X19, #seg_class]
+    ADD     X19, X19, #seg_len
+    ADD     X1, X20, X1
+    CMP     X3, #KEY_STRING
+    B.EQ    .Ltypehash_string
+    B.HI    .Ltypehash_special
+.Ltypehash_bytes:
+    BL      runtime.fnvbytes
+    B       .Ltypehash_next
+.Ltypehash_string:
+    LDP     X1, X2, [X1]
+    B       .Ltypehash_bytes
+.Ltypehash_special:
+    CMP     X3, #KEY_F32
+    B.EQ    .Ltypehash_f32
+    B.HI    .Ltypehash_f64
+    LDR     X22, [X1]               // Type or itab word
+    CBZ     X22, .Ltypehash_next    // nil interfaces hash alike
+    CMP     X3, #KEY_IFACE
+    B.NE    1f
+    LDR     X22, [X22, #itab_type]
+1:  ADD     X1, X1, #8              // Data word
+    POINTERSHAPED X22, W16, W17, 2f
+    LDR     X1, [X1]                // Boxed value
+2:  MOV     X2, X0
+    MOV     X0, X22
+    BL      runtime.typehash
+    CBZ     X1, .Ltypehash_next
+    B       .Ltypehash_ret
+.Ltypehash_f32:
+    LDR     S0, [X1]
+    FCMP    S0, #0.0
+    B.NE    .Ltypehash_bytes
+    ADR     X1, .Lmap_zero
+    B       .Ltypehash_bytes
+.Ltypehash_f64:
+    LDR     D0, [X1]
+    FCMP    D0, #0.0
+    B.NE    .Ltypehash_bytes
+    ADR     X1, .Lmap_zero
+    B       .Ltypehash_bytes
+.Ltypehash_unhashable:
+    ADR     X1, .Lunhashable_msg
+    B       .Ltypehash_ret
+.Ltypehash_done:
+    MOV     X1, #0
+.Ltypehash_ret:
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Compare two values following the key algorithm of their type
+// Input:
+//   X0 = type descriptor
+//   X1, X2 = values
+// Output:
+//   X0 = 1 when they are equal
+//   X1 = string header of a panic message, 0 when they are comparable
+// Clobbers caller saved registers
+runtime.typeequal:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    STP     X23, X24, [sp, #-16]!
+    LDR     X19, [X0, #type_keyalg]
+    CBZ     X19, .Ltypeequal_uncomparable
+    MOV     X20, X1
+    MOV     X21, X2
+    LDR     X22, [X19], #ka_segs    // Segment count
+.Ltypeequal_next:
+    CBZ     X22, .Ltypeequal_true
+    SUB     X22, X22, #1
+    LDP     X3, X2, [X19, #seg_off]
+    LDR     X4, [X19, #seg_class]
+    ADD     X19, X19, #seg_len
+    ADD     X0, X20, X3
+    ADD     X1, X21, X3
+    CMP     X4, #KEY_STRING
+    B.EQ    .Ltypeequal_string
+    B.HI    .Ltypeequal_special
+.Ltypeequal_bytes:
+    BL      runtime.memequal
+    CBZ     X0, .Ltypeequal_false
+    B       .Ltypeequal_next
+.Ltypeequal_string:
+    LDR     X2, [X0, #8]
+    LDR     X3, [X1, #8]
+    CMP     X2, X3
+    B.NE    .Ltypeequal_false
+    LDR     X0, [X0]
+    LDR     X1, [X1]
+    B       .Ltypeequal_bytes
+.Ltypeequal_special:
+    CMP     X4, #KEY_F32
+    B.EQ    .Ltypeequal_f32
+    B.HI    .Ltypeequal_f64
+    LDR     X23, [X0]
+    LDR     X24, [X1]
+    CMP     X23, X24
+    B.NE    .Ltypeequal_false
+    CBZ     X23, .Ltypeequal_next
+    CMP     X4, #KEY_IFACE
+    B.NE    1f
+    LDR     X23, [X23, #itab_type]
+1:  ADD     X0, X0, #8              // Data words
+    ADD     X1, X1, #8
+    POINTERSHAPED X23, W16, W17, 2f
+    LDR     X0, [X0]                // Boxed values
+    LDR     X1, [X1]
+2:  MOV     X2, X1
+    MOV     X1, X0
+    MOV     X0, X23
+    BL      runtime.typeequal
+    CBNZ    X1, .Ltypeequal_ret
+    CBZ     X0, .Ltypeequal_false
+    B       .Ltypeequal_next
+.Ltypeequal_f32:
+    LDR     S0, [X0]
+    LDR     S1, [X1]
+    FCMP    S0, S1
+    B.NE    .Ltypeequal_false       // Also taken for NaN
+    B       .Ltypeequal_next
+.Ltypeequal_f64:
+    LDR     D0, [X0]
+    LDR     D1, [X1]
+    FCMP    D0, D1
+    B.NE    .Ltypeequal_false
+    B       .Ltypeequal_next
+.Ltypeequal_uncomparable:
+    ADR     X1, .Luncomparable_msg
+    B       .Ltypeequal_ret
+.Ltypeequal_true:
+    MOV     X0, #1
+    MOV     X1, #0
+    B       .Ltypeequal_ret
+.Ltypeequal_false:
+    MOV     X0, #0
+    MOV     X1, #0
+.Ltypeequal_ret:
+    LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Compare two interfaces, x == y in compiled code
+//   panic: runtime error: comparing uncomparable type
+// Input:
+//   X0 = interface type descriptor
+//   X1, X2 = interfaces
+// Output:
+//   X0 = 1 when they are equal
+// Clobbers caller saved registers
+runtime.ifaceeq:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    BL      runtime.typeequal
+    LDP     X29, X30, [sp], #16
+    CBNZ    X1, 1f
+    RET
+1:  B       runtime.panicstring
+
+// Hash bytes with FNV-1a
+// Input:
+//   X0 = hash so far
+//   X1 = address
+//   X2 = size in bytes
+// Output:
+//   X0 = hash
+// Clobbers X1-X3, X16
+runtime.fnvbytes:
+    CBZ     X2, 2f
+    FNVPRIME X16
+1:  LDRB    W3, [X1], #1
+    EOR     X0, X0, X3
+    MUL     X0, X0, X16
+    SUBS    X2, X2, #1
+    B.NE    1b
+2:  RET
+
+// Compare memory
+// Input:
+//   X0, X1 = addresses
+//   X2 = size in bytes
+// Output:
+//   X0 = 1 when the bytes are equal
+// Clobbers X1-X4
+runtime.memequal:
+    CBZ     X2, 2f
+1:  LDRB    W3, [X0], #1
+    LDRB    W4, [X1], #1
+    CMP     W3, W4
+    B.NE    3f
+    SUBS    X2, X2, #1
+    B.NE    1b
+2:  MOV     X0, #1
+    RET
+3:  MOV     X0, #0
+    RET
+
+    .balign 8
+.Lmap_zero:
+    .quad   0
+.Lmakemap_msg:
+    .quad   .Lmakemap_str, .Lmapassign_nil_str - .Lmakemap_str
+.Lmapassign_nil_msg:
+    .quad   .Lmapassign_nil_str, .Lunhashable_str - .Lmapassign_nil_str
+.Lunhashable_msg:
+    .quad   .Lunhashable_str, .Luncomparable_str - .Lunhashable_str
+.Luncomparable_msg:
+    .quad   .Luncomparable_str, .Lmap_str_end - .Luncomparable_str
+.Lmakemap_str:
+    .ascii  "makemap: size out of range"
+.Lmapassign_nil_str:
+    .ascii  "assignment to entry in nil map"
+.Lunhashable_str:
+    .ascii  "runtime error: hash of unhashable type"
+.Luncomparable_str:
+    .ascii  "runtime error: comparing uncomparable type"
+.Lmap_str_end:
+    .balign 4
@@ -0,0 +1,409 @@
+// Incremental tri-color mark and sweep collector. A cycle starts once the
+// allocations since the last one exceed the live heap, it greys the roots
+// and every allocation of compiled code then scans MARK_BUDGET grey objects.
+// Once none are left the roots are greyed again, as stacks and globals are
+// written without barriers, marking finishes and the heap is swept into a
+// free list. Objects never move. Linked after runtime.asm
+.equ MARK_BUDGET,    64               // Grey objects scanned per allocation
+.equ MARK_STACK,     1024 * 1024
+.equ MIN_TRIGGER,    4 * 1024 * 1024  // Allocated bytes starting the first cycle
+
+.equ ms_top,         0    // End of the heap in use, allocations past the free list bump it
+.equ ms_free,        8    // Free chunks, linked through obj_type
+.equ ms_allocated,   16   // Bytes allocated since the last cycle
+.equ ms_trigger,     24
+.equ ms_stack,       32   // Grey objects to scan
+.equ ms_sp,          40
+.equ ms_stackend,    48
+.equ ms_overflow,    56   // Grey objects did not fit on the mark stack
+.equ ms_state_size,  64
+
+// Marking in progress, polled by the write barriers of compiled code
+.data
+runtime.gcphase:    .byte 0
+
+.bss
+    .balign 8
+ms_state:   .space ms_state_size
+
+.text
+
+// Set up the heap, called by the entry point before package initializers
+runtime.gcinit:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    BL      gc_mapheap
+    ADRP    X1, ms_state
+    ADD     X1, X1, :lo12:ms_state
+    STR     X0, [X1, #ms_top]
+    MOV     X0, #MIN_TRIGGER
+    STR     X0, [X1, #ms_trigger]
+    MOV     X0, #MARK_STACK
+    BL      gc_mmap
+    ADRP    X1, ms_state
+    ADD     X1, X1, :lo12:ms_state
+    STR     X0, [X1, #ms_stack]
+    STR     X0, [X1, #ms_sp]
+    MOV     X2, #MARK_STACK
+    ADD     X0, X0, X2
+    STR     X0, [X1, #ms_stackend]
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Allocation entry point of compiled code, does a share of the marking
+// first. Same calling convention as runtime.alloc in alloc.asm
+// Input:
+//   X0 = size in bytes
+//   X1 = type descriptor
+// Output:
+//   X0 = zeroed object, following its header
+// Clobbers all caller saved registers
+runtime.alloc:
+    STP     X29, X30, [sp, #-16]!   // Frame record for runtime.scanstack
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    ADD     X19, X0, #obj_header + 7
+    AND     X19, X19, #~7           // Header and object rounded to words
+    MOV     X20, X1
+    ADRP    X0, runtime.gcphase
+    LDRB    W0, [X0, :lo12:runtime.gcphase]
+    CBNZ    W0, 1f
+    ADRP    X0, ms_state
+    ADD     X0, X0, :lo12:ms_state
+    LDP     X0, X1, [X0, #ms_allocated]
+    CMP     X0, X1
+    B.LO    2f
+    BL      ms_start
+1:  MOV     X0, #MARK_BUDGET
+    BL      ms_drain
+    CBZ     X0, 2f
+    BL      ms_finish
+2:  MOV     X0, X19
+    MOV     X1, X20
+    BL      ms_new
+    CBNZ    X0, 4f
+    // The heap is full, finish the cycle in progress or run a whole one
+    ADRP    X0, runtime.gcphase
+    LDRB    W0, [X0, :lo12:runtime.gcphase]
+    CBNZ    W0, 3f
+    BL      ms_start
+3:  BL      ms_finish
+    MOV     X0, X19
+    MOV     X1, X20
+    BL      ms_new
+    CBZ     X0, gc_outofmemory
+4:  LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Allocation of the runtime, which holds heap pointers in registers the
+// collector does not know about. Never collects nor marks
+// Input:
+//   X0 = size in bytes
+//   X1 = type descriptor
+// Output:
+//   X0 = zeroed object, following its header
+// Clobbers X1-X9, X16, X17
+runtime.rawalloc:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    ADD     X0, X0, #obj_header + 7
+    AND     X0, X0, #~7
+    BL      ms_new
+    CBZ     X0, gc_outofmemory
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Take a chunk from the free list, first fit, or from the end of the heap.
+// Objects are allocated black while marking so the cycle keeps them
+// Input:
+//   X0 = size including the header, rounded to words
+//   X1 = type descriptor
+// Output:
+//   X0 = zeroed object, 0 when the heap is full
+// Clobbers X1-X9, X16, X17
+ms_new:
+    ADRP    X2, ms_state
+    ADD     X2, X2, :lo12:ms_state
+    ADD     X3, X2, #ms_free        // Link to the chunk
+1:  LDR     X4, [X3]
+    CBZ     X4, .Lms_new_bump
+    LDR     W5, [X4, #obj_size]
+    CMP     X5, X0
+    B.HS    2f
+    MOV     X3, X4                  // obj_type links the next chunk
+    B       1b
+2:  SUB     X6, X5, X0
+    LDR     X8, [X4, #obj_type]
+    CMP     X6, #obj_header
+    B.LO    3f                      // Not enough left for a chunk, take it all
+    ADD     X7, X4, X0              // The rest stays free
+    STR     X8, [X7, #obj_type]
+    STR     W6, [X7, #obj_size]
+    MOV     W8, #(1 << FLAG_FREE) << 8
+    STR     W8, [X7, #obj_color]    // White, free
+    MOV     X8, X7
+    STARTBIT X7, X9, X16, X17
+    MOV     X5, X0
+3:  STR     X8, [X3]
+    B       .Lms_new_init
+.Lms_new_bump:
+    LDR     X4, [X2, #ms_top]
+    ADD     X6, X4, X0
+    ADRP    X7, gc_state
+    ADD     X7, X7, :lo12:gc_state
+    LDR     X7, [X7, #gc_hi]
+    CMP     X6, X7
+    B.HI    .Lms_new_full
+    STR     X6, [X2, #ms_top]
+    MOV     X5, X0
+    STARTBIT X4, X7, X8, X9
+.Lms_new_init:                      // X4 = header, X5 = size
+    STR     X1, [X4, #obj_type]
+    STR     W5, [X4, #obj_size]
+    ADRP    X7, runtime.gcphase
+    LDRB    W7, [X7, :lo12:runtime.gcphase]
+    CMP     W7, #0
+    MOV     W7, #COLOR_BLACK
+    CSEL    W7, W7, WZR, NE
+    STR     W7, [X4, #obj_color]    // Color, flags and age
+    LDR     X6, [X2, #ms_allocated]
+    ADD     X6, X6, X5
+    STR     X6, [X2, #ms_allocated]
+    ADD     X0, X4, #obj_header
+    ADD     X6, X4, X5
+    MOV     X7, X0
+4:  CMP     X7, X6                  // Swept memory is reused dirty
+    B.HS    5f
+    STR     XZR, [X7], #8
+    B       4b
+5:  RET
+.Lms_new_full:
+    MOV     X0, #0
+    RET
+
+// Start a cycle, greys the roots
+// Clobbers all caller saved registers
+ms_start:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    ADRP    X0, runtime.gcphase
+    MOV     W1, #1
+    STRB    W1, [X0, :lo12:runtime.gcphase]
+    ADR     X0, ms_grey
+    BL      runtime.scanroots
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Finish a cycle, greys the roots again and marks everything reachable
+// before sweeping
+// Clobbers all caller saved registers
+ms_finish:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    ADR     X0, ms_grey
+    BL      runtime.scanroots
+    MOV     X0, #-1                 // No budget
+    BL      ms_drain
+    BL      ms_sweep
+    ADRP    X0, runtime.gcphase
+    STRB    WZR, [X0, :lo12:runtime.gcphase]
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Grey the object a pointer points into unless it is marked already
+// Input:
+//   X0 = pointer
+// Clobbers X0-X5
+ms_grey:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    BL      gc_findobject
+    CBZ     X0, 1f
+    LDRB    W1, [X0, #obj_flags]
+    TBNZ    W1, #FLAG_FREE, 1f
+    LDRB    W1, [X0, #obj_color]
+    CBNZ    W1, 1f                  // Grey or black already
+    MOV     W1, #COLOR_GREY
+    STRB    W1, [X0, #obj_color]
+    ADRP    X1, ms_state
+    ADD     X1, X1, :lo12:ms_state
+    LDP     X2, X3, [X1, #ms_sp]
+    CMP     X2, X3
+    B.HS    2f
+    STR     X0, [X2], #8
+    STR     X2, [X1, #ms_sp]
+1:  LDP     X29, X30, [sp], #16
+    RET
+2:  MOV     W2, #1                  // ms_drain finds it walking the heap
+    STRB    W2, [X1, #ms_overflow]
+    B       1b
+
+// Scan grey objects, blackening them and greying what they point to
+// Input:
+//   X0 = number of objects to scan
+// Output:
+//   X0 = 1 when no grey objects are left
+// Clobbers all caller saved registers
+ms_drain:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    MOV     X19, X0
+    ADRP    X20, ms_state
+    ADD     X20, X20, :lo12:ms_state
+1:  LDR     X0, [X20, #ms_stack]
+    LDR     X1, [X20, #ms_sp]
+    CMP     X1, X0
+    B.EQ    2f
+    MOV     X0, #0
+    CBZ     X19, 3f                 // Out of budget
+    SUB     X19, X19, #1
+    LDR     X0, [X1, #-8]!
+    STR     X1, [X20, #ms_sp]
+    MOV     W1, #COLOR_BLACK
+    STRB    W1, [X0, #obj_color]
+    ADR     X1, ms_grey
+    BL      gc_scanobject
+    B       1b
+2:  LDRB    W0, [X20, #ms_overflow]
+    CBZ     W0, 4f
+    STRB    WZR, [X20, #ms_overflow]
+    BL      ms_regrey
+    B       1b
+4:  MOV     X0, #1
+3:  LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Push the grey objects left off the full mark stack, found walking the
+// heap. The stack is empty on entry
+// Clobbers X0-X5
+ms_regrey:
+    ADRP    X0, gc_state
+    LDR     X0, [X0, :lo12:gc_state]  // Heap start
+    ADRP    X1, ms_state
+    ADD     X1, X1, :lo12:ms_state
+    LDR     X2, [X1, #ms_top]
+    LDP     X3, X4, [X1, #ms_sp]
+1:  CMP     X0, X2
+    B.HS    3f
+    LDRB    W5, [X0, #obj_color]
+    CMP     W5, #COLOR_GREY
+    B.NE    2f
+    CMP     X3, X4
+    B.HS    4f
+    STR     X0, [X3], #8
+2:  LDR     W5, [X0, #obj_size]
+    ADD     X0, X0, X5
+    B       1b
+4:  MOV     W5, #1
+    STRB    W5, [X1, #ms_overflow]
+3:  STR     X3, [X1, #ms_sp]
+    RET
+
+// Free the white objects into the free list, merging neighbouring free
+// chunks, and whiten the black ones for the next cycle. The next one starts
+// once as much as survived has been allocated again
+// Clobbers X0-X12
+ms_sweep:
+    ADRP    X9, ms_state
+    ADD     X9, X9, :lo12:ms_state
+    ADRP    X0, gc_state
+    LDR     X0, [X0, :lo12:gc_state]  // Heap start
+    LDR     X2, [X9, #ms_top]
+    ADD     X3, X9, #ms_free        // Link to fill
+    MOV     X4, #0                  // Free chunk to grow, 0 after a live object
+    MOV     X5, #0                  // Live bytes
+1:  CMP     X0, X2
+    B.HS    5f
+    LDR     W6, [X0, #obj_size]
+    LDRB    W7, [X0, #obj_flags]
+    TBNZ    W7, #FLAG_FREE, 2f
+    LDRB    W7, [X0, #obj_color]
+    CBZ     W7, 2f                  // Unreachable
+    STRB    WZR, [X0, #obj_color]
+    ADD     X5, X5, X6
+    MOV     X4, #0
+    B       4f
+2:  CBZ     X4, 3f
+    LDR     W7, [X4, #obj_size]
+    ADD     W7, W7, W6
+    STR     W7, [X4, #obj_size]
+    STARTBIT X0, X10, X11, X12, 1
+    B       4f
+3:  MOV     W7, #(1 << FLAG_FREE) << 8
+    STR     W7, [X0, #obj_color]    // White, free
+    STR     X0, [X3]
+    MOV     X3, X0                  // obj_type links the next chunk
+    MOV     X4, X0
+4:  ADD     X0, X0, X6
+    B       1b
+5:  STR     XZR, [X3]
+    STR     XZR, [X9, #ms_allocated]
+    MOV     X6, #MIN_TRIGGER
+    CMP     X5, X6
+    CSEL    X5, X5, X6, HI
+    STR     X5, [X9, #ms_trigger]
+    RET
+
+// Shade a pointer stored while marking is in progress, incremental marking
+// must never leave a black object pointing to a white one
+// Input:
+//   X16 = stored pointer
+// Preserves all registers except X16, X17 and X30
+runtime.gcshade:
+    CBZ     X16, 1f
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X0, X1, [sp, #-16]!
+    STP     X2, X3, [sp, #-16]!
+    STP     X4, X5, [sp, #-16]!
+    STP     X6, X7, [sp, #-16]!
+    STP     X8, X9, [sp, #-16]!
+    STP     X10, X11, [sp, #-16]!
+    STP     X12, X13, [sp, #-16]!
+    STP     X14, X15, [sp, #-16]!
+    MOV     X0, X16
+    BL      ms_grey
+    LDP     X14, X15, [sp], #16
+    LDP     X12, X13, [sp], #16
+    LDP     X10, X11, [sp], #16
+    LDP     X8, X9, [sp], #16
+    LDP     X6, X7, [sp], #16
+    LDP     X4, X5, [sp], #16
+    LDP     X2, X3, [sp], #16
+    LDP     X0, X1, [sp], #16
+    LDP     X29, X30, [sp], #16
+1:  RET
+
+// Write barrier of values the runtime copies into the heap eg channel
+// buffers, shades their pointers while marking is in progress
+// Input:
+//   X0 = address of the value
+//   X1 = type descriptor
+// Clobbers X2-X4, X16, X17
+runtime.typedbarrier:
+    ADRP    X16, runtime.gcphase
+    LDRB    W16, [X16, :lo12:runtime.gcphase]
+    CBZ     W16, 2f
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    LDR     X2, [X1, #type_ptrdata]
+    LSR     X2, X2, #3              // Pointer words
+    LDR     X3, [X1, #type_gcdata]
+    MOV     X4, #0                  // Word index
+1:  CMP     X4, X2
+    B.HS    3f
+    LSR     X17, X4, #3
+    LDRB    W17, [X3, X17]          // Bitmap byte of the word
+    AND     X16, X4, #7
+    LSR     W17, W17, W16
+    ADD     X4, X4, #1
+    TBZ     W17, #0, 1b
+    SUB     X16, X4, #1
+    LDR     X16, [X0, X16, LSL #3]
+    BL      runtime.gcshade
+    B       1b
+3:  LDP     X29, X30, [sp], #16
+2:  RET
@@ -0,0 +1,410 @@
+// Output of the print and println builtins, written to stderr unbuffered like
+// the Go runtime does. Integers are printed by runtime.printint and
+// runtime.printuint, strings by runtime.printstderr
+
+.text
+
+// Print a boolean
+// Input:
+//   X0 = value
+runtime.printbool:
+    TST     X0, #0xff
+    ADR     X0, .Lprintbool_true
+    MOV     X1, #4
+    B.NE    runtime.printstderr
+    ADR     X0, .Lprintbool_false
+    MOV     X1, #5
+    B       runtime.printstderr
+.Lprintbool_true:
+    .ascii  "true"
+.Lprintbool_false:
+    .ascii  "false"
+    .balign 4
+
+// Print a float in the shortest form reading back to the same value, like
+// strconv.FormatFloat(v, 'g', -1, bits)
+// Input:
+//   D0 = float64, S0 = float32
+runtime.printfloat64:
+    MOV     X1, #64
+    B       .Lprintfloat
+runtime.printfloat32:
+    FCVT    D0, S0
+    MOV     X1, #32
+.Lprintfloat:
+    STP     X29, X30, [sp, #-48]!
+    ADD     X0, sp, #16
+    BL      runtime.formatfloat
+    MOV     X1, X0
+    ADD     X0, sp, #16
+    BL      runtime.printstderr
+    LDP     X29, X30, [sp], #48
+    RET
+
+// Print a pointer in hexadecimal, nil prints 0x0
+// Input:
+//   X0 = pointer
+runtime.printpointer:
+    SUB     sp, sp, #32
+    STR     X30, [sp]
+    ADD     X3, sp, #32             // Digits are written backwards
+    ADR     X5, .Lprintpointer_digits
+.Lprintpointer_loop:
+    AND     X6, X0, #0xf
+    LDRB    W6, [X5, X6]
+    STRB    W6, [X3, #-1]!
+    LSR     X0, X0, #4
+    CBNZ    X0, .Lprintpointer_loop
+    MOV     W6, #'x'
+    STRB    W6, [X3, #-1]!
+    MOV     W6, #'0'
+    STRB    W6, [X3, #-1]!
+    MOV     X0, X3
+    ADD     X1, sp, #32
+    SUB     X1, X1, X3
+    BL      runtime.printstderr
+    LDR     X30, [sp]
+    ADD     sp, sp, #32
+    RET
+.Lprintpointer_digits:
+    .ascii  "0123456789abcdef"
+    .balign 4
+
+// Separators of println
+runtime.printsp:
+    ADR     X0, .Lprintsp_space
+    MOV     X1, #1
+    B       runtime.printstderr
+runtime.printnl:
+    ADR     X0, .Lprintsp_newline
+    MOV     X1, #1
+    B       runtime.printstderr
+.Lprintsp_space:
+    .ascii  " "
+.Lprintsp_newline:
+    .ascii  "\n"
+    .balign 4
+
+// Format a float in the shortest form reading back to the same value, %e
+// for exponents below -4 or from 6 on like strconv with precision -1. The
+// value is scaled by powers of ten as a double-double, digits are added
+// until the nearest decimal lies within half the gap to the neighbouring
+// floats, ties read back to the even mantissa
+// Input:
+//   X0 = buffer of 32 bytes
+//   X1 = 64 or 32, the size of the float
+//   D0 = value, a float32 converted to float64
+// Output:
+//   X0 = length
+// Clobbers X1-X15, D1-D7
+runtime.formatfloat:
+    SUB     sp, sp, #48
+    STR     X30, [sp]
+    MOV     X15, X0                 // Start of the output
+    MOV     X9, X0                  // Output cursor
+    FCMP    D0, D0
+    B.VS    .Lformatfloat_nan
+    FMOV    X2, D0
+    TBZ     X2, #63, .Lformatfloat_abs
+    MOV     W4, #'-'
+    STRB    W4, [X9], #1
+    FABS    D0, D0
+    FMOV    X2, D0
+.Lformatfloat_abs:
+    CBZ     X2, .Lformatfloat_zero
+    MOV     X3, #0x7ff0000000000000
+    CMP     X2, X3
+    B.EQ    .Lformatfloat_inf
+    // Decimal exponent e from the binary one, floor(e2 * log10(2)) may be
+    // off by one which the digit count corrects
+    LSR     X3, X2, #52
+    CBNZ    X3, .Lformatfloat_normal
+    CLZ     X4, X2                  // Subnormal, the leading bit sets e2
+    MOV     X5, #(63 - 1074)
+    SUB     X3, X5, X4
+    B       .Lformatfloat_log
+.Lformatfloat_normal:
+    SUB     X3, X3, #1023
+.Lformatfloat_log:
+    MOV     X4, #0x3441             // 78913 = log10(2) * 2^18
+    MOVK    X4, #1, LSL #16
+    MUL     X3, X3, X4
+    ASR     X10, X3, #18            // e
+    // D3 = gap to the float below, X3 = odd mantissa
+    CMP     X1, #32
+    B.EQ    .Lformatfloat_single
+    SUB     X4, X2, #1
+    FMOV    D3, X4
+    FSUB    D3, D0, D3
+    AND     X3, X2, #1
+    UBFX    X8, X2, #0, #52
+    LSR     X5, X2, #52
+    MOV     X12, #17                // Digits always reading back
+    B       .Lformatfloat_gap
+.Lformatfloat_single:
+    FCVT    S3, D0
+    FMOV    W4, S3
+    SUB     W5, W4, #1
+    FMOV    S3, W5
+    FCVT    D3, S3
+    FSUB    D3, D0, D3
+    AND     X3, X4, #1
+    UBFX    X8, X4, #0, #23
+    UBFX    X5, X4, #23, #8
+    MOV     X12, #9
+.Lformatfloat_gap:
+    // X8 = 1 for powers of two, the gap above is twice the one below
+    CMP     X8, #0
+    CCMP    X5, #1, #0, EQ
+    CSET    X8, HI
+    MOV     X11, #1                 // Digits
+    // X13 = nearest integer to v * 10^(p-1-e), it has p digits
+.Lformatfloat_digits:
+    SUB     X4, X11, #1
+    SUB     X4, X4, X10
+    MOV     X14, X4                 // k
+    FMOV    D1, D0
+    BL      .Lformatfloat_scale
+    FCVTNS  X7, D1
+    SCVTF   D4, X7
+    FSUB    D6, D1, D4
+    FADD    D6, D6, D2              // Fraction, v * 10^k = X7 + D6
+    FCVTNS  X13, D6
+    ADD     X13, X7, X13
+    FMOV    D4, #0.5
+    FCMP    D6, D4
+    B.NE    1f
+    AND     X4, X7, #1              // Halfway, round to even
+    ADD     X13, X7, X4
+1:  FMOV    D4, #-0.5
+    FCMP    D6, D4
+    B.NE    2f
+    AND     X4, X7, #1
+    SUB     X13, X7, X4
+2:  ADR     X5, .Lformatfloat_ipow10
+    LDR     X6, [X5, X11, LSL #3]
+    CMP     X13, X6
+    B.LO    .Lformatfloat_low
+    ADD     X10, X10, #1            // One digit too many
+    B       .Lformatfloat_digits
+.Lformatfloat_low:
+    SUB     X4, X11, #1
+    LDR     X6, [X5, X4, LSL #3]
+    CMP     X13, X6
+    B.HS    .Lformatfloat_check
+    SUB     X10, X10, #1            // One digit short
+    B       .Lformatfloat_digits
+.Lformatfloat_check:
+    // Reads back when |v * 10^k - X13| is below half the gap scaled
+    CMP     X11, X12
+    B.EQ    .Lformatfloat_trim
+    SUB     X4, X7, X13
+    SCVTF   D7, X4
+    FADD    D7, D7, D6
+    FMOV    D1, D3
+    MOV     X4, X14
+    BL      .Lformatfloat_scale
+    FCMP    D7, #0.0
+    B.PL    3f
+    CBZ     X8, 3f
+    FADD    D1, D1, D1              // Rounded up, use the gap above
+    FADD    D2, D2, D2
+3:  FABS    D7, D7
+    FMOV    D4, #0.5
+    FMSUB   D7, D1, D4, D7
+    FMSUB   D7, D2, D4, D7
+    MOV     X4, #0x3d70000000000000 // 2^-40, margin of a tie
+    FMOV    D4, X4
+    FMUL    D4, D1, D4
+    FNEG    D5, D4
+    FCMP    D7, D5
+    B.LT    .Lformatfloat_trim
+    FCMP    D7, D4
+    B.GT    4f
+    CBZ     X3, .Lformatfloat_trim
+4:  ADD     X11, X11, #1
+    B       .Lformatfloat_digits
+    // Drop trailing zeros, X14 = number of digits
+.Lformatfloat_trim:
+    MOV     X5, #10
+    MOV     X14, X11
+.Lformatfloat_trim_loop:
+    CMP     X14, #1
+    B.EQ    .Lformatfloat_text
+    UDIV    X6, X13, X5
+    MSUB    X7, X6, X5, X13
+    CBNZ    X7, .Lformatfloat_text
+    MOV     X13, X6
+    SUB     X14, X14, #1
+    B       .Lformatfloat_trim_loop
+.Lformatfloat_text:
+    ADD     X8, sp, #16             // Digits as text
+    ADD     X6, X8, X14
+.Lformatfloat_text_loop:
+    UDIV    X7, X13, X5
+    MSUB    X3, X7, X5, X13
+    ADD     W3, W3, #'0'
+    STRB    W3, [X6, #-1]!
+    MOV     X13, X7
+    CMP     X6, X8
+    B.NE    .Lformatfloat_text_loop
+    CMN     X10, #4
+    B.LT    .Lformatfloat_exp
+    CMP     X10, #6
+    B.GE    .Lformatfloat_exp
+    TBNZ    X10, #63, .Lformatfloat_fraction
+    // ddd.ddd, the integer part is padded with zeros
+    MOV     X3, #0
+.Lformatfloat_int:
+    MOV     W4, #'0'
+    CMP     X3, X14
+    B.HS    .Lformatfloat_pad
+    LDRB    W4, [X8, X3]
+.Lformatfloat_pad:
+    STRB    W4, [X9], #1
+    ADD     X3, X3, #1
+    CMP     X3, X10
+    B.LE    .Lformatfloat_int
+    CMP     X3, X14
+    B.HS    .Lformatfloat_done
+    MOV     W4, #'.'
+    STRB    W4, [X9], #1
+.Lformatfloat_frac:
+    LDRB    W4, [X8, X3]
+    STRB    W4, [X9], #1
+    ADD     X3, X3, #1
+    CMP     X3, X14
+    B.LO    .Lformatfloat_frac
+    B       .Lformatfloat_done
+    // 0.000ddd
+.Lformatfloat_fraction:
+    MOV     W4, #'0'
+    STRB    W4, [X9], #1
+    MOV     W4, #'.'
+    STRB    W4, [X9], #1
+    MOV     W4, #'0'
+    MOV     X3, #-1
+.Lformatfloat_zeros:
+    CMP     X3, X10
+    B.LE    .Lformatfloat_all
+    STRB    W4, [X9], #1
+    SUB     X3, X3, #1
+    B       .Lformatfloat_zeros
+.Lformatfloat_all:
+    MOV     X3, #0
+    B       .Lformatfloat_frac
+    // d.ddde±dd
+.Lformatfloat_exp:
+    LDRB    W4, [X8]
+    STRB    W4, [X9], #1
+    MOV     X3, #1
+    CMP     X14, #1
+    B.EQ    .Lformatfloat_e
+    MOV     W4, #'.'
+    STRB    W4, [X9], #1
+.Lformatfloat_mantissa:
+    LDRB    W4, [X8, X3]
+    STRB    W4, [X9], #1
+    ADD     X3, X3, #1
+    CMP     X3, X14
+    B.LO    .Lformatfloat_mantissa
+.Lformatfloat_e:
+    MOV     W4, #'e'
+    STRB    W4, [X9], #1
+    MOV     W4, #'+'
+    TBZ     X10, #63, .Lformatfloat_sign
+    MOV     W4, #'-'
+    NEG     X10, X10
+.Lformatfloat_sign:
+    STRB    W4, [X9], #1
+    CMP     X10, #100
+    B.LO    .Lformatfloat_two
+    MOV     X5, #100
+    UDIV    X6, X10, X5
+    ADD     W4, W6, #'0'
+    STRB    W4, [X9], #1
+    MSUB    X10, X6, X5, X10
+.Lformatfloat_two:
+    MOV     X5, #10
+    UDIV    X6, X10, X5
+    ADD     W4, W6, #'0'
+    STRB    W4, [X9], #1
+    MSUB    X10, X6, X5, X10
+    ADD     W4, W10, #'0'
+    STRB    W4, [X9], #1
+    B       .Lformatfloat_done
+.Lformatfloat_nan:
+    MOV     W4, #'N'
+    STRB    W4, [X9], #1
+    MOV     W4, #'a'
+    STRB    W4, [X9], #1
+    MOV     W4, #'N'
+    STRB    W4, [X9], #1
+    B       .Lformatfloat_done
+.Lformatfloat_inf:
+    CMP     X9, X15
+    B.NE    .Lformatfloat_infinity
+    MOV     W4, #'+'
+    STRB    W4, [X9], #1
+.Lformatfloat_infinity:
+    MOV     W4, #'I'
+    STRB    W4, [X9], #1
+    MOV     W4, #'n'
+    STRB    W4, [X9], #1
+    MOV     W4, #'f'
+    STRB    W4, [X9], #1
+    B       .Lformatfloat_done
+.Lformatfloat_zero:
+    MOV     W4, #'0'
+    STRB    W4, [X9], #1
+.Lformatfloat_done:
+    SUB     X0, X9, X15
+    LDR     X30, [sp]
+    ADD     sp, sp, #48
+    RET
+
+// Multiply D1 by 10^X4 as a double-double D1 + D2, powers beyond 1e22 are
+// applied in steps
+// Clobbers X4-X6, D4-D5
+.Lformatfloat_scale:
+    ADR     X5, .Lformatfloat_pow10
+    FMOV    D2, XZR
+.Lformatfloat_scale_loop:
+    CBZ     X4, .Lformatfloat_scale_done
+    TBNZ    X4, #63, .Lformatfloat_scale_down
+    MOV     X6, X4
+    CMP     X6, #22
+    B.LE    1f
+    MOV     X6, #22
+1:  LDR     D4, [X5, X6, LSL #3]
+    SUB     X4, X4, X6
+    FMUL    D5, D1, D4
+    FNMSUB  D1, D1, D4, D5          // Rounding error of the product
+    FMADD   D2, D2, D4, D1
+    FMOV    D1, D5
+    B       .Lformatfloat_scale_loop
+.Lformatfloat_scale_down:
+    NEG     X6, X4
+    CMP     X6, #22
+    B.LE    2f
+    MOV     X6, #22
+2:  LDR     D4, [X5, X6, LSL #3]
+    ADD     X4, X4, X6
+    FDIV    D5, D1, D4
+    FMSUB   D1, D5, D4, D1          // Remainder of the quotient
+    FADD    D2, D2, D1
+    FDIV    D2, D2, D4
+    FMOV    D1, D5
+    B       .Lformatfloat_scale_loop
+.Lformatfloat_scale_done:
+    RET
+
+    .balign 8
+.Lformatfloat_pow10:                // Exact as float64
+    .double 1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11
+    .double 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22
+.Lformatfloat_ipow10:
+    .quad   1, 10, 100, 1000, 10000, 100000, 1000000, 10000000, 100000000
+    .quad   1000000000, 10000000000, 100000000000, 1000000000000
+    .quad   10000000000000, 100000000000000, 1000000000000000
+    .quad   10000000000000000, 100000000000000000, 1000000000000000000
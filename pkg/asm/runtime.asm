@@ -1,315 +1,244 @@
-// Garbage Collector Constants and Structures
+// Garbage Collector Constants and Structures, shared by gen_gc.asm and
+// marksweep.asm which are linked after this file
 .equ COLOR_WHITE,    0    // Unreachable/not visited
 .equ COLOR_GREY,     1    // Reachable but not scanned
 .equ COLOR_BLACK,    2    // Reachable and scanned
-.equ PTR_BITS,       48   // Useful pointer bits
-.equ PAGE_SIZE,      4096
 .equ CARD_SIZE,      512  // Write barrier card table granularity
-.equ MIN_HEAP_SIZE,  64 * 1024 * 1024  // 64MB
+.equ CARD_SIZE_SHIFT, 9
+.equ CARD_COUNT,     1 << 20
+.equ CARD_DIRTY,     1
+.equ HEAP_SIZE,      256 * 1024 * 1024  // Reserved up front, pages are backed once touched
 
-// Object Header (16 bytes)
-.struct 0
-obj_size:    .space 8     // Object size including header
-obj_type:    .space 4     // Type descriptor index
-obj_color:   .space 1     // GC color
-obj_flags:   .space 3     // Flags (finalizer, etc)
-.end
+.equ SYS_mmap,       222
+.equ PROT_RW,        3      // PROT_READ | PROT_WRITE
+.equ MAP_RESERVE,    0x4022 // MAP_PRIVATE | MAP_ANONYMOUS | MAP_NORESERVE
 
-// Heap Page Header (64 bytes)
-.struct 0
-page_next:   .space 8     // Next page in list
-page_prev:   .space 8     // Previous page in list
-page_start:  .space 8     // Start of allocatable space
-page_end:    .space 8     // End of allocatable space
-page_free:   .space 8     // Free list head
-page_used:   .space 8     // Bytes allocated
-page_mark:   .space 8     // Mark bits
-page_spans:  .space 8     // Span information
-.end
+// Object Header (16 bytes), kept in sync with alloc.asm
+.equ obj_type,       0    // Type descriptor, see pkg/mapper/typedesc.go
+.equ obj_size,       8    // Object size including header, 32 bits
+.equ obj_color,      12   // Mark and sweep color
+.equ obj_flags,      13
+.equ obj_age,        14   // Minor collections survived
+.equ obj_header,     16
 
-// Write Barrier Card Table
-.data
-card_table:  .space (1 << 20)  // 1MB card table for 512GB address space
+.equ FLAG_FREE,      0    // Bit of a free chunk, obj_type links the next one
+.equ FLAG_FORWARDED, 1    // Bit of a copied object, obj_type holds the copy
+.equ FLAG_REMEMBERED, 2   // Bit of a tenured object on a dirty card
 
-// GC State
-.struct 0
-gc_enabled:  .space 1     // GC enabled flag
-gc_phase:    .space 1     // Current GC phase
-gc_workers:  .space 2     // Number of GC workers
-gc_trigger:  .space 8     // Next GC trigger point
-gc_heap:     .space 8     // Heap start
-gc_limit:    .space 8     // Heap limit
-gc_alloc:    .space 8     // Allocation pointer
-gc_roots:    .space 8     // Root set pointer
-.end
+// Heap State. The heap is a single mapping, its start bitmap holds a bit per
+// word set where an object header starts so interior pointers find their
+// object
+.equ gc_lo,          0    // Heap start
+.equ gc_bitmap,      8    // Start bitmap
+.equ gc_hi,          16   // Heap end
+.equ gc_state_size,  24
 
-.text
-// Initialize Garbage Collector
-.macro GC_INIT heap_size=MIN_HEAP_SIZE
-    // Allocate heap space
-    mov     x0, #0
-    ldr     x1, =\heap_size
-    mov     x2, #(PROT_READ | PROT_WRITE)
-    mov     x3, #(MAP_PRIVATE | MAP_ANONYMOUS)
-    mov     x8, #SYS_mmap
-    svc     #0
-    
-    // Initialize GC state
-    adr     x19, gc_state
-    str     x0, [x19, #gc_heap]
-    add     x1, x0, \heap_size
-    str     x1, [x19, #gc_limit]
-    str     x0, [x19, #gc_alloc]
-    
-    // Initialize card table
-    adr     x0, card_table
-    mov     x1, #0
-    ldr     x2, =(1 << 20)
-    bl      memset
-    
-    // Enable GC
-    mov     w0, #1
-    strb    w0, [x19, #gc_enabled]
-.endm
+// Write Barrier Card Table, kept in sync with pkg/mapper/barrier.go. Cards
+// are indexed by the address bits above CARD_SIZE_SHIFT modulo CARD_COUNT,
+// 512MB of heap map to distinct cards. The table starts clean, .bss takes no
+// room in the executable
+.bss
+runtime.cardtable:  .space CARD_COUNT
 
-// Write Barrier Implementation
-.macro WRITE_BARRIER ptr, val
-    // Get card table index
-    lsr     x15, \ptr, #CARD_SIZE_SHIFT
-    adr     x16, card_table
-    mov     w17, #1
-    strb    w17, [x16, x15]      // Mark card as dirty
-    
-    // Perform write
-    str     \val, [\ptr]
-    
-    // Check if we're in GC phase
-    adr     x15, gc_state
-    ldrb    w16, [x15, #gc_phase]
-    cbz     w16, 1f              // Skip if not in GC
-    
-    // Handle concurrent write
-    mov     x0, \val
-    bl      shade_grey           // Make value grey if needed
-    
-1:  // Continue
-.endm
+.bss
+    .balign 8
+gc_state:   .space gc_state_size
 
-// Mark Phase Implementation
-.macro MARK_PHASE
-    // Save registers
-    INIT_FRAME 6
-    
-    // Start marking from roots
-    adr     x19, gc_state
-    ldr     x20, [x19, #gc_roots]
-    
-    // Process root set
-1:  cbz     x20, 2f              // No more roots
-    ldr     x0, [x20]            // Load root pointer
-    bl      mark_grey            // Mark as grey
-    ldr     x20, [x20, #8]       // Next root
-    b       1b
-    
-2:  // Process grey objects
-    bl      process_grey_objects
-    
-    SAFE_STACK_RET 6
+.text
+// Set the start bit of the header at hdr, or clear it
+.macro STARTBIT hdr, t1, t2, t3, clear=0
+    adrp    \t1, gc_state
+    add     \t1, \t1, :lo12:gc_state
+    ldp     \t2, \t1, [\t1, #gc_lo]     // Heap start, bitmap
+    sub     \t2, \hdr, \t2
+    lsr     \t2, \t2, #3                // Word index of the header
+    lsr     \t3, \t2, #6
+    add     \t1, \t1, \t3, lsl #3       // Bitmap word holding its bit
+    mov     \t3, #1
+    lsl     \t2, \t3, \t2               // Shifts are taken modulo 64
+    ldr     \t3, [\t1]
+.if \clear
+    bic     \t3, \t3, \t2
+.else
+    orr     \t3, \t3, \t2
+.endif
+    str     \t3, [\t1]
 .endm
 
-// Process Grey Objects
-process_grey_objects:
-    INIT_FRAME 4
-    
-    // While grey set not empty
-1:  bl      get_grey_object
-    cbz     x0, 2f               // No more grey objects
-    
-    // Process object
-    mov     x19, x0              // Save object pointer
-    ldr     w20, [x19, #obj_type]
-    
-    // Get type descriptor
-    adr     x21, type_table
-    ldr     x21, [x21, x20, lsl #3]
-    
-    // Scan object fields
-    mov     x0, x19
-    mov     x1, x21
-    bl      scan_object_fields
-    
-    // Mark object black
-    mov     w0, #COLOR_BLACK
-    strb    w0, [x19, #obj_color]
-    
-    b       1b                   // Continue processing
-    
-2:  SAFE_STACK_RET 4
+// Map memory for the collector, exits when the system is out of it
+// Input:
+//   X0 = size in bytes, a multiple of the page size
+// Output:
+//   X0 = zeroed memory
+// Clobbers X1-X8
+gc_mmap:
+    MOV     X1, X0
+    MOV     X0, #0
+    MOV     X2, #PROT_RW
+    MOV     X3, #MAP_RESERVE
+    MOV     X4, #-1                 // No file
+    MOV     X5, #0
+    MOV     X8, #SYS_mmap
+    SVC     #0
+    CMN     X0, #4095               // -4095..-1 are errors
+    B.HS    gc_outofmemory
+    RET
 
-// Scan Object Fields
-scan_object_fields:
-    INIT_FRAME 4
-    
-    // Get field information
-    ldr     x19, [x1, #type_fields]
-    ldr     w20, [x1, #type_field_count]
-    
-    // For each field
-1:  cbz     w20, 2f              // No more fields
-    ldr     x21, [x19]           // Load field offset
-    ldr     x22, [x0, x21]       // Load field value
-    
-    // If pointer field
-    ldr     w23, [x19, #8]       // Load field type
-    tbz     w23, #0, 3f          // Skip if not pointer
-    
-    // Mark field grey
-    mov     x0, x22
-    bl      mark_grey
-    
-3:  add     x19, x19, #16        // Next field
-    sub     w20, w20, #1
-    b       1b
-    
-2:  SAFE_STACK_RET 4
+// Reserve the heap and its start bitmap
+// Output:
+//   X0 = heap start
+// Clobbers X1-X8
+gc_mapheap:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    MOV     X0, #HEAP_SIZE / 64     // A bit per word
+    BL      gc_mmap
+    ADRP    X1, gc_state
+    ADD     X1, X1, :lo12:gc_state
+    STR     X0, [X1, #gc_bitmap]
+    MOV     X0, #HEAP_SIZE
+    BL      gc_mmap
+    ADRP    X1, gc_state
+    ADD     X1, X1, :lo12:gc_state
+    STR     X0, [X1, #gc_lo]
+    MOV     X2, #HEAP_SIZE
+    ADD     X2, X0, X2
+    STR     X2, [X1, #gc_hi]
+    LDP     X29, X30, [sp], #16
+    RET
 
-// Sweep Phase Implementation
-.macro SWEEP_PHASE
-    INIT_FRAME 4
-    
-    // Get heap bounds
-    adr     x19, gc_state
-    ldr     x20, [x19, #gc_heap]
-    ldr     x21, [x19, #gc_limit]
-    
-    // For each page
-1:  cmp     x20, x21
-    b.ge    2f                   // Done
-    
-    // Get page header
-    mov     x0, x20
-    bl      sweep_page
-    
-    // Next page
-    ldr     x22, [x20, #page_next]
-    mov     x20, x22
-    b       1b
-    
-2:  SAFE_STACK_RET 4
-.endm
+// Clear the start bits of a heap range, both ends are multiples of 512
+// bytes apart from the heap start so whole bitmap words are cleared
+// Input:
+//   X0 = start
+//   X1 = end
+// Clobbers X0-X3
+gc_clearstarts:
+    ADRP    X2, gc_state
+    ADD     X2, X2, :lo12:gc_state
+    LDP     X3, X2, [X2, #gc_lo]    // Heap start, bitmap
+    SUB     X0, X0, X3
+    SUB     X1, X1, X3
+    ADD     X0, X2, X0, LSR #6      // A bitmap byte per 64 heap bytes
+    ADD     X1, X2, X1, LSR #6
+1:  CMP     X0, X1
+    B.HS    2f
+    STR     XZR, [X0], #8
+    B       1b
+2:  RET
 
-// Sweep Single Page
-sweep_page:
-    INIT_FRAME 4
-    
-    // Get page bounds
-    ldr     x19, [x0, #page_start]
-    ldr     x20, [x0, #page_end]
-    
-    // For each object in page
-1:  cmp     x19, x20
-    b.ge    2f                   // Done with page
-    
-    // Check color
-    ldrb    w21, [x19, #obj_color]
-    cmp     w21, #COLOR_WHITE
-    b.ne    3f                   // Skip if not white
-    
-    // Free white object
-    mov     x0, x19
-    bl      free_object
-    
-3:  // Next object
-    ldr     x22, [x19, #obj_size]
-    add     x19, x19, x22
-    b       1b
-    
-2:  SAFE_STACK_RET 4
+// Find the object a pointer points into. Pointers one past the end of an
+// object find that object, like the pointers Go allows
+// Input:
+//   X0 = pointer
+// Output:
+//   X0 = object header, 0 when the pointer is outside of the heap
+// Clobbers X1-X5
+gc_findobject:
+    ADRP    X1, gc_state
+    ADD     X1, X1, :lo12:gc_state
+    LDP     X2, X3, [X1, #gc_lo]    // Heap start, bitmap
+    LDR     X4, [X1, #gc_hi]
+    SUB     X0, X0, #obj_header     // Objects start a header below their pointers
+    CMP     X0, X2
+    B.LO    .Lfindobject_none
+    CMP     X0, X4
+    B.HS    .Lfindobject_none
+    SUB     X0, X0, X2
+    LSR     X0, X0, #3              // Word index
+    LSR     X4, X0, #6              // Bitmap word
+    AND     X0, X0, #63
+    MOV     X5, #63
+    SUB     X5, X5, X0              // Shift dropping the bits above the word
+    LDR     X1, [X3, X4, LSL #3]
+    LSL     X1, X1, X5
+    CBNZ    X1, .Lfindobject_found
+.Lfindobject_prev:
+    CBZ     X4, .Lfindobject_none
+    SUB     X4, X4, #1
+    LDR     X1, [X3, X4, LSL #3]
+    MOV     X5, #0
+    CBZ     X1, .Lfindobject_prev
+.Lfindobject_found:
+    CLZ     X1, X1
+    ADD     X1, X1, X5
+    MOV     X0, #63
+    SUB     X1, X0, X1              // Bit of the closest header below
+    ADD     X1, X1, X4, LSL #6      // Its word index
+    ADD     X0, X2, X1, LSL #3
+    RET
+.Lfindobject_none:
+    MOV     X0, #0
+    RET
 
-// Concurrent GC Worker
-.macro GC_WORKER
-    INIT_FRAME 2
-    
-1:  // Wait for work
-    bl      wait_for_gc_work
-    
-    // Check GC phase
-    adr     x19, gc_state
-    ldrb    w20, [x19, #gc_phase]
-    
-    // Handle different phases
-    cmp     w20, #GC_MARK_PHASE
-    b.eq    2f
-    cmp     w20, #GC_SWEEP_PHASE
-    b.eq    3f
-    b       1b
-    
-2:  // Mark phase work
-    bl      process_grey_objects
-    b       1b
-    
-3:  // Sweep phase work
-    bl      sweep_worker
-    b       1b
-    
-    SAFE_STACK_RET 2
-.endm
-
-// Allocator with GC Integration
-.macro GC_ALLOC size, type
-    // Try fast path allocation
-    adr     x19, gc_state
-    ldr     x20, [x19, #gc_alloc]
-    add     x21, x20, \size
-    ldr     x22, [x19, #gc_trigger]
-    cmp     x21, x22
-    b.gt    1f                   // Need GC
-    
-    // Fast path
-    str     x21, [x19, #gc_alloc]
-    mov     x0, x20
-    
-    // Initialize object header
-    str     \size, [x0, #obj_size]
-    str     \type, [x0, #obj_type]
-    mov     w1, #COLOR_BLACK
-    strb    w1, [x0, #obj_color]
-    b       2f
-    
-1:  // Trigger GC
-    bl      trigger_gc
-    
-    // Retry allocation
-    GC_ALLOC \size, \type
-    
-2:  // Done
-.endm
+// Scan Object Fields
+// Walks the pointer bitmap of the type descriptor, one bit per word of the
+// object, and stops after the words that can hold pointers. Objects larger
+// than their type are arrays of it eg channel buffers, the bitmap applies
+// to every element
+// Input:
+//   X0 = object header
+//   X1 = routine called with each non-nil pointer in X0 and its slot in X1,
+//        it must preserve X19-X28
+// Clobbers all caller saved registers
+gc_scanobject:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    STP     X23, X24, [sp, #-16]!
+    STP     X25, X26, [sp, #-16]!
+    MOV     X19, X1                 // Callback
+    LDR     X2, [X0, #obj_type]
+    CBZ     X2, .Lscanobject_done   // Raw memory
+    LDR     X23, [X2, #type_ptrdata]
+    CBZ     X23, .Lscanobject_done
+    LDR     X22, [X2, #type_size]
+    LDR     X24, [X2, #type_gcdata]
+    LDR     W3, [X0, #obj_size]
+    ADD     X20, X0, X3             // Object end
+    ADD     X21, X0, #obj_header    // Element
+.Lscanobject_elem:
+    ADD     X2, X21, X22
+    CMP     X2, X20
+    B.HI    .Lscanobject_done       // No room for another element
+    MOV     X25, #0                 // Word index
+.Lscanobject_word:
+    LSL     X2, X25, #3
+    CMP     X2, X23
+    B.HS    .Lscanobject_next
+    LSR     X3, X25, #3
+    LDRB    W3, [X24, X3]           // Bitmap byte of the word
+    AND     X4, X25, #7
+    LSR     W3, W3, W4
+    ADD     X25, X25, #1
+    TBZ     W3, #0, .Lscanobject_word
+    ADD     X1, X21, X2             // Slot
+    LDR     X0, [X1]
+    CBZ     X0, .Lscanobject_word
+    BLR     X19
+    B       .Lscanobject_word
+.Lscanobject_next:
+    ADD     X21, X21, X22
+    B       .Lscanobject_elem
+.Lscanobject_done:
+    LDP     X25, X26, [sp], #16
+    LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
 
-// Root Registration
-.macro REGISTER_ROOT ptr
-    adr     x19, gc_state
-    ldr     x20, [x19, #gc_roots]
-    
-    // Allocate root node
-    mov     x0, #16
-    bl      malloc
-    
-    // Initialize node
-    str     \ptr, [x0]
-    str     x20, [x0, #8]
-    
-    // Update root list
-    str     x0, [x19, #gc_roots]
-.endm
+// The heap is exhausted
+gc_outofmemory:
+    ADR     X0, .Lgc_oom_msg
+    MOV     X1, #(.Lgc_oom_end - .Lgc_oom_msg)
+    BL      runtime.printstderr
+    MOV     X0, #2
+    MOV     X8, #94                 // exit_group
+    SVC     #0
 
-// Finalizer Support
-.macro REGISTER_FINALIZER obj, func
-    // Add finalizer to object
-    ldr     w0, [x19, #obj_flags]
-    orr     w0, w0, #FLAG_FINALIZER
-    str     w0, [x19, #obj_flags]
-    
-    // Register finalizer function
-    adr     x0, finalizer_table
-    str     \func, [x0, \obj, lsl #3]
-.endm
\ No newline at end of file
+.Lgc_oom_msg:
+    .ascii  "fatal error: runtime: out of memory\n"
+.Lgc_oom_end:
+    .balign 4
@@ -0,0 +1,490 @@
+// Goroutine layout, kept in sync with pkg/mapper/goroutine.go. A goroutine
+// starts by making the call described by its record, which shares the defer
+// record layout with the argument pointer bitmap in place of defer_resume
+.equ g_sp,         0    // Saved stack pointer while switched out
+.equ g_status,     8
+.equ g_next,       16   // Run queue or free list
+.equ g_alllink,    24   // Every goroutine, walked by runtime.scanstack
+.equ g_stack,      32   // Top of the stack
+.equ g_rec,        48   // Call made by the goroutine
+.equ g_panic,      208  // Panic state, g_rec + defer_size
+.equ g_size,       240  // g_panic + panic_size
+.equ go_ptrs,      24   // Bit i set when x<i> of the record holds a pointer, bit 8 the context
+
+.equ G_IDLE,       0
+.equ G_RUNNABLE,   1
+.equ G_RUNNING,    2
+.equ G_WAITING,    3    // Parked until runtime.goready
+.equ G_DEAD,       4    // On the free list
+
+// OS thread running goroutines, TPIDR_EL0 points to its M
+.equ m_curg,       0    // Running goroutine
+.equ m_g0sp,       8    // Top of the scheduler stack
+.equ m_size,       16
+
+// Context pushed on the stack of a goroutine switched out, the frame record
+// comes first so stack scanning resumes its frame chain
+.equ ctx_fp,       0
+.equ ctx_lr,       8
+.equ ctx_x19,      16   // x19-x28
+.equ ctx_d8,       96   // d8-d15
+.equ ctx_size,     160
+
+.equ STACK_SIZE,   64 * 1024   // Goroutine, guard page included
+.equ SCHED_STACK,  16 * 1024   // Scheduler of an M
+.equ GUARD_SIZE,   4096        // Page below each goroutine stack
+.equ CLONE_THREAD_FLAGS, 0x50f00 // CLONE_VM | CLONE_FS | CLONE_FILES | CLONE_SIGHAND | CLONE_THREAD | CLONE_SYSVSEM
+.equ SYS_sched_yield, 124
+.equ SYS_mprotect, 226
+.equ SYS_clone,    220
+
+// Run queue, free goroutines and thread accounting behind one spin lock
+.equ sched_lock,     0
+.equ sched_head,     8
+.equ sched_tail,     16
+.equ sched_free,     24
+.equ sched_nidle,    32   // Threads without a goroutine to run
+.equ sched_nthreads, 40
+
+// Append a goroutine to the run queue, the scheduler lock is held
+.macro RUNQPUT g, sched, tmp
+    mov     \tmp, #G_RUNNABLE
+    str     \tmp, [\g, #g_status]
+    str     xzr, [\g, #g_next]
+    ldr     \tmp, [\sched, #sched_tail]
+    cbz     \tmp, 1f
+    str     \g, [\tmp, #g_next]
+    b       2f
+1:  str     \g, [\sched, #sched_head]
+2:  str     \g, [\sched, #sched_tail]
+.endm
+
+.data
+    .balign 8
+runtime.gcwaiting:
+    .quad   0                       // Polled by compiled code at loop back-edges
+    .quad   0                       // Collector run at safepoints, 0 for none
+    .quad   0                       // Scheduler run at safepoints, 0 for none
+
+    .balign 8
+runtime.sched:
+    .space  48
+
+    .balign 8
+runtime.allgs:
+    .quad   0                       // Most recently created goroutine
+
+    .balign 8
+runtime.g0:
+    .space  g_size                  // Main goroutine, runs on the stack of the process
+
+    .balign 8
+runtime.m0:
+    .space  m_size
+
+.text
+
+// Safepoint reached by a loop while runtime.gcwaiting is set. The registers
+// of the caller are in its save area and described by the stack map of this
+// call, so the collector can scan and update them and the scheduler can
+// switch to another goroutine
+runtime.gcsafepoint:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    ADRP    X0, runtime.gcwaiting
+    ADD     X0, X0, :lo12:runtime.gcwaiting
+    STR     XZR, [X0]
+    LDR     X16, [X0, #8]
+    CBZ     X16, 1f
+    BLR     X16
+1:  ADRP    X0, runtime.gcwaiting
+    ADD     X0, X0, :lo12:runtime.gcwaiting
+    LDR     X16, [X0, #16]
+    CBZ     X16, 2f
+    BLR     X16
+2:  LDP     X29, X30, [sp], #16
+    RET
+
+// Acquire a spin lock
+// Input:
+//   X0 = lock word
+// Clobbers X16, X17
+runtime.lock:
+    MOV     X17, #1
+1:  LDAXR   X16, [X0]
+    CBNZ    X16, 2f
+    STXR    W16, X17, [X0]
+    CBNZ    W16, 1b
+    RET
+2:  YIELD
+    B       1b
+
+// Release a spin lock
+// Input:
+//   X0 = lock word
+runtime.unlock:
+    STLR    XZR, [X0]
+    RET
+
+// Map zeroed memory
+// Input:
+//   X0 = size in bytes
+// Output:
+//   X0 = address
+// Clobbers X1-X5, X8
+runtime.sysalloc:
+    MOV     X1, X0
+    MOV     X0, #0
+    MOV     X2, #3                  // PROT_READ | PROT_WRITE
+    MOV     X3, #0x22               // MAP_PRIVATE | MAP_ANONYMOUS
+    MOV     X4, #-1
+    MOV     X5, #0
+    MOV     X8, #222                // mmap
+    SVC     #0
+    CMN     X0, #4095               // -4095..-1 are errors
+    B.HS    .Lsysalloc_oom
+    RET
+.Lsysalloc_oom:
+    ADR     X0, .Lsysalloc_msg
+    MOV     X1, #(.Lsysalloc_end - .Lsysalloc_msg)
+    BL      runtime.printstderr
+    MOV     X0, #2
+    MOV     X8, #94                 // exit_group
+    SVC     #0
+.Lsysalloc_msg:
+    .ascii  "fatal error: runtime: cannot allocate stack\n"
+.Lsysalloc_end:
+    .balign 4
+
+// Find the panic state of the running goroutine, the main goroutine keeps it
+// in runtime.panicstate until runtime.schedinit gives it a G
+// Output:
+//   X2 = panic state
+runtime.getpanic:
+    MRS     X2, TPIDR_EL0
+    CBZ     X2, 1f
+    LDR     X2, [X2, #m_curg]
+    ADD     X2, X2, #g_panic
+    RET
+1:  ADRP    X2, runtime.panicstate
+    ADD     X2, X2, :lo12:runtime.panicstate
+    RET
+
+// Make the running code the main goroutine and start the threads asked for
+// by go.maxprocs, called by the entry point before the package initializers
+runtime.schedinit:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    ADRP    X19, runtime.m0
+    ADD     X19, X19, :lo12:runtime.m0
+    MSR     TPIDR_EL0, X19
+    ADRP    X20, runtime.g0
+    ADD     X20, X20, :lo12:runtime.g0
+    STR     X20, [X19, #m_curg]
+    MOV     X0, #G_RUNNING
+    STR     X0, [X20, #g_status]
+    ADRP    X0, runtime.allgs
+    ADD     X0, X0, :lo12:runtime.allgs
+    STR     X20, [X0]
+    MOV     X0, #SCHED_STACK
+    BL      runtime.sysalloc
+    MOV     X1, #SCHED_STACK
+    ADD     X0, X0, X1
+    STR     X0, [X19, #m_g0sp]
+    // Safepoints yield to other goroutines once some are runnable
+    ADRP    X0, runtime.gcwaiting
+    ADD     X0, X0, :lo12:runtime.gcwaiting
+    ADR     X1, runtime.gosched
+    STR     X1, [X0, #16]
+    ADRP    X0, go.maxprocs
+    LDR     X20, [X0, :lo12:go.maxprocs]
+    ADRP    X0, runtime.sched
+    ADD     X0, X0, :lo12:runtime.sched
+    STR     X20, [X0, #sched_nthreads]
+.Lschedinit_threads:
+    SUBS    X20, X20, #1
+    B.LE    .Lschedinit_done
+    BL      runtime.newm
+    B       .Lschedinit_threads
+.Lschedinit_done:
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Start an OS thread looking for goroutines to run, its M sits at the bottom
+// of its scheduler stack
+// Clobbers caller saved registers
+runtime.newm:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    MOV     X0, #SCHED_STACK
+    BL      runtime.sysalloc
+    MOV     X1, #SCHED_STACK
+    ADD     X1, X0, X1
+    STR     X1, [X0, #m_g0sp]
+    STR     X0, [X1, #-16]!         // M for the new thread
+    MOV     X0, #(CLONE_THREAD_FLAGS & 0xffff)
+    MOVK    X0, #(CLONE_THREAD_FLAGS >> 16), LSL #16
+    MOV     X2, #0
+    MOV     X3, #0
+    MOV     X4, #0
+    MOV     X8, #SYS_clone
+    SVC     #0
+    CBZ     X0, .Lnewm_thread
+    LDP     X29, X30, [sp], #16
+    RET
+.Lnewm_thread:
+    LDR     X0, [sp]
+    MSR     TPIDR_EL0, X0
+    LDR     X1, [X0, #m_g0sp]
+    MOV     sp, X1
+    MOV     X29, #0
+    MOV     X0, #0                  // No goroutine to put away
+    MOV     X2, #0
+    B       runtime.schedule
+
+// Create a goroutine making the call described by a record, it runs once the
+// goroutines queued before it had their turn
+// Input:
+//   X0 = record, defer record layout with go_ptrs
+// Clobbers caller saved registers
+runtime.newproc:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    MOV     X19, X0
+    ADRP    X20, runtime.sched
+    ADD     X20, X20, :lo12:runtime.sched
+    // Reuse a goroutine that exited
+    MOV     X0, X20
+    BL      runtime.lock
+    LDR     X21, [X20, #sched_free]
+    CBZ     X21, 1f
+    LDR     X0, [X21, #g_next]
+    STR     X0, [X20, #sched_free]
+1:  MOV     X0, X20
+    BL      runtime.unlock
+    CBNZ    X21, .Lnewproc_init
+    // The goroutine, a guard page and its stack in one mapping
+    MOV     X0, #STACK_SIZE
+    BL      runtime.sysalloc
+    MOV     X21, X0
+    ADD     X0, X21, #GUARD_SIZE
+    MOV     X1, #GUARD_SIZE
+    MOV     X2, #0                  // PROT_NONE
+    MOV     X8, #SYS_mprotect
+    SVC     #0
+    MOV     X0, #STACK_SIZE
+    ADD     X0, X21, X0
+    STR     X0, [X21, #g_stack]
+    MOV     X0, X20
+    BL      runtime.lock
+    ADRP    X0, runtime.allgs
+    ADD     X0, X0, :lo12:runtime.allgs
+    LDR     X1, [X0]
+    STR     X1, [X21, #g_alllink]
+    STR     X21, [X0]
+    MOV     X0, X20
+    BL      runtime.unlock
+.Lnewproc_init:
+    // Copy the record
+    ADD     X1, X21, #g_rec
+    ADD     X5, X19, #defer_size
+2:  LDP     X3, X4, [X19], #16
+    STP     X3, X4, [X1], #16
+    CMP     X19, X5
+    B.LO    2b
+    // First switch lands in runtime.goentry with an empty frame chain
+    LDR     X5, [X21, #g_stack]
+    SUB     X1, X5, #ctx_size
+    MOV     X2, X1
+3:  STP     XZR, XZR, [X2], #16
+    CMP     X2, X5
+    B.LO    3b
+    ADR     X2, runtime.goentry
+    STR     X2, [X1, #ctx_lr]
+    STR     X1, [X21, #g_sp]
+    MOV     X0, X20
+    BL      runtime.lock
+    RUNQPUT X21, X20, X1
+    MOV     X0, X20
+    BL      runtime.unlock
+    // Ask the running goroutine to yield at its next safepoint
+    ADRP    X0, runtime.gcwaiting
+    ADD     X0, X0, :lo12:runtime.gcwaiting
+    MOV     X1, #1
+    STR     X1, [X0]
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Make a parked goroutine runnable
+// Input:
+//   X0 = goroutine
+// Clobbers X0-X2, X16, X17
+runtime.goready:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    MOV     X2, X0
+    ADRP    X0, runtime.sched
+    ADD     X0, X0, :lo12:runtime.sched
+    BL      runtime.lock
+    RUNQPUT X2, X0, X1
+    BL      runtime.unlock
+    ADRP    X0, runtime.gcwaiting
+    ADD     X0, X0, :lo12:runtime.gcwaiting
+    MOV     X1, #1
+    STR     X1, [X0]
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Yield the processor, the goroutine goes to the back of the run queue
+// Preserves X19-X29 and D8-D15 like any call
+runtime.gosched:
+    MOV     X1, #G_RUNNABLE
+    MOV     X2, #0
+    B       .Lgopark_switch
+
+// Park the goroutine until runtime.goready, the lock protecting the wait
+// queue holding it is released once it is off its stack
+// Input:
+//   X0 = lock word, 0 for none
+// Preserves X19-X29 and D8-D15 like any call
+runtime.gopark:
+    MOV     X1, #G_WAITING
+    MOV     X2, X0
+.Lgopark_switch:
+    SUB     sp, sp, #ctx_size
+    STP     X29, X30, [sp, #ctx_fp]
+    STP     X19, X20, [sp, #ctx_x19]
+    STP     X21, X22, [sp, #ctx_x19 + 16]
+    STP     X23, X24, [sp, #ctx_x19 + 32]
+    STP     X25, X26, [sp, #ctx_x19 + 48]
+    STP     X27, X28, [sp, #ctx_x19 + 64]
+    STP     D8, D9, [sp, #ctx_d8]
+    STP     D10, D11, [sp, #ctx_d8 + 16]
+    STP     D12, D13, [sp, #ctx_d8 + 32]
+    STP     D14, D15, [sp, #ctx_d8 + 48]
+    MRS     X9, TPIDR_EL0
+    LDR     X0, [X9, #m_curg]
+    MOV     X10, sp
+    STR     X10, [X0, #g_sp]
+    LDR     X10, [X9, #m_g0sp]
+    MOV     sp, X10
+    MOV     X29, #0
+    B       runtime.schedule
+
+// First code run by a goroutine, makes the call of its record and exits
+runtime.goentry:
+    MRS     X9, TPIDR_EL0
+    LDR     X9, [X9, #m_curg]
+    ADD     X16, X9, #g_rec
+    STR     XZR, [X16, #go_ptrs]    // Arguments are in registers from now on
+    LDP     X0, X1, [X16, #defer_args]
+    LDP     X2, X3, [X16, #defer_args + 16]
+    LDP     X4, X5, [X16, #defer_args + 32]
+    LDP     X6, X7, [X16, #defer_args + 48]
+    LDP     D0, D1, [X16, #defer_fargs]
+    LDP     D2, D3, [X16, #defer_fargs + 16]
+    LDP     D4, D5, [X16, #defer_fargs + 32]
+    LDP     D6, D7, [X16, #defer_fargs + 48]
+    LDR     X26, [X16, #defer_ctx]
+    LDR     X16, [X16, #defer_fn]
+    BLR     X16
+runtime.goexit:
+    MRS     X9, TPIDR_EL0
+    LDR     X0, [X9, #m_curg]
+    LDR     X10, [X9, #m_g0sp]
+    MOV     sp, X10
+    MOV     X29, #0
+    MOV     X1, #G_DEAD
+    MOV     X2, #0
+    B       runtime.schedule
+
+// Put away the goroutine switched out and run the next one, on the scheduler
+// stack of the M. With every thread idle and nothing to run all goroutines
+// are blocked for good
+// Input:
+//   X0 = goroutine switched out, 0 for none
+//   X1 = its new status
+//   X2 = lock word to release, 0 for none
+runtime.schedule:
+    MOV     X20, X0
+    MOV     X21, X1
+    MOV     X22, X2
+    ADRP    X19, runtime.sched
+    ADD     X19, X19, :lo12:runtime.sched
+    MOV     X0, X19
+    BL      runtime.lock
+    CBZ     X20, .Lschedule_next
+    CBZ     X22, 1f
+    STLR    XZR, [X22]
+1:  CMP     X21, #G_RUNNABLE
+    B.NE    .Lschedule_status
+    RUNQPUT X20, X19, X1
+    B       .Lschedule_next
+.Lschedule_status:
+    STR     X21, [X20, #g_status]
+    CMP     X21, #G_DEAD
+    B.NE    .Lschedule_next
+    LDR     X1, [X19, #sched_free]
+    STR     X1, [X20, #g_next]
+    STR     X20, [X19, #sched_free]
+.Lschedule_next:
+    LDR     X20, [X19, #sched_head]
+    CBNZ    X20, .Lschedule_run
+    LDR     X1, [X19, #sched_nidle]
+    ADD     X1, X1, #1
+    LDR     X2, [X19, #sched_nthreads]
+    CMP     X1, X2
+    B.HS    .Lschedule_deadlock
+    STR     X1, [X19, #sched_nidle]
+    MOV     X0, X19
+    BL      runtime.unlock
+    MOV     X8, #SYS_sched_yield
+    SVC     #0
+    MOV     X0, X19
+    BL      runtime.lock
+    LDR     X1, [X19, #sched_nidle]
+    SUB     X1, X1, #1
+    STR     X1, [X19, #sched_nidle]
+    B       .Lschedule_next
+.Lschedule_run:
+    LDR     X1, [X20, #g_next]
+    STR     X1, [X19, #sched_head]
+    CBNZ    X1, 3f
+    STR     XZR, [X19, #sched_tail]
+3:  MOV     X1, #G_RUNNING
+    STR     X1, [X20, #g_status]
+    MRS     X9, TPIDR_EL0
+    STR     X20, [X9, #m_curg]
+    MOV     X0, X19
+    BL      runtime.unlock
+    LDR     X10, [X20, #g_sp]
+    MOV     sp, X10
+    LDP     X29, X30, [sp, #ctx_fp]
+    LDP     X19, X20, [sp, #ctx_x19]
+    LDP     X21, X22, [sp, #ctx_x19 + 16]
+    LDP     X23, X24, [sp, #ctx_x19 + 32]
+    LDP     X25, X26, [sp, #ctx_x19 + 48]
+    LDP     X27, X28, [sp, #ctx_x19 + 64]
+    LDP     D8, D9, [sp, #ctx_d8]
+    LDP     D10, D11, [sp, #ctx_d8 + 16]
+    LDP     D12, D13, [sp, #ctx_d8 + 32]
+    LDP     D14, D15, [sp, #ctx_d8 + 48]
+    ADD     sp, sp, #ctx_size
+    RET
+.Lschedule_deadlock:
+    ADR     X0, .Lschedule_msg
+    MOV     X1, #(.Lschedule_end - .Lschedule_msg)
+    BL      runtime.printstderr
+    MOV     X0, #2
+    MOV     X8, #94                 // exit_group
+    SVC     #0
+.Lschedule_msg:
+    .ascii  "fatal error: all goroutines are asleep - deadlock!\n"
+.Lschedule_end:
+    .balign 4
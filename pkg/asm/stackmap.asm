@@ -0,0 +1,157 @@
+// Stack map table layout, kept in sync with pkg/mapper/stackmap.go. The
+// compiler lists every call site with pointers in its frame in go.stackmaps
+.equ stackmap_pc,    0    // Return address of the call
+.equ stackmap_words, 8    // Frame words described by the bitmap
+.equ stackmap_bits,  16   // Bit i is set when [x29, #-8*(i+1)] holds a pointer
+.equ stackmap_size,  24
+
+.text
+
+// Enumerate the pointers held in the frames of compiled code. Frames are
+// walked through the frame records linked by the function prologues up to
+// the zero frame pointer of the entry point, frames without a stack map
+// belong to the runtime and are skipped. Goroutines switched out resume the
+// walk at their saved context, the ones not started yet hold their pointers
+// in the arguments of their record. Every goroutine holds the value of its
+// panic in progress
+// Input:
+//   X0 = routine called with each non-nil pointer in X0, it must preserve
+//        X19-X28 and may update the slot through X1
+// Clobbers all caller saved registers
+runtime.scanstack:
+    STP     X29, X30, [sp, #-16]!
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    STP     X23, X24, [sp, #-16]!
+    STP     X25, X26, [sp, #-16]!
+    MOV     X19, X0                 // Callback
+    MOV     X20, X29                // Frame of the caller
+    MOV     X21, X30                // Return address into that frame
+    ADRP    X26, runtime.allgs
+    LDR     X26, [X26, :lo12:runtime.allgs]
+.Lscanstack_frame:
+    CBZ     X20, .Lscanstack_g
+    // Find the stack map of the call site
+    ADRP    X22, go.stackmaps
+    ADD     X22, X22, :lo12:go.stackmaps
+    LDR     X23, [X22], #8          // Number of entries
+.Lscanstack_find:
+    CBZ     X23, .Lscanstack_up
+    LDR     X24, [X22, #stackmap_pc]
+    CMP     X24, X21
+    B.EQ    .Lscanstack_found
+    ADD     X22, X22, #stackmap_size
+    SUB     X23, X23, #1
+    B       .Lscanstack_find
+.Lscanstack_found:
+    LDR     X23, [X22, #stackmap_words]
+    LDR     X24, [X22, #stackmap_bits]
+    MOV     X25, #0                 // Word index
+.Lscanstack_word:
+    CMP     X25, X23
+    B.HS    .Lscanstack_up
+    LSR     X2, X25, #3
+    LDRB    W2, [X24, X2]
+    AND     X3, X25, #7
+    LSR     W2, W2, W3
+    ADD     X25, X25, #1
+    TBZ     W2, #0, .Lscanstack_word
+    SUB     X1, X20, X25, LSL #3    // [x29, #-8*(i+1)]
+    LDR     X0, [X1]
+    CBZ     X0, .Lscanstack_word
+    BLR     X19
+    B       .Lscanstack_word
+.Lscanstack_up:
+    LDR     X21, [X20, #8]          // Return address into the caller
+    LDR     X20, [X20]              // Frame of the caller
+    B       .Lscanstack_frame
+.Lscanstack_g:
+    CBZ     X26, .Lscanstack_done
+    MOV     X22, X26
+    LDR     X26, [X22, #g_alllink]
+    ADD     X1, X22, #g_panic + panic_value + 8
+    LDR     X0, [X1]
+    CBZ     X0, 5f
+    BLR     X19
+5:  LDR     X23, [X22, #g_status]
+    CMP     X23, #G_RUNNABLE
+    B.EQ    1f
+    CMP     X23, #G_WAITING
+    B.NE    .Lscanstack_g
+1:  // Arguments of a goroutine that has not started
+    LDR     X24, [X22, #g_rec + go_ptrs]
+    MOV     X25, #0
+2:  TBZ     X24, #0, 3f
+    ADD     X1, X22, #g_rec + defer_args
+    ADD     X1, X1, X25, LSL #3
+    CMP     X25, #8
+    B.NE    4f
+    ADD     X1, X22, #g_rec + defer_ctx
+4:  LDR     X0, [X1]
+    CBZ     X0, 3f
+    BLR     X19
+3:  LSR     X24, X24, #1
+    ADD     X25, X25, #1
+    CBNZ    X24, 2b
+    LDR     X23, [X22, #g_sp]
+    LDP     X20, X21, [X23, #ctx_fp]
+    B       .Lscanstack_frame
+.Lscanstack_done:
+    LDP     X25, X26, [sp], #16
+    LDP     X23, X24, [sp], #16
+    LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Enumerate the pointers held in globals, go.gcroots lists the address of
+// every global word holding one, and the value of a panic of the main
+// goroutine running without a scheduler
+// Input:
+//   X0 = routine called with each non-nil pointer in X0, it must preserve
+//        X19-X28 and may update the slot through X1
+// Clobbers all caller saved registers
+runtime.scanglobals:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    STP     X21, X22, [sp, #-16]!
+    MOV     X19, X0
+    ADRP    X20, go.gcroots
+    ADD     X20, X20, :lo12:go.gcroots
+    LDR     X21, [X20], #8          // Number of roots
+1:  CBZ     X21, 2f
+    SUB     X21, X21, #1
+    LDR     X1, [X20], #8
+    LDR     X0, [X1]
+    CBZ     X0, 1b
+    BLR     X19
+    B       1b
+2:  ADRP    X1, runtime.panicstate
+    ADD     X1, X1, :lo12:runtime.panicstate
+    ADD     X1, X1, #panic_value + 8
+    LDR     X0, [X1]
+    CBZ     X0, 3f
+    BLR     X19
+3:  LDP     X21, X22, [sp], #16
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
+
+// Enumerate the roots of a collection, the pointers held by goroutine stacks
+// and globals
+// Input:
+//   X0 = routine called with each non-nil pointer in X0, it must preserve
+//        X19-X28 and may update the slot through X1
+// Clobbers all caller saved registers
+runtime.scanroots:
+    STP     X29, X30, [sp, #-16]!
+    MOV     X29, sp
+    STP     X19, X20, [sp, #-16]!
+    MOV     X19, X0
+    BL      runtime.scanstack
+    MOV     X0, X19
+    BL      runtime.scanglobals
+    LDP     X19, X20, [sp], #16
+    LDP     X29, X30, [sp], #16
+    RET
@@ -0,0 +1,229 @@
+// String iterator, kept in sync with pkg/mapper/range.go. It lives in the
+// frame of the ranging function
+.equ si_data,      0
+.equ si_len,       8
+.equ si_pos,       16    // Byte offset of the next rune
+
+.equ RUNE_ERROR,   0xfffd
+
+// Branch to label unless lo <= b <= lo+span
+.macro INRANGE b, lo, span, tmp, label
+    sub     \tmp, \b, \lo
+    cmp     \tmp, \span
+    b.hi    \label
+.endm
+
+.text
+
+// Decode the next rune of a string like for range does: invalid encodings
+// yield RuneError and advance a single byte
+// Input:
+//   X0 = iterator
+// Output:
+//   X0 = 1 until the string is exhausted
+//   X1 = byte offset of the rune
+//   X2 = rune
+// Clobbers X3-X13
+runtime.stringnext:
+    LDP     X3, X4, [X0, #si_data]
+    LDR     X1, [X0, #si_pos]
+    CMP     X1, X4
+    B.HS    .Lstringnext_done
+    ADD     X5, X3, X1              // Lead byte
+    SUB     X6, X4, X1              // Bytes left
+    LDRB    W2, [X5]
+    MOV     X8, #1                  // Width
+    CMP     W2, #0x80
+    B.LO    .Lstringnext_ret
+    MOV     W7, W2
+    MOV     W2, #RUNE_ERROR
+    MOV     W9, #0x80               // Range of the second byte
+    MOV     W10, #0x3f
+    CMP     W7, #0xc2
+    B.LO    .Lstringnext_ret
+    CMP     W7, #0xe0
+    B.LO    .Lstringnext_two
+    CMP     W7, #0xf0
+    B.LO    .Lstringnext_three
+    CMP     W7, #0xf4
+    B.HI    .Lstringnext_ret
+    // Four bytes, F0 starts at 90 and F4 stops at 8F to stay under 10FFFF
+    CMP     X6, #4
+    B.LO    .Lstringnext_ret
+    CMP     W7, #0xf0
+    MOV     W13, #0x90
+    CSEL    W9, W13, W9, EQ
+    MOV     W13, #0x2f
+    CSEL    W10, W13, W10, EQ
+    CMP     W7, #0xf4
+    MOV     W13, #0x0f
+    CSEL    W10, W13, W10, EQ
+    LDRB    W11, [X5, #1]
+    INRANGE W11, W9, W10, W13, .Lstringnext_ret
+    LDRB    W12, [X5, #2]
+    INRANGE W12, #0x80, #0x3f, W13, .Lstringnext_ret
+    LDRB    W13, [X5, #3]
+    SUB     W13, W13, #0x80
+    CMP     W13, #0x3f
+    B.HI    .Lstringnext_ret
+    AND     W2, W7, #0x07
+    AND     W11, W11, #0x3f
+    AND     W12, W12, #0x3f
+    LSL     W2, W2, #18
+    ORR     W2, W2, W11, LSL #12
+    ORR     W2, W2, W12, LSL #6
+    ORR     W2, W2, W13
+    MOV     X8, #4
+    B       .Lstringnext_ret
+.Lstringnext_two:
+    CMP     X6, #2
+    B.LO    .Lstringnext_ret
+    LDRB    W11, [X5, #1]
+    INRANGE W11, W9, W10, W13, .Lstringnext_ret
+    AND     W2, W7, #0x1f
+    AND     W11, W11, #0x3f
+    ORR     W2, W11, W2, LSL #6
+    MOV     X8, #2
+    B       .Lstringnext_ret
+.Lstringnext_three:
+    // E0 starts at A0 against overlong forms, ED stops at 9F before surrogates
+    CMP     X6, #3
+    B.LO    .Lstringnext_ret
+    CMP     W7, #0xe0
+    MOV     W13, #0xa0
+    CSEL    W9, W13, W9, EQ
+    MOV     W13, #0x1f
+    CSEL    W10, W13, W10, EQ
+    CMP     W7, #0xed
+    CSEL    W10, W13, W10, EQ
+    LDRB    W11, [X5, #1]
+    INRANGE W11, W9, W10, W13, .Lstringnext_ret
+    LDRB    W12, [X5, #2]
+    INRANGE W12, #0x80, #0x3f, W13, .Lstringnext_ret
+    AND     W2, W7, #0x0f
+    AND     W11, W11, #0x3f
+    AND     W12, W12, #0x3f
+    LSL     W2, W2, #12
+    ORR     W2, W2, W11, LSL #6
+    ORR     W2, W2, W12
+    MOV     X8, #3
+.Lstringnext_ret:
+    ADD     X3, X1, X8
+    STR     X3, [X0, #si_pos]
+    MOV     X0, #1
+    RET
+.Lstringnext_done:
+    MOV     X0, #0
+    RET
+
+// Copy a string into a new byte slice, []byte(s). An empty string keeps its
+// data pointer
+// Input:
+//   X0, X1 = string
+// Output:
+//   X0, X1, X2 = slice
+runtime.stringtobytes:
+    MOV     X2, X1
+    CBZ     X1, .Lstringtobytes_done
+    STP     X29, X30, [sp, #-32]!
+    STP     X19, X20, [sp, #16]
+    MOV     X19, X0
+    MOV     X20, X1
+    MOV     X0, X1
+    ADRP    X1, type.uint8
+    ADD     X1, X1, :lo12:type.uint8
+    BL      runtime.rawalloc        // The string may be a heap object held in X19
+    MOV     X2, #0
+.Lstringtobytes_copy:
+    LDRB    W3, [X19, X2]
+    STRB    W3, [X0, X2]
+    ADD     X2, X2, #1
+    CMP     X2, X20
+    B.LO    .Lstringtobytes_copy
+    MOV     X1, X20
+    LDP     X19, X20, [sp, #16]
+    LDP     X29, X30, [sp], #32
+.Lstringtobytes_done:
+    RET
+
+// strings.Index, the offset of the first instance of substr in s or -1
+// Input:
+//   X0, X1 = s
+//   X2, X3 = substr
+// Output:
+//   X0 = offset or -1
+// Clobbers X1-X9
+runtime.stringindex:
+    CMP     X1, X3
+    B.LO    .Lstringindex_none
+    STP     X29, X30, [sp, #-16]!
+    MOV     X5, X0                  // Candidate
+    SUB     X6, X1, X3
+    ADD     X6, X0, X6              // Last candidate
+    MOV     X7, X2
+    MOV     X8, X3
+    MOV     X9, X0
+.Lstringindex_loop:
+    MOV     X0, X5
+    MOV     X1, X7
+    MOV     X2, X8
+    BL      runtime.memequal
+    CBNZ    X0, .Lstringindex_found
+    ADD     X5, X5, #1
+    CMP     X5, X6
+    B.LS    .Lstringindex_loop
+    LDP     X29, X30, [sp], #16
+.Lstringindex_none:
+    MOV     X0, #-1
+    RET
+.Lstringindex_found:
+    SUB     X0, X5, X9
+    LDP     X29, X30, [sp], #16
+    RET
+
+// strings.Contains
+// Input:
+//   X0, X1 = s
+//   X2, X3 = substr
+// Output:
+//   X0 = 1 when substr is within s
+// Clobbers X1-X9
+runtime.stringcontains:
+    STP     X29, X30, [sp, #-16]!
+    BL      runtime.stringindex
+    CMP     X0, #0
+    CSET    X0, GE
+    LDP     X29, X30, [sp], #16
+    RET
+
+// strings.HasPrefix
+// Input:
+//   X0, X1 = s
+//   X2, X3 = prefix
+// Output:
+//   X0 = 1 when s begins with prefix
+// Clobbers X1-X4
+runtime.stringhasprefix:
+    CMP     X1, X3
+    B.LO    .Lbytes_unequal
+    MOV     X1, X2
+    MOV     X2, X3
+    B       runtime.memequal
+
+// bytes.Equal, slices are passed by address
+// Input:
+//   X0, X1 = addresses of the slices
+// Output:
+//   X0 = 1 when the slices hold the same bytes
+// Clobbers X1-X4
+runtime.bytesequal:
+    LDR     X2, [X0, #8]
+    LDR     X3, [X1, #8]
+    CMP     X2, X3
+    B.NE    .Lbytes_unequal
+    LDR     X0, [X0]
+    LDR     X1, [X1]
+    B       runtime.memequal
+.Lbytes_unequal:
+    MOV     X0, #0
+    RET
@@ -3,9 +3,12 @@ package compile
 import (
 	"fmt"
 	"go/token"
+	"io"
+	"strings"
 
-	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/asm"
 	"github.com/algoboyz/garm/pkg/dbg"
+	"github.com/algoboyz/garm/pkg/diag"
 	"github.com/algoboyz/garm/pkg/ir"
 	"github.com/algoboyz/garm/pkg/mapper"
 	"golang.org/x/tools/go/ssa"
@@ -13,29 +16,34 @@ import (
 
 // Compiler handles the conversion from Go AST to ARM64 assembly
 type Compiler struct {
-	prog   Program
-	fset   *token.FileSet
-	dbg    *dbg.Debugger
-	mapper *mapper.SSAMapper
-	gen    *Generator
+	prog             Program
+	gc               mapper.GC
+	allowUnsupported bool
+	fset             *token.FileSet
+	dbg              *dbg.Debugger
+	mapper           *mapper.SSAMapper
+	gen              *Generator
 }
 
 // IRProgram represents the entire program
 type Program struct {
 	Functions []*ir.Function
-	Globals   []alloc.ARM64Type
-	Constants []alloc.ARM64Type // for constant handling
-	Imports   []string          // to handle external dependencies
+	Globals   []*ir.Global // package level variables in .data and .bss
+	Constants []*ir.Global // literals and tables in .rodata
+	Imports   []string     // to handle external dependencies
+	Runtime   string       // runtime routines linked into the program
+	Arch      string       // architecture the assembler targets, empty for the default
 }
 
 func New(debug *dbg.Debugger) *Compiler {
 	compiler := &Compiler{
 		prog: Program{
 			Functions: make([]*ir.Function, 0),
-			Globals:   make([]alloc.ARM64Type, 0),
-			Constants: make([]alloc.ARM64Type, 0),
+			Globals:   make([]*ir.Global, 0),
+			Constants: make([]*ir.Global, 0),
 			Imports:   make([]string, 0),
 		},
+		gc:     mapper.GCMarkSweep,
 		mapper: mapper.NewSSAMapper(debug),
 		fset:   token.NewFileSet(),
 		gen:    NewCodeGenerator(debug),
@@ -44,16 +52,65 @@ func New(debug *dbg.Debugger) *Compiler {
 	return compiler
 }
 
+// SetGC selects the garbage collector the generated code is built for
+func (c *Compiler) SetGC(gc mapper.GC) {
+	c.gc = gc
+	c.mapper.SetGC(gc)
+}
+
+// SetLSE selects the ARMv8.1 atomic instructions, otherwise the generated
+// code runs on baseline ARMv8.0
+func (c *Compiler) SetLSE(lse bool) {
+	c.prog.Arch = ""
+	if lse {
+		c.prog.Arch = "armv8-a+lse"
+	}
+	c.mapper.SetLSE(lse)
+}
+
+// SetThreads sets the number of OS threads running goroutines
+func (c *Compiler) SetThreads(n int) {
+	c.mapper.SetThreads(n)
+}
+
+// SetDependencies compiles the imported packages of the same module and the
+// supported packages of the standard library along with the loaded ones
+func (c *Compiler) SetDependencies(deps bool) {
+	c.mapper.SetDependencies(deps)
+}
+
+// SetAllowUnsupported builds programs using constructs garm does not support
+// yet, the functions using them panic when called
+func (c *Compiler) SetAllowUnsupported(allow bool) {
+	c.allowUnsupported = allow
+}
+
+// Diagnostics returns the unsupported constructs of the program
+func (c *Compiler) Diagnostics() *diag.List {
+	return c.mapper.Diagnostics()
+}
+
+// Report lists the functions compiled, substituted and unsupported
+func (c *Compiler) Report() mapper.Report {
+	return c.mapper.Report()
+}
+
 func (c *Compiler) Parse(target string, debug bool) (*ssa.Function, error) {
 	if err := c.mapper.Load(target); err != nil {
 		return nil, fmt.Errorf("loading package: %w", err)
 	}
 	fns, err := c.mapper.MapPackage()
 	if err != nil {
-		return nil, fmt.Errorf("loading package: %w", err)
+		return nil, fmt.Errorf("mapping package: %w", err)
+	}
+	if n := c.mapper.Diagnostics().Errors(); n > 0 && !c.allowUnsupported {
+		return nil, fmt.Errorf("%d unsupported constructs", n)
 	}
 
 	c.prog.Functions = fns
+	data := c.mapper.Data()
+	c.prog.Globals = append(data.Section(ir.SectionData), data.Section(ir.SectionBSS)...)
+	c.prog.Constants = data.Section(ir.SectionRodata)
 
 	// f, err := parser.ParseFile(c.fset, target, nil, parser.ParseComments)
 	// if err != nil {
@@ -75,6 +132,28 @@ func (c *Compiler) Parse(target string, debug bool) (*ssa.Function, error) {
 	return nil, nil
 }
 
+// Check loads the packages matching target and dry runs the mapper over all
+// their functions, reporting what garm supports of each
+func (c *Compiler) Check(target string) ([]mapper.Support, error) {
+	if err := c.mapper.Load(target); err != nil {
+		return nil, fmt.Errorf("loading package: %w", err)
+	}
+	report, err := c.mapper.Check()
+	if err != nil {
+		return nil, fmt.Errorf("checking package: %w", err)
+	}
+	return report, nil
+}
+
+// WriteSSA loads the packages matching target and writes the SSA form garm
+// compiles of their functions, or only of those named fn
+func (c *Compiler) WriteSSA(w io.Writer, target, fn string) error {
+	if err := c.mapper.Load(target); err != nil {
+		return fmt.Errorf("loading package: %w", err)
+	}
+	return c.mapper.WriteSSA(w, fn)
+}
+
 func (c *Compiler) Map(fn *ssa.Function) error {
 	fun, err := c.mapper.MapFunction(fn)
 	if err != nil {
@@ -84,12 +163,34 @@ func (c *Compiler) Map(fn *ssa.Function) error {
 	return nil
 }
 
-// Generate produces the final ARM64 assembly with optional optimization
+// Generate produces the final ARM64 assembly, in debug mode it is also
+// rendered to the terminal
 func (c *Compiler) Generate() (string, error) {
-	return c.gen.Glamour(c.prog)
+	if err := c.link(); err != nil {
+		return "", err
+	}
+	if c.dbg.ModeDebug {
+		if _, err := c.gen.Glamour(c.prog); err != nil {
+			return "", err
+		}
+	}
+	return c.gen.Generate(c.prog), nil
+}
 
-	// if c.dbg.ModeDebug {
-	// 	return c.gen.Glamour(c.prog)
-	// }
-	// return c.gen.Generate(c.prog), nil
+// link pulls the runtime routines the program references from the common
+// runtime and the files of the selected collector. Calls of external
+// functions nothing substitutes would branch to undefined symbols, they fail
+// the link
+func (c *Compiler) link() error {
+	c.prog.Runtime = ""
+	if unresolved := c.mapper.Unresolved(); len(unresolved) > 0 {
+		return fmt.Errorf("unresolved external functions: %s", strings.Join(unresolved, ", "))
+	}
+	files := append(append([]string(nil), asm.Common...), c.gc.Runtime()...)
+	runtime, err := asm.Link(c.gen.Generate(c.prog), files)
+	if err != nil {
+		return fmt.Errorf("linking %s runtime: %w", c.gc, err)
+	}
+	c.prog.Runtime = runtime
+	return nil
 }
@@ -0,0 +1,482 @@
+package compile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/algoboyz/garm/pkg/dbg"
+	"github.com/algoboyz/garm/pkg/mapper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// run compiles a single file program, builds and runs it and returns what
+// it printed, options are applied to the compiler before parsing. The test
+// is skipped without an ARM64 toolchain or a way to run its executables
+func run(t *testing.T, src string, opts ...func(*Compiler)) string {
+	t.Helper()
+	toolchain, err := FindToolchain("linux")
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+
+	c := New(dbg.NewDebugger(false))
+	for _, opt := range opts {
+		opt(c)
+	}
+	_, err = c.Parse(path, false)
+	require.NoError(t, err)
+	code, err := c.Generate()
+	require.NoError(t, err)
+
+	asm := filepath.Join(dir, "main.s")
+	require.NoError(t, os.WriteFile(asm, []byte(code), 0o644))
+	binary := filepath.Join(dir, "main")
+	require.NoError(t, toolchain.Build(asm, binary, false))
+
+	cmd, err := Command(binary)
+	if err != nil {
+		t.Skip(err)
+	}
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return string(out)
+}
+
+func TestRunScheduler(t *testing.T) {
+	// A single thread runs the goroutines in the order they were started,
+	// each parks on the unbuffered channel until main receives
+	out := run(t, `package main
+
+func worker(id int, c chan int) {
+	for i := range 2 {
+		c <- id*10 + i
+	}
+}
+
+func main() {
+	c := make(chan int)
+	for id := range 3 {
+		go worker(id, c)
+	}
+	for range 6 {
+		println(<-c)
+	}
+}
+`, func(c *Compiler) {
+		c.SetGC(mapper.GCNone)
+		c.SetThreads(1)
+	})
+	assert.Equal(t, "0\n10\n20\n1\n11\n21\n", out)
+}
+
+func TestRunThreads(t *testing.T) {
+	// Goroutines on two threads allocate from the same arena and recover
+	// from their own panics
+	out := run(t, `package main
+
+type node struct {
+	v    int
+	next *node
+}
+
+func build(n, id int, done chan int) {
+	var head *node
+	for range n {
+		head = &node{v: id, next: head}
+	}
+	sum := 0
+	for p := head; p != nil; p = p.next {
+		sum += p.v
+	}
+	done <- sum
+}
+
+func safe(id int) (r int) {
+	defer func() {
+		if recover() != nil {
+			r = id
+		}
+	}()
+	for i := range 100 {
+		_ = &node{v: i}
+	}
+	panic("boom")
+}
+
+func worker(id int, done chan int) {
+	s := 0
+	for range 50 {
+		s += safe(id)
+	}
+	done <- s
+}
+
+func main() {
+	done := make(chan int)
+	for id := 1; id <= 4; id++ {
+		go build(2000, id, done)
+		go worker(id, done)
+	}
+	t := 0
+	for range 8 {
+		t += <-done
+	}
+	println(t, recover() == nil)
+}
+`, func(c *Compiler) {
+		c.SetGC(mapper.GCNone)
+		c.SetThreads(2)
+	})
+	assert.Equal(t, "20500 true\n", out)
+}
+
+func TestRunCollectors(t *testing.T) {
+	// The garbage fills eden and the mark and sweep trigger several times
+	// over while both lists are live, one from a global and one from a frame
+	const src = `package main
+
+type node struct {
+	next *node
+	val  int
+	pad  [6]int
+}
+
+var keep *node
+
+func push(head *node, v int) *node {
+	n := &node{}
+	n.val = v
+	n.next = head
+	return n
+}
+
+func garbage(n int) {
+	for i := range n {
+		g := &node{}
+		g.val = i
+	}
+}
+
+func sum(head *node) int {
+	s := 0
+	for p := head; p != nil; p = p.next {
+		s += p.val
+	}
+	return s
+}
+
+func build(n int) *node {
+	var head *node
+	for i := range n {
+		head = push(head, i)
+		garbage(50)
+	}
+	return head
+}
+
+func main() {
+	keep = build(1000)
+	local := build(2000)
+	garbage(100000)
+	println(sum(keep))
+	println(sum(local))
+}
+`
+	for _, gc := range []mapper.GC{mapper.GCMarkSweep, mapper.GCGenerational} {
+		t.Run(gc.String(), func(t *testing.T) {
+			out := run(t, src, func(c *Compiler) { c.SetGC(gc) })
+			assert.Equal(t, "499500\n1999000\n", out)
+		})
+	}
+}
+
+func TestRunLoops(t *testing.T) {
+	out := run(t, `package main
+
+func sum(s []int) int {
+	t := 0
+	for _, v := range s {
+		t += v
+	}
+	return t
+}
+
+func runes(s string) int {
+	n := 0
+	for i, r := range s {
+		n += i + int(r)
+	}
+	return n
+}
+
+func count(n int) int {
+	t := 0
+	for i := range n {
+		t += i
+	}
+	return t
+}
+
+func fib(n int) int {
+	a, b := 0, 1
+	for range n {
+		a, b = b, a+b
+	}
+	return a
+}
+
+func seq(n int) func(func(int) bool) {
+	return func(yield func(int) bool) {
+		for i := range n {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+func first(n int) int {
+	t := 0
+	for v := range seq(n) {
+		if v == 3 {
+			break
+		}
+		t += v
+	}
+	return t
+}
+
+func main() {
+	a := [3]int{1, 2, 3}
+	println(sum(a[:]))
+	println(runes("héllo"))
+	println(count(5), fib(10), first(10))
+}
+`)
+	// The é spans two bytes so the indices are 0 1 3 4 5
+	assert.Equal(t, "6\n677\n10 55 3\n", out)
+}
+
+func TestRunDeferBuiltins(t *testing.T) {
+	// Deferred builtins run last in first out with the arguments of the
+	// defer statement, a deferred recover does not stop anything
+	out := run(t, `package main
+
+func count(n int) {
+	for i := range n {
+		defer println(i, "deferred")
+	}
+	defer print("start\n")
+}
+
+func drop(m map[int]int, c chan int) {
+	defer delete(m, 1)
+	defer close(c)
+	defer recover()
+	println(len(m))
+}
+
+func main() {
+	count(3)
+	m := map[int]int{1: 1, 2: 2}
+	c := make(chan int)
+	drop(m, c)
+	_, ok := <-c
+	println(len(m), ok)
+	done := make(chan bool)
+	go println("started")
+	go func() { done <- true }()
+	<-done
+}
+`)
+	assert.Equal(t, "start\n2 deferred\n1 deferred\n0 deferred\n2\n1 false\nstarted\n", out)
+}
+
+func TestRunManyValues(t *testing.T) {
+	// More values than registers over the function, each register is
+	// reused once its value was used for the last time
+	out := run(t, `package main
+
+import "sync/atomic"
+
+func main() {
+	m := make(map[string]int)
+	m["a"] = 1
+	m["b"] = 2
+	m["c"] = 3
+	total := 0
+	for k, v := range m {
+		total += v + len(k)
+	}
+	delete(m, "a")
+	x, ok := m["b"]
+	y := m["z"]
+	var n int64
+	atomic.AddInt64(&n, 5)
+	atomic.AddInt64(&n, int64(total))
+	c := make(chan int, 4)
+	c <- x
+	c <- y
+	c <- len(m)
+	a := <-c
+	b := <-c
+	d := <-c
+	println(total, x, ok, y, a, b, d, atomic.LoadInt64(&n))
+}
+`)
+	assert.Equal(t, "9 2 true 0 2 0 2 14\n", out)
+}
+
+func TestRunInterfaceCompare(t *testing.T) {
+	// Interfaces are equal when they hold the same dynamic type and equal
+	// values, conversions between interfaces keep both
+	out := run(t, `package main
+
+type myErr struct{ msg string }
+
+func (e *myErr) Error() string { return e.msg }
+
+type shape interface{ area() int }
+
+type named interface {
+	shape
+	name() string
+}
+
+type square struct{ s int }
+
+func (q square) area() int    { return q.s * q.s }
+func (q square) name() string { return "square" }
+
+func fail(n int) error {
+	if n > 0 {
+		return &myErr{"fail"}
+	}
+	return nil
+}
+
+func safe() (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			println("recovered", r.(string))
+			ok = true
+		}
+	}()
+	panic("boom")
+}
+
+func main() {
+	println(fail(0) == nil, fail(1) != nil)
+	var n named = square{3}
+	var s shape = n
+	var e any = s
+	println(s.area(), s == shape(square{3}), s == shape(square{4}), e == any(square{3}))
+	var z any
+	println(z == nil, e == nil, e == any(3))
+	println(safe())
+}
+`)
+	assert.Equal(t, "true true\n9 true false true\ntrue false false\nrecovered boom\ntrue\n", out)
+}
+
+func TestRunSliceNil(t *testing.T) {
+	// A slice is nil when its data word is, empty slices of an array are not
+	out := run(t, `package main
+
+func isNil(s []int) bool { return s == nil }
+
+func main() {
+	var s []int
+	println(s == nil, s != nil, isNil(s))
+	a := [3]int{1, 2, 3}
+	s = a[:]
+	println(s == nil, nil != s, isNil(s[:0]))
+}
+`)
+	assert.Equal(t, "true false true\nfalse true false\n", out)
+}
+
+func TestRunRecover(t *testing.T) {
+	// Only a deferred call run by the panic recovers, and a panic raised by
+	// a call deferred to a normal return unwinds past rundefers
+	out := run(t, `package main
+
+func helper() any { return recover() }
+
+func indirect() (ok bool) {
+	defer func() {
+		ok = recover() != nil
+	}()
+	defer func() {
+		println("helper", helper() != nil)
+	}()
+	panic("indirect")
+}
+
+func inner() {
+	defer func() {
+		panic("from defer")
+	}()
+	println("inner returns")
+}
+
+func outer() (msg string) {
+	defer func() {
+		msg = recover().(string)
+	}()
+	inner()
+	return "none"
+}
+
+func main() {
+	println(indirect())
+	println(outer())
+	println(recover() == nil)
+}
+`)
+	assert.Equal(t, "helper false\ntrue\ninner returns\nfrom defer\ntrue\n", out)
+}
+
+func TestRunGenerics(t *testing.T) {
+	out := run(t, `package main
+
+type number interface{ ~int | ~float64 }
+
+type celsius int
+
+func sum[T number](xs ...T) T {
+	var t T
+	for _, x := range xs {
+		t += x
+	}
+	return t
+}
+
+type stack[T any] struct {
+	items [4]T
+	n     int
+}
+
+func (s *stack[T]) push(x T) { s.items[s.n] = x; s.n++ }
+func (s *stack[T]) pop() T   { s.n--; return s.items[s.n] }
+
+func apply[T any](x T, f func(T) T) T { return f(x) }
+
+func main() {
+	println(sum(1, 2, 3), sum[celsius](1, 3))
+	var a stack[int]
+	a.push(1)
+	a.push(2)
+	var b stack[string]
+	b.push("x")
+	println(a.pop(), a.pop(), b.pop())
+	println(apply(3, func(x int) int { return x * x }))
+}
+`)
+	assert.Equal(t, "6 4\n2 1 x\n9\n", out)
+}
@@ -22,22 +22,46 @@ func NewCodeGenerator(debug *dbg.Debugger) *Generator {
 	}
 }
 
-// Generate produces the final ARM64 assembly
+// Generate produces the final ARM64 assembly. The runtime comes first, the
+// assembler needs the values of its constants in the runtime macros expanded
+// into the functions
 func (g *Generator) Generate(program Program) string {
 	var sb strings.Builder
+	if program.Arch != "" {
+		sb.WriteString(fmt.Sprintf("\t.arch %s\n", program.Arch))
+	}
+	sb.WriteString(program.Runtime)
 	sb.WriteString("\t.global main\n")
 	sb.WriteString("\t.text\n")
 	for _, f := range program.Functions {
 		if f.Public {
-			sb.WriteString(fmt.Sprintf(".global %s:\n", f.Label))
+			sb.WriteString(fmt.Sprintf("\t.global %s\n", ir.Symbol(f.Label)))
 		}
 		for _, inst := range f.Blocks {
 			sb.WriteString(inst.String(g.dbg.ModeDebug))
 		}
 	}
+	g.section(&sb, ir.SectionData, program.Globals)
+	g.section(&sb, ir.SectionBSS, program.Globals)
+	g.section(&sb, ir.SectionRodata, program.Constants)
 	return sb.String()
 }
 
+// section writes the globals belonging to section s
+func (g *Generator) section(sb *strings.Builder, s ir.Section, globals []*ir.Global) {
+	header := false
+	for _, global := range globals {
+		if global.Section != s {
+			continue
+		}
+		if !header {
+			sb.WriteString(fmt.Sprintf("\n\t%s\n", s))
+			header = true
+		}
+		sb.WriteString(global.String(g.dbg.ModeDebug))
+	}
+}
+
 // Render assembly outout as markdown to ANSI-styled terminal output
 func (g *Generator) Glamour(program Program) (string, error) {
 	content := fmt.Sprintf("```asm\n%s```\n", g.Generate(program))
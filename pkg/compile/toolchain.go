@@ -0,0 +1,100 @@
+package compile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Operating systems garm generates code for, the runtime makes their system
+// calls directly
+var targetOS = map[string]bool{
+	"linux": true,
+}
+
+// Libraries of the target installed along the cross compiler
+const sysroot = "/usr/aarch64-linux-gnu"
+
+// Toolchain assembles and links the generated assembly with a C compiler
+// driver, the entry point is main so the C runtime starts the program
+type Toolchain struct {
+	CC    string
+	Flags []string
+}
+
+// CheckOS reports whether garm generates code for goos
+func CheckOS(goos string) error {
+	if !targetOS[goos] {
+		return fmt.Errorf("unsupported target OS %s, the runtime only makes linux system calls", goos)
+	}
+	return nil
+}
+
+// FindToolchain returns the C compiler targeting ARM64 goos, $CC when set,
+// the native one on ARM64 hosts and a cross compiler otherwise
+func FindToolchain(goos string) (*Toolchain, error) {
+	if err := CheckOS(goos); err != nil {
+		return nil, err
+	}
+	if cc := os.Getenv("CC"); cc != "" {
+		return &Toolchain{CC: cc}, nil
+	}
+	if native() {
+		if cc, err := exec.LookPath("cc"); err == nil {
+			return &Toolchain{CC: cc}, nil
+		}
+	}
+	for _, name := range []string{"aarch64-linux-gnu-gcc", "aarch64-unknown-linux-gnu-gcc"} {
+		if cc, err := exec.LookPath(name); err == nil {
+			return &Toolchain{CC: cc}, nil
+		}
+	}
+	if cc, err := exec.LookPath("clang"); err == nil {
+		return &Toolchain{CC: cc, Flags: []string{"--target=aarch64-linux-gnu"}}, nil
+	}
+	return nil, errors.New("no C compiler for arm64 linux, install aarch64-linux-gnu-gcc or set CC")
+}
+
+// Build assembles src into out, an object file when object is set and an
+// executable otherwise
+func (t *Toolchain) Build(src, out string, object bool) error {
+	args := append(append([]string(nil), t.Flags...), "-o", out)
+	if object {
+		args = append(args, "-c")
+	}
+	cmd := exec.Command(t.CC, append(args, src)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", t.CC, err)
+	}
+	return nil
+}
+
+// Command returns the command running an ARM64 executable, directly on ARM64
+// linux hosts and under qemu user mode emulation elsewhere
+func Command(binary string, args ...string) (*exec.Cmd, error) {
+	if native() {
+		return exec.Command(binary, args...), nil
+	}
+	for _, name := range []string{"qemu-aarch64", "qemu-aarch64-static"} {
+		qemu, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		var flags []string
+		// Dynamically linked executables need the libraries of the target
+		if _, err := os.Stat(sysroot); err == nil && os.Getenv("QEMU_LD_PREFIX") == "" {
+			flags = []string{"-L", sysroot}
+		}
+		return exec.Command(qemu, append(append(flags, binary), args...)...), nil
+	}
+	return nil, fmt.Errorf("cannot run arm64 executables on %s/%s, install qemu-user", runtime.GOOS, runtime.GOARCH)
+}
+
+// native reports whether the host runs the generated code itself
+func native() bool {
+	return runtime.GOOS == "linux" && runtime.GOARCH == "arm64"
+}
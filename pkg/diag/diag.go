@@ -0,0 +1,139 @@
+package diag
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"sort"
+)
+
+// Severity of a diagnostic, errors fail the build
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Category groups diagnostics by the kind of construct they are about
+type Category string
+
+const (
+	Instruction Category = "instruction" // SSA instruction without a lowering
+	Type        Category = "type"        // type without a machine representation
+	Conversion  Category = "conversion"  // conversion between types
+	Operator    Category = "operator"    // unary or binary operator of a type
+	Builtin     Category = "builtin"     // builtin function or argument
+	Constant    Category = "constant"    // constant or static initializer
+	Call        Category = "call"        // calling convention
+)
+
+// Diagnostic is a message about a source position
+type Diagnostic struct {
+	Pos      token.Position
+	Severity Severity
+	Category Category
+	Message  string
+}
+
+// String renders the diagnostic like gcc eg
+//
+//	main.go:12:5: error: unsupported instruction *ssa.MakeMap
+//
+// The position is left out when it is unknown
+func (d Diagnostic) String() string {
+	if !d.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Pos, d.Severity, d.Message)
+}
+
+// List accumulates the diagnostics of a compilation, constructs garm does not
+// support yet are reported with their position rather than failing the build
+// at the first one. The same message at the same position is kept once
+type List struct {
+	diags []Diagnostic
+	seen  map[Diagnostic]bool
+}
+
+// Add records a diagnostic
+func (l *List) Add(d Diagnostic) {
+	if l.seen == nil {
+		l.seen = make(map[Diagnostic]bool)
+	}
+	if l.seen[d] {
+		return
+	}
+	l.seen[d] = true
+	l.diags = append(l.diags, d)
+}
+
+// Errorf records an error at pos
+func (l *List) Errorf(pos token.Position, category Category, format string, args ...any) {
+	l.Add(Diagnostic{Pos: pos, Severity: Error, Category: category, Message: fmt.Sprintf(format, args...)})
+}
+
+// All returns the diagnostics sorted by position, those without one last
+func (l *List) All() []Diagnostic {
+	diags := append([]Diagnostic(nil), l.diags...)
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i].Pos, diags[j].Pos
+		if a.IsValid() != b.IsValid() {
+			return a.IsValid()
+		}
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return diags
+}
+
+// Len returns the number of diagnostics
+func (l *List) Len() int {
+	return len(l.diags)
+}
+
+// Since returns the diagnostics added after the first n in the order they
+// were added
+func (l *List) Since(n int) []Diagnostic {
+	return append([]Diagnostic(nil), l.diags[n:]...)
+}
+
+// Errors returns the number of diagnostics of error severity
+func (l *List) Errors() int {
+	n := 0
+	for _, d := range l.diags {
+		if d.Severity == Error {
+			n++
+		}
+	}
+	return n
+}
+
+// Print writes the diagnostics to w a line each
+func (l *List) Print(w io.Writer) error {
+	for _, d := range l.All() {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package diag
+
+import (
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	var l List
+	l.Errorf(token.Position{Filename: "main.go", Line: 12, Column: 5}, Instruction, "unsupported instruction %s", "*ssa.MakeMap")
+	l.Add(Diagnostic{Severity: Warning, Category: Type, Message: "no position"})
+	l.Errorf(token.Position{Filename: "main.go", Line: 3, Column: 1}, Type, "unsupported type: complex128")
+	l.Errorf(token.Position{Filename: "main.go", Line: 3, Column: 1}, Type, "unsupported type: complex128")
+
+	assert.Equal(t, 2, l.Errors(), "duplicates are dropped")
+	var sb strings.Builder
+	require.NoError(t, l.Print(&sb))
+	assert.Equal(t, "main.go:3:1: error: unsupported type: complex128\n"+
+		"main.go:12:5: error: unsupported instruction *ssa.MakeMap\n"+
+		"warning: no position\n", sb.String())
+}
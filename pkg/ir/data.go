@@ -0,0 +1,219 @@
+package ir
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Section identifies where a package level symbol is emitted
+type Section uint8
+
+const (
+	SectionData   Section = iota // initialized writable data
+	SectionBSS                   // zero initialized writable data
+	SectionRodata                // read only data eg string literals
+)
+
+func (s Section) String() string {
+	switch s {
+	case SectionData:
+		return ".data"
+	case SectionBSS:
+		return ".bss"
+	case SectionRodata:
+		return ".section .rodata"
+	default:
+		return fmt.Sprintf("unknown section %d", s)
+	}
+}
+
+// Datum is a static initializer placed at an offset inside a global
+type Datum struct {
+	Offset    int
+	Size      int
+	Directive string // .byte .hword .word .quad .ascii
+	Value     string
+}
+
+// Global represents a package level symbol living outside of .text
+type Global struct {
+	Label   string
+	Section Section
+	Size    int
+	Align   int
+	Init    []Datum
+	Comment string
+}
+
+// Set records a static initializer, the directive is derived from the size
+func (g *Global) Set(offset, size int, value string) {
+	g.Init = append(g.Init, Datum{
+		Offset:    offset,
+		Size:      size,
+		Directive: directive(size),
+		Value:     value,
+	})
+	if g.Section == SectionBSS {
+		g.Section = SectionData
+	}
+}
+
+func directive(size int) string {
+	switch size {
+	case 1:
+		return ".byte"
+	case 2:
+		return ".hword"
+	case 4:
+		return ".word"
+	default:
+		return ".quad"
+	}
+}
+
+func (g *Global) String(debug bool) string {
+	var sb strings.Builder
+	if g.Align > 1 {
+		sb.WriteString(fmt.Sprintf("\t.balign %d\n", g.Align))
+	}
+	sb.WriteString(Symbol(g.Label) + ":")
+	if debug && g.Comment != "" {
+		sb.WriteString("\t// " + g.Comment)
+	}
+	sb.WriteString("\n")
+
+	init := append([]Datum(nil), g.Init...)
+	sort.SliceStable(init, func(i, j int) bool { return init[i].Offset < init[j].Offset })
+	offset := 0
+	for _, d := range init {
+		if d.Offset > offset {
+			sb.WriteString(fmt.Sprintf("\t.zero %d\n", d.Offset-offset))
+		}
+		sb.WriteString(fmt.Sprintf("\t%s %s\n", d.Directive, d.Value))
+		offset = d.Offset + d.Size
+	}
+	if g.Size > offset {
+		sb.WriteString(fmt.Sprintf("\t.zero %d\n", g.Size-offset))
+	}
+	return sb.String()
+}
+
+// Data collects every package level symbol of a program
+type Data struct {
+	globals  []*Global
+	index    map[string]*Global
+	literals map[string]string
+}
+
+func NewData() *Data {
+	return &Data{
+		globals:  make([]*Global, 0),
+		index:    make(map[string]*Global),
+		literals: make(map[string]string),
+	}
+}
+
+// Add registers a global, adding the same label twice returns the first one
+func (d *Data) Add(g *Global) *Global {
+	if existing, ok := d.index[g.Label]; ok {
+		return existing
+	}
+	d.index[g.Label] = g
+	d.globals = append(d.globals, g)
+	return g
+}
+
+func (d *Data) Lookup(label string) (*Global, bool) {
+	g, ok := d.index[label]
+	return g, ok
+}
+
+// Section returns the globals emitted into the given section in insertion order
+func (d *Data) Section(s Section) (globals []*Global) {
+	for _, g := range d.globals {
+		if g.Section == s {
+			globals = append(globals, g)
+		}
+	}
+	return globals
+}
+
+// String interns the bytes of a string literal into rodata and returns its label
+func (d *Data) String(s string) string {
+	key := "str:" + s
+	if label, ok := d.literals[key]; ok {
+		return label
+	}
+	label := fmt.Sprintf("go.string.%d", len(d.literals))
+	d.literals[key] = label
+	d.Add(&Global{
+		Label:   label,
+		Section: SectionRodata,
+		Size:    len(s),
+		Align:   1,
+		Init:    []Datum{{Size: len(s), Directive: ".ascii", Value: Quote(s)}},
+	})
+	return label
+}
+
+// Float64 interns a float constant into rodata and returns its label
+func (d *Data) Float64(f float64) string {
+	bits := math.Float64bits(f)
+	label := fmt.Sprintf("$f64.%016x", bits)
+	d.Add(&Global{
+		Label:   label,
+		Section: SectionRodata,
+		Size:    8,
+		Align:   8,
+		Init:    []Datum{{Size: 8, Directive: ".quad", Value: fmt.Sprintf("0x%016x", bits)}},
+	})
+	return label
+}
+
+// Float32 interns a single precision float constant into rodata and returns its label
+func (d *Data) Float32(f float32) string {
+	bits := math.Float32bits(f)
+	label := fmt.Sprintf("$f32.%08x", bits)
+	d.Add(&Global{
+		Label:   label,
+		Section: SectionRodata,
+		Size:    4,
+		Align:   4,
+		Init:    []Datum{{Size: 4, Directive: ".word", Value: fmt.Sprintf("0x%08x", bits)}},
+	})
+	return label
+}
+
+// Quote escapes a string for the .ascii directive, anything outside printable
+// ASCII is written as an octal escape so multi byte runes survive unchanged
+func Quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			sb.WriteByte(c)
+		default:
+			sb.WriteString(fmt.Sprintf("\\%03o", c))
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+var plainSymbol = regexp.MustCompile(`^[A-Za-z_.$][A-Za-z0-9_.$]*$`)
+
+// Symbol quotes labels the assembler would otherwise reject eg main.(*rect).area
+func Symbol(label string) string {
+	if plainSymbol.MatchString(label) {
+		return label
+	}
+	return `"` + label + `"`
+}
@@ -0,0 +1,60 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobalString(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   *Global
+		expected string
+	}{
+		{
+			name:     "Zero initialized",
+			global:   &Global{Label: "main.counter", Section: SectionBSS, Size: 8, Align: 8},
+			expected: "\t.balign 8\nmain.counter:\n\t.zero 8\n",
+		},
+		{
+			name: "Static initializer with gaps",
+			global: &Global{Label: "main.table", Section: SectionData, Size: 12, Align: 4, Init: []Datum{
+				{Offset: 8, Size: 4, Directive: ".word", Value: "3"},
+				{Offset: 0, Size: 4, Directive: ".word", Value: "1"},
+			}},
+			expected: "\t.balign 4\nmain.table:\n\t.word 1\n\t.zero 4\n\t.word 3\n",
+		},
+		{
+			name:     "Quoted symbol",
+			global:   &Global{Label: "main.init#1", Section: SectionBSS, Size: 1, Align: 1},
+			expected: "\"main.init#1\":\n\t.zero 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.global.String(false))
+		})
+	}
+}
+
+func TestGlobalSetMovesToData(t *testing.T) {
+	g := &Global{Label: "main.limit", Section: SectionBSS, Size: 8, Align: 8}
+	g.Set(0, 8, "10")
+	assert.Equal(t, SectionData, g.Section)
+	assert.Equal(t, []Datum{{Offset: 0, Size: 8, Directive: ".quad", Value: "10"}}, g.Init)
+}
+
+func TestDataLiterals(t *testing.T) {
+	d := NewData()
+	label := d.String("arGO彡")
+	assert.Equal(t, label, d.String("arGO彡"), "literals are interned")
+	lit, ok := d.Lookup(label)
+	assert.True(t, ok)
+	assert.Equal(t, SectionRodata, lit.Section)
+	assert.Equal(t, `"arGO\345\275\241"`, lit.Init[0].Value)
+
+	assert.Equal(t, "$f64.3ff8000000000000", d.Float64(1.5))
+	assert.Len(t, d.Section(SectionRodata), 2)
+}
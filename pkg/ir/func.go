@@ -9,44 +9,29 @@ import (
 
 // Function represents a function in our IR
 type Function struct {
-	Label            string
-	Public           bool
-	StackSize        int
-	nextGlobalOffset int
-	Globals          map[string]alloc.Location
-	Params           map[string]alloc.Location
-	Locals           map[string]alloc.Location
-	Blocks           []Instruction
-	Returns          map[string]alloc.Location
-	dbg              *dbg.Debugger
+	Label     string
+	Public    bool
+	StackSize int
+	Params    map[string]alloc.Location
+	Locals    map[string]alloc.Location
+	Blocks    []Instruction
+	Returns   map[string]alloc.Location
+	dbg       *dbg.Debugger
 	// Frames           *FrameManager
 }
 
 func NewFunction(label string, debug *dbg.Debugger) *Function {
 	return &Function{
-		Label:   label,
-		Public:  false,
-		Params:  make(map[string]alloc.Location),
-		Locals:  make(map[string]alloc.Location),
-		Globals: make(map[string]alloc.Location),
-		Blocks:  make([]Instruction, 0),
-		dbg:     debug,
+		Label:  label,
+		Public: false,
+		Params: make(map[string]alloc.Location),
+		Locals: make(map[string]alloc.Location),
+		Blocks: make([]Instruction, 0),
+		dbg:    debug,
 		// Frames:  NewFrameManager(),
 	}
 }
 
-// AllocateGlobalOffset allocates space in the global section and returns the offset
-func (f *Function) AllocateGlobalOffset(size int) int {
-	// Align to 8-byte boundary for AArch64
-	alignment := 8
-	f.nextGlobalOffset = (f.nextGlobalOffset + alignment - 1) & ^(alignment - 1)
-
-	offset := f.nextGlobalOffset
-	f.nextGlobalOffset += size
-
-	return offset
-}
-
 func (f *Function) StackFrame() int {
 	// Calculate space needed for local vars
 	var sum int
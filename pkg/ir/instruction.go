@@ -47,6 +47,19 @@ func (i *Instruction) String(debug bool) string {
 	// Start building the instruction
 	sb.WriteString("\t") // Indent for assembly format
 
+	// Unexpanded macros are left to the assembler eg DEFER x16, xzr
+	if i.Macro != nil {
+		sb.WriteString(string(*i.Macro))
+		if args := i.macroArgs(); len(args) > 0 {
+			sb.WriteString(" " + strings.Join(args, ", "))
+		}
+		if debug && i.Comment != "" {
+			sb.WriteString("\t// " + i.Comment)
+		}
+		sb.WriteString("\n")
+		return sb.String()
+	}
+
 	// Write operation with predicates
 	if len(i.Pred) > 0 {
 		sb.WriteString(i.Op.String())
@@ -59,7 +72,15 @@ func (i *Instruction) String(debug bool) string {
 
 	// Handle branch instructions specially
 	if i.Op.IsBranch() && len(i.Labels) > 0 {
-		sb.WriteString(" " + i.Labels[0])
+		sb.WriteString(" ")
+		// Compare and branch forms test a register first eg CBNZ x0, label
+		if i.Dst != nil {
+			sb.WriteString(i.Dst.String() + ", ")
+		}
+		for _, src := range i.Src {
+			sb.WriteString(src.String() + ", ")
+		}
+		sb.WriteString(i.Labels[0])
 		// Add comment if in debug mode
 		if debug && i.Comment != "" {
 			sb.WriteString("\t\t// " + i.Comment)
@@ -68,10 +89,7 @@ func (i *Instruction) String(debug bool) string {
 		return sb.String()
 	}
 
-	switch {
-	case i.Macro != nil:
-		sb.WriteString(fmt.Sprintf(" %s", *i.Macro))
-	case i.Dst != nil:
+	if i.Dst != nil {
 		sb.WriteString(fmt.Sprintf(" %s", i.Dst.String()))
 	}
 
@@ -81,7 +99,10 @@ func (i *Instruction) String(debug bool) string {
 		formattedOps = append(formattedOps, op.String())
 	}
 
-	if len(formattedOps) > 0 {
+	switch {
+	case len(formattedOps) > 0 && i.Dst == nil:
+		sb.WriteString(" " + strings.Join(formattedOps, ", "))
+	case len(formattedOps) > 0:
 		sb.WriteString(", " + strings.Join(formattedOps, ", "))
 	}
 
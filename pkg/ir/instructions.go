@@ -20,12 +20,22 @@ func FuncPrologue(label string) (instructions []Instruction) {
 			reg.NewMemOperand(reg.SP, -16),
 		},
 		Comment: "Set up frame pointer",
+	}, Instruction{
+		Op:      op.MOV,
+		Dst:     &reg.Register{ID: 29, Class: reg.RegisterClassGPR},
+		Src:     []reg.Operand{reg.NewRegOperand("sp")},
+		Comment: "Link frame",
 	})
 }
 
 // todo needs to regate registers for return values
 func FuncEpilogue() (instructions []Instruction) {
 	return append(instructions, Instruction{
+		Op:      op.MOV,
+		Dst:     reg.SP,
+		Src:     []reg.Operand{reg.NewRegOperand("x29")},
+		Comment: "Drop frame",
+	}, Instruction{
 		Op: op.LDP,
 		Dst: &reg.Register{
 			ID:    29,
@@ -33,7 +43,7 @@ func FuncEpilogue() (instructions []Instruction) {
 		},
 		Src: []reg.Operand{
 			reg.NewRegOperand("X30"),
-			reg.NewMemOperand(reg.SP, 16),
+			reg.NewPostIndexOperand(reg.SP, 16),
 		},
 		Comment: "Restore frame pointer",
 	}, Instruction{
@@ -78,7 +88,7 @@ func EpilogueMain() (instructions []Instruction) {
 		},
 		Src: []reg.Operand{
 			reg.NewRegOperand("X30"),
-			reg.NewMemOperand(reg.SP, 16, true),
+			reg.NewPostIndexOperand(reg.SP, 16),
 		},
 		Comment: "Restore frame pointer",
 	}, Instruction{
@@ -98,9 +108,9 @@ func EpilogueMain() (instructions []Instruction) {
 			Class: reg.RegisterClassGPR,
 		},
 		Src: []reg.Operand{
-			reg.NewImmediateOperand("93"),
+			reg.NewImmediateOperand("94"),
 		},
-		Comment: "Prepare for syscall",
+		Comment: "exit_group, ends every thread",
 	}, Instruction{
 		Op: op.SVC,
 		Src: []reg.Operand{
@@ -109,3 +119,36 @@ func EpilogueMain() (instructions []Instruction) {
 		Comment: "Call supervisor",
 	})
 }
+
+// EntryPoint is the program entry, it runs the package initializers in
+// dependency order before calling main.main and exiting
+func EntryPoint(runtime, inits []string, main string) (instructions []Instruction) {
+	instructions = PrologueMain()
+	// Panics walk the frame chain up to the outermost frame
+	instructions = append(instructions, Instruction{
+		Op:      op.MOV,
+		Dst:     &reg.Register{ID: 29, Class: reg.RegisterClassGPR},
+		Src:     []reg.Operand{reg.NewRegOperand(reg.XZR.String())},
+		Comment: "Terminate frame chain",
+	})
+	for _, setup := range runtime {
+		instructions = append(instructions, Instruction{
+			Op:      op.BL,
+			Labels:  []string{setup},
+			Comment: "Start runtime",
+		})
+	}
+	for _, init := range inits {
+		instructions = append(instructions, Instruction{
+			Op:      op.BL,
+			Labels:  []string{init},
+			Comment: "Initialize package",
+		})
+	}
+	instructions = append(instructions, Instruction{
+		Op:      op.BL,
+		Labels:  []string{main},
+		Comment: "Run main",
+	})
+	return append(instructions, EpilogueMain()...)
+}
@@ -0,0 +1,219 @@
+package ir
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/algoboyz/garm/pkg/asm"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+)
+
+var (
+	labelPrefix = regexp.MustCompile(`^\s*([A-Za-z_.$][\w.$]*|\d+):`)
+	shiftPrefix = regexp.MustCompile(`(?i)^(lsl|lsr|asr|ror|[su]xt[bhwx])\s+(\S.*)$`)
+)
+
+// ExpandMacros replaces macro instructions with the instructions of their
+// definitions, so everything after mapping only sees concrete instructions
+func ExpandMacros(instrs []Instruction) ([]Instruction, error) {
+	var out []Instruction
+	for _, i := range instrs {
+		if i.Macro == nil {
+			out = append(out, i)
+			continue
+		}
+		expanded, err := i.Expand()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
+
+// Expand returns the instructions of a macro invocation. The arguments are
+// the destination, if any, followed by the sources, labels of the invocation
+// stay in front of the expansion
+func (i *Instruction) Expand() ([]Instruction, error) {
+	def, err := asm.Lookup(*i.Macro)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := def.Expand(i.macroArgs())
+	if err != nil {
+		return nil, err
+	}
+	var instrs []Instruction
+	if len(i.Labels) > 0 {
+		instrs = append(instrs, Instruction{Labels: i.Labels})
+	}
+	for _, line := range lines {
+		parsed, err := ParseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("expanding %s: %w", *i.Macro, err)
+		}
+		instrs = append(instrs, parsed...)
+	}
+	if i.Comment != "" {
+		for j := range instrs {
+			if instrs[j].Op == "" {
+				continue
+			}
+			if instrs[j].Comment != "" {
+				instrs[j].Comment = i.Comment + ", " + instrs[j].Comment
+			} else {
+				instrs[j].Comment = i.Comment
+			}
+			break
+		}
+	}
+	return instrs, nil
+}
+
+func (i *Instruction) macroArgs() (args []string) {
+	if i.Dst != nil {
+		args = append(args, i.Dst.String())
+	}
+	for _, src := range i.Src {
+		args = append(args, src.String())
+	}
+	return args
+}
+
+// ParseLine parses a line of assembly into its labels and instruction
+func ParseLine(line string) (instrs []Instruction, err error) {
+	code, comment := splitComment(line)
+	for {
+		m := labelPrefix.FindStringSubmatchIndex(code)
+		if m == nil {
+			break
+		}
+		instrs = append(instrs, Instruction{Labels: []string{code[m[2]:m[3]]}})
+		code = code[m[1]:]
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		if len(instrs) > 0 {
+			instrs[len(instrs)-1].Comment = comment
+		}
+		return instrs, nil
+	}
+	instr, err := ParseInstruction(code)
+	if err != nil {
+		return nil, err
+	}
+	instr.Comment = comment
+	return append(instrs, instr), nil
+}
+
+// ParseInstruction parses an instruction without labels or comment eg
+// ldr x0, [x1, #8]. Branch targets become labels and a leading register the
+// destination, the way the mapper builds instructions
+func ParseInstruction(code string) (Instruction, error) {
+	mnemonic, rest := code, ""
+	if i := strings.IndexAny(code, " \t"); i >= 0 {
+		mnemonic, rest = code[:i], code[i+1:]
+	}
+	operands := asm.SplitOperands(rest)
+	if strings.HasPrefix(mnemonic, ".") {
+		instr := Instruction{Op: op.Op(mnemonic)}
+		for _, o := range operands {
+			instr.Src = append(instr.Src, reg.NewLabelOperand(o))
+		}
+		return instr, nil
+	}
+
+	instr := Instruction{Op: op.Op(strings.ToUpper(mnemonic))}
+	var parsed []reg.Operand
+	for j := 0; j < len(operands); j++ {
+		o := operands[j]
+		switch {
+		case o == "":
+			return instr, fmt.Errorf("%s: empty operand", code)
+		case strings.HasPrefix(o, "["):
+			mem, err := parseMemory(o)
+			if err != nil {
+				return instr, fmt.Errorf("%s: %w", code, err)
+			}
+			// Post-index eg [sp], #16
+			if j+1 < len(operands) && strings.HasPrefix(operands[j+1], "#") &&
+				!mem.WriteBack && mem.Offset == "" && mem.Index == "" {
+				mem.Post = true
+				mem.Offset = strings.TrimPrefix(operands[j+1], "#")
+				j++
+			}
+			parsed = append(parsed, reg.Operand{Type: reg.OperandMemory, Memory: mem})
+		case strings.HasPrefix(o, "#"):
+			parsed = append(parsed, reg.NewImmediateOperand(o))
+		default:
+			if m := shiftPrefix.FindStringSubmatch(o); m != nil && len(parsed) > 0 && parsed[len(parsed)-1].Type == reg.OperandRegister {
+				prev := &parsed[len(parsed)-1]
+				prev.Type = reg.OperandShift
+				prev.Shift = &reg.Shift{Type: op.Op(strings.ToUpper(m[1])), Value: m[2]}
+				continue
+			}
+			if r, ok := reg.Parse(o); ok {
+				parsed = append(parsed, reg.NewRegOperand(r.String()))
+				continue
+			}
+			parsed = append(parsed, reg.NewLabelOperand(o))
+		}
+	}
+
+	if n := len(parsed); instr.Op.IsBranch() && n > 0 && parsed[n-1].Type == reg.OperandLabel {
+		instr.Labels = []string{parsed[n-1].Var}
+		parsed = parsed[:n-1]
+	}
+	if len(parsed) > 0 && parsed[0].Type == reg.OperandRegister {
+		instr.Dst, _ = reg.Parse(parsed[0].Var)
+		parsed = parsed[1:]
+	}
+	if len(parsed) > 0 {
+		instr.Src = parsed
+	}
+	return instr, nil
+}
+
+// parseMemory parses an addressing mode eg [x0], [x0, #8]! or [x0, x1, lsl #3]
+func parseMemory(o string) (*reg.MemoryOperand, error) {
+	mem := &reg.MemoryOperand{}
+	if strings.HasSuffix(o, "!") {
+		mem.Pre, mem.WriteBack = true, true
+		o = strings.TrimSuffix(o, "!")
+	}
+	if !strings.HasSuffix(o, "]") {
+		return nil, fmt.Errorf("malformed memory operand %s", o)
+	}
+	parts := asm.SplitOperands(o[1 : len(o)-1])
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("memory operand %s without base register", o)
+	}
+	base, ok := reg.Parse(parts[0])
+	if !ok {
+		return nil, fmt.Errorf("memory operand %s: %s is not a register", o, parts[0])
+	}
+	mem.BaseRegister = base
+	switch {
+	case len(parts) == 2 && strings.HasPrefix(parts[1], "#"):
+		mem.Offset = strings.TrimPrefix(parts[1], "#")
+	case len(parts) > 1:
+		mem.Index = strings.Join(parts[1:], ", ")
+	}
+	return mem, nil
+}
+
+// splitComment separates a // comment from the code of a line
+func splitComment(line string) (code, comment string) {
+	quoted := false
+	for i := 0; i+1 < len(line); i++ {
+		switch {
+		case line[i] == '"':
+			quoted = !quoted
+		case !quoted && line[i] == '/' && line[i+1] == '/':
+			return line[:i], strings.TrimSpace(line[i+2:])
+		}
+	}
+	return line, ""
+}
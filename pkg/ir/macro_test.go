@@ -0,0 +1,57 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/algoboyz/garm/pkg/asm"
+	"github.com/algoboyz/garm/pkg/reg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInstruction(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"stp x29, x30, [sp, #-16]!", "\tSTP x29, x30, [sp, #-16]!\n"},
+		{"ldp x29, x30, [sp], #16", "\tLDP x29, x30, [sp], #16\n"},
+		{"cbz x16, 1f", "\tCBZ x16, 1f\n"},
+		{"b.hs .Ldone", "\tB.HS .Ldone\n"},
+		{"sub x1, x20, x25, lsl #3", "\tSUB x1, x20, x25, LSL #3\n"},
+		{"add x0, x0, :lo12:runtime.gcwaiting", "\tADD x0, x0, :lo12:runtime.gcwaiting\n"},
+		{"str x2, [x0, x1, lsl #3]", "\tSTR x2, [x0, x1, lsl #3]\n"},
+		{"ret", "\tRET\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			instr, err := ParseInstruction(tt.line)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, instr.String(false))
+		})
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	instrs, err := ExpandMacros([]Instruction{{
+		Macro:   &asm.DEFER,
+		Src:     []reg.Operand{reg.NewRegOperand("x16")},
+		Comment: "defer cleanup",
+	}})
+	require.NoError(t, err)
+
+	var sb strings.Builder
+	for _, i := range instrs {
+		assert.Nil(t, i.Macro)
+		sb.WriteString(i.String(true))
+	}
+	out := sb.String()
+	assert.True(t, strings.HasPrefix(out, "\tSUB sp, sp, #defer_size\t// defer cleanup\n"), out)
+	assert.Contains(t, out, "\tSTR x16, [sp, #defer_fn]\n")
+	assert.Contains(t, out, "\tSTR xzr, [sp, #defer_ctx]\n", "ctx defaults to xzr")
+	assert.Contains(t, out, "\tSTP d6, d7, [sp, #defer_fargs + 48]\n")
+
+	_, err = ExpandMacros([]Instruction{{Macro: &asm.DEFER, Src: make([]reg.Operand, 4)}})
+	assert.ErrorContains(t, err, "macro DEFER: 4 arguments, want at most 3")
+}
@@ -0,0 +1,285 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+	"slices"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Scratch registers reserved from allocation, x16 holds indirect call targets
+// and x17 addresses the save area and parameter slots
+var (
+	scratchCall = &reg.Register{ID: 16, Class: reg.RegisterClassGPR}
+	scratchAddr = &reg.Register{ID: 17, Class: reg.RegisterClassGPR}
+)
+
+// part is a register sized piece of a value in the calling convention,
+// indirect parts hold the address of the value
+type part struct {
+	offset   int
+	typ      types.Type
+	indirect bool
+}
+
+// abi hands out the AAPCS64 argument and result registers x0-x7 and d0-d7
+type abi struct {
+	ints, floats uint8
+	results      bool // results are never passed indirectly
+}
+
+func (a *abi) next(p part) (*reg.Register, error) {
+	if isFloat(p.typ) {
+		if a.floats == 8 {
+			return nil, unsupported(diag.Call, "more than 8 floating point registers are not supported")
+		}
+		a.floats++
+		return &reg.Register{ID: a.floats - 1, Class: reg.RegisterClassFPR}, nil
+	}
+	if a.ints == 8 {
+		return nil, unsupported(diag.Call, "more than 8 general purpose registers are not supported")
+	}
+	a.ints++
+	return &reg.Register{ID: a.ints - 1, Class: reg.RegisterClassGPR}, nil
+}
+
+// isAggregate reports whether values of typ live in a stack slot
+func (m *SSAMapper) isAggregate(typ types.Type) bool {
+	t, err := m.MapType("", typ)
+	return err == nil && alloc.IsAggregate(t)
+}
+
+// parts splits a value of type typ into the pieces passed in registers.
+// Scalars are a single piece, aggregates of up to two words are passed as
+// words and larger ones by address as in AAPCS64. Tuples concatenate the
+// pieces of their elements
+func (m *SSAMapper) parts(typ types.Type) ([]part, error) {
+	if t, ok := typ.(*types.Tuple); ok {
+		offsets := m.tupleOffsets(t)
+		var ps []part
+		for i := range t.Len() {
+			elem, err := m.parts(t.At(i).Type())
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range elem {
+				ps = append(ps, part{offset: offsets[i] + p.offset, typ: p.typ})
+			}
+		}
+		return ps, nil
+	}
+	if !m.isAggregate(typ) {
+		return []part{{typ: typ}}, nil
+	}
+	size := m.sizeof(typ)
+	if size > 2*alloc.WordSize {
+		return []part{{typ: types.Typ[types.Uintptr], indirect: true}}, nil
+	}
+	var ps []part
+	for offset := 0; offset < size; offset += alloc.WordSize {
+		ps = append(ps, part{offset: offset, typ: wordType(size - offset)})
+	}
+	return ps, nil
+}
+
+// wordType is the unsigned integer type moving n bytes of a slot, slots are
+// padded to whole words so odd sizes move a full word
+func wordType(n int) types.Type {
+	switch n {
+	case 1:
+		return types.Typ[types.Uint8]
+	case 2:
+		return types.Typ[types.Uint16]
+	case 4:
+		return types.Typ[types.Uint32]
+	}
+	return types.Typ[types.Uint64]
+}
+
+// moveOut copies v into the registers assigned to its parts
+func (m *SSAMapper) moveOut(v ssa.Value, a *abi, comment string) error {
+	ps, err := m.parts(v.Type())
+	if err != nil {
+		return err
+	}
+	if len(ps) == 0 {
+		// Zero sized values take no registers
+		return nil
+	}
+	src, err := m.load(v)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.Name(), err)
+	}
+	defer m.release(v, src)
+	if !m.isAggregate(v.Type()) || ps[0].indirect {
+		if ps[0].indirect && a.results {
+			return unsupported(diag.Call, "returning %s in registers is not supported", v.Type())
+		}
+		dst, err := a.next(ps[0])
+		if err != nil {
+			return err
+		}
+		m.move(dst, src, m.sizeof(ps[0].typ), comment)
+		return nil
+	}
+	for _, p := range ps {
+		dst, err := a.next(p)
+		if err != nil {
+			return err
+		}
+		m.loadFrom(dst, p.typ, src, p.offset, comment)
+	}
+	return nil
+}
+
+// moveIn defines v from the registers assigned to its parts
+func (m *SSAMapper) moveIn(v ssa.Value, a *abi, comment string) error {
+	ps, err := m.parts(v.Type())
+	if err != nil {
+		return err
+	}
+	if len(ps) == 0 {
+		_, err = m.slot(v)
+		return err
+	}
+	if !m.isAggregate(v.Type()) {
+		src, err := a.next(ps[0])
+		if err != nil {
+			return err
+		}
+		dst, err := m.define(v)
+		if err != nil {
+			return err
+		}
+		m.move(dst, src, m.sizeof(v.Type()), comment)
+		return nil
+	}
+	if ps[0].indirect && a.results {
+		return unsupported(diag.Call, "returning %s in registers is not supported", v.Type())
+	}
+	mem, err := m.slot(v)
+	if err != nil {
+		return err
+	}
+	m.slotAddress(scratchAddr, mem)
+	if ps[0].indirect {
+		src, err := a.next(ps[0])
+		if err != nil {
+			return err
+		}
+		return m.copyMem(scratchAddr, src, m.sizeof(v.Type()), comment)
+	}
+	for _, p := range ps {
+		src, err := a.next(p)
+		if err != nil {
+			return err
+		}
+		m.storeTo(src, p.typ, scratchAddr, p.offset, comment)
+	}
+	return nil
+}
+
+// move copies a scalar between registers, registers spilled for the current
+// call are reloaded from the save area since argument registers may already
+// have been overwritten
+func (m *SSAMapper) move(dst, src *reg.Register, size int, comment string) {
+	if offset, ok := m.saved[*src]; ok && src.ID < 8 {
+		m.slotAddress(scratchAddr, m.saveArea)
+		m.emit(ir.Instruction{
+			Op:      op.LDR,
+			Dst:     dst.Sized(size),
+			Src:     []reg.Operand{reg.NewOffsetOperand(scratchAddr, offset)},
+			Comment: comment,
+		})
+		return
+	}
+	instr := ir.Instruction{
+		Op:      op.MOV,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewRegOperand(src.String())},
+		Comment: comment,
+	}
+	if dst.Class == reg.RegisterClassFPR || src.Class == reg.RegisterClassFPR {
+		instr.Op = op.FMOV
+		instr.Dst = dst.Sized(size)
+		instr.Src = []reg.Operand{reg.NewRegOperand(src.Sized(size).String())}
+	}
+	m.emit(instr)
+}
+
+// saveRegisters spills the registers allocated at a call site, all of them
+// are caller saved in AAPCS64
+func (m *SSAMapper) saveRegisters() []reg.Register {
+	regs := m.alloc.Allocated()
+	if len(regs) == 0 {
+		return nil
+	}
+	if m.saveArea == nil {
+		loc, err := m.alloc.AllocateStack(alloc.MemoryLocation{
+			Name:      "save area",
+			Size:      saveAreaSize,
+			Alignment: alloc.WordSize,
+		})
+		if err != nil {
+			m.debug.Fatal("allocating save area: " + err.Error())
+		}
+		m.saveArea = loc.GetMemory()
+	}
+	m.slotAddress(scratchAddr, m.saveArea)
+	m.saved = make(map[reg.Register]int, len(regs))
+	for _, r := range regs {
+		offset := saveOffset(r)
+		m.saved[r] = offset
+		m.emit(ir.Instruction{
+			Op:      op.STR,
+			Dst:     &r,
+			Src:     []reg.Operand{reg.NewOffsetOperand(scratchAddr, offset)},
+			Comment: "save " + r.String(),
+		})
+	}
+	return regs
+}
+
+// emitCall emits a call instruction and its stack map, arguments are in
+// place so registers are no longer reloaded from the save area
+func (m *SSAMapper) emitCall(call ir.Instruction) {
+	m.emit(call)
+	m.recordStackMap()
+	m.saved = nil
+}
+
+// restoreRegisters reloads the registers spilled by saveRegisters, except for
+// the ones in keep which were allocated to hold the result of the call
+func (m *SSAMapper) restoreRegisters(regs []reg.Register, keep ...*reg.Register) {
+	regs = slices.DeleteFunc(regs, func(r reg.Register) bool {
+		return slices.ContainsFunc(keep, func(k *reg.Register) bool { return *k == r })
+	})
+	if len(regs) == 0 {
+		return
+	}
+	m.slotAddress(scratchAddr, m.saveArea)
+	for _, r := range regs {
+		m.emit(ir.Instruction{
+			Op:      op.LDR,
+			Dst:     &r,
+			Src:     []reg.Operand{reg.NewOffsetOperand(scratchAddr, saveOffset(r))},
+			Comment: "restore " + r.String(),
+		})
+	}
+}
+
+// saveAreaSize holds x0-x15 followed by d0-d7
+const saveAreaSize = (16 + 8) * alloc.WordSize
+
+func saveOffset(r reg.Register) int {
+	if r.Class == reg.RegisterClassFPR {
+		return (16 + int(r.ID)) * alloc.WordSize
+	}
+	return int(r.ID) * alloc.WordSize
+}
@@ -0,0 +1,30 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapZeroSize(t *testing.T) {
+	fns, m := compile(t, `package main
+
+type E struct{}
+
+func (E) Error() string { return "e" }
+
+func f(x struct{}, n int) int { return n }
+
+func g(n int) (struct{}, int) { return struct{}{}, n }
+
+func main() {
+	var err error = E{}
+	_, k := g(2)
+	println(f(struct{}{}, 1)+k, err.Error())
+}
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	assert.Empty(t, m.Diagnostics().All())
+	assert.Empty(t, m.Report().Unsupported, "zero sized parameters, receivers and results are passed in no registers")
+	assert.Contains(t, fns, "main.E.Error")
+	assert.Contains(t, fns, "main.f")
+}
@@ -2,21 +2,37 @@ package mapper
 
 import (
 	"fmt"
+	"go/types"
 
+	"github.com/algoboyz/garm/pkg/alloc"
 	"golang.org/x/tools/go/ssa"
 )
 
+// MapAlloc lowers new(T), composite literals and address taken locals. The
+// value is the address of a zeroed T, escaping allocations are placed on the
+// heap and everything else in the stack frame
 func (m *SSAMapper) MapAlloc(v *ssa.Alloc) error {
-	typ, err := m.MapLiteral(v.Name(), v.Type())
+	elem := v.Type().(*types.Pointer).Elem()
+	dst, err := m.define(v)
 	if err != nil {
-		return fmt.Errorf("mapping alloc type: %w", err)
+		return err
 	}
-
-	allocation, err := m.alloc.AllocateRegister(typ)
+	if v.Heap {
+		m.newObject(dst, elem)
+		return nil
+	}
+	// The stack object lives apart from the pointer held in dst
+	loc, err := m.alloc.AllocateStack(alloc.MemoryLocation{
+		Name:      fmt.Sprintf("%s (%s)", v.Name(), v.Comment),
+		Size:      alloc.AlignSize(m.sizeof(elem), alloc.WordSize),
+		Alignment: alloc.WordSize,
+	})
 	if err != nil {
 		return fmt.Errorf("allocating %s: %w", v.Name(), err)
 	}
-
-	m.currentIR.Locals[v.Name()] = allocation
+	m.frameObjects[v] = loc.GetMemory()
+	m.slotAddress(dst, loc.GetMemory())
+	// Locals are zeroed every time their declaration executes
+	m.zeroMem(dst, loc.GetMemory().Size, "zero "+v.Name())
 	return nil
 }
@@ -0,0 +1,39 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapAlloc(t *testing.T) {
+	fns, _ := compile(t, `package main
+
+type small struct {
+	b  bool
+	i8 int8
+	u  uint16
+}
+
+func escape() *int { return new(int) }
+
+func local() int8 {
+	var s small
+	s.b = true
+	s.i8 = -3
+	return s.i8
+}
+
+func main() {}
+`)
+	escape := fns["main.escape"]
+	assert.Contains(t, escape, "MOVZ x0, #0x8", "object size")
+	assert.Contains(t, escape, "ADRP x1, type.int", "type descriptor")
+	assert.Contains(t, escape, "BL runtime.alloc")
+
+	local := fns["main.local"]
+	assert.NotContains(t, local, "runtime.alloc", "locals live in the frame")
+	assert.Regexp(t, `STR xzr, \[x\d+\]`, local, "zeroed on declaration")
+	assert.Regexp(t, `STRB w\d+, \[x\d+\]`, local, "bool stored as a byte")
+	assert.Contains(t, local, "LDRSB", "int8 loaded sign extended")
+}
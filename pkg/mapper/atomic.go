@@ -0,0 +1,220 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// atomicOp is the operation of a sync/atomic function
+type atomicOp string
+
+const (
+	atomicLoad  atomicOp = "Load"
+	atomicStore atomicOp = "Store"
+	atomicAdd   atomicOp = "Add"
+	atomicAnd   atomicOp = "And"
+	atomicOr    atomicOp = "Or"
+	atomicSwap  atomicOp = "Swap"
+	atomicCAS   atomicOp = "CompareAndSwap"
+)
+
+// Functions are named after the operation and the type eg AddInt64, methods
+// after the operation alone
+var atomicOps = []atomicOp{atomicCAS, atomicLoad, atomicStore, atomicAdd, atomicAnd, atomicOr, atomicSwap}
+
+// SetLSE selects the ARMv8.1 atomic instructions, without them atomics are
+// exclusive load and store loops running on baseline ARMv8.0
+func (m *SSAMapper) SetLSE(lse bool) {
+	m.lse = lse
+}
+
+// atomicIntrinsic returns the operation of a function of sync/atomic or a
+// method of its types. Both take the address first so they are lowered
+// alike, atomic.Value keeps its Go implementation
+func atomicIntrinsic(fn *ssa.Function) (atomicOp, bool) {
+	obj, ok := fn.Object().(*types.Func)
+	if !ok || obj.Pkg() == nil || obj.Pkg().Path() != "sync/atomic" {
+		return "", false
+	}
+	if recv := fn.Signature.Recv(); recv != nil {
+		if ptr, ok := recv.Type().(*types.Pointer); ok {
+			if named, ok := ptr.Elem().(*types.Named); ok && named.Obj().Name() == "Value" {
+				return "", false
+			}
+		}
+	}
+	for _, o := range atomicOps {
+		if strings.HasPrefix(obj.Name(), string(o)) {
+			return o, true
+		}
+	}
+	return "", false
+}
+
+// mapAtomic inlines a sync/atomic operation. Every operation is sequentially
+// consistent as the Go memory model requires: loads acquire, stores release
+// and read-modify-writes do both. x16 and x17 hold the status and temporary
+// values of the exclusive loops
+func (m *SSAMapper) mapAtomic(expr *ssa.Call, kind atomicOp) error {
+	args := expr.Call.Args
+	addr, err := m.load(args[0])
+	if err != nil {
+		return fmt.Errorf("loading address %s: %w", args[0].Name(), err)
+	}
+	defer m.release(args[0], addr)
+	var vals []*reg.Register
+	for _, arg := range args[1:] {
+		r, err := m.load(arg)
+		if err != nil {
+			return fmt.Errorf("loading operand %s: %w", arg.Name(), err)
+		}
+		defer m.release(arg, r)
+		vals = append(vals, r)
+	}
+	// Booleans are stored as uint32 by atomic.Bool
+	typ := args[len(args)-1].Type()
+	if kind == atomicLoad {
+		typ = expr.Type()
+	}
+	size := max(m.sizeof(typ), 4)
+	mem := reg.NewOffsetOperand(addr, 0)
+	comment := fmt.Sprintf("atomic %s %s", kind, args[0].Name())
+
+	var dst *reg.Register
+	if kind != atomicStore {
+		if dst, err = m.define(expr); err != nil {
+			return err
+		}
+	}
+	switch kind {
+	case atomicLoad:
+		m.emit(ir.Instruction{Op: op.LDAR, Dst: dst.Sized(size), Src: []reg.Operand{mem}, Comment: comment})
+	case atomicStore:
+		m.emit(ir.Instruction{Op: op.STLR, Dst: vals[0].Sized(size), Src: []reg.Operand{mem}, Comment: comment})
+	case atomicCAS:
+		m.compareAndSwap(addr, vals[0], vals[1], dst, size, comment)
+	default:
+		if m.lse {
+			m.atomicLSE(kind, mem, vals[0], dst, size, comment)
+		} else {
+			m.atomicLoop(kind, mem, vals[0], dst, size, comment)
+		}
+	}
+	// Globals are roots, pointers stored anywhere else need the barrier
+	if _, global := args[0].(*ssa.Global); !global && hasPointers(typ) {
+		if kind == atomicStore || kind == atomicSwap || kind == atomicCAS {
+			m.barrier(addr, typ, args[0].Name(), args[len(args)-1].Name())
+		}
+	}
+	return nil
+}
+
+// atomicLSE emits a single LSE instruction returning the old value, Add
+// returns the new one
+func (m *SSAMapper) atomicLSE(kind atomicOp, mem reg.Operand, val, dst *reg.Register, size int, comment string) {
+	old := reg.NewRegOperand(dst.Sized(size).String())
+	switch kind {
+	case atomicAdd:
+		m.emit(ir.Instruction{Op: op.LDADDAL, Dst: val.Sized(size), Src: []reg.Operand{old, mem}, Comment: comment},
+			ir.Instruction{Op: op.ADD, Dst: dst.Sized(size), Src: []reg.Operand{old, reg.NewRegOperand(val.Sized(size).String())}})
+	case atomicAnd:
+		// Clear the bits not set in the operand
+		m.emit(ir.Instruction{Op: op.MVN, Dst: scratchCall.Sized(size), Src: []reg.Operand{reg.NewRegOperand(val.Sized(size).String())}},
+			ir.Instruction{Op: op.LDCLRAL, Dst: scratchCall.Sized(size), Src: []reg.Operand{old, mem}, Comment: comment})
+	case atomicOr:
+		m.emit(ir.Instruction{Op: op.LDSETAL, Dst: val.Sized(size), Src: []reg.Operand{old, mem}, Comment: comment})
+	case atomicSwap:
+		m.emit(ir.Instruction{Op: op.SWPAL, Dst: val.Sized(size), Src: []reg.Operand{old, mem}, Comment: comment})
+	}
+}
+
+// atomicLoop emits an exclusive load and store loop, retried until no other
+// store to the address intervened
+func (m *SSAMapper) atomicLoop(kind atomicOp, mem reg.Operand, val, dst *reg.Register, size int, comment string) {
+	retry := m.localLabel("atomic")
+	old := reg.NewRegOperand(dst.Sized(size).String())
+	operand := reg.NewRegOperand(val.Sized(size).String())
+	m.emit(ir.Instruction{Labels: []string{retry}},
+		ir.Instruction{Op: op.LDAXR, Dst: dst.Sized(size), Src: []reg.Operand{mem}, Comment: comment})
+	next := scratchAddr.Sized(size)
+	switch kind {
+	case atomicAdd:
+		next = dst.Sized(size)
+		m.emit(ir.Instruction{Op: op.ADD, Dst: next, Src: []reg.Operand{old, operand}})
+	case atomicAnd:
+		m.emit(ir.Instruction{Op: op.AND, Dst: next, Src: []reg.Operand{old, operand}})
+	case atomicOr:
+		m.emit(ir.Instruction{Op: op.ORR, Dst: next, Src: []reg.Operand{old, operand}})
+	case atomicSwap:
+		next = val.Sized(size)
+	}
+	m.emit(ir.Instruction{
+		Op:  op.STLXR,
+		Dst: scratchCall.Sized(4),
+		Src: []reg.Operand{reg.NewRegOperand(next.String()), mem},
+	}, ir.Instruction{
+		Op:      op.CBNZ,
+		Dst:     scratchCall.Sized(4),
+		Labels:  []string{retry},
+		Comment: "retry when the store failed",
+	})
+}
+
+// compareAndSwap stores next at addr when it holds old and sets dst when it
+// did
+func (m *SSAMapper) compareAndSwap(addr, old, next, dst *reg.Register, size int, comment string) {
+	mem := reg.NewOffsetOperand(addr, 0)
+	expected := reg.NewRegOperand(old.Sized(size).String())
+	if m.lse {
+		m.emit(ir.Instruction{
+			Op:  op.MOV,
+			Dst: scratchCall.Sized(size),
+			Src: []reg.Operand{expected},
+		}, ir.Instruction{
+			Op:      op.CASAL,
+			Dst:     scratchCall.Sized(size),
+			Src:     []reg.Operand{reg.NewRegOperand(next.Sized(size).String()), mem},
+			Comment: comment,
+		}, ir.Instruction{
+			Op:  op.CMP,
+			Dst: scratchCall.Sized(size),
+			Src: []reg.Operand{expected},
+		})
+	} else {
+		retry, done := m.localLabel("cas"), m.localLabel("casdone")
+		m.emit(ir.Instruction{Labels: []string{retry}}, ir.Instruction{
+			Op:      op.LDAXR,
+			Dst:     scratchCall.Sized(size),
+			Src:     []reg.Operand{mem},
+			Comment: comment,
+		}, ir.Instruction{
+			Op:  op.CMP,
+			Dst: scratchCall.Sized(size),
+			Src: []reg.Operand{expected},
+		}, ir.Instruction{
+			Op:     op.BNE,
+			Labels: []string{done},
+		}, ir.Instruction{
+			Op:  op.STLXR,
+			Dst: scratchCall.Sized(4),
+			Src: []reg.Operand{reg.NewRegOperand(next.Sized(size).String()), mem},
+		}, ir.Instruction{
+			Op:      op.CBNZ,
+			Dst:     scratchCall.Sized(4),
+			Labels:  []string{retry},
+			Comment: "retry when the store failed",
+		}, ir.Instruction{Labels: []string{done}})
+	}
+	m.emit(ir.Instruction{
+		Op:      op.CSET,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewLabelOperand("eq")},
+		Comment: "swapped",
+	})
+}
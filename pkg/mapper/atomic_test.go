@@ -0,0 +1,34 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapAtomics(t *testing.T) {
+	src := `package main
+
+import "sync/atomic"
+
+type node struct{ next *node }
+
+func add(n *int64) int64 { return atomic.AddInt64(n, 2) }
+
+func cas(n *int32) bool { return atomic.CompareAndSwapInt32(n, 1, 2) }
+
+func push(head *atomic.Pointer[node], n *node) { head.Store(n) }
+
+func main() {}
+`
+	fns, _ := compile(t, src, func(m *SSAMapper) { m.SetGC(GCGenerational) })
+	assert.Regexp(t, `LDADDAL x\d+, x\d+, \[x\d+\]\n\tADD x\d+, x\d+, x\d+\n`, fns["main.add"], "Add returns the new value")
+	assert.Regexp(t, `CASAL w\d+, w\d+, \[x\d+\]\n\tCMP w\d+, w\d+\n\tCSET x\d+, eq\n`, fns["main.cas"])
+	assert.Regexp(t, `STLR x\d+, \[x\d+\]\n\tLSR x\d+, x\d+, #9\n`, fns["main.push"], "pointer stores mark the card")
+	assert.NotContains(t, fns["main.push"], "BL ", "atomics are inlined")
+
+	fns, _ = compile(t, src, func(m *SSAMapper) { m.SetGC(GCNone); m.SetLSE(false) })
+	assert.Regexp(t, `LDAXR x\d+, \[x\d+\]\n\tADD x\d+, x\d+, x\d+\n\tSTLXR w\d+, x\d+, \[x\d+\]\n\tCBNZ w\d+, \S+atomic`, fns["main.add"])
+	assert.Regexp(t, `LDAXR w\d+, \[x\d+\]\n\tCMP w\d+, w\d+\n\tB\.NE `, fns["main.cas"])
+	assert.NotRegexp(t, `LSR x\d+, x\d+, #9`, fns["main.push"], "no barrier without a collector")
+}
@@ -0,0 +1,170 @@
+package mapper
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Card table shared with pkg/asm/runtime.asm. The table is indexed by the
+// address bits above the card size, higher bits wrap around so stores
+// outside the heap only ever dirty a card spuriously
+const (
+	runtimeCardTable = "runtime.cardtable"
+	cardShift        = 9 // 512 byte cards
+	cardMask         = 1<<20 - 1
+	cardDirty        = 1
+)
+
+// Runtime symbols of the mark and sweep barrier, see pkg/asm/marksweep.asm
+const (
+	runtimeGCPhase = "runtime.gcphase" // non zero while marking
+	runtimeGCShade = "runtime.gcshade" // greys the pointer in x16
+)
+
+// writeBarrier follows a store of a value holding pointers with the barrier
+// of the selected collector
+func (m *SSAMapper) writeBarrier(v *ssa.Store, addr *reg.Register) {
+	if !hasPointers(v.Val.Type()) || m.barrierElided(v) {
+		return
+	}
+	m.barrier(addr, v.Val.Type(), v.Addr.Name(), v.Val.Name())
+}
+
+// barrier emits the barrier of the selected collector for a value of type t
+// just stored at addr
+func (m *SSAMapper) barrier(addr *reg.Register, t types.Type, dst, val string) {
+	switch m.gc {
+	case GCGenerational:
+		m.cardBarrier(addr, dst)
+	case GCMarkSweep:
+		m.shadeBarrier(addr, t, val)
+	}
+}
+
+// cardBarrier dirties the card of addr, the collector rescans old objects on
+// dirty cards for pointers into the young generation
+func (m *SSAMapper) cardBarrier(addr *reg.Register, dst string) {
+	m.emit(ir.Instruction{
+		Op:      op.LSR,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewRegOperand(addr.String()), reg.NewImmediateOperand(fmt.Sprint(cardShift))},
+		Comment: "write barrier, card of " + dst,
+	}, ir.Instruction{
+		Op:  op.AND,
+		Dst: scratchCall,
+		Src: []reg.Operand{reg.NewRegOperand(scratchCall.String()), reg.NewImmediateOperand(fmt.Sprintf("0x%x", cardMask))},
+	})
+	m.loadAddress(scratchAddr, runtimeCardTable)
+	m.emit(ir.Instruction{
+		Op:  op.ADD,
+		Dst: scratchAddr,
+		Src: []reg.Operand{reg.NewRegOperand(scratchAddr.String()), reg.NewRegOperand(scratchCall.String())},
+	})
+	m.movImm(scratchCall, cardDirty)
+	m.emit(ir.Instruction{
+		Op:      op.STRB,
+		Dst:     scratchCall.Sized(1),
+		Src:     []reg.Operand{reg.NewOffsetOperand(scratchAddr, 0)},
+		Comment: "dirty card",
+	})
+}
+
+// shadeBarrier greys the pointers just stored while marking is in progress,
+// the runtime call preserves every register but the scratch ones and x30
+func (m *SSAMapper) shadeBarrier(addr *reg.Register, t types.Type, val string) {
+	done := m.localLabel("wb")
+	m.loadAddress(scratchCall, runtimeGCPhase)
+	m.emit(ir.Instruction{
+		Op:      op.LDRB,
+		Dst:     scratchCall.Sized(1),
+		Src:     []reg.Operand{reg.NewOffsetOperand(scratchCall, 0)},
+		Comment: "write barrier, marking?",
+	}, ir.Instruction{
+		Op:     op.CBZ,
+		Dst:    scratchCall.Sized(1),
+		Labels: []string{done},
+	})
+	words := make([]bool, (m.sizeof(t)+alloc.WordSize-1)/alloc.WordSize)
+	m.pointerWords(t, 0, words)
+	for i, ptr := range words {
+		if !ptr {
+			continue
+		}
+		m.emit(ir.Instruction{
+			Op:  op.LDR,
+			Dst: scratchCall,
+			Src: []reg.Operand{reg.NewOffsetOperand(addr, i*alloc.WordSize)},
+		}, ir.Instruction{
+			Op:      op.BL,
+			Labels:  []string{runtimeGCShade},
+			Comment: "shade " + val,
+		})
+	}
+	m.emit(ir.Instruction{Labels: []string{done}})
+}
+
+// barrierElided reports whether the store needs no barrier: stack slots and
+// globals are scanned as roots on every collection. For the generational
+// collector objects allocated in the same block without anything in between
+// that could collect are still in the young generation, the mark and sweep
+// collector allocates black so they need shading all the same
+func (m *SSAMapper) barrierElided(v *ssa.Store) bool {
+	base := v.Addr
+	for {
+		switch x := base.(type) {
+		case *ssa.FieldAddr:
+			base = x.X
+			continue
+		case *ssa.IndexAddr:
+			if _, ok := x.X.Type().Underlying().(*types.Slice); ok {
+				return false // the backing array can be anywhere
+			}
+			base = x.X
+			continue
+		case *ssa.Global:
+			return true
+		case *ssa.Alloc:
+			return !x.Heap || m.gc == GCGenerational && freshObject(x, v)
+		}
+		return false
+	}
+}
+
+// freshObject reports whether obj is allocated earlier in the block of the
+// store and only instructions that cannot trigger a collection run between
+// the allocation and the store
+func freshObject(obj *ssa.Alloc, store *ssa.Store) bool {
+	if obj.Block() != store.Block() {
+		return false
+	}
+	fresh := false
+	for _, instr := range store.Block().Instrs {
+		switch instr {
+		case obj:
+			fresh = true
+			continue
+		case store:
+			return fresh
+		}
+		if !fresh {
+			continue
+		}
+		switch x := instr.(type) {
+		case *ssa.FieldAddr, *ssa.IndexAddr, *ssa.Store, *ssa.BinOp, *ssa.DebugRef, *ssa.ChangeType:
+		case *ssa.UnOp:
+			if x.Op == token.ARROW {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return false
+}
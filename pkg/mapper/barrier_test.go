@@ -0,0 +1,57 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapWriteBarrier(t *testing.T) {
+	const src = `package main
+
+type node struct {
+	next *node
+	val  int
+}
+
+var head *node
+
+func link(a, b *node) { a.next = b }
+
+func fresh(b *node) *node {
+	n := &node{}
+	n.next = b
+	return n
+}
+
+func count(a *node) { a.val = 1 }
+
+func global(b *node) { head = b }
+
+func main() {}
+`
+	fns, m := compile(t, src, func(m *SSAMapper) { m.SetGC(GCGenerational) })
+	assert.Regexp(t, `ADRP x\d+, runtime\.cardtable\n(\t.*\n)*?\tSTRB w\d+, \[x\d+\]`, fns["main.link"], "pointer store into the heap dirties the card")
+	assert.NotContains(t, fns["main.fresh"], "runtime.cardtable", "young object")
+	assert.NotContains(t, fns["main.count"], "runtime.cardtable", "no pointers stored")
+	assert.NotContains(t, fns["main.global"], "runtime.cardtable", "globals are roots")
+	assert.Contains(t, fns["main"], "BL runtime.gcinit")
+	roots, ok := m.data.Lookup("go.gcroots")
+	require.True(t, ok)
+	assert.Contains(t, roots.String(false), ".quad 1\n\t.quad main.head+0\n", "head is scanned as a root")
+
+	fns, _ = compile(t, src)
+	assert.Regexp(t, `ADRP x\d+, runtime\.gcphase\n(\t.*\n)*?\tLDRB w\d+, \[x\d+\]`, fns["main.link"], "marking phase checked")
+	assert.Contains(t, fns["main.link"], "BL runtime.gcshade")
+	assert.Contains(t, fns["main.fresh"], "BL runtime.gcshade", "objects are allocated black")
+	assert.NotContains(t, fns["main.global"], "runtime.gcshade")
+
+	fns, m = compile(t, src, func(m *SSAMapper) { m.SetGC(GCNone) })
+	assert.NotContains(t, fns["main.link"], "runtime.cardtable", "no barriers without a collector")
+	assert.NotContains(t, fns["main"], "runtime.gcinit")
+	_, ok = m.data.Lookup("go.stackmaps")
+	assert.False(t, ok, "no stack maps without a collector")
+	_, ok = m.data.Lookup("go.gcroots")
+	assert.False(t, ok, "no roots without a collector")
+}
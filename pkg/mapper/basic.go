@@ -5,6 +5,7 @@ import (
 	"go/types"
 
 	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
 )
 
 func (m *SSAMapper) MapBasicType(name string, typ types.Type) (p alloc.Primitive, err error) {
@@ -15,30 +16,188 @@ func (m *SSAMapper) MapBasicType(name string, typ types.Type) (p alloc.Primitive
 		return alloc.Pointer, nil
 	case *types.Named:
 		return m.MapBasicType(name, t.Underlying())
+	case *types.Alias:
+		return m.MapBasicType(name, types.Unalias(t))
 	default:
-		return p, fmt.Errorf("unsupported type: %T", typ)
+		return p, unsupported(diag.Type, "unsupported type: %T", typ)
 	}
 }
 
 func (m *SSAMapper) mapBasicType(name string, typ *types.Basic) (p alloc.Primitive, err error) {
 	switch typ.Kind() {
-	case types.Int8:
+	case types.Int8, types.Uint8:
 		return alloc.Int8, nil
-	case types.Int16:
+	case types.Int16, types.Uint16:
 		return alloc.Int16, nil
-	case types.Int32:
+	case types.Int32, types.Uint32:
 		return alloc.Int32, nil
-	case types.Int64, types.Int:
+	case types.Int64, types.Int, types.Uint64, types.Uint, types.Uintptr:
 		return alloc.Int64, nil
 	case types.Float32:
 		return alloc.Float32, nil
 	case types.Float64:
 		return alloc.Float64, nil
-	case types.Bool:
+	case types.Bool, types.UntypedBool:
 		return alloc.Bool, nil
+	case types.UnsafePointer, types.UntypedNil:
+		return alloc.Pointer, nil
 	case types.String:
 		return alloc.String, nil
 	default:
-		return p, fmt.Errorf("unsupported basic type: %v", typ)
+		return p, unsupported(diag.Type, "unsupported basic type: %v", typ)
+	}
+}
+
+// MapType maps any Go type onto its ARM64 representation. Values wider than a
+// machine word (strings, slices, interfaces, structs...) are aggregates that
+// live in memory and are referenced by address
+func (m *SSAMapper) MapType(name string, typ types.Type) (alloc.ARM64Type, error) {
+	if parameterized(typ) {
+		return nil, unsupported(diag.Type, "unsupported type parameter in %s", typ)
+	}
+	size := m.sizeof(typ)
+	if size > alloc.WordSize {
+		return alloc.NewType(name, typ.String(), alloc.Aggregate, size), nil
+	}
+	if p, err := m.MapBasicType(name, typ); err == nil {
+		return alloc.NewType(name, typ.String(), p, size), nil
+	}
+	switch typ.Underlying().(type) {
+	case *types.Map, *types.Chan, *types.Signature, *types.Pointer:
+		return alloc.NewType(name, typ.String(), alloc.Pointer, size), nil
+	case *types.Struct, *types.Array, *types.Tuple:
+		return alloc.NewType(name, typ.String(), alloc.Aggregate, size), nil
+	default:
+		return nil, unsupported(diag.Type, "unsupported type: %s", typ)
+	}
+}
+
+// sizeof returns the arm64 size of typ in bytes, tuples are laid out like structs
+func (m *SSAMapper) sizeof(typ types.Type) int {
+	switch t := typ.(type) {
+	case *types.Tuple:
+		return int(m.sizes.Sizeof(tupleStruct(t)))
+	case *types.Basic:
+		// Untyped constants take the size of their default type, nil that
+		// of a pointer
+		if t.Kind() == types.UntypedNil {
+			return alloc.WordSize
+		}
+		if t.Info()&types.IsUntyped != 0 {
+			return int(m.sizes.Sizeof(types.Default(t)))
+		}
+	}
+	return int(m.sizes.Sizeof(typ))
+}
+
+// parameterized reports whether typ refers to type parameters, it has no
+// size until instantiated
+func parameterized(typ types.Type) bool {
+	return refersTypeParams(typ, make(map[*types.Named]bool))
+}
+
+func refersTypeParams(typ types.Type, seen map[*types.Named]bool) bool {
+	switch t := types.Unalias(typ).(type) {
+	case *types.TypeParam:
+		return true
+	case *types.Named:
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+		if t.TypeParams().Len() > t.TypeArgs().Len() {
+			return true
+		}
+		for i := range t.TypeArgs().Len() {
+			if refersTypeParams(t.TypeArgs().At(i), seen) {
+				return true
+			}
+		}
+		// Types declared in generic functions refer to their type
+		// parameters through the underlying type
+		obj := t.Obj()
+		if obj.Pkg() != nil && obj.Parent() != nil && obj.Parent() != obj.Pkg().Scope() {
+			return refersTypeParams(t.Underlying(), seen)
+		}
+	case *types.Pointer:
+		return refersTypeParams(t.Elem(), seen)
+	case *types.Slice:
+		return refersTypeParams(t.Elem(), seen)
+	case *types.Array:
+		return refersTypeParams(t.Elem(), seen)
+	case *types.Chan:
+		return refersTypeParams(t.Elem(), seen)
+	case *types.Map:
+		return refersTypeParams(t.Key(), seen) || refersTypeParams(t.Elem(), seen)
+	case *types.Struct:
+		for i := range t.NumFields() {
+			if refersTypeParams(t.Field(i).Type(), seen) {
+				return true
+			}
+		}
+	case *types.Tuple:
+		for i := range t.Len() {
+			if refersTypeParams(t.At(i).Type(), seen) {
+				return true
+			}
+		}
+	case *types.Signature:
+		return refersTypeParams(t.Params(), seen) || refersTypeParams(t.Results(), seen)
+	case *types.Interface:
+		for i := range t.NumEmbeddeds() {
+			if refersTypeParams(t.EmbeddedType(i), seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tupleStruct describes the memory layout of a multi value result
+func tupleStruct(t *types.Tuple) *types.Struct {
+	fields := make([]*types.Var, t.Len())
+	for i := range fields {
+		fields[i] = types.NewField(t.At(i).Pos(), t.At(i).Pkg(), fmt.Sprintf("r%d", i), t.At(i).Type(), false)
+	}
+	return types.NewStruct(fields, nil)
+}
+
+// tupleOffsets returns the byte offsets of the elements of a tuple
+func (m *SSAMapper) tupleOffsets(t *types.Tuple) []int {
+	st := tupleStruct(t)
+	fields := make([]*types.Var, st.NumFields())
+	for i := range fields {
+		fields[i] = st.Field(i)
+	}
+	offsets := make([]int, len(fields))
+	for i, offset := range m.sizes.Offsetsof(fields) {
+		offsets[i] = int(offset)
+	}
+	return offsets
+}
+
+// isSigned reports whether loads of typ need sign extension
+func isSigned(typ types.Type) bool {
+	b, ok := typ.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsInteger != 0 && b.Info()&types.IsUnsigned == 0
+}
+
+func isFloat(typ types.Type) bool {
+	b, ok := typ.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsFloat != 0
+}
+
+func isString(typ types.Type) bool {
+	b, ok := typ.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsString != 0
+}
+
+// isBytes reports whether typ is a slice of bytes
+func isBytes(typ types.Type) bool {
+	s, ok := typ.Underlying().(*types.Slice)
+	if !ok {
+		return false
 	}
+	b, ok := s.Elem().Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Byte
 }
@@ -2,8 +2,11 @@ package mapper
 
 import (
 	"fmt"
-	"strings"
+	"go/token"
+	"go/types"
 
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
 	"github.com/algoboyz/garm/pkg/ir"
 	"github.com/algoboyz/garm/pkg/op"
 	"github.com/algoboyz/garm/pkg/reg"
@@ -11,64 +14,135 @@ import (
 )
 
 func (m *SSAMapper) MapBinaryOperation(expr *ssa.BinOp) error {
+	if _, ok := conditions[expr.Op]; ok {
+		return m.mapCompare(expr)
+	}
 	var err error
 	token, err := m.MapToken(expr.Op)
 	if err != nil {
 		return fmt.Errorf("mapping operator: %w", err)
 	}
-	x, err := m.MapLiteral(expr.X.Name(), expr.X.Type())
+	if isFloat(expr.X.Type()) {
+		if token, err = floatOp(token); err != nil {
+			return err
+		}
+	}
+	// Load operands, constants are materialised into scratch registers
+	lhs, err := m.load(expr.X)
 	if err != nil {
 		return err
 	}
-	y, err := m.MapLiteral(expr.Y.Name(), expr.Y.Type())
+	defer m.release(expr.X, lhs)
+	rhs, err := m.load(expr.Y)
 	if err != nil {
 		return err
 	}
-	result, err := m.MapLiteral(expr.Name(), expr.Type())
+	defer m.release(expr.Y, rhs)
+	// Allocate result register
+	dst, err := m.define(expr)
 	if err != nil {
 		return err
 	}
-	// Allocate registers
-	dst, err := m.alloc.AllocateRegister(result)
+	// Generate ARM64 instruction
+	size := m.sizeof(expr.Type())
+	m.emit(ir.Instruction{
+		Op:  token,
+		Dst: dst.Sized(size),
+		Src: []reg.Operand{
+			reg.NewRegOperand(lhs.Sized(size).String()),
+			reg.NewRegOperand(rhs.Sized(size).String()),
+		},
+		Comment: fmt.Sprintf("%s = %s %s %s", expr.Name(), expr.X.Name(), expr.Op.String(), expr.Y.Name()),
+	})
+	return nil
+}
+
+// floatOp returns the floating point variant of an arithmetic instruction
+func floatOp(o op.Op) (op.Op, error) {
+	switch o {
+	case op.ADD:
+		return op.FADD, nil
+	case op.SUB:
+		return op.FSUB, nil
+	case op.MUL:
+		return op.FMUL, nil
+	case op.SDIV:
+		return op.FDIV, nil
+	default:
+		return op.NOP, unsupported(diag.Operator, "unsupported floating point operator %s", o)
+	}
+}
+
+// Condition codes of the comparisons: signed, unsigned and floating point
+var conditions = map[token.Token][3]string{
+	token.EQL: {"eq", "eq", "eq"},
+	token.NEQ: {"ne", "ne", "ne"},
+	token.LSS: {"lt", "lo", "mi"},
+	token.LEQ: {"le", "ls", "ls"},
+	token.GTR: {"gt", "hi", "gt"},
+	token.GEQ: {"ge", "hs", "ge"},
+}
+
+// mapCompare sets the result to 1 when the comparison holds, operands are
+// numbers, booleans, pointer shaped values, slices against nil or interfaces
+func (m *SSAMapper) mapCompare(expr *ssa.BinOp) error {
+	cond, cmp := conditions[expr.Op][0], op.CMP
+	switch t := expr.X.Type().Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case t.Info()&types.IsFloat != 0:
+			cond, cmp = conditions[expr.Op][2], op.FCMP
+		case t.Info()&(types.IsUnsigned|types.IsBoolean) != 0:
+			cond = conditions[expr.Op][1]
+		case t.Info()&(types.IsString|types.IsComplex) != 0:
+			return unsupported(diag.Operator, "unsupported comparison of %s", types.TypeString(expr.X.Type(), nil))
+		}
+	case *types.Pointer, *types.Chan, *types.Signature, *types.Map, *types.Slice:
+		cond = conditions[expr.Op][1]
+	case *types.Interface:
+		return m.mapInterfaceCompare(expr)
+	default:
+		return unsupported(diag.Operator, "unsupported comparison of %s", types.TypeString(expr.X.Type(), nil))
+	}
+	lhs, err := m.load(expr.X)
 	if err != nil {
 		return err
 	}
-	m.currentIR.Locals[result.String()] = dst
-	lhs, err := m.alloc.AllocateRegister(x)
+	defer m.release(expr.X, lhs)
+	rhs, err := m.load(expr.Y)
 	if err != nil {
 		return err
 	}
-	rhs, err := m.alloc.AllocateRegister(y)
+	defer m.release(expr.Y, rhs)
+	size := m.sizeof(expr.X.Type())
+	if _, ok := expr.X.Type().Underlying().(*types.Slice); ok {
+		// Slices only compare to nil, the data word of the header tells
+		m.emit(ir.Instruction{
+			Op:      op.LDR,
+			Dst:     scratchCall,
+			Src:     []reg.Operand{reg.NewOffsetOperand(lhs, sliceData)},
+			Comment: "data word of " + expr.X.Name(),
+		}, ir.Instruction{
+			Op:      op.LDR,
+			Dst:     scratchAddr,
+			Src:     []reg.Operand{reg.NewOffsetOperand(rhs, sliceData)},
+			Comment: "data word of " + expr.Y.Name(),
+		})
+		lhs, rhs, size = scratchCall, scratchAddr, alloc.WordSize
+	}
+	dst, err := m.define(expr)
 	if err != nil {
 		return err
 	}
-	// Generate ARM64 instruction
-	parts := strings.Split(expr.X.String(), ":")
-	m.currentIR.Blocks = append(m.currentIR.Blocks, ir.Instruction{
-		Op:  op.MOV,
-		Dst: lhs.GetRegister(),
-		Src: []reg.Operand{
-			reg.NewImmediateOperand(parts[0]),
-		},
-		Comment: fmt.Sprintf("load: %s = %s", parts[1], parts[0]),
-	})
-	parts = strings.Split(expr.Y.String(), ":")
-	m.currentIR.Blocks = append(m.currentIR.Blocks, ir.Instruction{
-		Op:  op.MOV,
-		Dst: rhs.GetRegister(),
-		Src: []reg.Operand{
-			reg.NewImmediateOperand(parts[0]),
-		},
-		Comment: fmt.Sprintf("load: %s = %s", parts[1], parts[0]),
-	})
-	m.currentIR.Blocks = append(m.currentIR.Blocks, ir.Instruction{
-		Op:  token,
-		Dst: dst.GetRegister(),
-		Src: []reg.Operand{
-			reg.NewRegOperand(lhs.GetRegister().String()),
-			reg.NewRegOperand(rhs.GetRegister().String()),
-		},
+	m.emit(ir.Instruction{
+		Op:      cmp,
+		Dst:     lhs.Sized(size),
+		Src:     []reg.Operand{reg.NewRegOperand(rhs.Sized(size).String())},
 		Comment: fmt.Sprintf("%s = %s %s %s", expr.Name(), expr.X.Name(), expr.Op.String(), expr.Y.Name()),
+	}, ir.Instruction{
+		Op:  op.CSET,
+		Dst: dst,
+		Src: []reg.Operand{reg.NewLabelOperand(cond)},
 	})
 	return nil
 }
@@ -3,21 +3,43 @@ package mapper
 import (
 	"fmt"
 
+	"github.com/algoboyz/garm/pkg/ir"
 	"golang.org/x/tools/go/ssa"
 )
 
 // processBlock converts an SSA basic block to ARM64 IR
 func (m *SSAMapper) MapBlock(block *ssa.BasicBlock) (err error) {
 	// Add block label
-	// 	m.addBlockLabel(block)
-	for _, instr := range block.Instrs {
+	m.emit(ir.Instruction{
+		Labels:  []string{m.blockLabel(block)},
+		Comment: block.Comment,
+	})
+	if block == m.currentFunc.Recover {
+		m.recoverEntry()
+	}
+	for i, instr := range block.Instrs {
+		m.currentInstr = instr
+		if err = m.advance(instr); err != nil {
+			if err = m.diagnose(instr.Pos(), err); err != nil {
+				return fmt.Errorf("processing instruction %v: %w", instr, err)
+			}
+		}
+		if m.unsupported {
+			// Only diagnostics are left to collect, the mapper state may
+			// lack the values of instructions that failed
+			if err = m.diagnose(instr.Pos(), m.MapInstruction(instr)); err != nil {
+				return err
+			}
+			continue
+		}
+		if i == len(block.Instrs)-1 && backEdge(block) {
+			m.safepoint()
+		}
 		if err = m.MapInstruction(instr); err != nil {
-			return fmt.Errorf("processing instruction %v: %w", instr, err)
+			if err = m.diagnose(instr.Pos(), err); err != nil {
+				return fmt.Errorf("processing instruction %v: %w", instr, err)
+			}
 		}
 	}
-	// Handle block terminator
-	// if err = m.processTerminator(block.Instrs[len(block.Instrs)-1], irFunc); err != nil {
-	// 	return nil, fmt.Errorf("processing terminator: %w", err)
-	// }
 	return nil
 }
@@ -0,0 +1,62 @@
+package mapper
+
+import (
+	"fmt"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"golang.org/x/tools/go/ssa"
+)
+
+const runtimePanicWrap = "runtime.panicwrap" // x0,x1 type name, x2,x3 method name
+
+// mapBuiltin lowers calls to builtin functions
+func (m *SSAMapper) mapBuiltin(expr *ssa.Call, fn *ssa.Builtin) error {
+	switch fn.Name() {
+	case "ssa:wrapnilchk":
+		return m.mapWrapNilCheck(expr)
+	case "recover":
+		return m.mapRecover(expr)
+	case "close":
+		return m.mapClose(expr)
+	case "delete":
+		return m.mapDelete(expr)
+	case "len":
+		return m.mapLen(expr)
+	case "print", "println":
+		return m.mapPrint(expr, fn.Name() == "println")
+	default:
+		return unsupported(diag.Builtin, "unsupported builtin %s", fn.Name())
+	}
+}
+
+// mapWrapNilCheck guards the pointer receiver of a wrapper calling a value
+// method, Go panics rather than dereferencing nil
+func (m *SSAMapper) mapWrapNilCheck(expr *ssa.Call) error {
+	args := expr.Call.Args
+	ptr, err := m.load(args[0])
+	if err != nil {
+		return fmt.Errorf("loading receiver: %w", err)
+	}
+	defer m.release(args[0], ptr)
+	ok := m.localLabel("nonnil")
+	m.emit(ir.Instruction{
+		Op:      op.CBNZ,
+		Dst:     ptr,
+		Labels:  []string{ok},
+		Comment: "nil check " + args[0].Name(),
+	})
+	if err := m.passArgs(args[1:], &abi{}); err != nil {
+		return err
+	}
+	m.emit(ir.Instruction{Op: op.BL, Labels: []string{runtimePanicWrap}})
+	m.emit(ir.Instruction{Labels: []string{ok}})
+	dst, err := m.define(expr)
+	if err != nil {
+		return err
+	}
+	m.move(dst, ptr, alloc.WordSize, expr.Name()+" = "+args[0].Name())
+	return nil
+}
@@ -5,20 +5,132 @@ import (
 
 	"github.com/algoboyz/garm/pkg/ir"
 	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
 	"golang.org/x/tools/go/ssa"
 )
 
 func (m *SSAMapper) MapCall(expr *ssa.Call) error {
-	// m.debug.Println(fun)
+	if expr.Call.IsInvoke() {
+		return m.mapInvoke(expr)
+	}
+	if fn, ok := expr.Call.Value.(*ssa.Builtin); ok {
+		return m.mapBuiltin(expr, fn)
+	}
+	callee, ok := expr.Call.Value.(*ssa.Function)
+	if !ok {
+		return m.mapClosureCall(expr)
+	}
+	if kind, ok := atomicIntrinsic(callee); ok {
+		return m.mapAtomic(expr, kind)
+	}
+	target := ir.Symbol(m.funcLabel(callee))
+	if callee.Blocks == nil {
+		if callee.Name() == "init" {
+			return nil // initializer of a package garm does not compile
+		}
+		name := m.libraryName(callee)
+		if lower, ok := libraryIntrinsics[name]; ok {
+			m.substitute(name)
+			return lower(m, expr)
+		}
+		if routine, ok := libraryRoutines[name]; ok {
+			m.substitute(name)
+			target = routine
+		} else {
+			m.require(callee)
+		}
+	} else {
+		m.require(callee)
+	}
 	params := []string{}
 	for _, arg := range expr.Call.Args {
 		params = append(params, arg.Name())
 	}
+	saved := m.saveRegisters()
+	if err := m.passArgs(expr.Call.Args, &abi{}); err != nil {
+		return err
+	}
 	block := ir.Instruction{
 		Op:      op.BL,
-		Labels:  []string{expr.Name()},
-		Comment: fmt.Sprintf("Call %s with %s", expr.Name(), params),
+		Labels:  []string{target},
+		Comment: fmt.Sprintf("Call %s with %s", callee.Name(), params),
+	}
+	m.emitCall(block)
+	if err := m.callResult(expr); err != nil {
+		return err
+	}
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// mapInvoke calls a method of an interface value through the method table of
+// its itab, the data word is passed as the receiver
+func (m *SSAMapper) mapInvoke(expr *ssa.Call) error {
+	saved := m.saveRegisters()
+	if err := m.invokeTarget(&expr.Call); err != nil {
+		return err
+	}
+	if err := m.passArgs(expr.Call.Args, &abi{ints: 1}); err != nil {
+		return err
+	}
+	m.emitCall(ir.Instruction{
+		Op:      op.BLR,
+		Dst:     scratchCall,
+		Comment: fmt.Sprintf("invoke %s.%s", expr.Call.Value.Name(), expr.Call.Method.Name()),
+	})
+	if err := m.callResult(expr); err != nil {
+		return err
 	}
-	m.currentIR.Blocks = append(m.currentIR.Blocks, block)
+	m.restoreRegisters(saved)
 	return nil
 }
+
+// invokeTarget loads the method of an invoke mode call into x16 and the data
+// word of the interface into x0
+func (m *SSAMapper) invokeTarget(call *ssa.CallCommon) error {
+	index, err := m.methodIndex(call.Value.Type(), call.Method)
+	if err != nil {
+		return err
+	}
+	iface, err := m.load(call.Value)
+	if err != nil {
+		return fmt.Errorf("loading interface %s: %w", call.Value.Name(), err)
+	}
+	m.emit(ir.Instruction{
+		Op:      op.LDR,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewOffsetOperand(iface, ifaceTab)},
+		Comment: "itab of " + call.Value.Name(),
+	}, ir.Instruction{
+		Op:      op.LDR,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewOffsetOperand(scratchCall, itabFun+index*8)},
+		Comment: "method " + call.Method.Name(),
+	}, ir.Instruction{
+		Op:      op.LDR,
+		Dst:     &reg.Register{ID: 0, Class: reg.RegisterClassGPR},
+		Src:     []reg.Operand{reg.NewOffsetOperand(iface, ifaceData)},
+		Comment: "receiver",
+	})
+	m.release(call.Value, iface)
+	return nil
+}
+
+// passArgs moves call arguments into the AAPCS64 argument registers x0-x7 and d0-d7
+func (m *SSAMapper) passArgs(args []ssa.Value, regs *abi) error {
+	for _, arg := range args {
+		if err := m.moveOut(arg, regs, "argument "+arg.Name()); err != nil {
+			return fmt.Errorf("passing argument %s: %w", arg.Name(), err)
+		}
+	}
+	return nil
+}
+
+// callResult copies the results out of the result registers, multiple results
+// are kept as a tuple in a stack slot
+func (m *SSAMapper) callResult(expr *ssa.Call) error {
+	if expr.Call.Signature().Results().Len() == 0 {
+		return nil
+	}
+	return m.moveIn(expr, &abi{results: true}, expr.Name()+" = result")
+}
@@ -0,0 +1,256 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Runtime routines of channels, see pkg/asm/chan.asm. Values are passed by
+// address so the runtime copies elements of any size
+const (
+	runtimeMakeChan  = "runtime.makechan"  // x0 element type, x1 buffer size -> x0 channel
+	runtimeChanSend  = "runtime.chansend"  // x0 channel, x1 value
+	runtimeChanRecv  = "runtime.chanrecv"  // x0 channel, x1 destination -> x0 ok
+	runtimeCloseChan = "runtime.closechan" // x0 channel
+	runtimeSelectGo  = "runtime.selectgo"  // x0 cases, x1 count, x2 blocking -> x0 index, x1 recvOk
+)
+
+// Select case layout shared with the runtime
+const (
+	selectCaseChan = 0
+	selectCaseDir  = 8
+	selectCaseElem = 16
+	selectCaseSize = 24
+	caseSend       = 1
+	caseRecv       = 2
+)
+
+// MapMakeChan creates a channel, its buffer is allocated by the runtime
+func (m *SSAMapper) MapMakeChan(v *ssa.MakeChan) error {
+	if m.gc == GCGenerational {
+		// Parked goroutines and the runtime hold channels in registers and
+		// queues the copying collector does not update
+		return unsupported(diag.Instruction, "channels are not supported by the generational collector, build with -gc=marksweep")
+	}
+	m.runtimePanics()
+	size, err := m.load(v.Size)
+	if err != nil {
+		return fmt.Errorf("loading buffer size: %w", err)
+	}
+	defer m.release(v.Size, size)
+	dst, err := m.define(v)
+	if err != nil {
+		return err
+	}
+	elem := v.Type().Underlying().(*types.Chan).Elem()
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, size, alloc.WordSize, "buffer size")
+	m.loadAddress(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, m.typeDescriptor(elem))
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeMakeChan},
+		Comment: "make " + typeString(v.Type()),
+	})
+	m.move(dst, &reg.Register{ID: 0, Class: reg.RegisterClassGPR}, alloc.WordSize, v.Name()+" = channel")
+	m.restoreRegisters(saved, dst)
+	return nil
+}
+
+// MapSend sends a value on a channel, blocking until it is taken or buffered
+func (m *SSAMapper) MapSend(v *ssa.Send) error {
+	m.runtimePanics()
+	ch, err := m.load(v.Chan)
+	if err != nil {
+		return fmt.Errorf("loading channel %s: %w", v.Chan.Name(), err)
+	}
+	defer m.release(v.Chan, ch)
+	val, err := m.valueAddress(v.X)
+	if err != nil {
+		return err
+	}
+	defer m.release(v.X, val)
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, ch, alloc.WordSize, "channel "+v.Chan.Name())
+	m.move(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, val, alloc.WordSize, "address of "+v.X.Name())
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeChanSend},
+		Comment: fmt.Sprintf("%s <- %s", v.Chan.Name(), v.X.Name()),
+	})
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// mapRecv lowers <-ch, the runtime writes the value to the memory of the
+// result
+func (m *SSAMapper) mapRecv(expr *ssa.UnOp) error {
+	ch, err := m.load(expr.X)
+	if err != nil {
+		return fmt.Errorf("loading channel %s: %w", expr.X.Name(), err)
+	}
+	defer m.release(expr.X, ch)
+	comment := fmt.Sprintf("%s = <-%s", expr.Name(), expr.X.Name())
+
+	mem, dst, err := m.result(expr, expr.CommaOk, expr.Name()+" (received)")
+	if err != nil {
+		return err
+	}
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, ch, alloc.WordSize, "channel "+expr.X.Name())
+	m.slotAddress(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, mem)
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeChanRecv}, Comment: comment})
+	m.slotAddress(scratchAddr, mem)
+	switch {
+	case expr.CommaOk:
+		m.storeTo(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, types.Typ[types.Bool], scratchAddr, m.tupleOffsets(expr.Type().(*types.Tuple))[1], "ok")
+		m.restoreRegisters(saved)
+	case dst != nil:
+		m.loadFrom(dst, expr.Type(), scratchAddr, 0, comment)
+		m.restoreRegisters(saved, dst)
+	default:
+		m.restoreRegisters(saved)
+	}
+	return nil
+}
+
+// mapClose closes a channel
+func (m *SSAMapper) mapClose(expr *ssa.Call) error {
+	m.runtimePanics()
+	saved := m.saveRegisters()
+	if err := m.passArgs(expr.Call.Args, &abi{}); err != nil {
+		return err
+	}
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeCloseChan},
+		Comment: "close " + expr.Call.Args[0].Name(),
+	})
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// MapSelect lowers a select statement to runtime.selectgo. The cases are
+// described by an array on the stack and received values are written
+// straight into the result tuple (index, recvOk, r0, ..., rn)
+func (m *SSAMapper) MapSelect(v *ssa.Select) error {
+	m.runtimePanics()
+	offsets := m.tupleOffsets(v.Type().(*types.Tuple))
+	res, err := m.slot(v)
+	if err != nil {
+		return err
+	}
+	loc, err := m.alloc.AllocateStack(alloc.MemoryLocation{
+		Name:      v.Name() + " cases",
+		Size:      max(len(v.States), 1) * selectCaseSize,
+		Alignment: alloc.WordSize,
+	})
+	if err != nil {
+		return fmt.Errorf("allocating cases of %s: %w", v.Name(), err)
+	}
+	cases := loc.GetMemory()
+	base, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating address of %s: %w", v.Name(), err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(base))
+	// Only the values of the case run are written
+	m.slotAddress(base, res)
+	m.zeroMem(base, res.Size, "zero "+v.Name())
+
+	m.slotAddress(base, cases)
+	recv := 2
+	for i, st := range v.States {
+		entry := i * selectCaseSize
+		ch, err := m.load(st.Chan)
+		if err != nil {
+			return fmt.Errorf("loading channel %s: %w", st.Chan.Name(), err)
+		}
+		m.storeTo(ch, types.Typ[types.Uintptr], base, entry+selectCaseChan, fmt.Sprintf("case %d channel %s", i, st.Chan.Name()))
+		m.release(st.Chan, ch)
+		switch st.Dir {
+		case types.SendOnly:
+			m.movImm(scratchCall, caseSend)
+			m.storeTo(scratchCall, types.Typ[types.Uintptr], base, entry+selectCaseDir, "send")
+			val, err := m.valueAddress(st.Send)
+			if err != nil {
+				return err
+			}
+			m.storeTo(val, types.Typ[types.Uintptr], base, entry+selectCaseElem, "address of "+st.Send.Name())
+			m.release(st.Send, val)
+		default:
+			m.movImm(scratchCall, caseRecv)
+			m.storeTo(scratchCall, types.Typ[types.Uintptr], base, entry+selectCaseDir, "receive")
+			m.slotAddress(scratchCall, res)
+			m.addOffset(scratchCall, scratchCall, offsets[recv], fmt.Sprintf("address of %s #%d", v.Name(), recv))
+			m.storeTo(scratchCall, types.Typ[types.Uintptr], base, entry+selectCaseElem, "destination")
+			recv++
+		}
+	}
+
+	saved := m.saveRegisters()
+	m.slotAddress(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, cases)
+	m.movImm(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, uint64(len(v.States)))
+	blocking := uint64(0)
+	if v.Blocking {
+		blocking = 1
+	}
+	m.movImm(&reg.Register{ID: 2, Class: reg.RegisterClassGPR}, blocking)
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeSelectGo}, Comment: "select " + v.Name()})
+	m.slotAddress(scratchAddr, res)
+	m.storeTo(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, types.Typ[types.Int], scratchAddr, offsets[0], "index")
+	m.storeTo(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, types.Typ[types.Bool], scratchAddr, offsets[1], "recvOk")
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// valueAddress returns a register holding the address of v in memory,
+// aggregates load as their address and scalars are stored to a temporary
+// slot. The register is released with m.release(v, r)
+func (m *SSAMapper) valueAddress(v ssa.Value) (*reg.Register, error) {
+	src, err := m.load(v)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", v.Name(), err)
+	}
+	if m.isAggregate(v.Type()) {
+		return src, nil
+	}
+	defer m.release(v, src)
+	mem, err := m.temporary(v.Type(), v.Name()+" (sent)")
+	if err != nil {
+		return nil, err
+	}
+	addr, err := m.scratch()
+	if err != nil {
+		return nil, fmt.Errorf("allocating address of %s: %w", v.Name(), err)
+	}
+	m.slotAddress(addr, mem)
+	m.storeTo(src, v.Type(), addr, 0, "spill "+v.Name())
+	return addr, nil
+}
+
+// temporary reserves a stack slot for a scalar of type t the runtime reads
+// or writes through its address
+func (m *SSAMapper) temporary(t types.Type, name string) (*alloc.MemoryLocation, error) {
+	loc, err := m.alloc.AllocateStack(alloc.MemoryLocation{
+		Name:      name,
+		Size:      alloc.AlignSize(m.sizeof(t), alloc.WordSize),
+		Alignment: alloc.WordSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("allocating %s: %w", name, err)
+	}
+	return loc.GetMemory(), nil
+}
+
+// runtimePanics links the descriptor of string, the channel and map routines
+// panic with string values
+func (m *SSAMapper) runtimePanics() {
+	m.typeDescriptor(types.Typ[types.String])
+}
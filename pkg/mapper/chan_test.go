@@ -0,0 +1,52 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapChannels(t *testing.T) {
+	fns, m := compile(t, `package main
+
+type pair struct{ a, b int }
+
+func main() {
+	in := make(chan int)
+	out := make(chan pair, 4)
+	close(in)
+	v, ok := <-in
+	out <- pair{v, 2}
+	p := <-out
+	select {
+	case out <- p:
+	case <-in:
+	default:
+	}
+	_ = ok
+}
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	main := fns["main.main"]
+	for _, routine := range []string{"makechan", "closechan", "chanrecv", "chansend", "selectgo"} {
+		assert.Contains(t, main, "BL runtime."+routine)
+	}
+	assert.Contains(t, main, "MOVZ x1, #0x2\n\tMOVZ x2, #0x0\n\tBL runtime.selectgo", "two cases with a default")
+	assert.Contains(t, main, "CSET", "cases are dispatched on the index")
+	assert.Contains(t, fns["main"], "BL runtime.schedinit", "channels park on the scheduler")
+
+	_, ok := m.data.Lookup("type.string")
+	assert.True(t, ok, "runtime panics carry strings")
+
+	fns, m = compile(t, `package main
+
+func main() {
+	c := make(chan int, 1)
+	c <- 1
+	println(<-c)
+}
+`, func(m *SSAMapper) { m.SetGC(GCGenerational) })
+	assert.Contains(t, fns["main.main"], "BL runtime.panicunsupported", "the copying collector does not update channels")
+	require.NotEmpty(t, m.Diagnostics().All())
+	assert.Contains(t, m.Diagnostics().All()[0].String(), "channels are not supported by the generational collector")
+}
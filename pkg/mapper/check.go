@@ -0,0 +1,168 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+	"io"
+	"strings"
+
+	"github.com/algoboyz/garm/pkg/diag"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Support is what a dry run of the mapper makes of a function
+type Support struct {
+	Function     string            `json:"function"`
+	Pos          string            `json:"pos,omitempty"`
+	Supported    bool              `json:"supported"`
+	Instructions map[string]*Count `json:"instructions"` // by SSA instruction eg Call or MakeMap
+	Types        map[string]bool   `json:"types"`        // types of the parameters and values, true when supported
+	Diagnostics  []string          `json:"diagnostics,omitempty"`
+}
+
+// Count tallies the instructions of a kind that compiled and that did not
+type Count struct {
+	Supported   int `json:"supported"`
+	Unsupported int `json:"unsupported"`
+}
+
+// Check dry runs the mapper over every function of the loaded packages,
+// including methods and nested functions, and reports what it supports of
+// each one. Nothing is emitted, a function that fails for any reason is
+// reported unsupported rather than ending the run
+func (m *SSAMapper) Check() ([]Support, error) {
+	order := m.initOrder()
+	for _, pkg := range order {
+		if err := m.MapGlobals(pkg); err != nil {
+			return nil, fmt.Errorf("mapping globals of %s: %w", pkg.Pkg.Path(), err)
+		}
+	}
+	m.scheduler = m.schedules(order)
+	var report []Support
+	for _, fn := range m.loadedFunctions() {
+		m.compiled[fn] = true
+		report = append(report, m.checkFunction(fn))
+	}
+	return report, nil
+}
+
+// WriteSSA writes the SSA form of the functions of the loaded packages, or
+// only of those whose name or label is name
+func (m *SSAMapper) WriteSSA(w io.Writer, name string) error {
+	found := false
+	for _, fn := range m.loadedFunctions() {
+		if name != "" && fn.Name() != name && m.funcLabel(fn) != name {
+			continue
+		}
+		found = true
+		if _, err := fn.WriteTo(w); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	if !found && name != "" {
+		return fmt.Errorf("no function %s", name)
+	}
+	return nil
+}
+
+// loadedFunctions returns the functions of the loaded packages with a body,
+// including methods and nested functions
+func (m *SSAMapper) loadedFunctions() (fns []*ssa.Function) {
+	seen := make(map[*ssa.Function]bool)
+	var add func(fn *ssa.Function)
+	add = func(fn *ssa.Function) {
+		if seen[fn] || fn.Blocks == nil || generic(fn) {
+			return
+		}
+		seen[fn] = true
+		fns = append(fns, fn)
+		for _, anon := range fn.AnonFuncs {
+			add(anon)
+		}
+	}
+	for _, pkg := range m.pkgs {
+		for _, fn := range m.packageFunctions(pkg) {
+			add(fn)
+		}
+	}
+	return fns
+}
+
+// checkFunction maps fn for the diagnostics, the instructions they were
+// raised by and the errors that stop the mapping
+func (m *SSAMapper) checkFunction(fn *ssa.Function) (s Support) {
+	s = Support{
+		Function:     m.funcLabel(fn),
+		Instructions: make(map[string]*Count),
+		Types:        make(map[string]bool),
+	}
+	if pos := fn.Pos(); pos.IsValid() {
+		s.Pos = m.prog.Fset.Position(pos).String()
+	}
+	before := m.diags.Len()
+	m.failed = make(map[ssa.Instruction]bool)
+	defer func() { m.failed = nil }()
+	err := m.checkMap(fn)
+	for _, d := range m.diags.Since(before) {
+		s.Diagnostics = append(s.Diagnostics, d.String())
+	}
+	if err != nil {
+		s.Diagnostics = append(s.Diagnostics, fmt.Sprintf("%s: %s", diag.Error, err))
+	}
+	s.Supported = err == nil && !m.unsupported
+
+	for _, param := range fn.Params {
+		s.Types[param.Type().String()] = m.supportedType(param.Type())
+	}
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instrs {
+			kind := strings.TrimPrefix(fmt.Sprintf("%T", instr), "*ssa.")
+			if s.Instructions[kind] == nil {
+				s.Instructions[kind] = &Count{}
+			}
+			if m.failed[instr] {
+				s.Instructions[kind].Unsupported++
+			} else {
+				s.Instructions[kind].Supported++
+			}
+			if v, ok := instr.(ssa.Value); ok && !isVoid(v.Type()) {
+				s.Types[v.Type().String()] = m.supportedType(v.Type())
+			}
+		}
+	}
+	return s
+}
+
+// checkMap maps fn, an error the mapper cannot diagnose is charged to the
+// instruction being mapped
+func (m *SSAMapper) checkMap(fn *ssa.Function) error {
+	m.currentInstr = nil
+	_, err := m.MapFunction(fn)
+	if err != nil && m.currentInstr != nil {
+		m.failed[m.currentInstr] = true
+	}
+	return err
+}
+
+// supportedType reports whether values of typ have a machine representation
+func (m *SSAMapper) supportedType(typ types.Type) bool {
+	if t, isTuple := typ.(*types.Tuple); isTuple {
+		for i := range t.Len() {
+			if !m.supportedType(t.At(i).Type()) {
+				return false
+			}
+		}
+		return true
+	}
+	_, err := m.MapType("", typ)
+	return err == nil
+}
+
+// isVoid reports whether typ is the empty result of a call
+func isVoid(typ types.Type) bool {
+	t, ok := typ.(*types.Tuple)
+	return ok && t.Len() == 0
+}
@@ -0,0 +1,56 @@
+package mapper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/algoboyz/garm/pkg/dbg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.go")
+	require.NoError(t, os.WriteFile(path, []byte(`package main
+
+func mk(n int) []int { return make([]int, n) }
+
+func first[T any](s []T) T { return s[0] }
+
+func ok(x int) int { return x + 1 }
+
+func main() { println(ok(1)) }
+`), 0o644))
+	m := NewSSAMapper(dbg.NewDebugger(false))
+	m.SetGC(GCNone)
+	require.NoError(t, m.Load(path))
+	report, err := m.Check()
+	require.NoError(t, err)
+
+	byName := make(map[string]Support, len(report))
+	for _, s := range report {
+		byName[s.Function] = s
+	}
+	assert.ElementsMatch(t, []string{"main.init", "main.mk", "main.ok", "main.main"}, keys(byName),
+		"generic functions are left to their instantiations")
+
+	mk := byName["main.mk"]
+	assert.False(t, mk.Supported)
+	assert.Equal(t, &Count{Unsupported: 1}, mk.Instructions["MakeSlice"])
+	assert.Equal(t, &Count{Supported: 1}, mk.Instructions["Return"])
+	assert.True(t, mk.Types["[]int"])
+	require.Len(t, mk.Diagnostics, 1)
+	assert.Contains(t, mk.Diagnostics[0], "main.go:3:")
+
+	assert.True(t, byName["main.ok"].Supported)
+	assert.Empty(t, byName["main.ok"].Diagnostics)
+}
+
+func keys[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,169 @@
+package mapper
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Function values point at a closure object whose first word is the code
+// pointer, the captured free variables follow it. Calls through a function
+// value pass the closure in the context register, functions with free
+// variables copy them out of it on entry. x26 is reserved from allocation
+// and not preserved for callers outside of generated code
+//
+//	[x26]       code pointer
+//	[x26, #8]   first free variable
+var closureContext = &reg.Register{ID: 26, Class: reg.RegisterClassGPR}
+
+// closureCode is the offset of the code pointer in a closure object
+const closureCode = 0
+
+// closureType describes the closure object of fn, free variables are named
+// by position as those of range over func bodies have no names
+func closureType(fn *ssa.Function) *types.Struct {
+	fields := []*types.Var{types.NewField(token.NoPos, nil, "F", types.Typ[types.Uintptr], false)}
+	for i, fv := range fn.FreeVars {
+		fields = append(fields, types.NewField(token.NoPos, nil, fmt.Sprintf("v%d", i), fv.Type(), false))
+	}
+	return types.NewStruct(fields, nil)
+}
+
+// MapMakeClosure allocates the closure object of an anonymous function or
+// bound method on the heap and fills in the captured values
+func (m *SSAMapper) MapMakeClosure(v *ssa.MakeClosure) error {
+	fn := v.Fn.(*ssa.Function)
+	m.require(fn)
+	st := closureType(fn)
+	dst, err := m.define(v)
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf("%s = make closure %s", v.Name(), fn.Name())
+	m.newObject(dst, st)
+	m.loadAddress(scratchCall, m.funcLabel(fn))
+	m.storeTo(scratchCall, types.Typ[types.Uintptr], dst, closureCode, comment)
+	for i, b := range v.Bindings {
+		x, err := m.load(b)
+		if err != nil {
+			return fmt.Errorf("loading binding %s: %w", b.Name(), err)
+		}
+		offset := m.fieldOffset(st, i+1)
+		if m.isAggregate(b.Type()) {
+			m.addOffset(scratchCall, dst, offset, comment)
+			if err := m.copyMem(scratchCall, x, m.sizeof(b.Type()), "capture "+b.Name()); err != nil {
+				return err
+			}
+		} else {
+			m.storeTo(x, b.Type(), dst, offset, "capture "+b.Name())
+		}
+		m.release(b, x)
+	}
+	return nil
+}
+
+// processFreeVars copies the free variables of the current function out of
+// the closure object passed in the context register
+func (m *SSAMapper) processFreeVars(fvs []*ssa.FreeVar) error {
+	if len(fvs) == 0 {
+		return nil
+	}
+	st := closureType(m.currentFunc)
+	for i, fv := range fvs {
+		offset := m.fieldOffset(st, i+1)
+		comment := "free variable " + fv.Name()
+		if !m.isAggregate(fv.Type()) {
+			dst, err := m.define(fv)
+			if err != nil {
+				return err
+			}
+			m.loadFrom(dst, fv.Type(), closureContext, offset, comment)
+			continue
+		}
+		mem, err := m.slot(fv)
+		if err != nil {
+			return err
+		}
+		dst, err := m.scratch()
+		if err != nil {
+			return fmt.Errorf("allocating address of %s: %w", fv.Name(), err)
+		}
+		m.slotAddress(dst, mem)
+		m.addOffset(scratchCall, closureContext, offset, comment)
+		err = m.copyMem(dst, scratchCall, m.sizeof(fv.Type()), comment)
+		m.alloc.Free(alloc.NewRegisterLocation(dst))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// funcValue returns the label of the static closure object of a function
+// without free variables, used when the function itself is a value
+func (m *SSAMapper) funcValue(fn *ssa.Function) string {
+	m.require(fn)
+	label := m.funcLabel(fn) + "·f"
+	if _, ok := m.data.Lookup(label); ok {
+		return label
+	}
+	g := &ir.Global{
+		Label:   label,
+		Section: ir.SectionRodata,
+		Size:    alloc.WordSize,
+		Align:   alloc.WordSize,
+		Comment: "func value " + fn.Name(),
+	}
+	g.Set(closureCode, alloc.WordSize, ir.Symbol(m.funcLabel(fn)))
+	m.data.Add(g)
+	return label
+}
+
+// mapClosureCall calls a function value, the closure is passed in the context
+// register and the call goes through its code pointer
+func (m *SSAMapper) mapClosureCall(expr *ssa.Call) error {
+	saved := m.saveRegisters()
+	fv, err := m.load(expr.Call.Value)
+	if err != nil {
+		return fmt.Errorf("loading function %s: %w", expr.Call.Value.Name(), err)
+	}
+	m.move(closureContext, fv, alloc.WordSize, "closure "+expr.Call.Value.Name())
+	m.release(expr.Call.Value, fv)
+	m.emit(ir.Instruction{
+		Op:      op.LDR,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewOffsetOperand(closureContext, closureCode)},
+		Comment: "code pointer of " + expr.Call.Value.Name(),
+	})
+	if err := m.passArgs(expr.Call.Args, &abi{}); err != nil {
+		return err
+	}
+	m.emitCall(ir.Instruction{
+		Op:      op.BLR,
+		Dst:     scratchCall,
+		Comment: "call " + expr.Call.Value.Name(),
+	})
+	if err := m.callResult(expr); err != nil {
+		return err
+	}
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// require queues a function the SSA builder synthesized or nested in another
+// one for compilation, members of the loaded packages are compiled anyway
+func (m *SSAMapper) require(fn *ssa.Function) {
+	if fn.Parent() == nil && fn.Synthetic == "" && !m.onDemand(fn.Pkg) {
+		return
+	}
+	if m.compiled[fn] {
+		return
+	}
+	m.pending = append(m.pending, fn)
+}
@@ -0,0 +1,69 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapClosures(t *testing.T) {
+	fns, m := compile(t, `package main
+
+type counter int
+
+func (c counter) get() int { return int(c) }
+
+func twice(x int) int { return 2 * x }
+
+func apply(f func(int) int, x int) int { return f(x) }
+
+func bind(c counter) int {
+	get := c.get
+	return get()
+}
+
+func main() {
+	inc := func(x int) int { return x + 1 }
+	apply(inc, 1)
+	apply(twice, 2)
+}
+`)
+	for _, label := range []string{"main.main.func1", "main.counter.get-fm"} {
+		assert.Contains(t, fns, label)
+	}
+	assert.Regexp(t, `MOV x26, x\d+\n`, fns["main.apply"], "closure in the context register")
+	assert.Regexp(t, `LDR (x\d+), \[x26\]\n(\t.*\n)*?\tBLR x\d+\n`, fns["main.apply"], "call through the code pointer")
+	assert.Contains(t, fns["main.bind"], "BL runtime.alloc", "closure object on the heap")
+	assert.Regexp(t, `LDR x\d+, \[x26, #8\]`, fns["main.counter.get-fm"], "free variable from the context")
+
+	fv, ok := m.Data().Lookup("main.twice·f")
+	require.True(t, ok)
+	assert.Contains(t, fv.String(false), ".quad main.twice")
+}
+
+func TestMapClosureUnnamedFreeVars(t *testing.T) {
+	_, m := compile(t, `package main
+
+func seq(yield func(int) bool) {
+	for i := range 3 {
+		if !yield(i) {
+			return
+		}
+	}
+}
+
+func find(n int) (int, bool) {
+	for i := range seq {
+		if i == n {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func main() { println(find(1)) }
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	assert.NotContains(t, m.Report().Unsupported, "main.find$1",
+		"the unnamed free variables of range over func bodies get their own fields")
+}
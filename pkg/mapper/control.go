@@ -0,0 +1,94 @@
+package mapper
+
+import (
+	"fmt"
+
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"golang.org/x/tools/go/ssa"
+)
+
+// MapJump assigns the φ-nodes of the single successor and branches to it
+// unless it is laid out next
+func (m *SSAMapper) MapJump(v *ssa.Jump) error {
+	succ := v.Block().Succs[0]
+	if err := m.phiMoves(v.Block(), succ); err != nil {
+		return err
+	}
+	if succ.Index == v.Block().Index+1 {
+		return nil // fall through
+	}
+	m.emit(ir.Instruction{
+		Op:      op.B,
+		Labels:  []string{m.blockLabel(succ)},
+		Comment: fmt.Sprintf("jump %d", succ.Index),
+	})
+	return nil
+}
+
+// MapIf branches to the first successor when the condition is true. A
+// successor with φ-nodes is reached through the code assigning them, the
+// edge of the true branch follows the false one
+func (m *SSAMapper) MapIf(v *ssa.If) error {
+	cond, err := m.load(v.Cond)
+	if err != nil {
+		return fmt.Errorf("loading condition: %w", err)
+	}
+	defer m.release(v.Cond, cond)
+	then, els := v.Block().Succs[0], v.Block().Succs[1]
+	target := m.blockLabel(then)
+	edge := len(phis(then)) > 0
+	if edge {
+		target = m.localLabel("edge")
+	}
+	m.emit(ir.Instruction{
+		Op:      op.CBNZ,
+		Dst:     cond,
+		Labels:  []string{target},
+		Comment: fmt.Sprintf("if %s goto %d", v.Cond.Name(), then.Index),
+	})
+	if err := m.phiMoves(v.Block(), els); err != nil {
+		return err
+	}
+	if edge || els.Index != v.Block().Index+1 {
+		m.emit(ir.Instruction{
+			Op:      op.B,
+			Labels:  []string{m.blockLabel(els)},
+			Comment: fmt.Sprintf("else goto %d", els.Index),
+		})
+	}
+	if !edge {
+		return nil
+	}
+	m.emit(ir.Instruction{Labels: []string{target}})
+	if err := m.phiMoves(v.Block(), then); err != nil {
+		return err
+	}
+	if then.Index != v.Block().Index+1 {
+		m.emit(ir.Instruction{
+			Op:      op.B,
+			Labels:  []string{m.blockLabel(then)},
+			Comment: fmt.Sprintf("goto %d", then.Index),
+		})
+	}
+	return nil
+}
+
+// MapReturn moves results into the AAPCS64 result registers and branches to
+// the shared epilogue of the function
+func (m *SSAMapper) MapReturn(v *ssa.Return) error {
+	regs := abi{results: true}
+	for _, result := range v.Results {
+		if err := m.moveOut(result, &regs, "return "+result.Name()); err != nil {
+			return fmt.Errorf("returning %s: %w", result.Name(), err)
+		}
+	}
+	if v.Block().Index == len(m.currentFunc.Blocks)-1 {
+		return nil // the epilogue follows the last block
+	}
+	m.emit(ir.Instruction{
+		Op:     op.B,
+		Labels: []string{m.returnLabel()},
+	})
+	return nil
+}
@@ -0,0 +1,141 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+const runtimeStringToBytes = "runtime.stringtobytes" // x0,x1 string -> x0-x2 slice
+
+// MapConvert lowers numeric and pointer conversions. Integers are kept sign or
+// zero extended to 64 bits, so narrowing re-extends from the target width
+func (m *SSAMapper) MapConvert(v *ssa.Convert) error {
+	from, to := v.X.Type(), v.Type()
+	if isString(from) && isBytes(to) {
+		return m.stringToBytes(v)
+	}
+	if m.isAggregate(from) || m.isAggregate(to) {
+		return unsupported(diag.Conversion, "unsupported conversion %s -> %s", from, to)
+	}
+	x, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.X.Name(), err)
+	}
+	defer m.release(v.X, x)
+	dst, err := m.define(v)
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf("%s = convert %s <- %s (%s)", v.Name(), typeString(to), typeString(from), v.X.Name())
+	fromSize, toSize := m.sizeof(from), m.sizeof(to)
+
+	switch {
+	case isFloat(from) && isFloat(to) && fromSize == toSize:
+		m.move(dst, x, toSize, comment)
+	case isFloat(from) && isFloat(to):
+		m.convert(op.FCVT, dst.Sized(toSize), x.Sized(fromSize), comment)
+	case isFloat(to):
+		cvt := op.SCVTF
+		if !isSigned(from) {
+			cvt = op.UCVTF
+		}
+		m.convert(cvt, dst.Sized(toSize), x, comment)
+	case isFloat(from):
+		cvt := op.FCVTZS
+		if !isSigned(to) {
+			cvt = op.FCVTZU
+		}
+		m.convert(cvt, dst, x.Sized(fromSize), comment)
+		m.extend(dst, dst, to, comment)
+	case toSize < alloc.WordSize:
+		m.extend(dst, x, to, comment)
+	default:
+		m.move(dst, x, alloc.WordSize, comment)
+	}
+	return nil
+}
+
+// stringToBytes copies a string into a new byte slice, the slice is stored
+// from the registers since three words are returned in memory otherwise
+func (m *SSAMapper) stringToBytes(v *ssa.Convert) error {
+	m.typeDescriptor(types.Typ[types.Uint8])
+	res, err := m.slot(v)
+	if err != nil {
+		return err
+	}
+	saved := m.saveRegisters()
+	if err := m.passArgs([]ssa.Value{v.X}, &abi{}); err != nil {
+		return err
+	}
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeStringToBytes},
+		Comment: fmt.Sprintf("%s = []byte(%s)", v.Name(), v.X.Name()),
+	})
+	m.slotAddress(scratchAddr, res)
+	for i, field := range []int{sliceData, sliceLen, sliceCap} {
+		m.storeTo(&reg.Register{ID: uint8(i), Class: reg.RegisterClassGPR}, types.Typ[types.Uintptr], scratchAddr, field, "")
+	}
+	m.restoreRegisters(saved)
+	return nil
+}
+
+func (m *SSAMapper) convert(cvt op.Op, dst, src *reg.Register, comment string) {
+	m.emit(ir.Instruction{
+		Op:      cvt,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewRegOperand(src.String())},
+		Comment: comment,
+	})
+}
+
+// extend sign or zero extends the low bytes of src holding a value of type to
+func (m *SSAMapper) extend(dst, src *reg.Register, to types.Type, comment string) {
+	size := m.sizeof(to)
+	if size >= alloc.WordSize {
+		return
+	}
+	signed := isSigned(to)
+	switch {
+	case signed && size == 1:
+		m.convert(op.SXTB, dst, src.Sized(4), comment)
+	case signed && size == 2:
+		m.convert(op.SXTH, dst, src.Sized(4), comment)
+	case signed:
+		m.convert(op.SXTW, dst, src.Sized(4), comment)
+	case size == 1:
+		m.convert(op.UXTB, dst.Sized(4), src.Sized(4), comment)
+	case size == 2:
+		m.convert(op.UXTH, dst.Sized(4), src.Sized(4), comment)
+	default:
+		// Writing a w register clears the upper half
+		m.convert(op.MOV, dst.Sized(4), src.Sized(4), comment)
+	}
+}
+
+// MapChangeType converts between types with identical underlying types, the
+// representation is unchanged
+func (m *SSAMapper) MapChangeType(v *ssa.ChangeType) error {
+	comment := fmt.Sprintf("%s = changetype %s <- %s", v.Name(), typeString(v.Type()), v.X.Name())
+	if m.isAggregate(v.Type()) {
+		return m.loadMember(v, v.X, 0, comment)
+	}
+	x, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.X.Name(), err)
+	}
+	defer m.release(v.X, x)
+	dst, err := m.define(v)
+	if err != nil {
+		return err
+	}
+	m.move(dst, x, m.sizeof(v.Type()), comment)
+	return nil
+}
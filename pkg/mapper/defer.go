@@ -0,0 +1,211 @@
+package mapper
+
+import (
+	"fmt"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/asm"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Defer record layout, kept in sync with pkg/asm/defer.asm. Defer records
+// are pushed by its DEFER macro and linked from the frame of the deferring
+// function, goroutine records share the layout
+const (
+	frameDefer   = -8  // head of the defer list, [x29, #-8]
+	deferFn      = 0   // code pointer
+	deferCtx     = 16  // closure context passed in x26
+	deferResume  = 24  // recovery point of the deferring function
+	deferArgs    = 32  // x0-x7
+	deferFArgs   = 96  // d0-d7
+	deferRecSize = 160 // multiple of 16 so sp stays aligned
+)
+
+// Runtime routines backing defer, panic and recover, see pkg/asm/defer.asm
+const (
+	runtimeRunDefers = "runtime.rundefers" // runs the defers of the frame in x29
+	runtimeGoPanic   = "runtime.gopanic"   // x0, x1 panic value, does not return
+	runtimeGoRecover = "runtime.gorecover" // returns the panic value in x0, x1
+)
+
+// clearDefers marks the frame as having no deferred calls, panics walk the
+// frame chain and look at every frame
+func (m *SSAMapper) clearDefers() {
+	m.emit(ir.Instruction{
+		Op:      op.STR,
+		Dst:     reg.XZR,
+		Src:     []reg.Operand{reg.NewOffsetOperand(reg.FP, frameDefer)},
+		Comment: "No deferred calls",
+	})
+}
+
+// MapDefer pushes a defer record holding the callee and its arguments, they
+// are evaluated at the defer statement like Go does. The record is pushed
+// and linked by the DEFER macro of the runtime
+func (m *SSAMapper) MapDefer(v *ssa.Defer) error {
+	saved := m.saveRegisters()
+	ctx, _, err := m.loadCall(&v.Call, "deferwrap")
+	if err != nil {
+		return fmt.Errorf("deferred call: %w", err)
+	}
+	resume := reg.XZR
+	if m.currentFunc.Recover != nil {
+		m.loadAddress(scratchAddr, m.blockLabel(m.currentFunc.Recover))
+		resume = scratchAddr
+	}
+	m.emit(ir.Instruction{
+		Macro: &asm.DEFER,
+		Src: []reg.Operand{
+			reg.NewRegOperand(scratchCall.String()),
+			reg.NewRegOperand(ctx.String()),
+			reg.NewRegOperand(resume.String()),
+		},
+		Comment: "push defer record",
+	})
+	// Nothing was called, the arguments are dead once they are in the record
+	m.saved = nil
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// loadCall loads the callee of call into scratchCall, its closure context
+// and its arguments into the argument registers. The context register is
+// xzr for anything but closures. Builtins are called through a wrapper
+// named after wrap
+func (m *SSAMapper) loadCall(call *ssa.CallCommon, wrap string) (*reg.Register, *abi, error) {
+	ctx, args := reg.XZR, &abi{}
+	switch callee := call.Value.(type) {
+	case *ssa.Builtin:
+		label, err := m.wrapBuiltin(callee, call.Args, wrap)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.loadAddress(scratchCall, label)
+	case *ssa.Function:
+		m.require(callee)
+		m.loadAddress(scratchCall, m.funcLabel(callee))
+	default:
+		if call.IsInvoke() {
+			if err := m.invokeTarget(call); err != nil {
+				return nil, nil, err
+			}
+			args.ints = 1
+			break
+		}
+		fv, err := m.load(call.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading function %s: %w", call.Value.Name(), err)
+		}
+		m.move(closureContext, fv, alloc.WordSize, "closure "+call.Value.Name())
+		m.release(call.Value, fv)
+		m.emit(ir.Instruction{
+			Op:      op.LDR,
+			Dst:     scratchCall,
+			Src:     []reg.Operand{reg.NewOffsetOperand(closureContext, closureCode)},
+			Comment: "code pointer of " + call.Value.Name(),
+		})
+		ctx = closureContext
+	}
+	if err := m.passArgs(call.Args, args); err != nil {
+		return nil, nil, err
+	}
+	return ctx, args, nil
+}
+
+// pushRecord pushes a record holding the callee, closure context and
+// arguments of call on the stack in the layout of defer records, the word
+// at deferResume is left to the caller
+func (m *SSAMapper) pushRecord(call *ssa.CallCommon, wrap, comment string) error {
+	ctx, args, err := m.loadCall(call, wrap)
+	if err != nil {
+		return err
+	}
+	m.emit(ir.Instruction{
+		Op:      op.SUB,
+		Dst:     reg.SP,
+		Src:     []reg.Operand{reg.NewRegOperand(reg.SP.String()), reg.NewImmediateOperand(fmt.Sprint(deferRecSize))},
+		Comment: comment,
+	}, ir.Instruction{
+		Op:      op.STR,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewOffsetOperand(reg.SP, deferFn)},
+		Comment: "callee " + call.Value.Name(),
+	}, ir.Instruction{
+		Op:  op.STR,
+		Dst: ctx,
+		Src: []reg.Operand{reg.NewOffsetOperand(reg.SP, deferCtx)},
+	})
+	for i := range args.ints {
+		m.emit(ir.Instruction{
+			Op:  op.STR,
+			Dst: &reg.Register{ID: i, Class: reg.RegisterClassGPR},
+			Src: []reg.Operand{reg.NewOffsetOperand(reg.SP, deferArgs+int(i)*alloc.WordSize)},
+		})
+	}
+	for i := range args.floats {
+		m.emit(ir.Instruction{
+			Op:  op.STR,
+			Dst: &reg.Register{ID: i, Class: reg.RegisterClassFPR},
+			Src: []reg.Operand{reg.NewOffsetOperand(reg.SP, deferFArgs+int(i)*alloc.WordSize)},
+		})
+	}
+	return nil
+}
+
+// MapRunDefers runs the deferred calls of the function before it returns
+func (m *SSAMapper) MapRunDefers(v *ssa.RunDefers) error {
+	saved := m.saveRegisters()
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeRunDefers}, Comment: "run deferred calls"})
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// MapPanic starts panicking with an interface value. Registers are saved so
+// the recovery point can reload them
+func (m *SSAMapper) MapPanic(v *ssa.Panic) error {
+	m.saveRegisters()
+	x, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.X.Name(), err)
+	}
+	m.move(scratchCall, x, alloc.WordSize, "panic value")
+	m.release(v.X, x)
+	m.emit(ir.Instruction{
+		Op:  op.LDR,
+		Dst: &reg.Register{ID: 0, Class: reg.RegisterClassGPR},
+		Src: []reg.Operand{reg.NewOffsetOperand(scratchCall, ifaceTab)},
+	}, ir.Instruction{
+		Op:  op.LDR,
+		Dst: &reg.Register{ID: 1, Class: reg.RegisterClassGPR},
+		Src: []reg.Operand{reg.NewOffsetOperand(scratchCall, ifaceData)},
+	})
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeGoPanic},
+		Comment: "panic " + v.X.Name(),
+	})
+	return nil
+}
+
+// mapRecover returns the current panic value and stops panicking
+func (m *SSAMapper) mapRecover(expr *ssa.Call) error {
+	saved := m.saveRegisters()
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeGoRecover}, Comment: expr.Name() + " = recover()"})
+	if err := m.callResult(expr); err != nil {
+		return err
+	}
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// recoverEntry reloads the registers at the recovery point of the function,
+// they were spilled to the save area by the call that panicked
+func (m *SSAMapper) recoverEntry() {
+	if m.saveArea == nil {
+		return
+	}
+	m.restoreRegisters(m.alloc.Allocated())
+}
@@ -0,0 +1,53 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapDefer(t *testing.T) {
+	fns, _ := compile(t, `package main
+
+type shape interface{ area() int }
+
+func cleanup(x int) {}
+
+func safe() {
+	defer func() {
+		recover()
+	}()
+	panic("boom")
+}
+
+func work(s shape) {
+	defer cleanup(42)
+	defer s.area()
+}
+
+func trace(i int) {
+	defer println(i)
+}
+
+func main() {}
+`)
+	work := fns["main.work"]
+	assert.Contains(t, work, "STR xzr, [fp, #-8]", "frame starts without deferred calls")
+	assert.Contains(t, work, "SUB sp, sp, #defer_size", "DEFER macro expanded in place")
+	assert.Contains(t, work, "STP x0, x1, [sp, #defer_args]", "arguments evaluated at the defer statement")
+	assert.Contains(t, work, "MOV x16, sp\n\tSTR x16, [x29, #frame_defer]", "record linked from the frame")
+	assert.NotContains(t, work, "DEFER")
+	assert.Contains(t, work, "BL runtime.rundefers", "deferred calls run before returning")
+
+	safe := fns["main.safe"]
+	assert.Regexp(t, `ADRP x17, \.Lmain\.safe\.1\n`, safe, "recovery point in the record")
+	assert.Contains(t, safe, "STR x17, [sp, #defer_resume]")
+	assert.Contains(t, safe, "BL runtime.gopanic")
+	assert.Contains(t, fns["main.safe.func1"], "BL runtime.gorecover")
+	assert.Contains(t, fns["main"], "MOV x29, xzr", "frame chain ends at the entry")
+
+	// Builtins are deferred through a wrapper taking their arguments
+	assert.Contains(t, fns["main.trace"], "main.trace.deferwrap1")
+	assert.Contains(t, fns["main.trace.deferwrap1"], "BL runtime.printint")
+	assert.Contains(t, fns["main.trace.deferwrap1"], "BL runtime.printnl")
+}
@@ -0,0 +1,114 @@
+package mapper
+
+import (
+	"errors"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Packages of the standard library garm compiles from source when
+// dependencies are compiled, the others are substituted or unsupported
+var stdlibPackages = map[string]bool{
+	"container/list": true,
+	"container/ring": true,
+	"unicode/utf16":  true,
+}
+
+// SetDependencies compiles the packages the loaded ones import from the same
+// module and the supported packages of the standard library. Only the
+// functions the program reaches are compiled, other dependencies stay
+// external
+func (m *SSAMapper) SetDependencies(deps bool) {
+	m.compileDeps = deps
+}
+
+// createPackages creates the SSA packages of the import graph, packages get
+// their syntax when they are compiled. The loaded packages are returned first
+// followed by the dependencies compiled on demand
+func (m *SSAMapper) createPackages(initial []*packages.Package) (prog *ssa.Program, pkgs, deps []*ssa.Package) {
+	prog = ssa.NewProgram(initial[0].Fset, ssa.SanityCheckFunctions|ssa.InstantiateGenerics)
+	loaded := make(map[*packages.Package]bool, len(initial))
+	modules := make(map[string]bool)
+	for _, p := range initial {
+		loaded[p] = true
+		if p.Module != nil {
+			modules[p.Module.Path] = true
+		}
+	}
+	created := make(map[*packages.Package]*ssa.Package)
+	packages.Visit(initial, nil, func(p *packages.Package) {
+		compile := loaded[p] || m.compileDeps && (p.Module != nil && modules[p.Module.Path] || stdlibPackages[p.PkgPath])
+		if !compile {
+			created[p] = prog.CreatePackage(p.Types, nil, nil, true)
+			return
+		}
+		pkg := prog.CreatePackage(p.Types, p.Syntax, p.TypesInfo, true)
+		created[p] = pkg
+		if !loaded[p] {
+			deps = append(deps, pkg)
+		}
+	})
+	for _, p := range initial {
+		if pkg := created[p]; pkg != nil {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return prog, pkgs, deps
+}
+
+// packageErrors returns the errors of the packages and their imports, like
+// the go command they fail the build rather than leaving packages out
+func packageErrors(pkgs []*packages.Package) error {
+	var errs []error
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		for _, err := range p.Errors {
+			if err.Pos == "" {
+				errs = append(errs, errors.New(err.Msg))
+				continue
+			}
+			errs = append(errs, err)
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// onDemand reports whether pkg is a dependency whose functions are compiled
+// when the program references them
+func (m *SSAMapper) onDemand(pkg *ssa.Package) bool {
+	for _, dep := range m.deps {
+		if dep == pkg {
+			return true
+		}
+	}
+	return false
+}
+
+// requireMethods queues the methods of a type of a dependency compiled on
+// demand, values of it converted to interfaces may call any of them
+func (m *SSAMapper) requireMethods(t types.Type) {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return
+	}
+	if pkg := m.prog.Package(named.Obj().Pkg()); pkg == nil || !m.onDemand(pkg) {
+		return
+	}
+	for _, fn := range m.methods(named) {
+		m.require(fn)
+	}
+}
+
+// symbolPrefix qualifies the symbols of a package by its import path, so
+// equally named packages such as math/rand and crypto/rand do not collide.
+// The main package keeps its name like gc
+func symbolPrefix(pkg *types.Package) string {
+	if pkg.Name() == "main" {
+		return pkg.Name()
+	}
+	return pkg.Path()
+}
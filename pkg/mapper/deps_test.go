@@ -0,0 +1,62 @@
+package mapper
+
+import (
+	"go/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/algoboyz/garm/pkg/dbg"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapDependencies(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"go.mod":      "module example.com/app\n\ngo 1.23\n",
+		"a/util/a.go": "package util\n\nfunc Double(x int) int { return 2 * x }\n\nfunc Unused() {}\n",
+		"b/util/b.go": "package util\n\nfunc Double(x int) int { return x + x }\n",
+		"cmd/main.go": "package main\n\nimport (\n\ta \"example.com/app/a/util\"\n\tb \"example.com/app/b/util\"\n)\n\nfunc main() { println(a.Double(1) + b.Double(2)) }\n",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+	}
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	load := func(deps bool) Report {
+		m := NewSSAMapper(dbg.NewDebugger(false))
+		m.SetGC(GCNone)
+		m.SetDependencies(deps)
+		require.NoError(t, m.Load("./cmd"))
+		_, err := m.MapPackage()
+		require.NoError(t, err)
+		return m.Report()
+	}
+	report := load(true)
+	assert.Subset(t, report.Compiled, []string{"example.com/app/a/util.Double", "example.com/app/b/util.Double", "main.main"},
+		"packages of the module are prefixed by their import path")
+	assert.NotContains(t, report.Compiled, "example.com/app/a/util.Unused", "only reachable functions are compiled")
+	assert.Empty(t, report.Unsupported)
+
+	report = load(false)
+	assert.Equal(t, []string{"example.com/app/a/util.Double", "example.com/app/b/util.Double"}, report.Unsupported)
+}
+
+func TestSymbolPrefix(t *testing.T) {
+	for _, pkg := range []*types.Package{
+		types.NewPackage("fmt", "fmt"),
+		types.NewPackage("math/rand", "rand"),
+		types.NewPackage("crypto/rand", "rand"),
+		types.NewPackage("example.com/app/a/util", "util"),
+	} {
+		assert.Equal(t, pkg.Path(), symbolPrefix(pkg), "packages are prefixed by their import path")
+	}
+	assert.Equal(t, "main", symbolPrefix(types.NewPackage("command-line-arguments", "main")))
+	assert.Equal(t, `"math/rand.Intn"`, ir.Symbol(symbolPrefix(types.NewPackage("math/rand", "rand"))+".Intn"))
+}
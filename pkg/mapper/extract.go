@@ -0,0 +1,14 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// MapExtract reads an element of a tuple held in a stack slot
+func (m *SSAMapper) MapExtract(v *ssa.Extract) error {
+	offset := m.tupleOffsets(v.Tuple.Type().(*types.Tuple))[v.Index]
+	return m.loadMember(v, v.Tuple, offset, fmt.Sprintf("%s = extract %s #%d", v.Name(), v.Tuple.Name(), v.Index))
+}
@@ -0,0 +1,88 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// MapFieldAddr computes the address of a field eg t1 = &t0.width
+func (m *SSAMapper) MapFieldAddr(v *ssa.FieldAddr) error {
+	base, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.X.Name(), err)
+	}
+	defer m.release(v.X, base)
+	dst, err := m.define(v)
+	if err != nil {
+		return err
+	}
+	st := v.X.Type().Underlying().(*types.Pointer).Elem().Underlying().(*types.Struct)
+	m.addOffset(dst, base, m.fieldOffset(st, v.Field), fmt.Sprintf("%s = &%s.%s", v.Name(), v.X.Name(), st.Field(v.Field).Name()))
+	return nil
+}
+
+// MapField reads a field of a struct value held in a stack slot
+func (m *SSAMapper) MapField(v *ssa.Field) error {
+	st := v.X.Type().Underlying().(*types.Struct)
+	return m.loadMember(v, v.X, m.fieldOffset(st, v.Field), fmt.Sprintf("%s = %s.%s", v.Name(), v.X.Name(), st.Field(v.Field).Name()))
+}
+
+// loadMember defines v as a copy of the member at offset of the aggregate x
+func (m *SSAMapper) loadMember(v, x ssa.Value, offset int, comment string) error {
+	src, err := m.load(x)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", x.Name(), err)
+	}
+	defer m.release(x, src)
+	if !m.isAggregate(v.Type()) {
+		dst, err := m.define(v)
+		if err != nil {
+			return err
+		}
+		m.loadFrom(dst, v.Type(), src, offset, comment)
+		return nil
+	}
+	mem, err := m.slot(v)
+	if err != nil {
+		return err
+	}
+	dst, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating address of %s: %w", v.Name(), err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(dst))
+	m.slotAddress(dst, mem)
+	m.addOffset(scratchCall, src, offset, comment)
+	return m.copyMem(dst, scratchCall, m.sizeof(v.Type()), comment)
+}
+
+// addOffset computes base+offset, offsets beyond the 12 bit immediate of ADD
+// are materialised first
+func (m *SSAMapper) addOffset(dst, base *reg.Register, offset int, comment string) {
+	imm := reg.NewImmediateOperand(fmt.Sprint(offset))
+	if offset >= 1<<12 {
+		m.movImm(scratchAddr, uint64(offset))
+		imm = reg.NewRegOperand(scratchAddr.String())
+	}
+	m.emit(ir.Instruction{
+		Op:      op.ADD,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewRegOperand(base.String()), imm},
+		Comment: comment,
+	})
+}
+
+// fieldOffset returns the byte offset of field i of st
+func (m *SSAMapper) fieldOffset(st *types.Struct, i int) int {
+	fields := make([]*types.Var, st.NumFields())
+	for i := range fields {
+		fields[i] = st.Field(i)
+	}
+	return int(m.sizes.Offsetsof(fields)[i])
+}
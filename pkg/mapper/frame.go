@@ -0,0 +1,63 @@
+package mapper
+
+import (
+	"fmt"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Frame layout, x29 points at the frame record and slots grow down from it
+//
+//	[x29, #8]    saved x30
+//	[x29]        saved x29
+//	[x29, #-16]  reserved for the runtime
+//	[x29, #-n]   stack slots handed out by the allocator
+//	[sp]         bottom of the frame, 16 byte aligned
+
+// reserveFrame emits the stack adjustment of the prologue, the frame size is
+// only known once the whole function has been mapped
+func (m *SSAMapper) reserveFrame() {
+	m.frameIndex = len(m.currentIR.Blocks)
+	m.emit(ir.Instruction{
+		Op:      op.SUB,
+		Dst:     reg.SP,
+		Src:     []reg.Operand{reg.NewRegOperand(reg.SP.String()), reg.NewImmediateOperand("0")},
+		Comment: "Reserve frame",
+	})
+}
+
+// finishFrame patches the size of the frame into the prologue
+func (m *SSAMapper) finishFrame() {
+	size := alloc.AlignSize(m.alloc.CurrentStackOffset(), 16)
+	m.currentIR.Blocks[m.frameIndex].Src[1] = reg.NewImmediateOperand(fmt.Sprint(size))
+}
+
+// slot reserves a stack slot holding the value v, slots are rounded up to
+// whole words so they can be moved with word sized loads and stores
+func (m *SSAMapper) slot(v ssa.Value) (*alloc.MemoryLocation, error) {
+	size := alloc.AlignSize(m.sizeof(v.Type()), alloc.WordSize)
+	loc, err := m.alloc.AllocateStack(alloc.MemoryLocation{
+		Name:      v.Name(),
+		Size:      size,
+		Alignment: alloc.WordSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("allocating slot for %s: %w", v.Name(), err)
+	}
+	m.currentIR.Locals[v.Name()] = loc
+	return loc.GetMemory(), nil
+}
+
+// slotAddress materialises the address of a stack slot
+func (m *SSAMapper) slotAddress(dst *reg.Register, mem *alloc.MemoryLocation) {
+	m.emit(ir.Instruction{
+		Op:      op.SUB,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewRegOperand("x29"), reg.NewImmediateOperand(fmt.Sprint(mem.Offset + mem.Size))},
+		Comment: "address of " + mem.Name,
+	})
+}
@@ -8,31 +8,58 @@ import (
 	"golang.org/x/tools/go/ssa"
 )
 
-func (m *SSAMapper) MapFunction(fn *ssa.Function) (irFunc *ir.Function, err error) {
+// MapFunction maps a function, one using constructs garm does not support
+// is reported by diagnostics and mapped to a stub that panics
+func (m *SSAMapper) MapFunction(fn *ssa.Function) (*ir.Function, error) {
 	if fn.Blocks == nil {
-		fmt.Println("Skipping external function:", fn.Name())
-		return nil, nil // Skip external functions
+		if external(fn) {
+			m.unresolved(fn)
+		}
+		return nil, nil
+	}
+	if generic(fn) {
+		// Calls go to the instantiations, the generic body has no sizes or
+		// layouts to map
+		return nil, nil
 	}
 	// Reset mapper state
-	// m.reset(ssaFunc)
-	m.currentFunc = fn
+	m.reset(fn, m.funcLabel(fn))
+	stackMaps, wrappers := len(m.stackMaps), len(m.wrappers)
+	irFunc, err := m.mapFunction(fn)
+	if err = m.diagnose(fn.Pos(), err); err != nil {
+		return nil, err
+	}
+	if m.unsupported {
+		m.stackMaps = m.stackMaps[:stackMaps]
+		m.wrappers = m.wrappers[:wrappers]
+		return m.stub(fn), nil
+	}
+	return irFunc, nil
+}
 
-	// Generate labels for basic blocks
-	// m.generateBlockLabels()
+// generic reports whether fn is a generic function rather than one of its
+// instantiations
+func generic(fn *ssa.Function) bool {
+	return fn.TypeParams().Len() > 0 && len(fn.TypeArgs()) == 0
+}
+
+func (m *SSAMapper) mapFunction(fn *ssa.Function) (irFunc *ir.Function, err error) {
+	// Create function prologue
+	m.prologue()
+	m.computeLiveness(fn)
+
+	// Move parameters out of the argument registers
 	params, err := m.processParams(fn.Params)
 	if err != nil {
 		return nil, fmt.Errorf("processing parameters: %w", err)
 	}
-	m.currentIR = ir.NewFunction(fn.Name(), m.debug)
 	m.currentIR.Params = params
-	// Allocate registers for parameters and return values
-	// err := m.alloc.AllocateFn(irFunc)
-	// if err != nil {
-	// 	return nil, fmt.Errorf("allocating registers: %w", err)
-	// }
-
-	// Create function prologue
-	m.currentIR.Blocks = append(m.currentIR.Blocks, m.prologue()...)
+	if err = m.processFreeVars(fn.FreeVars); err != nil {
+		return nil, fmt.Errorf("processing free variables: %w", err)
+	}
+	if err = m.reservePhis(fn); err != nil {
+		return nil, err
+	}
 
 	// Iterate through SSA instructions
 	for _, block := range fn.Blocks {
@@ -43,22 +70,40 @@ func (m *SSAMapper) MapFunction(fn *ssa.Function) (irFunc *ir.Function, err erro
 	}
 	// Create function epilogue
 	m.currentIR.Blocks = append(m.currentIR.Blocks, m.epilogue()...)
+	m.finishFrame()
+	if m.currentIR.Blocks, err = ir.ExpandMacros(m.currentIR.Blocks); err != nil {
+		return nil, fmt.Errorf("expanding macros: %w", err)
+	}
 
 	return m.currentIR, nil
 }
 
+// reset prepares the mapper for a new function labelled label, registers
+// and stack slots are allocated per function
+func (m *SSAMapper) reset(fn *ssa.Function, label string) {
+	m.currentFunc = fn
+	m.wrapper = nil
+	m.live = nil
+	m.currentBlock = nil
+	m.currentInstr = nil
+	m.frameObjects = make(map[*ssa.Alloc]*alloc.MemoryLocation)
+	m.alloc = alloc.NewAllocator()
+	m.saveArea = nil
+	m.saved = nil
+	m.labelMap = make(map[*ssa.BasicBlock]string)
+	m.labelCount = 0
+	m.unsupported = false
+	m.currentIR = ir.NewFunction(label, m.debug)
+}
+
 func (m *SSAMapper) processParams(params []*ssa.Parameter) (map[string]alloc.Location, error) {
 	irParams := make(map[string]alloc.Location)
+	var regs abi
 	for _, param := range params {
-		val := param.Object()
-		prim := alloc.NewPrimitive(val.Type().String())
-		typ := alloc.NewType(param.Name(), val.String(), prim, 64)
-		paramAlloc, err := m.alloc.AllocateRegister(typ)
-		if err != nil {
-			return nil, fmt.Errorf("allocating parameter: %w", err)
+		if err := m.moveIn(param, &regs, "parameter "+param.Name()); err != nil {
+			return nil, fmt.Errorf("mapping parameter %s: %w", param.Name(), err)
 		}
-		irParams[param.Name()] = paramAlloc
-
+		irParams[param.Name()] = m.currentIR.Locals[param.Name()]
 	}
 	return irParams, nil
 }
@@ -84,12 +129,12 @@ func (m *SSAMapper) processParams(params []*ssa.Parameter) (map[string]alloc.Loc
 // 	return irParams, nil
 // }
 
-func (m *SSAMapper) prologue() (instructions []ir.Instruction) {
-	if m.currentIR.Label == "main" {
-		instructions = ir.PrologueMain()
-	} else {
-		instructions = ir.FuncPrologue(m.currentIR.Label)
-	}
+// prologue links the frame record, reserves the stack frame and clears the
+// defer list of the frame
+func (m *SSAMapper) prologue() {
+	m.emit(ir.FuncPrologue(ir.Symbol(m.currentIR.Label))...)
+	m.reserveFrame()
+	m.clearDefers()
 	// Create a new frame
 	// frame := m.currentIR.Frames.PushFrame(true) // true means we need a frame pointer
 
@@ -108,17 +153,12 @@ func (m *SSAMapper) prologue() (instructions []ir.Instruction) {
 	// for _, inst := range instructions {
 	// 	fmt.Println(inst)
 	// }
-
-	return instructions
 }
 
 func (m *SSAMapper) epilogue() (instructions []ir.Instruction) {
-	// Generate epilogue
-	if m.currentIR.Label == "main" {
-		instructions = ir.EpilogueMain()
-	} else {
-		instructions = ir.FuncEpilogue()
-	}
+	// Generate epilogue, returns branch here
+	instructions = append(instructions, ir.Instruction{Labels: []string{m.returnLabel()}})
+	instructions = append(instructions, ir.FuncEpilogue()...)
 	// instructions = m.currentIR.Frames.GenerateFrameTeardown()
 	// for _, inst := range instructions {
 	// 	fmt.Println(inst)
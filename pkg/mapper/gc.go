@@ -0,0 +1,65 @@
+package mapper
+
+import "fmt"
+
+// GC selects the collector linked into the program, it decides which write
+// barriers, safepoint polls and stack maps the generated code carries. Every
+// collector provides runtime.alloc so allocation sites are the same, and
+// runtime.rawalloc which never collects for the runtime
+type GC int
+
+const (
+	GCGenerational GC = iota // copying young generation, card marking barriers
+	GCMarkSweep              // incremental tri-color marking, shading barriers
+	GCNone                   // bump allocation only, objects are never freed
+)
+
+func (gc GC) String() string {
+	switch gc {
+	case GCGenerational:
+		return "generational"
+	case GCMarkSweep:
+		return "marksweep"
+	case GCNone:
+		return "none"
+	default:
+		return fmt.Sprintf("gc(%d)", int(gc))
+	}
+}
+
+// ParseGC returns the collector named by a -gc flag value
+func ParseGC(name string) (GC, error) {
+	for _, gc := range []GC{GCGenerational, GCMarkSweep, GCNone} {
+		if gc.String() == name {
+			return gc, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown garbage collector %q, want generational, marksweep or none", name)
+}
+
+// Runtime lists the files of pkg/asm implementing the collector in the
+// order they are linked
+func (gc GC) Runtime() []string {
+	switch gc {
+	case GCGenerational:
+		return []string{"runtime.asm", "gen_gc.asm", "stackmap.asm"}
+	case GCMarkSweep:
+		return []string{"runtime.asm", "marksweep.asm", "stackmap.asm"}
+	default:
+		return []string{"alloc.asm"}
+	}
+}
+
+// collects reports whether the collector can run while the program does,
+// only then are roots described and safepoints polled
+func (gc GC) collects() bool {
+	return gc != GCNone
+}
+
+// runtimeGCInit sets up the heap of a collector before package initializers
+const runtimeGCInit = "runtime.gcinit"
+
+// SetGC selects the collector, it has to be set before mapping
+func (m *SSAMapper) SetGC(gc GC) {
+	m.gc = gc
+}
@@ -0,0 +1,173 @@
+package mapper
+
+import (
+	"fmt"
+	"go/constant"
+	"go/types"
+	"math"
+	"sort"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"golang.org/x/tools/go/ssa"
+)
+
+// MapGlobals lays out the package level variables of pkg in .data/.bss and
+// folds constant initializers of the package init into static data
+func (m *SSAMapper) MapGlobals(pkg *ssa.Package) error {
+	names := make([]string, 0, len(pkg.Members))
+	for name := range pkg.Members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g, ok := pkg.Members[name].(*ssa.Global)
+		if !ok {
+			continue
+		}
+		// Globals are typed as pointers to the variable
+		typ := g.Type().(*types.Pointer).Elem()
+		label := m.globalLabel(g)
+		m.data.Add(&ir.Global{
+			Label:   label,
+			Section: ir.SectionBSS,
+			Size:    m.sizeof(typ),
+			Align:   int(m.sizes.Alignof(typ)),
+			Comment: fmt.Sprintf("var %s %s", g.Name(), typ),
+		})
+		if m.gc.collects() && hasPointers(typ) {
+			words := make([]bool, (m.sizeof(typ)+alloc.WordSize-1)/alloc.WordSize)
+			m.pointerWords(typ, 0, words)
+			for i, ptr := range words {
+				if ptr {
+					m.globalRoots = append(m.globalRoots, fmt.Sprintf("%s+%d", label, i*alloc.WordSize))
+				}
+			}
+		}
+	}
+	if init := pkg.Func("init"); init != nil {
+		return m.foldInitializers(init)
+	}
+	return nil
+}
+
+// foldInitializers turns stores of constants into globals done by the package
+// initializer into static data. Folding stops at the first call since the callee
+// may observe the zero value of a variable that is initialized later on
+func (m *SSAMapper) foldInitializers(init *ssa.Function) error {
+	for _, block := range init.Blocks {
+		if block.Comment != "init.start" {
+			continue
+		}
+		for _, instr := range block.Instrs {
+			switch v := instr.(type) {
+			case *ssa.Call:
+				return nil
+			case *ssa.Store:
+				if isInitGuard(v.Addr) {
+					continue
+				}
+				c, ok := v.Val.(*ssa.Const)
+				if !ok {
+					continue
+				}
+				g, offset, ok := m.staticAddress(v.Addr)
+				if !ok {
+					continue
+				}
+				if err := m.setStatic(g, offset, c); err != nil {
+					return fmt.Errorf("folding initializer of %s: %w", g.Label, err)
+				}
+				m.folded[v] = true
+			}
+		}
+	}
+	return nil
+}
+
+// staticAddress resolves addresses of the form global, &global[const] and
+// &global.field to the global and the byte offset they refer to
+func (m *SSAMapper) staticAddress(addr ssa.Value) (*ir.Global, int, bool) {
+	switch a := addr.(type) {
+	case *ssa.Global:
+		g, ok := m.data.Lookup(m.globalLabel(a))
+		return g, 0, ok
+	case *ssa.IndexAddr:
+		idx, ok := a.Index.(*ssa.Const)
+		if !ok {
+			return nil, 0, false
+		}
+		g, offset, ok := m.staticAddress(a.X)
+		if !ok {
+			return nil, 0, false
+		}
+		array, ok := a.X.Type().(*types.Pointer).Elem().Underlying().(*types.Array)
+		if !ok {
+			return nil, 0, false
+		}
+		return g, offset + int(idx.Int64())*m.sizeof(array.Elem()), true
+	case *ssa.FieldAddr:
+		g, offset, ok := m.staticAddress(a.X)
+		if !ok {
+			return nil, 0, false
+		}
+		st := a.X.Type().(*types.Pointer).Elem().Underlying().(*types.Struct)
+		return g, offset + m.fieldOffset(st, a.Field), true
+	}
+	return nil, 0, false
+}
+
+// setStatic writes the constant c at offset into g
+func (m *SSAMapper) setStatic(g *ir.Global, offset int, c *ssa.Const) error {
+	size := m.sizeof(c.Type())
+	switch {
+	case c.Value == nil:
+		return nil // zero value, nothing to emit
+	case c.Value.Kind() == constant.String:
+		s := constant.StringVal(c.Value)
+		g.Set(offset, 8, m.data.String(s))
+		g.Set(offset+8, 8, fmt.Sprint(len(s)))
+	case c.Value.Kind() == constant.Bool:
+		if constant.BoolVal(c.Value) {
+			g.Set(offset, size, "1")
+		} else {
+			g.Set(offset, size, "0")
+		}
+	case c.Value.Kind() == constant.Int && isFloat(c.Type()):
+		return m.setFloat(g, offset, size, c)
+	case c.Value.Kind() == constant.Int:
+		if isSigned(c.Type()) {
+			g.Set(offset, size, fmt.Sprint(c.Int64()))
+		} else {
+			g.Set(offset, size, fmt.Sprint(c.Uint64()))
+		}
+	case c.Value.Kind() == constant.Float:
+		return m.setFloat(g, offset, size, c)
+	default:
+		return unsupported(diag.Constant, "unsupported static initializer %s", c)
+	}
+	return nil
+}
+
+func (m *SSAMapper) setFloat(g *ir.Global, offset, size int, c *ssa.Const) error {
+	switch size {
+	case 4:
+		g.Set(offset, size, fmt.Sprintf("0x%08x", math.Float32bits(float32(c.Float64()))))
+	case 8:
+		g.Set(offset, size, fmt.Sprintf("0x%016x", math.Float64bits(c.Float64())))
+	default:
+		return unsupported(diag.Constant, "unsupported float size %d", size)
+	}
+	return nil
+}
+
+// globalLabel returns the package qualified symbol of a global eg main.counter,
+// variables of the standard library the runtime defines use its symbol
+func (m *SSAMapper) globalLabel(g *ssa.Global) string {
+	label := symbolPrefix(g.Pkg.Pkg) + "." + g.Name()
+	if sym, ok := libraryGlobals[label]; ok {
+		return sym
+	}
+	return label
+}
@@ -0,0 +1,173 @@
+package mapper
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Goroutine records share the defer record layout, the recovery point holds
+// the pointer bitmap of the arguments instead, see pkg/asm/sched.asm
+const (
+	goPtrs     = deferResume // bit i set when x<i> holds a pointer
+	goCtxPtr   = 8           // bit set when the closure context does
+	goMaxProcs = "go.maxprocs"
+)
+
+// Runtime routines of the scheduler, see pkg/asm/sched.asm
+const (
+	runtimeNewProc   = "runtime.newproc"   // x0 goroutine record
+	runtimeSchedInit = "runtime.schedinit" // makes main a goroutine, starts threads
+)
+
+// SetThreads sets the number of OS threads running goroutines, it has to be
+// set before mapping. With a single thread scheduling is deterministic
+func (m *SSAMapper) SetThreads(n int) {
+	m.threads = max(n, 1)
+}
+
+// MapGo starts a goroutine, the callee and its arguments are evaluated by
+// the go statement and handed to runtime.newproc in a record on the stack
+func (m *SSAMapper) MapGo(v *ssa.Go) error {
+	saved := m.saveRegisters()
+	if err := m.pushRecord(&v.Call, "gowrap", "push goroutine record"); err != nil {
+		return fmt.Errorf("go statement: %w", err)
+	}
+	bits, err := m.argPointers(&v.Call)
+	if err != nil {
+		return err
+	}
+	m.movImm(scratchCall, bits)
+	m.emit(ir.Instruction{
+		Op:      op.STR,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewOffsetOperand(reg.SP, goPtrs)},
+		Comment: "argument pointers",
+	}, ir.Instruction{
+		Op:  op.MOV,
+		Dst: &reg.Register{ID: 0, Class: reg.RegisterClassGPR},
+		Src: []reg.Operand{reg.NewRegOperand(reg.SP.String())},
+	})
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeNewProc}, Comment: "go " + v.Call.Value.Name()})
+	m.emit(ir.Instruction{
+		Op:      op.ADD,
+		Dst:     reg.SP,
+		Src:     []reg.Operand{reg.NewRegOperand(reg.SP.String()), reg.NewImmediateOperand(fmt.Sprint(deferRecSize))},
+		Comment: "pop goroutine record",
+	})
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// argPointers returns the bitmap of the record words holding pointers, the
+// collector scans them until the goroutine starts
+func (m *SSAMapper) argPointers(call *ssa.CallCommon) (uint64, error) {
+	var bits uint64
+	args := &abi{}
+	switch {
+	case call.IsInvoke():
+		bits |= 1 // receiver data word
+		args.ints = 1
+	default:
+		switch call.Value.(type) {
+		case *ssa.Function, *ssa.Builtin:
+			// Called directly or through a wrapper, there is no context
+		default:
+			bits |= 1 << goCtxPtr
+		}
+	}
+	for _, arg := range call.Args {
+		ps, err := m.parts(arg.Type())
+		if err != nil {
+			return 0, err
+		}
+		words := make([]bool, max(alloc.AlignSize(m.sizeof(arg.Type()), alloc.WordSize)/alloc.WordSize, 1))
+		m.pointerWords(arg.Type(), 0, words)
+		for _, p := range ps {
+			if p.indirect {
+				return 0, unsupported(diag.Call, "passing %s to a goroutine is not supported", arg.Type())
+			}
+			r, err := args.next(p)
+			if err != nil {
+				return 0, err
+			}
+			if r.Class == reg.RegisterClassGPR && words[p.offset/alloc.WordSize] {
+				bits |= 1 << r.ID
+			}
+		}
+	}
+	return bits, nil
+}
+
+// schedules reports whether the program needs the scheduler: a function of
+// the packages starts a goroutine or may block on a channel. Every function
+// then polls at its loop back-edges so other goroutines get to run. Generic
+// functions are only instantiated on demand so their syntax is searched
+// instead
+func (m *SSAMapper) schedules(order []*ssa.Package) bool {
+	var visit func(fn *ssa.Function) bool
+	visit = func(fn *ssa.Function) bool {
+		if fn.Blocks == nil && fn.Syntax() != nil {
+			found := false
+			ast.Inspect(fn.Syntax(), func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.GoStmt, *ast.SendStmt, *ast.SelectStmt:
+					found = true
+				case *ast.UnaryExpr:
+					found = found || n.Op == token.ARROW
+				}
+				return !found
+			})
+			return found
+		}
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				switch instr := instr.(type) {
+				case *ssa.Go, *ssa.Send, *ssa.Select:
+					return true
+				case *ssa.UnOp:
+					if instr.Op == token.ARROW {
+						return true
+					}
+				}
+			}
+		}
+		for _, anon := range fn.AnonFuncs {
+			if visit(anon) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, pkg := range order {
+		for _, fn := range m.packageFunctions(pkg) {
+			if visit(fn) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// linkScheduler emits the number of threads runtime.schedinit starts
+func (m *SSAMapper) linkScheduler() {
+	if !m.scheduler {
+		return
+	}
+	procs := &ir.Global{
+		Label:   goMaxProcs,
+		Section: ir.SectionRodata,
+		Size:    alloc.WordSize,
+		Align:   8,
+		Comment: "threads running goroutines",
+	}
+	procs.Set(0, 8, fmt.Sprint(max(m.threads, 1)))
+	m.data.Add(procs)
+}
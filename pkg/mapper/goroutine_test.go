@@ -0,0 +1,47 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapGo(t *testing.T) {
+	fns, m := compile(t, `package main
+
+var done bool
+
+type counter struct{ n int }
+
+func (c *counter) work(k int, p *int) {
+	for !done {
+		c.n = c.n + k
+		*p = c.n
+	}
+}
+
+func main() {
+	c := &counter{}
+	go c.work(1, p())
+	go func() { done = true }()
+	for !done {
+	}
+}
+
+func p() *int { return new(int) }
+`, func(m *SSAMapper) { m.SetGC(GCNone); m.SetThreads(2) })
+	main := fns["main.main"]
+	assert.Regexp(t, `MOVZ x\d+, #0x5\n\tSTR x\d+, \[sp, #24\]\n`, main, "c and p hold pointers")
+	assert.Contains(t, main, "MOV x0, sp\n\tBL runtime.newproc\n")
+	assert.Contains(t, main, "BL runtime.gcsafepoint", "goroutines poll without a collector")
+	assert.Contains(t, fns["main"], "BL runtime.schedinit")
+
+	procs, ok := m.data.Lookup("go.maxprocs")
+	require.True(t, ok)
+	assert.Contains(t, procs.String(false), ".quad 2")
+
+	fns, _ = compile(t, "package main\n\nfunc main() {\n\tfor {\n\t}\n}\n", func(m *SSAMapper) { m.SetGC(GCNone) })
+	assert.NotContains(t, fns["main"], "runtime.schedinit", "no scheduler without goroutines")
+	assert.NotContains(t, fns["main.main"], "runtime.gcsafepoint")
+}
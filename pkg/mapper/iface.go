@@ -0,0 +1,172 @@
+package mapper
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// runtimeIfaceEq compares interfaces, see pkg/asm/map.asm
+const runtimeIfaceEq = "runtime.ifaceeq" // x0 interface type, x1, x2 interfaces -> x0 equal
+
+// Interface values are two words, the itab followed by the data word. Empty
+// interfaces hold the type descriptor in place of the itab
+const (
+	ifaceTab  = 0
+	ifaceData = 8
+)
+
+// MapMakeInterface builds an interface value in a stack slot. Pointer shaped
+// values are stored in the data word directly, anything else is boxed on the heap
+func (m *SSAMapper) MapMakeInterface(v *ssa.MakeInterface) error {
+	t := v.X.Type()
+	var tab string
+	if types.IsInterface(t) {
+		return unsupported(diag.Conversion, "unsupported conversion between interfaces %s -> %s", t, v.Type())
+	}
+	if v.Type().Underlying().(*types.Interface).Empty() {
+		tab = m.typeDescriptor(t)
+	} else {
+		label, err := m.itab(t, v.Type())
+		if err != nil {
+			return err
+		}
+		tab = label
+	}
+	m.concrete.Set(t, true)
+	m.requireMethods(t)
+	comment := fmt.Sprintf("%s = make %s <- %s", v.Name(), typeString(v.Type()), v.X.Name())
+
+	// The data word is computed first, boxing calls into the runtime
+	data, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating data word: %w", err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(data))
+	x, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.X.Name(), err)
+	}
+	switch {
+	case isPointerShaped(t):
+		m.move(data, x, alloc.WordSize, comment)
+	case m.isAggregate(t):
+		m.newObject(data, t)
+		if err := m.copyMem(data, x, m.sizeof(t), comment); err != nil {
+			return err
+		}
+	default:
+		m.newObject(data, t)
+		m.storeTo(x, t, data, 0, comment)
+	}
+	m.release(v.X, x)
+
+	mem, err := m.slot(v)
+	if err != nil {
+		return err
+	}
+	m.slotAddress(scratchAddr, mem)
+	m.emit(ir.Instruction{
+		Op:      op.STR,
+		Dst:     data,
+		Src:     []reg.Operand{reg.NewOffsetOperand(scratchAddr, ifaceData)},
+		Comment: comment,
+	})
+	m.loadAddress(data, tab)
+	m.slotAddress(scratchAddr, mem)
+	m.emit(ir.Instruction{
+		Op:      op.STR,
+		Dst:     data,
+		Src:     []reg.Operand{reg.NewOffsetOperand(scratchAddr, ifaceTab)},
+		Comment: comment,
+	})
+	return nil
+}
+
+// MapChangeInterface converts between interface types. The data word is
+// kept, the type word of the itab is the new type word of empty interfaces
+// and is looked up in the itabs of non empty ones
+func (m *SSAMapper) MapChangeInterface(v *ssa.ChangeInterface) error {
+	iface, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.X.Name(), err)
+	}
+	defer m.release(v.X, iface)
+	have, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating dynamic type: %w", err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(have))
+	m.dynamicType(have, iface, v.X.Type())
+	tab, err := m.lookupItab(have, v.Type())
+	if err != nil {
+		return err
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(tab))
+
+	comment := fmt.Sprintf("%s = change %s <- %s", v.Name(), typeString(v.Type()), v.X.Name())
+	mem, err := m.slot(v)
+	if err != nil {
+		return err
+	}
+	m.emit(ir.Instruction{
+		Op:      op.LDR,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewOffsetOperand(iface, ifaceData)},
+		Comment: "data word of " + v.X.Name(),
+	})
+	m.slotAddress(scratchAddr, mem)
+	m.storeTo(tab, types.Typ[types.Uintptr], scratchAddr, ifaceTab, comment)
+	m.storeTo(scratchCall, types.Typ[types.Uintptr], scratchAddr, ifaceData, comment)
+	return nil
+}
+
+// mapInterfaceCompare compares two interfaces of the same type: their type
+// words first, then the data words following the key algorithm of the
+// dynamic type. Uncomparable dynamic types panic in the runtime
+func (m *SSAMapper) mapInterfaceCompare(expr *ssa.BinOp) error {
+	m.runtimePanics()
+	lhs, err := m.load(expr.X)
+	if err != nil {
+		return err
+	}
+	defer m.release(expr.X, lhs)
+	rhs, err := m.load(expr.Y)
+	if err != nil {
+		return err
+	}
+	defer m.release(expr.Y, rhs)
+	dst, err := m.define(expr)
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf("%s = %s %s %s", expr.Name(), expr.X.Name(), expr.Op.String(), expr.Y.Name())
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, lhs, alloc.WordSize, "address of "+expr.X.Name())
+	m.move(&reg.Register{ID: 2, Class: reg.RegisterClassGPR}, rhs, alloc.WordSize, "address of "+expr.Y.Name())
+	m.loadAddress(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, m.typeDescriptor(expr.X.Type()))
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeIfaceEq}, Comment: comment})
+	// x0 is 1 when they are equal
+	cond := "ne"
+	if expr.Op == token.NEQ {
+		cond = "eq"
+	}
+	m.emit(ir.Instruction{
+		Op:  op.CMP,
+		Dst: &reg.Register{ID: 0, Class: reg.RegisterClassGPR},
+		Src: []reg.Operand{reg.NewImmediateOperand("0")},
+	}, ir.Instruction{
+		Op:  op.CSET,
+		Dst: dst,
+		Src: []reg.Operand{reg.NewLabelOperand(cond)},
+	})
+	m.restoreRegisters(saved, dst)
+	return nil
+}
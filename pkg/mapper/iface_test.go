@@ -0,0 +1,66 @@
+package mapper
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapInterfaceDispatch(t *testing.T) {
+	fns, m := compile(t, `package main
+
+type shape interface {
+	area() int
+	sides() int
+}
+
+type square int
+
+func (s square) area() int  { return int(s * s) }
+func (s square) sides() int { return 4 }
+
+func sides(s shape) int { return s.sides() }
+
+func main() { sides(square(3)) }
+`)
+	tab, ok := m.Data().Lookup("go.itab.main.square,main.shape")
+	require.True(t, ok, "itab is emitted")
+	assert.Equal(t, itabFun+2*8, tab.Size, "one method slot per interface method")
+
+	_, ok = m.Data().Lookup("type.main.square")
+	assert.True(t, ok, "type descriptor is emitted")
+
+	assert.Contains(t, fns["main.main"], "BL runtime.alloc", "non pointer values are boxed")
+	assert.Regexp(t, fmt.Sprintf(`LDR x\d+, \[x\d+, #%d\]`, itabFun+8), fns["main.sides"], "sides is the second method")
+	assert.Regexp(t, `BLR x\d+\n`, fns["main.sides"])
+}
+
+func TestMapInterfaceCompare(t *testing.T) {
+	fns, _ := compile(t, `package main
+
+type shape interface{ area() int }
+
+type square int
+
+func (s square) area() int { return int(s * s) }
+
+func isNil(s shape) bool { return s == nil }
+
+func same(x, y any) bool { return x != y }
+
+func widen(s shape) any { return s }
+
+func main() {
+	isNil(square(1))
+	same(1, 2)
+	widen(square(2))
+}
+`)
+	assert.Contains(t, fns["main.isNil"], "BL "+runtimeIfaceEq)
+	assert.Contains(t, fns["main.isNil"], "CSET x", "the runtime yields 1 for equal interfaces")
+	assert.Regexp(t, `CSET x\d+, eq`, fns["main.same"], "!= negates the result")
+	assert.Regexp(t, fmt.Sprintf(`LDR x\d+, \[x\d+, #%d\]`, itabType), fns["main.widen"], "the type word comes from the itab")
+	assert.NotContains(t, fns["main.widen"], "BL "+runtimeGetItab, "empty interfaces need no itab")
+}
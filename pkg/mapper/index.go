@@ -0,0 +1,310 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+	"slices"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Runtime routines of the bounds checks, see pkg/asm/defer.asm
+const (
+	runtimePanicIndex = "runtime.panicindex"
+	runtimePanicSlice = "runtime.panicslice"
+)
+
+// Slice header layout, strings share the first two words
+const (
+	sliceData = 0
+	sliceLen  = 8
+	sliceCap  = 16
+)
+
+// sequence is the memory of an indexable value: its data pointer, length
+// and capacity. Registers handed out by m.sequence are freed by release
+type sequence struct {
+	data, len, cap *reg.Register
+	elem           types.Type
+	owned          []*reg.Register
+}
+
+// sequence loads the data pointer, length and capacity of x, a string, a
+// slice or an array held in memory or pointed to
+func (m *SSAMapper) sequence(x ssa.Value, base *reg.Register) (*sequence, error) {
+	s := &sequence{}
+	next := func() (*reg.Register, error) {
+		r, err := m.scratch()
+		if err != nil {
+			return nil, fmt.Errorf("allocating header of %s: %w", x.Name(), err)
+		}
+		s.owned = append(s.owned, r)
+		return r, nil
+	}
+	t := x.Type().Underlying()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem().Underlying()
+	}
+	var err error
+	if s.len, err = next(); err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case *types.Array:
+		s.data, s.cap, s.elem = base, s.len, t.Elem()
+		m.movImm(s.len, uint64(t.Len()))
+		return s, nil
+	case *types.Slice:
+		s.elem = t.Elem()
+		if s.cap, err = next(); err != nil {
+			return nil, err
+		}
+		m.loadFrom(s.cap, types.Typ[types.Int], base, sliceCap, "cap "+x.Name())
+	case *types.Basic:
+		if t.Info()&types.IsString == 0 {
+			return nil, unsupported(diag.Instruction, "unsupported index of %s", typeString(x.Type()))
+		}
+		s.cap, s.elem = s.len, types.Typ[types.Byte]
+	default:
+		return nil, unsupported(diag.Instruction, "unsupported index of %s", typeString(x.Type()))
+	}
+	if s.data, err = next(); err != nil {
+		return nil, err
+	}
+	m.loadFrom(s.data, types.Typ[types.Uintptr], base, sliceData, "data "+x.Name())
+	m.loadFrom(s.len, types.Typ[types.Int], base, sliceLen, "len "+x.Name())
+	return s, nil
+}
+
+// release frees the registers of the sequence
+func (s *sequence) release(m *SSAMapper) {
+	for _, r := range s.owned {
+		m.alloc.Free(alloc.NewRegisterLocation(r))
+	}
+}
+
+// MapIndexAddr computes the address of an element of a slice or of the
+// array a pointer points to eg t1 = &t0[i]
+func (m *SSAMapper) MapIndexAddr(v *ssa.IndexAddr) error {
+	return m.index(v, v.X, v.Index, func(addr *reg.Register, elem types.Type) error {
+		dst, err := m.define(v)
+		if err != nil {
+			return err
+		}
+		m.move(dst, addr, alloc.WordSize, fmt.Sprintf("%s = &%s[%s]", v.Name(), v.X.Name(), v.Index.Name()))
+		return nil
+	})
+}
+
+// MapIndex reads an element of a string or of an array value eg t1 = t0[i]
+func (m *SSAMapper) MapIndex(v *ssa.Index) error {
+	comment := fmt.Sprintf("%s = %s[%s]", v.Name(), v.X.Name(), v.Index.Name())
+	return m.index(v, v.X, v.Index, func(addr *reg.Register, elem types.Type) error {
+		if !m.isAggregate(elem) {
+			dst, err := m.define(v)
+			if err != nil {
+				return err
+			}
+			m.loadFrom(dst, elem, addr, 0, comment)
+			return nil
+		}
+		mem, err := m.slot(v)
+		if err != nil {
+			return err
+		}
+		dst, err := m.scratch()
+		if err != nil {
+			return fmt.Errorf("allocating address of %s: %w", v.Name(), err)
+		}
+		defer m.alloc.Free(alloc.NewRegisterLocation(dst))
+		m.slotAddress(dst, mem)
+		return m.copyMem(dst, addr, m.sizeof(elem), comment)
+	})
+}
+
+// index checks the bounds of x[i] and hands the address of the element to
+// use, indices are compared unsigned so negative ones fail too
+func (m *SSAMapper) index(v, x, i ssa.Value, use func(addr *reg.Register, elem types.Type) error) error {
+	base, err := m.load(x)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", x.Name(), err)
+	}
+	defer m.release(x, base)
+	seq, err := m.sequence(x, base)
+	if err != nil {
+		return err
+	}
+	defer seq.release(m)
+	idx, err := m.load(i)
+	if err != nil {
+		return fmt.Errorf("loading index %s: %w", i.Name(), err)
+	}
+	defer m.release(i, idx)
+
+	ok := m.localLabel("inbounds")
+	m.emit(ir.Instruction{
+		Op:      op.CMP,
+		Dst:     seq.len,
+		Src:     []reg.Operand{reg.NewRegOperand(idx.String())},
+		Comment: fmt.Sprintf("bounds check %s[%s]", x.Name(), i.Name()),
+	}, ir.Instruction{Op: op.BHI, Labels: []string{ok}})
+	m.panicBounds(runtimePanicIndex)
+	m.emit(ir.Instruction{Labels: []string{ok}})
+
+	addr, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating address of %s: %w", v.Name(), err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(addr))
+	m.elemAddr(addr, seq.data, idx, m.sizeof(seq.elem))
+	return use(addr, seq.elem)
+}
+
+// MapSlice slices a string, a slice or the array a pointer points to. The
+// bounds are checked as 0 <= low <= high <= max <= cap, an empty result
+// keeps the original data pointer so it never points past an object
+func (m *SSAMapper) MapSlice(v *ssa.Slice) error {
+	comment := fmt.Sprintf("%s = %s[%s:%s]", v.Name(), v.X.Name(), valueName(v.Low), valueName(v.High))
+	base, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.X.Name(), err)
+	}
+	defer m.release(v.X, base)
+	seq, err := m.sequence(v.X, base)
+	if err != nil {
+		return err
+	}
+	defer seq.release(m)
+	bound := func(b ssa.Value, def *reg.Register) (*reg.Register, error) {
+		if b == nil {
+			return def, nil
+		}
+		r, err := m.load(b)
+		if err != nil {
+			return nil, fmt.Errorf("loading bound %s: %w", b.Name(), err)
+		}
+		return r, nil
+	}
+	high, err := bound(v.High, seq.len)
+	if err != nil {
+		return err
+	}
+	if v.High != nil {
+		defer m.release(v.High, high)
+	}
+	limit, err := bound(v.Max, seq.cap)
+	if err != nil {
+		return err
+	}
+	if v.Max != nil {
+		defer m.release(v.Max, limit)
+	}
+	var low *reg.Register
+	if v.Low != nil {
+		if low, err = m.load(v.Low); err != nil {
+			return fmt.Errorf("loading bound %s: %w", v.Low.Name(), err)
+		}
+		defer m.release(v.Low, low)
+	}
+
+	pairs := [][2]*reg.Register{{limit, seq.cap}, {high, limit}}
+	if low != nil {
+		pairs = append(pairs, [2]*reg.Register{low, high})
+	}
+	pairs = slices.DeleteFunc(pairs, func(p [2]*reg.Register) bool { return p[0] == p[1] })
+	if len(pairs) > 0 {
+		fail, ok := m.localLabel("slicefail"), m.localLabel("slice")
+		for _, p := range pairs {
+			m.emit(ir.Instruction{
+				Op:      op.CMP,
+				Dst:     p[0],
+				Src:     []reg.Operand{reg.NewRegOperand(p[1].String())},
+				Comment: "bounds check " + comment,
+			}, ir.Instruction{Op: op.BHI, Labels: []string{fail}})
+		}
+		m.emit(ir.Instruction{Op: op.B, Labels: []string{ok}}, ir.Instruction{Labels: []string{fail}})
+		m.panicBounds(runtimePanicSlice)
+		m.emit(ir.Instruction{Labels: []string{ok}})
+	}
+
+	mem, err := m.slot(v)
+	if err != nil {
+		return err
+	}
+	dst, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating address of %s: %w", v.Name(), err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(dst))
+	m.slotAddress(dst, mem)
+	sub := func(hi *reg.Register, field int, what string) {
+		if low == nil {
+			m.move(scratchCall, hi, alloc.WordSize, what)
+		} else {
+			m.emit(ir.Instruction{
+				Op:      op.SUB,
+				Dst:     scratchCall,
+				Src:     []reg.Operand{reg.NewRegOperand(hi.String()), reg.NewRegOperand(low.String())},
+				Comment: what,
+			})
+		}
+		m.storeTo(scratchCall, types.Typ[types.Int], dst, field, what)
+	}
+	sub(high, sliceLen, "len "+v.Name())
+	if _, ok := v.Type().Underlying().(*types.Slice); ok {
+		sub(limit, sliceCap, "cap "+v.Name())
+	}
+	if low == nil {
+		m.storeTo(seq.data, types.Typ[types.Uintptr], dst, sliceData, "data "+v.Name())
+		return nil
+	}
+	m.emit(ir.Instruction{Op: op.CMP, Dst: scratchCall, Src: []reg.Operand{reg.NewImmediateOperand("0")}})
+	m.elemAddr(scratchCall, seq.data, low, m.sizeof(seq.elem))
+	m.emit(ir.Instruction{
+		Op:      op.CSEL,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewRegOperand(seq.data.String()), reg.NewRegOperand(scratchCall.String()), reg.NewLabelOperand("eq")},
+		Comment: "empty slices keep the data pointer",
+	})
+	m.storeTo(scratchCall, types.Typ[types.Uintptr], dst, sliceData, "data "+v.Name())
+	return nil
+}
+
+// elemAddr computes data+idx*size without touching the flags
+func (m *SSAMapper) elemAddr(dst, data, idx *reg.Register, size int) {
+	if size == 1 {
+		m.emit(ir.Instruction{
+			Op:  op.ADD,
+			Dst: dst,
+			Src: []reg.Operand{reg.NewRegOperand(data.String()), reg.NewRegOperand(idx.String())},
+		})
+		return
+	}
+	m.movImm(scratchAddr, uint64(size))
+	m.emit(ir.Instruction{
+		Op:  op.MADD,
+		Dst: dst,
+		Src: []reg.Operand{reg.NewRegOperand(idx.String()), reg.NewRegOperand(scratchAddr.String()), reg.NewRegOperand(data.String())},
+	})
+}
+
+// panicBounds calls a bounds check panic, the registers are saved so the
+// collector finds their pointers while deferred calls run
+func (m *SSAMapper) panicBounds(routine string) {
+	m.runtimePanics()
+	m.saveRegisters()
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{routine}})
+}
+
+// valueName returns the name of an optional value
+func valueName(v ssa.Value) string {
+	if v == nil {
+		return ""
+	}
+	return v.Name()
+}
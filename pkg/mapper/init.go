@@ -0,0 +1,113 @@
+package mapper
+
+import (
+	"go/types"
+	"sort"
+
+	"github.com/algoboyz/garm/pkg/ir"
+	"golang.org/x/tools/go/ssa"
+)
+
+// initOrder returns the compiled packages sorted so that every package comes
+// after the packages it imports, which is the order Go runs initializers in
+func (m *SSAMapper) initOrder() (order []*ssa.Package) {
+	compiled := make(map[string]*ssa.Package, len(m.pkgs)+len(m.deps))
+	paths := make([]string, 0, len(m.pkgs)+len(m.deps))
+	for _, pkg := range append(append([]*ssa.Package(nil), m.pkgs...), m.deps...) {
+		compiled[pkg.Pkg.Path()] = pkg
+		paths = append(paths, pkg.Pkg.Path())
+	}
+	sort.Strings(paths)
+
+	visited := make(map[string]bool)
+	var visit func(path string)
+	visit = func(path string) {
+		if visited[path] {
+			return
+		}
+		visited[path] = true
+		pkg, ok := compiled[path]
+		if !ok {
+			return
+		}
+		for _, imp := range pkg.Pkg.Imports() {
+			visit(imp.Path())
+		}
+		order = append(order, pkg)
+	}
+	for _, path := range paths {
+		visit(path)
+	}
+	return order
+}
+
+// packageFunctions returns the functions of pkg to compile in a stable order.
+// Every init() of the package is a member named init#N which the synthetic
+// package initializer calls in source order
+func (m *SSAMapper) packageFunctions(pkg *ssa.Package) (fns []*ssa.Function) {
+	names := make([]string, 0, len(pkg.Members))
+	for name := range pkg.Members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fn, ok := pkg.Members[name].(*ssa.Function); ok {
+			fns = append(fns, fn)
+		}
+	}
+	for _, name := range names {
+		if t, ok := pkg.Members[name].(*ssa.Type); ok {
+			fns = append(fns, m.methods(t.Type())...)
+		}
+	}
+	return fns
+}
+
+// methods returns the methods of the value and pointer method sets of a named
+// type, including the wrappers adapting receivers and promoting embedded methods
+func (m *SSAMapper) methods(t types.Type) (fns []*ssa.Function) {
+	if types.IsInterface(t) {
+		return nil
+	}
+	seen := make(map[*ssa.Function]bool)
+	for _, recv := range []types.Type{t, types.NewPointer(t)} {
+		mset := m.prog.MethodSets.MethodSet(recv)
+		for i := range mset.Len() {
+			// Generic methods have no function until instantiated
+			fn := m.prog.MethodValue(mset.At(i))
+			if fn == nil || seen[fn] {
+				continue
+			}
+			seen[fn] = true
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// MapEntry generates the program entry when a main package is compiled
+func (m *SSAMapper) MapEntry(order []*ssa.Package) *ir.Function {
+	var main *ssa.Function
+	var inits []string
+	for _, pkg := range order {
+		if init := pkg.Func("init"); init != nil && init.Blocks != nil {
+			inits = append(inits, ir.Symbol(m.funcLabel(init)))
+		}
+		if pkg.Pkg.Name() == "main" && pkg.Func("main") != nil {
+			main = pkg.Func("main")
+		}
+	}
+	if main == nil {
+		return nil // library packages have no entry
+	}
+	entry := ir.NewFunction("main", m.debug)
+	var runtime []string
+	if m.gc.collects() {
+		runtime = append(runtime, runtimeGCInit)
+	}
+	if m.scheduler {
+		runtime = append(runtime, runtimeSchedInit)
+	}
+	entry.Blocks = ir.EntryPoint(runtime, inits, ir.Symbol(m.funcLabel(main)))
+	return entry
+}
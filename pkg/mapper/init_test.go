@@ -0,0 +1,34 @@
+package mapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapPackageInitOrder(t *testing.T) {
+	fns, _ := compile(t, `package main
+
+var x = f()
+
+func f() int { return 3 }
+
+func init() { x++ }
+func init() { x += 2 }
+
+func main() { _ = x }
+`)
+	for _, label := range []string{"main.f", "main.init", "main.init#1", "main.init#2", "main.main", "main"} {
+		assert.Contains(t, fns, label)
+	}
+	entry := fns["main"]
+	assert.Less(t, strings.Index(entry, "BL main.init"), strings.Index(entry, "BL main.main"),
+		"initializers run before main")
+
+	init := fns["main.init"]
+	assert.Regexp(t, `LDRB w\d+, \[x\d+\]`, init, "guard is loaded")
+	assert.Regexp(t, `CBNZ x\d+, \.Lmain\.init\.2\n`, init, "initialized packages skip to init.done")
+	assert.Less(t, strings.Index(init, `BL "main.init#1"`), strings.Index(init, `BL "main.init#2"`),
+		"init functions run in source order")
+}
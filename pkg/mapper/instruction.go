@@ -1,7 +1,7 @@
 package mapper
 
 import (
-	"github.com/davecgh/go-spew/spew"
+	"github.com/algoboyz/garm/pkg/diag"
 	"golang.org/x/tools/go/ssa"
 )
 
@@ -13,21 +13,67 @@ func (m *SSAMapper) MapInstruction(instr ssa.Instruction) error {
 		return m.MapStore(v)
 	case *ssa.BinOp:
 		return m.MapBinaryOperation(v)
+	case *ssa.UnOp:
+		return m.MapUnOp(v)
 	case *ssa.Call:
 		return m.MapCall(v)
+	case *ssa.MakeInterface:
+		return m.MapMakeInterface(v)
+	case *ssa.ChangeInterface:
+		return m.MapChangeInterface(v)
+	case *ssa.TypeAssert:
+		return m.MapTypeAssert(v)
+	case *ssa.Extract:
+		return m.MapExtract(v)
+	case *ssa.FieldAddr:
+		return m.MapFieldAddr(v)
+	case *ssa.Field:
+		return m.MapField(v)
+	case *ssa.IndexAddr:
+		return m.MapIndexAddr(v)
+	case *ssa.Index:
+		return m.MapIndex(v)
+	case *ssa.Slice:
+		return m.MapSlice(v)
 	case *ssa.Convert:
-		// m.MapTypeConversion(v)
+		return m.MapConvert(v)
+	case *ssa.ChangeType:
+		return m.MapChangeType(v)
+	case *ssa.MakeClosure:
+		return m.MapMakeClosure(v)
 	case *ssa.Jump:
-		// m.MapJump(v)
+		return m.MapJump(v)
 	case *ssa.If:
-		// m.MapConditional(v)
+		return m.MapIf(v)
 	case *ssa.Phi:
-		// return m.MapPhi(v)
+		return m.MapPhi(v)
 	case *ssa.Return:
-		// return m.MapReturn(v)
+		return m.MapReturn(v)
+	case *ssa.Defer:
+		return m.MapDefer(v)
+	case *ssa.Go:
+		return m.MapGo(v)
+	case *ssa.MakeChan:
+		return m.MapMakeChan(v)
+	case *ssa.Send:
+		return m.MapSend(v)
+	case *ssa.Select:
+		return m.MapSelect(v)
+	case *ssa.MakeMap:
+		return m.MapMakeMap(v)
+	case *ssa.MapUpdate:
+		return m.MapMapUpdate(v)
+	case *ssa.Lookup:
+		return m.MapLookup(v)
+	case *ssa.Range:
+		return m.MapRange(v)
+	case *ssa.Next:
+		return m.MapNext(v)
+	case *ssa.RunDefers:
+		return m.MapRunDefers(v)
+	case *ssa.Panic:
+		return m.MapPanic(v)
 	default:
-		spew.Dump(instr)
-		// return fmt.Errorf("unsupported instruction type: %T", instr)
+		return unsupported(diag.Instruction, "unsupported instruction %T", instr)
 	}
-	return nil
 }
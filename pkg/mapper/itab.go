@@ -0,0 +1,120 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+
+	"github.com/algoboyz/garm/pkg/ir"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// Itab layout, the method table holds a code pointer for every method of the
+// interface in method set order
+const (
+	itabInter = 0  // .quad interface type descriptor
+	itabType  = 8  // .quad concrete type descriptor
+	itabHash  = 16 // .word hash of the concrete type
+	itabFun   = 24 // method table
+)
+
+// itab returns the label of the itab pairing the concrete type t with the
+// interface iface, emitting it on first use
+func (m *SSAMapper) itab(t, iface types.Type) (string, error) {
+	label := fmt.Sprintf("go.itab.%s,%s", typeString(t), typeString(iface))
+	if _, ok := m.data.Lookup(label); ok {
+		return label, nil
+	}
+	methods := types.NewMethodSet(iface)
+	tab := &ir.Global{
+		Label:   label,
+		Section: ir.SectionRodata,
+		Size:    itabFun + methods.Len()*8,
+		Align:   8,
+		Comment: fmt.Sprintf("itab %s, %s", typeString(t), typeString(iface)),
+	}
+	tab.Set(itabInter, 8, ir.Symbol(m.typeDescriptor(iface)))
+	tab.Set(itabType, 8, ir.Symbol(m.typeDescriptor(t)))
+	tab.Set(itabHash, 4, fmt.Sprint(typeHashOf(typeString(t))))
+
+	// The data word of an interface holds a pointer to values that are not
+	// pointer shaped, their methods are called through the pointer receiver
+	recv := t
+	if !isPointerShaped(t) {
+		recv = types.NewPointer(t)
+	}
+	for i := range methods.Len() {
+		method := methods.At(i).Obj()
+		sel := m.prog.MethodSets.MethodSet(recv).Lookup(method.Pkg(), method.Name())
+		if sel == nil {
+			return "", fmt.Errorf("%s does not implement %s: missing method %s", t, iface, method.Name())
+		}
+		tab.Set(itabFun+i*8, 8, ir.Symbol(m.funcLabel(m.prog.MethodValue(sel))))
+	}
+	m.data.Add(tab)
+	return label, nil
+}
+
+// methodIndex returns the slot of method in the method table of itabs for iface
+func (m *SSAMapper) methodIndex(iface types.Type, method *types.Func) (int, error) {
+	methods := types.NewMethodSet(iface)
+	for i := range methods.Len() {
+		if methods.At(i).Obj().Id() == method.Id() {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("method %s not found in %s", method.Name(), iface)
+}
+
+// isPointerShaped reports whether values of t fit the data word of an interface
+func isPointerShaped(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Pointer, *types.Map, *types.Chan, *types.Signature:
+		return true
+	case *types.Basic:
+		return u.Kind() == types.UnsafePointer
+	}
+	return false
+}
+
+// linkItabs emits the itabs type assertions to interfaces may look up at run
+// time, pairing every dynamic type of an interface value with every asserted
+// interface it implements. go.itablinks lists them for runtime.getitab
+func (m *SSAMapper) linkItabs() error {
+	if m.asserted.Len() == 0 {
+		return nil
+	}
+	var labels []string
+	for _, iface := range sortedTypes(&m.asserted) {
+		for _, t := range sortedTypes(&m.concrete) {
+			if !types.Implements(t, iface.Underlying().(*types.Interface)) {
+				continue
+			}
+			label, err := m.itab(t, iface)
+			if err != nil {
+				return err
+			}
+			labels = append(labels, label)
+		}
+	}
+	links := &ir.Global{
+		Label:   "go.itablinks",
+		Section: ir.SectionRodata,
+		Size:    (len(labels) + 1) * 8,
+		Align:   8,
+		Comment: "itabs for runtime.getitab",
+	}
+	links.Set(0, 8, fmt.Sprint(len(labels)))
+	for i, label := range labels {
+		links.Set((i+1)*8, 8, ir.Symbol(label))
+	}
+	m.data.Add(links)
+	return nil
+}
+
+// sortedTypes returns the keys of a type map in a stable order
+func sortedTypes(set *typeutil.Map) []types.Type {
+	keys := set.Keys()
+	sort.Slice(keys, func(i, j int) bool { return typeString(keys[i]) < typeString(keys[j]) })
+	return keys
+}
@@ -1,6 +1,13 @@
 package mapper
 
-import "fmt"
+import (
+	"fmt"
+	"go/types"
+	"strings"
+
+	"github.com/algoboyz/garm/pkg/ir"
+	"golang.org/x/tools/go/ssa"
+)
 
 // LabelGenerator handles creation of unique labels
 type LabelGenerator struct {
@@ -53,3 +60,86 @@ func (lm *LabelManager) Generate(prefix string) string {
 func (lm *LabelManager) MarkUsed(label string) {
 	lm.used[label] = true
 }
+
+// funcLabel returns the package qualified symbol of a function eg main.add.
+// Methods are qualified by their receiver eg main.rect.area and
+// main.(*rect).area, this covers the wrappers the SSA builder synthesizes.
+// Anonymous functions follow the gc naming eg main.main.func1, bound method
+// values and method expressions get a -fm and -thunk suffix
+func (m *SSAMapper) funcLabel(fn *ssa.Function) string {
+	if recv := fn.Signature.Recv(); recv != nil {
+		return methodLabel(recv.Type(), fn.Name())
+	}
+	if parent := fn.Parent(); parent != nil {
+		index := fn.Name()[strings.LastIndexByte(fn.Name(), '$')+1:]
+		if parent.Parent() != nil {
+			return m.funcLabel(parent) + "." + index
+		}
+		if parent.Synthetic != "" {
+			// Closures in package level initializers
+			return symbolPrefix(parent.Pkg.Pkg) + ".glob..func" + index
+		}
+		return m.funcLabel(parent) + ".func" + index
+	}
+	// Functions of dependencies loaded from export data are synthetic too
+	if obj, ok := fn.Object().(*types.Func); ok && fn.Synthetic != "" && obj.Type().(*types.Signature).Recv() != nil {
+		recv := obj.Type().(*types.Signature).Recv().Type()
+		switch {
+		case strings.HasSuffix(fn.Name(), "$bound"):
+			return methodLabel(recv, obj.Name()) + "-fm"
+		case strings.HasSuffix(fn.Name(), "$thunk"):
+			return methodLabel(recv, obj.Name()) + "-thunk"
+		}
+	}
+	pkg := fn.Pkg
+	if origin := fn.Origin(); origin != nil {
+		// Instantiations belong to no package, eg main.first[int]
+		pkg = origin.Pkg
+	}
+	if pkg == nil {
+		return fn.Name()
+	}
+	return symbolPrefix(pkg.Pkg) + "." + fn.Name()
+}
+
+// methodLabel returns the symbol of the method name of the receiver type recv
+func methodLabel(recv types.Type, name string) string {
+	ptr, isPtr := recv.(*types.Pointer)
+	if isPtr {
+		recv = ptr.Elem()
+	}
+	named, ok := types.Unalias(recv).(*types.Named)
+	if !ok {
+		return typeString(recv) + "." + name
+	}
+	obj := named.Obj()
+	var prefix string
+	if obj.Pkg() != nil {
+		prefix = symbolPrefix(obj.Pkg()) + "."
+	}
+	if isPtr {
+		return fmt.Sprintf("%s(*%s).%s", prefix, obj.Name(), name)
+	}
+	return prefix + obj.Name() + "." + name
+}
+
+// blockLabel returns the local label of a basic block in the current function
+func (m *SSAMapper) blockLabel(block *ssa.BasicBlock) string {
+	if label, ok := m.labelMap[block]; ok {
+		return label
+	}
+	label := ir.Symbol(fmt.Sprintf(".L%s.%d", m.currentIR.Label, block.Index))
+	m.labelMap[block] = label
+	return label
+}
+
+// returnLabel returns the local label of the current function epilogue
+func (m *SSAMapper) returnLabel() string {
+	return ir.Symbol(fmt.Sprintf(".L%s.ret", m.currentIR.Label))
+}
+
+// localLabel returns a fresh local label for control flow within an instruction
+func (m *SSAMapper) localLabel(kind string) string {
+	m.labelCount++
+	return ir.Symbol(fmt.Sprintf(".L%s.%s%d", m.currentIR.Label, kind, m.labelCount))
+}
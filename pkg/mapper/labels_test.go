@@ -0,0 +1,38 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapMethods(t *testing.T) {
+	fns, m := compile(t, `package main
+
+type counter int
+
+func (c counter) get() int { return int(c) }
+func (c *counter) inc()    { *c = *c + 1 }
+
+type getter interface{ get() int }
+
+func use(c *counter) int {
+	c.inc()
+	var g getter = c
+	return g.get()
+}
+
+func main() {}
+`)
+	for _, label := range []string{"main.counter.get", "main.(*counter).get", "main.(*counter).inc"} {
+		assert.Contains(t, fns, label)
+	}
+	assert.Contains(t, fns["main.use"], `BL "main.(*counter).inc"`, "static method call")
+	assert.Contains(t, fns["main.(*counter).get"], "BL runtime.panicwrap", "wrapper checks for nil")
+	assert.Contains(t, fns["main.(*counter).get"], "BL main.counter.get", "wrapper calls the value method")
+
+	tab, ok := m.Data().Lookup("go.itab.*main.counter,main.getter")
+	require.True(t, ok)
+	assert.Contains(t, tab.String(false), `.quad "main.(*counter).get"`)
+}
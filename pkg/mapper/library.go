@@ -0,0 +1,276 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// The standard library is not compiled, the functions programs use are
+// substituted instead. Keys are qualified by the package path eg
+// math/bits.OnesCount64 and os.(*File).Write
+
+// Standard library functions implemented by the runtime, see pkg/asm/fmt.asm
+// and pkg/asm/string.asm. The routines follow the calling convention of the
+// functions they replace
+var libraryRoutines = map[string]string{
+	"fmt.Print":              "runtime.fmtprint",        // x0 &operands -> x0 n, x1-x2 error
+	"fmt.Println":            "runtime.fmtprintln",      // x0 &operands -> x0 n, x1-x2 error
+	"os.Exit":                "runtime.exit",            // x0 code
+	"os.(*File).Write":       "runtime.filewritebytes",  // x0 file, x1 &bytes -> x0 n, x1-x2 error
+	"os.(*File).WriteString": "runtime.filewrite",       // x0 file, x1-x2 string -> x0 n, x1-x2 error
+	"strings.Index":          "runtime.stringindex",     // x0-x1 s, x2-x3 substr -> x0 index
+	"strings.Contains":       "runtime.stringcontains",  // x0-x1 s, x2-x3 substr -> x0 bool
+	"strings.HasPrefix":      "runtime.stringhasprefix", // x0-x1 s, x2-x3 prefix -> x0 bool
+	"bytes.Equal":            "runtime.bytesequal",      // x0 &a, x1 &b -> x0 bool
+}
+
+// intrinsic lowers a call of a standard library function inline
+type intrinsic func(m *SSAMapper, expr *ssa.Call) error
+
+// Standard library functions lowered to a few instructions
+var libraryIntrinsics = map[string]intrinsic{
+	"math.Sqrt":            floatIntrinsic(op.FSQRT),
+	"math.Abs":             floatIntrinsic(op.FABS),
+	"math.Floor":           floatIntrinsic(op.FRINTM),
+	"math.Ceil":            floatIntrinsic(op.FRINTP),
+	"math.Trunc":           floatIntrinsic(op.FRINTZ),
+	"math.Round":           floatIntrinsic(op.FRINTA),
+	"math.Min":             floatIntrinsic(op.FMIN),
+	"math.Max":             floatIntrinsic(op.FMAX),
+	"math.FMA":             floatIntrinsic(op.FMADD),
+	"math.Float64bits":     bitsIntrinsic(8, op.FMOV),
+	"math.Float64frombits": bitsIntrinsic(8, op.FMOV),
+	"math.Float32bits":     bitsIntrinsic(4, op.FMOV),
+	"math.Float32frombits": bitsIntrinsic(4, op.FMOV),
+
+	"math/bits.LeadingZeros":    bitsIntrinsic(8, op.CLZ),
+	"math/bits.LeadingZeros64":  bitsIntrinsic(8, op.CLZ),
+	"math/bits.LeadingZeros32":  bitsIntrinsic(4, op.CLZ),
+	"math/bits.TrailingZeros":   bitsIntrinsic(8, op.RBIT, op.CLZ),
+	"math/bits.TrailingZeros64": bitsIntrinsic(8, op.RBIT, op.CLZ),
+	"math/bits.TrailingZeros32": bitsIntrinsic(4, op.RBIT, op.CLZ),
+	"math/bits.Reverse":         bitsIntrinsic(8, op.RBIT),
+	"math/bits.Reverse64":       bitsIntrinsic(8, op.RBIT),
+	"math/bits.Reverse32":       bitsIntrinsic(4, op.RBIT),
+	"math/bits.ReverseBytes":    bitsIntrinsic(8, op.REV),
+	"math/bits.ReverseBytes64":  bitsIntrinsic(8, op.REV),
+	"math/bits.ReverseBytes32":  bitsIntrinsic(4, op.REV),
+	"math/bits.Len":             lenIntrinsic(8),
+	"math/bits.Len64":           lenIntrinsic(8),
+	"math/bits.Len32":           lenIntrinsic(4),
+	"math/bits.OnesCount":       (*SSAMapper).onesCount,
+	"math/bits.OnesCount64":     (*SSAMapper).onesCount,
+	"math/bits.OnesCount32":     (*SSAMapper).onesCount,
+}
+
+// Package level variables of the standard library defined by the runtime
+var libraryGlobals = map[string]string{
+	"os.Stdin":  "runtime.stdin",
+	"os.Stdout": "runtime.stdout",
+	"os.Stderr": "runtime.stderr",
+}
+
+// libraryName returns the package path qualified name of a function eg
+// math/bits.Len or os.(*File).Write, empty for functions of no package
+func (m *SSAMapper) libraryName(fn *ssa.Function) string {
+	obj, ok := fn.Object().(*types.Func)
+	if !ok || obj.Pkg() == nil {
+		return ""
+	}
+	pkg := obj.Pkg()
+	return pkg.Path() + strings.TrimPrefix(m.funcLabel(fn), symbolPrefix(pkg))
+}
+
+// external reports whether fn is a function garm does not compile, one of a
+// package loaded from export data or declared without a body
+func external(fn *ssa.Function) bool {
+	return fn.Blocks == nil && fn.Name() != "init"
+}
+
+// unresolved records a reference to an external function nothing substitutes
+func (m *SSAMapper) unresolved(fn *ssa.Function) {
+	if m.externals == nil {
+		m.externals = make(map[string]bool)
+	}
+	m.externals[m.libraryName(fn)] = true
+}
+
+// substitute records a call of a standard library function substituted by
+// the runtime or inline
+func (m *SSAMapper) substitute(name string) {
+	if m.substituted == nil {
+		m.substituted = make(map[string]bool)
+	}
+	m.substituted[name] = true
+}
+
+// Unresolved returns the external functions the program references that
+// neither the runtime nor an inline sequence substitutes, sorted by name
+func (m *SSAMapper) Unresolved() []string {
+	return sortedKeys(m.externals)
+}
+
+// Report lists what became of the functions a program references
+type Report struct {
+	Compiled    []string // compiled from source
+	Substituted []string // replaced by runtime routines or inline sequences
+	Unsupported []string // external functions nothing substitutes and functions using unsupported constructs
+}
+
+// Report returns the functions mapped so far by what became of them, each
+// list sorted by name
+func (m *SSAMapper) Report() Report {
+	compiled := make(map[string]bool, len(m.compiled))
+	unsupported := make(map[string]bool, len(m.externals)+len(m.stubs))
+	for fn := range m.compiled {
+		if label := m.funcLabel(fn); fn.Blocks != nil && !m.stubs[label] {
+			compiled[label] = true
+		}
+	}
+	for name := range m.externals {
+		unsupported[name] = true
+	}
+	for label := range m.stubs {
+		unsupported[label] = true
+	}
+	return Report{
+		Compiled:    sortedKeys(compiled),
+		Substituted: sortedKeys(m.substituted),
+		Unsupported: sortedKeys(unsupported),
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// floatIntrinsic applies a floating point instruction to the operands
+func floatIntrinsic(o op.Op) intrinsic {
+	return func(m *SSAMapper, expr *ssa.Call) error {
+		return m.intrinsic(expr, func(dst *reg.Register, args []*reg.Register) {
+			src := make([]reg.Operand, len(args))
+			for i, arg := range args {
+				src[i] = reg.NewRegOperand(arg.String())
+			}
+			m.emit(ir.Instruction{Op: o, Dst: dst, Src: src, Comment: intrinsicComment(expr)})
+		})
+	}
+}
+
+// bitsIntrinsic applies a chain of instructions of the given operand size to
+// a single operand, each one reading the result of the previous
+func bitsIntrinsic(size int, ops ...op.Op) intrinsic {
+	return func(m *SSAMapper, expr *ssa.Call) error {
+		return m.intrinsic(expr, func(dst *reg.Register, args []*reg.Register) {
+			src, comment := args[0].Sized(size), intrinsicComment(expr)
+			for _, o := range ops {
+				m.emit(ir.Instruction{
+					Op:      o,
+					Dst:     dst.Sized(size),
+					Src:     []reg.Operand{reg.NewRegOperand(src.String())},
+					Comment: comment,
+				})
+				src, comment = dst.Sized(size), ""
+			}
+		})
+	}
+}
+
+// lenIntrinsic computes bits.Len as the width less the leading zeros
+func lenIntrinsic(size int) intrinsic {
+	return func(m *SSAMapper, expr *ssa.Call) error {
+		return m.intrinsic(expr, func(dst *reg.Register, args []*reg.Register) {
+			comment := intrinsicComment(expr)
+			m.emit(ir.Instruction{
+				Op:      op.CLZ,
+				Dst:     dst.Sized(size),
+				Src:     []reg.Operand{reg.NewRegOperand(args[0].Sized(size).String())},
+				Comment: comment,
+			}, ir.Instruction{
+				Op:  op.SUB,
+				Dst: dst,
+				Src: []reg.Operand{reg.NewRegOperand(dst.String()), reg.NewImmediateOperand(fmt.Sprint(size * 8))},
+			}, ir.Instruction{
+				Op:  op.NEG,
+				Dst: dst,
+				Src: []reg.Operand{reg.NewRegOperand(dst.String())},
+			})
+		})
+	}
+}
+
+// onesCount counts the set bits in a SIMD register, CNT counts them per byte
+// and ADDV sums the bytes. Unsigned operands are kept zero extended so every
+// width counts all 64 bits
+func (m *SSAMapper) onesCount(expr *ssa.Call) error {
+	loc, err := m.alloc.AllocateRegister(alloc.TypeSet.Float64)
+	if err != nil {
+		return fmt.Errorf("allocating vector register: %w", err)
+	}
+	defer m.alloc.Free(loc)
+	v := loc.GetRegister()
+	bytes := &reg.Register{ID: v.ID, Name: fmt.Sprintf("v%d.8b", v.ID), Class: reg.RegisterClassVec}
+	sum := &reg.Register{ID: v.ID, Name: fmt.Sprintf("b%d", v.ID), Class: reg.RegisterClassVec}
+	return m.intrinsic(expr, func(dst *reg.Register, args []*reg.Register) {
+		m.emit(ir.Instruction{
+			Op:      op.FMOV,
+			Dst:     v,
+			Src:     []reg.Operand{reg.NewRegOperand(args[0].String())},
+			Comment: intrinsicComment(expr),
+		}, ir.Instruction{
+			Op:  op.CNT,
+			Dst: bytes,
+			Src: []reg.Operand{reg.NewRegOperand(bytes.String())},
+		}, ir.Instruction{
+			Op:  op.ADDV,
+			Dst: sum,
+			Src: []reg.Operand{reg.NewRegOperand(bytes.String())},
+		}, ir.Instruction{
+			Op:  op.FMOV,
+			Dst: dst,
+			Src: []reg.Operand{reg.NewRegOperand(v.String())},
+		})
+	})
+}
+
+// intrinsic loads the arguments of a call and defines its result for lower
+// to fill in
+func (m *SSAMapper) intrinsic(expr *ssa.Call, lower func(dst *reg.Register, args []*reg.Register)) error {
+	var args []*reg.Register
+	for _, arg := range expr.Call.Args {
+		r, err := m.load(arg)
+		if err != nil {
+			return fmt.Errorf("loading operand %s: %w", arg.Name(), err)
+		}
+		defer m.release(arg, r)
+		args = append(args, r)
+	}
+	dst, err := m.define(expr)
+	if err != nil {
+		return err
+	}
+	lower(dst, args)
+	return nil
+}
+
+func intrinsicComment(expr *ssa.Call) string {
+	callee := expr.Call.StaticCallee()
+	names := make([]string, len(expr.Call.Args))
+	for i, arg := range expr.Call.Args {
+		names[i] = arg.Name()
+	}
+	return fmt.Sprintf("%s = %s.%s(%s)", expr.Name(), callee.Object().Pkg().Name(), callee.Name(), strings.Join(names, ", "))
+}
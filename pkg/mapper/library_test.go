@@ -0,0 +1,42 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapLibrary(t *testing.T) {
+	fns, m := compile(t, `package main
+
+import (
+	"bytes"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+func root(x float64) float64 { return math.Sqrt(math.Abs(x)) }
+
+func count(x uint64) int { return bits.LeadingZeros64(x) + bits.OnesCount64(x) }
+
+func search(s string, b []byte) bool { return strings.Index(s, "x") > 0 && bytes.Equal(b, b) }
+
+func repeat() string { return strings.Repeat("a", 3) }
+
+func main() {}
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	root := fns["main.root"]
+	assert.Regexp(t, `FABS d\d+, d\d+\n\tFSQRT d\d+, d\d+\n`, root)
+	assert.NotContains(t, root, "BL ")
+
+	count := fns["main.count"]
+	assert.Regexp(t, `CLZ x\d+, x\d+\n`, count)
+	assert.Regexp(t, `FMOV d\d+, x\d+\n\tCNT v\d+\.8b, v\d+\.8b\n\tADDV b\d+, v\d+\.8b\n\tFMOV x\d+, d\d+\n`, count)
+
+	search := fns["main.search"]
+	assert.Contains(t, search, "BL runtime.stringindex\n")
+	assert.Contains(t, search, "BL runtime.bytesequal\n")
+
+	assert.Equal(t, []string{"strings.Repeat"}, m.Unresolved())
+}
@@ -1,10 +1,10 @@
 package mapper
 
 import (
-	"fmt"
 	"go/types"
 
 	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
 )
 
 func (m *SSAMapper) MapLiteral(name string, lit types.Type) (alloc.ARM64Type, error) {
@@ -20,7 +20,7 @@ func (m *SSAMapper) MapLiteral(name string, lit types.Type) (alloc.ARM64Type, er
 		typ = alloc.String
 		size = alloc.AlignSize(len(lit.String())+1, alloc.WordSize) // +1 for null terminator
 	default:
-		return nil, fmt.Errorf("unsupported literal type: %s", lit)
+		return nil, unsupported(diag.Constant, "unsupported literal type: %s", lit)
 	}
 
 	return alloc.NewType(name, lit.String(), typ, size), nil
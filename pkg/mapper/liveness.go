@@ -0,0 +1,154 @@
+package mapper
+
+import (
+	"slices"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"golang.org/x/tools/go/ssa"
+)
+
+// liveRange spans the instruction positions, counted in block order, from
+// where a value gets its register to its last use. Values keep a register
+// for the whole function, so a value live anywhere in a loop is live over
+// all of it and two values whose ranges do not overlap can share a register
+type liveRange struct {
+	start, end int
+}
+
+// liveness holds the live ranges of the current function
+type liveness struct {
+	positions map[ssa.Instruction]int
+	ranges    map[ssa.Value]liveRange
+	expiring  []ssa.Value // ordered by the end of their range
+	phis      []*ssa.Phi  // φ-nodes held in registers, ordered by the start of their range
+	position  int         // instruction being mapped
+}
+
+// computeLiveness numbers the instructions of fn and finds the live range
+// of every value defined by it. φ-nodes are assigned at the end of their
+// predecessors so their range starts at the first of them
+func (m *SSAMapper) computeLiveness(fn *ssa.Function) {
+	l := &liveness{
+		positions: make(map[ssa.Instruction]int),
+		ranges:    make(map[ssa.Value]liveRange),
+		position:  -1,
+	}
+	first := make(map[*ssa.BasicBlock]int, len(fn.Blocks))
+	last := make(map[*ssa.BasicBlock]int, len(fn.Blocks))
+	for _, b := range fn.Blocks {
+		first[b] = len(l.positions)
+		for _, instr := range b.Instrs {
+			l.positions[instr] = len(l.positions)
+		}
+		last[b] = len(l.positions) - 1
+	}
+
+	var values []ssa.Value
+	for _, p := range fn.Params {
+		values = append(values, p)
+	}
+	for _, fv := range fn.FreeVars {
+		values = append(values, fv)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if v, ok := instr.(ssa.Value); ok {
+				values = append(values, v)
+			}
+		}
+	}
+	for _, v := range values {
+		var def *ssa.BasicBlock
+		r := liveRange{start: -1, end: -1} // parameters and free variables
+		if instr, ok := v.(ssa.Instruction); ok {
+			def = instr.Block()
+			r = liveRange{start: l.positions[instr], end: l.positions[instr]}
+		}
+		at := func(pos int) {
+			r.start, r.end = min(r.start, pos), max(r.end, pos)
+		}
+		seen := make(map[*ssa.BasicBlock]bool)
+		var liveIn, liveOut func(b *ssa.BasicBlock)
+		liveIn = func(b *ssa.BasicBlock) {
+			if b == def || seen[b] {
+				return
+			}
+			seen[b] = true
+			at(first[b])
+			for _, pred := range b.Preds {
+				liveOut(pred)
+			}
+		}
+		liveOut = func(b *ssa.BasicBlock) {
+			at(last[b])
+			liveIn(b)
+		}
+		if phi, ok := v.(*ssa.Phi); ok {
+			// Assigned on every incoming edge
+			for _, pred := range phi.Block().Preds {
+				at(last[pred])
+			}
+		}
+		if refs := v.Referrers(); refs != nil {
+			for _, ref := range *refs {
+				if phi, ok := ref.(*ssa.Phi); ok {
+					for i, edge := range phi.Edges {
+						if edge == v {
+							liveOut(phi.Block().Preds[i])
+						}
+					}
+					continue
+				}
+				at(l.positions[ref])
+				if ref.Block() == fn.Recover {
+					// Entered from wherever a deferred call recovers, any
+					// instruction after the defer may be the last one run
+					at(len(l.positions) - 1)
+				}
+				if ref.Block() != def {
+					liveIn(ref.Block())
+				}
+			}
+		}
+		l.ranges[v] = r
+		l.expiring = append(l.expiring, v)
+		if phi, ok := v.(*ssa.Phi); ok && !m.isAggregate(phi.Type()) {
+			l.phis = append(l.phis, phi)
+		}
+	}
+	slices.SortStableFunc(l.expiring, func(a, b ssa.Value) int { return l.ranges[a].end - l.ranges[b].end })
+	slices.SortStableFunc(l.phis, func(a, b *ssa.Phi) int { return l.ranges[a].start - l.ranges[b].start })
+	m.live = l
+}
+
+// advance moves to instr: the registers of values used for the last time
+// before it are freed, then φ-nodes assigned from here on get theirs
+func (m *SSAMapper) advance(instr ssa.Instruction) error {
+	l := m.live
+	l.position = l.positions[instr]
+	for len(l.expiring) > 0 && l.ranges[l.expiring[0]].end < l.position {
+		v := l.expiring[0]
+		l.expiring = l.expiring[1:]
+		if loc, ok := m.currentIR.Locals[v.Name()]; ok && loc.IsRegister() {
+			m.alloc.Free(alloc.NewRegisterLocation(loc.GetRegister()))
+		}
+	}
+	for len(l.phis) > 0 && l.ranges[l.phis[0]].start <= l.position {
+		phi := l.phis[0]
+		l.phis = l.phis[1:]
+		if _, err := m.define(phi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dead reports whether v was used for the last time before the current
+// instruction, its register may hold another value by now
+func (m *SSAMapper) dead(v ssa.Value) bool {
+	if m.live == nil {
+		return false
+	}
+	r, ok := m.live.ranges[v]
+	return ok && r.end < m.live.position
+}
@@ -0,0 +1,97 @@
+package mapper
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/go/ssa"
+)
+
+func TestMapRegisterReuse(t *testing.T) {
+	// Far more values than registers, each dies right after its use
+	var body strings.Builder
+	for i := range 40 {
+		fmt.Fprintf(&body, "\tv%d := n * %d\n\tprintln(v%d)\n", i, i+2, i)
+	}
+	_, m := compile(t, "package main\n\nfunc many(n int) {\n"+body.String()+"}\n\nfunc main() { many(1) }\n",
+		func(m *SSAMapper) { m.SetGC(GCNone) })
+	assert.Empty(t, m.Diagnostics().All())
+}
+
+func TestLiveRanges(t *testing.T) {
+	_, m := compile(t, `package main
+
+func loop(n int) int {
+	k := n * 3
+	t := 0
+	for i := range n {
+		t += i + k
+	}
+	return t
+}
+
+func main() { println(loop(4)) }
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	fn := m.pkgs[0].Func("loop")
+	m.reset(fn, "main.loop")
+	m.computeLiveness(fn)
+
+	var k ssa.Value
+	var header *ssa.BasicBlock
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if bin, ok := instr.(*ssa.BinOp); ok && bin.Op == token.MUL {
+				k = bin
+			}
+			if phi, ok := instr.(*ssa.Phi); ok && len(phi.Edges) == 2 {
+				header = phi.Block()
+			}
+		}
+	}
+	require.NotNil(t, k)
+	require.NotNil(t, header)
+	// k is only used in the body, the back-edge keeps it live over the loop
+	for _, pred := range header.Preds {
+		latch := pred.Instrs[len(pred.Instrs)-1]
+		assert.GreaterOrEqual(t, m.live.ranges[k].end, m.live.positions[latch])
+	}
+	params := fn.Params[0]
+	assert.Equal(t, -1, m.live.ranges[params].start, "parameters are live from the entry")
+}
+
+func TestLiveRangesRecover(t *testing.T) {
+	_, m := compile(t, `package main
+
+func safe(id int) (r int) {
+	defer func() {
+		if recover() != nil {
+			r = id
+		}
+	}()
+	for i := range 3 {
+		println(i)
+	}
+	panic("boom")
+}
+
+func main() { println(safe(3)) }
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	fn := m.pkgs[0].Func("safe")
+	m.reset(fn, "main.safe")
+	m.computeLiveness(fn)
+
+	require.NotNil(t, fn.Recover)
+	var r ssa.Value
+	for _, instr := range fn.Recover.Instrs {
+		if load, ok := instr.(*ssa.UnOp); ok && load.Op == token.MUL {
+			r = load.X
+		}
+	}
+	require.NotNil(t, r)
+	// The panic may recover after the last instruction of the loop
+	assert.Equal(t, len(m.live.positions)-1, m.live.ranges[r].end)
+}
@@ -2,49 +2,119 @@ package mapper
 
 import (
 	"fmt"
+	"go/types"
+	"os"
+	"strings"
 
 	"github.com/algoboyz/garm/pkg/alloc"
 	"github.com/algoboyz/garm/pkg/dbg"
+	"github.com/algoboyz/garm/pkg/diag"
 	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/reg"
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/ssa"
-	"golang.org/x/tools/go/ssa/ssautil"
+	"golang.org/x/tools/go/types/typeutil"
 )
 
 type SSAMapper struct {
 	// SSA-specific context tracking
 	prog         *ssa.Program
 	pkgs         []*ssa.Package
+	deps         []*ssa.Package // dependencies compiled on demand
+	compileDeps  bool
 	currentFunc  *ssa.Function
 	currentBlock *ssa.BasicBlock
+	currentInstr ssa.Instruction
 	currentIR    *ir.Function
 	labelMap     map[*ssa.BasicBlock]string
+	labelCount   int
 	alloc        alloc.Allocator
+	frameIndex   int                   // prologue instruction reserving the frame
+	saveArea     *alloc.MemoryLocation // caller saved registers spilled around calls
+	saved        map[reg.Register]int  // registers spilled for the current call
+	sizes        types.Sizes
+	data         *ir.Data
+	folded       map[*ssa.Store]bool // stores emitted as static initializers
+	concrete     typeutil.Map        // dynamic types of interface values
+	asserted     typeutil.Map        // interfaces targeted by type assertions
+	compiled     map[*ssa.Function]bool
+	externals    map[string]bool                      // external functions referenced but not substituted
+	diags        diag.List                            // unsupported constructs
+	unsupported  bool                                 // the current function uses one, only diagnostics are collected
+	stubs        map[string]bool                      // functions replaced by a stub that panics
+	failed       map[ssa.Instruction]bool             // instructions that did not compile, tracked by Check
+	substituted  map[string]bool                      // standard library functions replaced by the runtime or inline
+	pending      []*ssa.Function                      // nested and synthetic functions still to compile
+	wrappers     []builtinWrapper                     // deferred and started builtins still to compile
+	wrapper      *builtinWrapper                      // the wrapper being compiled, currentFunc is nil
+	live         *liveness                            // live ranges of the values of currentFunc
+	frameObjects map[*ssa.Alloc]*alloc.MemoryLocation // locals allocated in the frame
+	stackMaps    []stackMap
+	globalRoots  []string // global words holding pointers
+	gc           GC
+	scheduler    bool // the program starts goroutines or blocks on channels
+	threads      int  // OS threads running goroutines
+	lse          bool // ARMv8.1 atomic instructions are available
 	debug        *dbg.Debugger
 }
 
 func NewSSAMapper(debug *dbg.Debugger) *SSAMapper {
-	return &SSAMapper{alloc: alloc.NewAllocator(), debug: debug}
+	return &SSAMapper{
+		alloc:    alloc.NewAllocator(),
+		sizes:    types.SizesFor("gc", "arm64"),
+		data:     ir.NewData(),
+		folded:   make(map[*ssa.Store]bool),
+		compiled: make(map[*ssa.Function]bool),
+		gc:       GCMarkSweep,
+		threads:  1,
+		lse:      true,
+		debug:    debug,
+	}
+}
+
+// Data returns the package level symbols collected while mapping
+func (m *SSAMapper) Data() *ir.Data {
+	return m.data
+}
+
+// emit appends instructions to the function currently being mapped
+func (m *SSAMapper) emit(instrs ...ir.Instruction) {
+	m.currentIR.Blocks = append(m.currentIR.Blocks, instrs...)
 }
 
 // LoadPackage loads and builds SSA for a Go package
 func (m *SSAMapper) Load(path string) error {
 	cfg := &packages.Config{
-		Mode: packages.NeedFiles |
+		Mode: packages.NeedName |
+			packages.NeedFiles |
+			packages.NeedImports |
 			packages.NeedSyntax |
 			packages.NeedTypesInfo |
 			packages.NeedTypes |
 			packages.NeedTypesSizes |
+			packages.NeedModule |
 			packages.NeedDeps,
 	}
 
+	// go list takes a missing file for a package of the standard library
+	if strings.HasSuffix(path, ".go") {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
 	pkgs, err := packages.Load(cfg, path)
 	if err != nil {
 		return fmt.Errorf("loading package: %w", err)
 	}
 
 	// Create SSA program
-	m.prog, m.pkgs = ssautil.Packages(pkgs, ssa.BuilderMode(ssa.SanityCheckFunctions))
+	if len(pkgs) == 0 {
+		return fmt.Errorf("no packages matching %s", path)
+	}
+	if err = packageErrors(pkgs); err != nil {
+		return err
+	}
+	m.prog, m.pkgs, m.deps = m.createPackages(pkgs)
 	m.prog.Build()
 
 	return nil
@@ -52,17 +122,69 @@ func (m *SSAMapper) Load(path string) error {
 
 // // MapPackage processes an entire SSA package
 func (m *SSAMapper) MapPackage() (fns []*ir.Function, err error) {
+	order := m.initOrder()
+	for _, pkg := range order {
+		if err = m.MapGlobals(pkg); err != nil {
+			return nil, fmt.Errorf("mapping globals of %s: %w", pkg.Pkg.Path(), err)
+		}
+	}
+	m.scheduler = m.schedules(order)
 	// Process all functions in the package
-	for _, pkg := range m.pkgs {
-		for _, member := range pkg.Members {
-			if fn, ok := member.(*ssa.Function); ok {
-				fun, err := m.MapFunction(fn)
-				if err != nil {
-					return nil, fmt.Errorf("mapping function %s: %w", fn.Name(), err)
-				}
-				fns = append(fns, fun)
+	for _, pkg := range order {
+		members := m.packageFunctions(pkg)
+		if m.onDemand(pkg) {
+			members = []*ssa.Function{pkg.Func("init")}
+		}
+		for _, fn := range members {
+			if fns, err = m.mapFunctions(fns, fn); err != nil {
+				return nil, err
 			}
 		}
 	}
+	// Closures and wrappers referenced by the code compiled so far
+	for len(m.pending) > 0 {
+		fn := m.pending[0]
+		m.pending = m.pending[1:]
+		if fns, err = m.mapFunctions(fns, fn); err != nil {
+			return nil, err
+		}
+	}
+	for _, w := range m.wrappers {
+		fun, err := m.mapWrapper(w)
+		if err != nil {
+			return nil, fmt.Errorf("mapping wrapper %s: %w", w.label, err)
+		}
+		fns = append(fns, fun)
+	}
+	if err = m.linkItabs(); err != nil {
+		return nil, err
+	}
+	m.linkStackMaps()
+	m.linkGlobalRoots()
+	m.linkScheduler()
+	if entry := m.MapEntry(order); entry != nil {
+		fns = append(fns, entry)
+	}
+	return fns, nil
+}
+
+// mapFunctions appends fn followed by the anonymous functions nested in it
+func (m *SSAMapper) mapFunctions(fns []*ir.Function, fn *ssa.Function) ([]*ir.Function, error) {
+	if m.compiled[fn] {
+		return fns, nil
+	}
+	m.compiled[fn] = true
+	fun, err := m.MapFunction(fn)
+	if err != nil {
+		return nil, fmt.Errorf("mapping function %s: %w", fn.Name(), err)
+	}
+	if fun != nil {
+		fns = append(fns, fun)
+	}
+	for _, anon := range fn.AnonFuncs {
+		if fns, err = m.mapFunctions(fns, anon); err != nil {
+			return nil, err
+		}
+	}
 	return fns, nil
 }
@@ -0,0 +1,53 @@
+package mapper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/algoboyz/garm/pkg/dbg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// compile maps a single file program and renders the assembly of every
+// function, options are applied to the mapper before loading
+func compile(t *testing.T, src string, opts ...func(*SSAMapper)) (map[string]string, *SSAMapper) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "main.go")
+	require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+
+	m := NewSSAMapper(dbg.NewDebugger(false))
+	for _, opt := range opts {
+		opt(m)
+	}
+	require.NoError(t, m.Load(path))
+	fns, err := m.MapPackage()
+	require.NoError(t, err)
+
+	out := make(map[string]string, len(fns))
+	for _, fn := range fns {
+		var sb strings.Builder
+		for _, instr := range fn.Blocks {
+			sb.WriteString(instr.String(false))
+		}
+		out[fn.Label] = sb.String()
+	}
+	return out, m
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	require.NoError(t, os.WriteFile(path, []byte("package main\n\nfunc main() { x := 1 }\n"), 0o644))
+
+	m := NewSSAMapper(dbg.NewDebugger(false))
+	err := m.Load(path)
+	require.Error(t, err, "a package with type errors fails to load")
+	assert.Contains(t, err.Error(), "declared and not used: x")
+
+	err = NewSSAMapper(dbg.NewDebugger(false)).Load(filepath.Join(dir, "missing.go"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no such file")
+}
@@ -0,0 +1,404 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Runtime routines of maps, see pkg/asm/map.asm. Keys and elements are
+// passed by address
+const (
+	runtimeMakeMap     = "runtime.makemap"     // x0 map type, x1 hint -> x0 map
+	runtimeMapAccess   = "runtime.mapaccess"   // x0 map type, x1 map, x2 key, x3 destination -> x0 found
+	runtimeMapAssign   = "runtime.mapassign"   // x0 map, x1 key, x2 element
+	runtimeMapDelete   = "runtime.mapdelete"   // x0 map, x1 key
+	runtimeMapIterInit = "runtime.mapiterinit" // x0 iterator, x1 map
+	runtimeMapNext     = "runtime.mapnext"     // x0 iterator, x1 key or 0, x2 element or 0 -> x0 ok
+)
+
+// Map type descriptor layout shared with the runtime
+const (
+	mapTypeKey       = 0
+	mapTypeElem      = 8
+	mapTypeEntry     = 16
+	mapTypeEntrySize = 24
+	mapTypeElemOff   = 32
+	mapTypeSize      = 40
+)
+
+// Key classes of the segments of a key algorithm, see pkg/asm/map.asm
+const (
+	keyMem = iota
+	keyString
+	keyEface
+	keyIface
+	keyFloat32
+	keyFloat64
+)
+
+// keySegment is a range of a key hashed and compared alike
+type keySegment struct {
+	offset, size, class int
+}
+
+// MapMakeMap creates a map, entries are allocated by the runtime on the
+// first insert unless a size hint is given
+func (m *SSAMapper) MapMakeMap(v *ssa.MakeMap) error {
+	if m.gc == GCGenerational {
+		// The map routines hold entries in registers across the allocations
+		// of a resize, the copying collector does not update them
+		return unsupported(diag.Instruction, "maps are not supported by the generational collector, build with -gc=marksweep")
+	}
+	m.runtimePanics()
+	var hint *reg.Register
+	if v.Reserve != nil {
+		r, err := m.load(v.Reserve)
+		if err != nil {
+			return fmt.Errorf("loading size hint: %w", err)
+		}
+		defer m.release(v.Reserve, r)
+		hint = r
+	}
+	dst, err := m.define(v)
+	if err != nil {
+		return err
+	}
+	saved := m.saveRegisters()
+	if hint != nil {
+		m.move(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, hint, alloc.WordSize, "size hint")
+	} else {
+		m.movImm(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, 0)
+	}
+	m.loadAddress(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, m.mapType(v.Type()))
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeMakeMap},
+		Comment: "make " + typeString(v.Type()),
+	})
+	m.move(dst, &reg.Register{ID: 0, Class: reg.RegisterClassGPR}, alloc.WordSize, v.Name()+" = map")
+	m.restoreRegisters(saved, dst)
+	return nil
+}
+
+// MapMapUpdate stores an element under a key, the runtime applies the write
+// barrier to the entry
+func (m *SSAMapper) MapMapUpdate(v *ssa.MapUpdate) error {
+	m.runtimePanics()
+	mp, err := m.load(v.Map)
+	if err != nil {
+		return fmt.Errorf("loading map %s: %w", v.Map.Name(), err)
+	}
+	defer m.release(v.Map, mp)
+	key, err := m.valueAddress(v.Key)
+	if err != nil {
+		return err
+	}
+	defer m.release(v.Key, key)
+	val, err := m.valueAddress(v.Value)
+	if err != nil {
+		return err
+	}
+	defer m.release(v.Value, val)
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, mp, alloc.WordSize, "map "+v.Map.Name())
+	m.move(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, key, alloc.WordSize, "address of "+v.Key.Name())
+	m.move(&reg.Register{ID: 2, Class: reg.RegisterClassGPR}, val, alloc.WordSize, "address of "+v.Value.Name())
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeMapAssign},
+		Comment: fmt.Sprintf("%s[%s] = %s", v.Map.Name(), v.Key.Name(), v.Value.Name()),
+	})
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// MapLookup lowers m[k]. The runtime copies the element out like a channel
+// receive, missing keys yield the zero value
+func (m *SSAMapper) MapLookup(v *ssa.Lookup) error {
+	if _, ok := v.X.Type().Underlying().(*types.Map); !ok {
+		return unsupported(diag.Instruction, "unsupported lookup in %s", typeString(v.X.Type()))
+	}
+	m.runtimePanics()
+	mp, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading map %s: %w", v.X.Name(), err)
+	}
+	defer m.release(v.X, mp)
+	key, err := m.valueAddress(v.Index)
+	if err != nil {
+		return err
+	}
+	defer m.release(v.Index, key)
+	mem, dst, err := m.result(v, v.CommaOk, v.Name()+" (element)")
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf("%s = %s[%s]", v.Name(), v.X.Name(), v.Index.Name())
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, mp, alloc.WordSize, "map "+v.X.Name())
+	m.move(&reg.Register{ID: 2, Class: reg.RegisterClassGPR}, key, alloc.WordSize, "address of "+v.Index.Name())
+	m.slotAddress(&reg.Register{ID: 3, Class: reg.RegisterClassGPR}, mem)
+	m.loadAddress(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, m.mapType(v.X.Type()))
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeMapAccess}, Comment: comment})
+	m.slotAddress(scratchAddr, mem)
+	switch {
+	case v.CommaOk:
+		m.storeTo(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, types.Typ[types.Bool], scratchAddr, m.tupleOffsets(v.Type().(*types.Tuple))[1], "ok")
+		m.restoreRegisters(saved)
+	case dst != nil:
+		m.loadFrom(dst, v.Type(), scratchAddr, 0, comment)
+		m.restoreRegisters(saved, dst)
+	default:
+		m.restoreRegisters(saved)
+	}
+	return nil
+}
+
+// mapDelete removes a key from a map
+func (m *SSAMapper) mapDelete(expr *ssa.Call) error {
+	m.runtimePanics()
+	args := expr.Call.Args
+	mp, err := m.load(args[0])
+	if err != nil {
+		return fmt.Errorf("loading map %s: %w", args[0].Name(), err)
+	}
+	defer m.release(args[0], mp)
+	key, err := m.valueAddress(args[1])
+	if err != nil {
+		return err
+	}
+	defer m.release(args[1], key)
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, mp, alloc.WordSize, "map "+args[0].Name())
+	m.move(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, key, alloc.WordSize, "address of "+args[1].Name())
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeMapDelete},
+		Comment: fmt.Sprintf("delete(%s, %s)", args[0].Name(), args[1].Name()),
+	})
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// mapLen reads the length of a map, string or slice. The count of live
+// entries is the first word of a map, nil maps are empty
+func (m *SSAMapper) mapLen(expr *ssa.Call) error {
+	x := expr.Call.Args[0]
+	src, err := m.load(x)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", x.Name(), err)
+	}
+	defer m.release(x, src)
+	dst, err := m.define(expr)
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf("%s = len(%s)", expr.Name(), x.Name())
+	switch t := x.Type().Underlying().(type) {
+	case *types.Map:
+		done := m.localLabel("len")
+		m.move(dst, src, alloc.WordSize, "nil map is empty")
+		m.emit(ir.Instruction{Op: op.CBZ, Dst: src, Labels: []string{done}})
+		m.loadFrom(dst, types.Typ[types.Int], src, 0, comment)
+		m.emit(ir.Instruction{Labels: []string{done}})
+	case *types.Basic:
+		if t.Info()&types.IsString == 0 {
+			return unsupported(diag.Builtin, "unsupported len of %s", typeString(x.Type()))
+		}
+		m.loadFrom(dst, types.Typ[types.Int], src, alloc.WordSize, comment)
+	case *types.Slice:
+		m.loadFrom(dst, types.Typ[types.Int], src, alloc.WordSize, comment)
+	default:
+		return unsupported(diag.Builtin, "unsupported len of %s", typeString(x.Type()))
+	}
+	return nil
+}
+
+// mapRange starts iterating over a map
+func (m *SSAMapper) mapRange(v *ssa.Range, it *alloc.MemoryLocation) error {
+	mp, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading map %s: %w", v.X.Name(), err)
+	}
+	defer m.release(v.X, mp)
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, mp, alloc.WordSize, "map "+v.X.Name())
+	m.slotAddress(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, it)
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeMapIterInit},
+		Comment: fmt.Sprintf("%s = range %s", v.Name(), v.X.Name()),
+	})
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// mapNext advances an iteration over a map, the key and element are written
+// straight into the result tuple. Unused ones are dropped by the runtime
+func (m *SSAMapper) mapNext(v *ssa.Next, res *alloc.MemoryLocation) error {
+	m.runtimePanics()
+	tuple := v.Type().(*types.Tuple)
+	offsets := m.tupleOffsets(tuple)
+	it, err := m.load(v.Iter)
+	if err != nil {
+		return fmt.Errorf("loading iterator %s: %w", v.Iter.Name(), err)
+	}
+	defer m.release(v.Iter, it)
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, it, alloc.WordSize, "iterator "+v.Iter.Name())
+	for i := 1; i <= 2; i++ {
+		dst := &reg.Register{ID: uint8(i), Class: reg.RegisterClassGPR}
+		if !valid(tuple.At(i).Type()) {
+			m.movImm(dst, 0)
+			continue
+		}
+		m.slotAddress(dst, res)
+		m.addOffset(dst, dst, offsets[i], fmt.Sprintf("address of %s #%d", v.Name(), i))
+	}
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeMapNext}, Comment: v.Name() + " = next " + v.Iter.Name()})
+	m.slotAddress(scratchAddr, res)
+	m.storeTo(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, types.Typ[types.Bool], scratchAddr, offsets[0], "ok")
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// result reserves the memory a runtime routine writes the value of v to:
+// aggregates and comma-ok tuples are written into their slot, scalars go
+// through a temporary one and are loaded into dst
+func (m *SSAMapper) result(v ssa.Value, commaOk bool, name string) (*alloc.MemoryLocation, *reg.Register, error) {
+	if commaOk || m.isAggregate(v.Type()) {
+		mem, err := m.slot(v)
+		return mem, nil, err
+	}
+	mem, err := m.temporary(v.Type(), name)
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := m.define(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mem, dst, nil
+}
+
+// mapType returns the label of the rodata descriptor of the map type t,
+// emitting it on first use. Entries are laid out as struct{ctrl; key; elem}
+func (m *SSAMapper) mapType(t types.Type) string {
+	mt := t.Underlying().(*types.Map)
+	label := "maptype." + typeString(mt)
+	if _, ok := m.data.Lookup(label); ok {
+		return label
+	}
+	entry := types.NewStruct([]*types.Var{
+		types.NewField(0, nil, "ctrl", types.Typ[types.Uintptr], false),
+		types.NewField(0, nil, "key", mt.Key(), false),
+		types.NewField(0, nil, "elem", mt.Elem(), false),
+	}, nil)
+	desc := &ir.Global{
+		Label:   label,
+		Section: ir.SectionRodata,
+		Size:    mapTypeSize,
+		Align:   8,
+		Comment: "map type " + typeString(mt),
+	}
+	desc.Set(mapTypeKey, 8, ir.Symbol(m.typeDescriptor(mt.Key())))
+	desc.Set(mapTypeElem, 8, ir.Symbol(m.typeDescriptor(mt.Elem())))
+	desc.Set(mapTypeEntry, 8, ir.Symbol(m.typeDescriptor(entry)))
+	desc.Set(mapTypeEntrySize, 8, fmt.Sprint(m.sizeof(entry)))
+	desc.Set(mapTypeElemOff, 8, fmt.Sprint(m.fieldOffset(entry, 2)))
+	m.data.Add(desc)
+	return label
+}
+
+// keyAlg returns the label of the key algorithm of t, "0" when values of t
+// are not comparable. Algorithms are shared between types with the same
+// segments
+func (m *SSAMapper) keyAlg(t types.Type) string {
+	if !types.Comparable(t) {
+		return "0"
+	}
+	segs := m.keySegments(t, 0, nil)
+	parts := []string{fmt.Sprint(len(segs))}
+	for _, s := range segs {
+		parts = append(parts, fmt.Sprintf("%d.%d.%d", s.offset, s.size, s.class))
+	}
+	label := "keyalg." + strings.Join(parts, ".")
+	if _, ok := m.data.Lookup(label); ok {
+		return label
+	}
+	g := &ir.Global{
+		Label:   label,
+		Section: ir.SectionRodata,
+		Size:    8 + len(segs)*3*8,
+		Align:   8,
+	}
+	g.Set(0, 8, fmt.Sprint(len(segs)))
+	for i, s := range segs {
+		g.Set(8+i*24, 8, fmt.Sprint(s.offset))
+		g.Set(8+i*24+8, 8, fmt.Sprint(s.size))
+		g.Set(8+i*24+16, 8, fmt.Sprint(s.class))
+	}
+	m.data.Add(g)
+	return label
+}
+
+// keySegments appends the segments of a t at offset. Padding and blank
+// fields are left out, adjacent memory compared bytewise is merged
+func (m *SSAMapper) keySegments(t types.Type, offset int, segs []keySegment) []keySegment {
+	add := func(s keySegment) {
+		if n := len(segs); n > 0 && s.class == keyMem && segs[n-1].class == keyMem && segs[n-1].offset+segs[n-1].size == s.offset {
+			segs[n-1].size += s.size
+			return
+		}
+		if s.size > 0 {
+			segs = append(segs, s)
+		}
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsString != 0:
+			add(keySegment{offset, 16, keyString})
+		case u.Kind() == types.Float32:
+			add(keySegment{offset, 4, keyFloat32})
+		case u.Kind() == types.Float64:
+			add(keySegment{offset, 8, keyFloat64})
+		case u.Kind() == types.Complex64:
+			add(keySegment{offset, 4, keyFloat32})
+			add(keySegment{offset + 4, 4, keyFloat32})
+		case u.Kind() == types.Complex128:
+			add(keySegment{offset, 8, keyFloat64})
+			add(keySegment{offset + 8, 8, keyFloat64})
+		default:
+			add(keySegment{offset, m.sizeof(t), keyMem})
+		}
+	case *types.Interface:
+		if u.Empty() {
+			add(keySegment{offset, 16, keyEface})
+		} else {
+			add(keySegment{offset, 16, keyIface})
+		}
+	case *types.Array:
+		size := m.sizeof(u.Elem())
+		for i := range int(u.Len()) {
+			segs = m.keySegments(u.Elem(), offset+i*size, segs)
+		}
+	case *types.Struct:
+		for i := range u.NumFields() {
+			if u.Field(i).Name() != "_" {
+				segs = m.keySegments(u.Field(i).Type(), offset+m.fieldOffset(u, i), segs)
+			}
+		}
+	default:
+		add(keySegment{offset, m.sizeof(t), keyMem})
+	}
+	return segs
+}
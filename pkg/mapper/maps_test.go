@@ -0,0 +1,66 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapMaps(t *testing.T) {
+	fns, m := compile(t, `package main
+
+type key struct {
+	id   int32
+	_    int32
+	name string
+	w    float64
+}
+
+func count(m map[key]int) int {
+	n := new(int)
+	for _, v := range m {
+		*n += v
+	}
+	return *n
+}
+
+func main() {
+	m := make(map[key]int, 4)
+	k := key{id: 1, name: "a"}
+	m[k] = 2
+	v, ok := m[k]
+	delete(m, k)
+	_, _, _ = v, ok, len(m)
+	_ = count(m)
+}
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	main := fns["main.main"]
+	for _, routine := range []string{"makemap", "mapassign", "mapaccess", "mapdelete"} {
+		assert.Contains(t, main, "BL runtime."+routine)
+	}
+	assert.Contains(t, main, "CBZ", "len of a nil map is 0")
+	assert.Contains(t, fns["main.count"], "BL runtime.mapiterinit")
+	assert.Contains(t, fns["main.count"], "MOVZ x1, #0x0\n", "the key is dropped")
+
+	desc, ok := m.data.Lookup("maptype.map[main.key]int")
+	require.True(t, ok)
+	assert.Contains(t, desc.String(false), "\t.quad 48\n\t.quad 40\n", "entry size and element offset")
+	// The blank field is skipped, strings and floats have their own classes
+	alg, ok := m.data.Lookup("keyalg.3.0.4.0.8.16.1.24.8.5")
+	require.True(t, ok)
+	assert.Contains(t, alg.String(false), "\t.quad 3\n")
+	_, ok = m.data.Lookup("type.string")
+	assert.True(t, ok, "runtime panics carry strings")
+
+	fns, m = compile(t, `package main
+
+func main() {
+	m := map[int]int{1: 2}
+	println(m[1])
+}
+`, func(m *SSAMapper) { m.SetGC(GCGenerational) })
+	assert.Contains(t, fns["main.main"], "BL runtime.panicunsupported", "the copying collector does not update maps")
+	require.NotEmpty(t, m.Diagnostics().All())
+	assert.Contains(t, m.Diagnostics().All()[0].String(), "maps are not supported by the generational collector")
+}
@@ -0,0 +1,147 @@
+package mapper
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// reservePhis gives aggregate φ-nodes their slot before the blocks are
+// mapped, loop headers are reached from blocks mapped after them. The others
+// get a register where their live range starts, see advance
+func (m *SSAMapper) reservePhis(fn *ssa.Function) error {
+	for _, b := range fn.Blocks {
+		for _, phi := range phis(b) {
+			if !m.isAggregate(phi.Type()) {
+				continue
+			}
+			if _, err := m.slot(phi); err != nil {
+				return fmt.Errorf("reserving %s: %w", phi.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// MapPhi emits nothing, the predecessors write their edge value into the
+// location of the φ-node before branching
+func (m *SSAMapper) MapPhi(v *ssa.Phi) error {
+	return nil
+}
+
+// phis returns the φ-nodes heading a block
+func phis(b *ssa.BasicBlock) (nodes []*ssa.Phi) {
+	for _, instr := range b.Instrs {
+		phi, ok := instr.(*ssa.Phi)
+		if !ok {
+			break
+		}
+		nodes = append(nodes, phi)
+	}
+	return nodes
+}
+
+// phiMoves assigns the φ-nodes of to their values on the edge from. The
+// assignments are parallel: when a value is another φ-node of the block all
+// values are staged through temporaries first so none is overwritten early
+func (m *SSAMapper) phiMoves(from, to *ssa.BasicBlock) error {
+	nodes := phis(to)
+	if len(nodes) == 0 {
+		return nil
+	}
+	pred := slices.Index(to.Preds, from)
+	staged := false
+	for _, phi := range nodes {
+		if src, ok := phi.Edges[pred].(*ssa.Phi); ok && src != phi && src.Block() == to {
+			staged = true
+		}
+	}
+	if !staged {
+		for _, phi := range nodes {
+			if phi.Edges[pred] != phi {
+				if err := m.assignPhi(phi, phi.Edges[pred]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	addr, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating address of φ temporaries: %w", err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(addr))
+	temps := make([]*alloc.MemoryLocation, len(nodes))
+	for i, phi := range nodes {
+		if temps[i], err = m.temporary(phi.Type(), phi.Name()+" (staged)"); err != nil {
+			return err
+		}
+		m.slotAddress(addr, temps[i])
+		if err := m.storeValue(phi.Edges[pred], addr, "stage "+phi.Edges[pred].Name()); err != nil {
+			return err
+		}
+	}
+	for i, phi := range nodes {
+		m.slotAddress(addr, temps[i])
+		if err := m.loadPhi(phi, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignPhi moves v into the location of phi
+func (m *SSAMapper) assignPhi(phi *ssa.Phi, v ssa.Value) error {
+	comment := fmt.Sprintf("%s = %s", phi.Name(), v.Name())
+	loc := m.currentIR.Locals[phi.Name()]
+	if loc.IsMemory() {
+		addr, err := m.scratch()
+		if err != nil {
+			return fmt.Errorf("allocating address of %s: %w", phi.Name(), err)
+		}
+		defer m.alloc.Free(alloc.NewRegisterLocation(addr))
+		m.slotAddress(addr, loc.GetMemory())
+		return m.storeValue(v, addr, comment)
+	}
+	src, err := m.load(v)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.Name(), err)
+	}
+	defer m.release(v, src)
+	m.move(loc.GetRegister(), src, m.sizeof(phi.Type()), comment)
+	return nil
+}
+
+// loadPhi assigns phi the value staged at addr
+func (m *SSAMapper) loadPhi(phi *ssa.Phi, addr *reg.Register) error {
+	loc := m.currentIR.Locals[phi.Name()]
+	if !loc.IsMemory() {
+		m.loadFrom(loc.GetRegister(), phi.Type(), addr, 0, phi.Name()+" = staged")
+		return nil
+	}
+	dst, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating address of %s: %w", phi.Name(), err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(dst))
+	m.slotAddress(dst, loc.GetMemory())
+	return m.copyMem(dst, addr, m.sizeof(phi.Type()), phi.Name()+" = staged")
+}
+
+// storeValue writes v to the memory at addr, aggregates are copied
+func (m *SSAMapper) storeValue(v ssa.Value, addr *reg.Register, comment string) error {
+	src, err := m.load(v)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.Name(), err)
+	}
+	defer m.release(v, src)
+	if m.isAggregate(v.Type()) {
+		return m.copyMem(addr, src, m.sizeof(v.Type()), comment)
+	}
+	m.storeTo(src, v.Type(), addr, 0, comment)
+	return nil
+}
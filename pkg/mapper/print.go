@@ -0,0 +1,92 @@
+package mapper
+
+import (
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Runtime routines of the print builtins, see pkg/asm/print.asm
+const (
+	runtimePrintBool    = "runtime.printbool"    // x0 value
+	runtimePrintInt     = "runtime.printint"     // x0 value
+	runtimePrintUint    = "runtime.printuint"    // x0 value
+	runtimePrintFloat32 = "runtime.printfloat32" // s0 value
+	runtimePrintFloat64 = "runtime.printfloat64" // d0 value
+	runtimePrintString  = "runtime.printstderr"  // x0 data, x1 len
+	runtimePrintPointer = "runtime.printpointer" // x0 value
+	runtimePrintSpace   = "runtime.printsp"
+	runtimePrintNewline = "runtime.printnl"
+)
+
+// mapPrint lowers print and println to a runtime call per operand, println
+// separates them by spaces and ends the line
+func (m *SSAMapper) mapPrint(expr *ssa.Call, newline bool) error {
+	name := "print"
+	if newline {
+		name = "println"
+	}
+	for i, arg := range expr.Call.Args {
+		if newline && i > 0 {
+			if err := m.printCall(runtimePrintSpace, nil, name); err != nil {
+				return err
+			}
+		}
+		routine, ok := printRoutine(arg.Type())
+		if !ok {
+			return unsupported(diag.Builtin, "unsupported argument of %s: %s", name, typeString(arg.Type()))
+		}
+		if err := m.printCall(routine, arg, name+" "+arg.Name()); err != nil {
+			return err
+		}
+	}
+	if !newline {
+		return nil
+	}
+	return m.printCall(runtimePrintNewline, nil, name)
+}
+
+// printCall calls a print routine with an optional operand
+func (m *SSAMapper) printCall(routine string, arg ssa.Value, comment string) error {
+	saved := m.saveRegisters()
+	if arg != nil {
+		if err := m.passArgs([]ssa.Value{arg}, &abi{}); err != nil {
+			return err
+		}
+	}
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{routine}, Comment: comment})
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// printRoutine picks the routine printing a value of type t, integers are
+// kept extended to 64 bits so the sized kinds share a routine
+func printRoutine(t types.Type) (string, bool) {
+	if isPointerShaped(t) {
+		return runtimePrintPointer, true
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return "", false
+	}
+	switch {
+	case b.Info()&types.IsBoolean != 0:
+		return runtimePrintBool, true
+	case b.Kind() == types.Uintptr:
+		return runtimePrintPointer, true
+	case b.Info()&types.IsUnsigned != 0:
+		return runtimePrintUint, true
+	case b.Info()&types.IsInteger != 0:
+		return runtimePrintInt, true
+	case b.Kind() == types.Float32:
+		return runtimePrintFloat32, true
+	case b.Kind() == types.Float64:
+		return runtimePrintFloat64, true
+	case b.Info()&types.IsString != 0:
+		return runtimePrintString, true
+	}
+	return "", false
+}
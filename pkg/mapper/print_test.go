@@ -0,0 +1,49 @@
+package mapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapPrint(t *testing.T) {
+	fns, m := compile(t, `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func builtins(p *int) {
+	println(1.5, -7, uint(9), true, "x", p)
+	print(float32(0.5))
+}
+
+func files() {
+	os.Stdout.Write([]byte("bytes\n"))
+	os.Exit(3)
+}
+
+func main() {
+	fmt.Println(12.0, "s")
+	builtins(nil)
+	files()
+}
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	builtins := fns["main.builtins"]
+	for _, routine := range []string{"printfloat64", "printint", "printuint", "printbool", "printstderr", "printpointer", "printfloat32"} {
+		assert.Contains(t, builtins, "BL runtime."+routine+"\n")
+	}
+	assert.Equal(t, 5, strings.Count(builtins, "BL runtime.printsp\n"), "println separates operands")
+	assert.Equal(t, 1, strings.Count(builtins, "BL runtime.printnl\n"), "print ends no line")
+
+	assert.Contains(t, fns["main.main"], "BL runtime.fmtprintln\n")
+	files := fns["main.files"]
+	assert.Contains(t, files, "runtime.stdout", "os.Stdout is defined by the runtime")
+	assert.Contains(t, files, "BL runtime.stringtobytes\n")
+	assert.Contains(t, files, "BL runtime.filewritebytes\n")
+	assert.Contains(t, files, "BL runtime.exit\n")
+	_, ok := m.data.Lookup("type.uint8")
+	assert.True(t, ok, "byte slices are allocated with the uint8 descriptor")
+}
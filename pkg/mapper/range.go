@@ -0,0 +1,132 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+const runtimeStringNext = "runtime.stringnext" // x0 iterator -> x0 ok, x1 index, x2 rune
+
+// Iterators live in the frame, the collector follows the map and the entries
+// the iteration started with, or the data of the string
+var (
+	mapIterType = types.NewStruct([]*types.Var{
+		types.NewField(0, nil, "m", types.Typ[types.UnsafePointer], false),
+		types.NewField(0, nil, "entries", types.Typ[types.UnsafePointer], false),
+		types.NewField(0, nil, "cap", types.Typ[types.Uintptr], false),
+		types.NewField(0, nil, "index", types.Typ[types.Uintptr], false),
+	}, nil)
+	stringIterType = types.NewStruct([]*types.Var{
+		types.NewField(0, nil, "data", types.Typ[types.UnsafePointer], false),
+		types.NewField(0, nil, "len", types.Typ[types.Int], false),
+		types.NewField(0, nil, "pos", types.Typ[types.Int], false),
+	}, nil)
+)
+
+// iterType returns the layout of the iterator of a range over x
+func iterType(x ssa.Value) types.Type {
+	if _, ok := x.Type().Underlying().(*types.Map); ok {
+		return mapIterType
+	}
+	return stringIterType
+}
+
+// MapRange starts iterating over a map or a string, the other forms of range
+// loops are lowered by SSA to indexing and φ-nodes
+func (m *SSAMapper) MapRange(v *ssa.Range) error {
+	switch t := v.X.Type().Underlying().(type) {
+	case *types.Map:
+	case *types.Basic:
+		if t.Info()&types.IsString == 0 {
+			return unsupported(diag.Instruction, "unsupported range over %s", typeString(v.X.Type()))
+		}
+	default:
+		return unsupported(diag.Instruction, "unsupported range over %s", typeString(v.X.Type()))
+	}
+	loc, err := m.alloc.AllocateStack(alloc.MemoryLocation{
+		Name:      v.Name(),
+		Size:      m.sizeof(iterType(v.X)),
+		Alignment: alloc.WordSize,
+	})
+	if err != nil {
+		return fmt.Errorf("allocating iterator %s: %w", v.Name(), err)
+	}
+	m.currentIR.Locals[v.Name()] = loc
+	if _, ok := v.X.Type().Underlying().(*types.Map); ok {
+		return m.mapRange(v, loc.GetMemory())
+	}
+	return m.stringRange(v, loc.GetMemory())
+}
+
+// MapNext advances an iteration, its results are the tuple (ok, k, v) in a
+// stack slot. Results the loop does not use have the invalid type
+func (m *SSAMapper) MapNext(v *ssa.Next) error {
+	res, err := m.slot(v)
+	if err != nil {
+		return err
+	}
+	if v.IsString {
+		return m.stringNext(v, res)
+	}
+	return m.mapNext(v, res)
+}
+
+// stringRange copies the string header into the iterator, decoding starts
+// at the first byte
+func (m *SSAMapper) stringRange(v *ssa.Range, it *alloc.MemoryLocation) error {
+	src, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading string %s: %w", v.X.Name(), err)
+	}
+	defer m.release(v.X, src)
+	dst, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating address of %s: %w", v.Name(), err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(dst))
+	m.slotAddress(dst, it)
+	comment := fmt.Sprintf("%s = range %s", v.Name(), v.X.Name())
+	if err := m.copyMem(dst, src, m.sizeof(v.X.Type()), comment); err != nil {
+		return err
+	}
+	m.storeTo(reg.XZR, types.Typ[types.Int], dst, m.sizeof(v.X.Type()), "start at byte 0")
+	return nil
+}
+
+// stringNext decodes the next rune, invalid UTF-8 yields utf8.RuneError one
+// byte at a time like the Go runtime
+func (m *SSAMapper) stringNext(v *ssa.Next, res *alloc.MemoryLocation) error {
+	tuple := v.Type().(*types.Tuple)
+	offsets := m.tupleOffsets(tuple)
+	it, err := m.load(v.Iter)
+	if err != nil {
+		return fmt.Errorf("loading iterator %s: %w", v.Iter.Name(), err)
+	}
+	defer m.release(v.Iter, it)
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, it, alloc.WordSize, "iterator "+v.Iter.Name())
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeStringNext}, Comment: v.Name() + " = next " + v.Iter.Name()})
+	m.slotAddress(scratchAddr, res)
+	m.storeTo(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, types.Typ[types.Bool], scratchAddr, offsets[0], "ok")
+	for i := 1; i <= 2; i++ {
+		if t := tuple.At(i).Type(); valid(t) {
+			m.storeTo(&reg.Register{ID: uint8(i), Class: reg.RegisterClassGPR}, t, scratchAddr, offsets[i], tuple.At(i).Name())
+		}
+	}
+	m.restoreRegisters(saved)
+	return nil
+}
+
+// valid reports whether t is the type of a value, SSA gives unused results
+// of Next the invalid type
+func valid(t types.Type) bool {
+	b, ok := t.(*types.Basic)
+	return !ok || b.Kind() != types.Invalid
+}
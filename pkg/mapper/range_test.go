@@ -0,0 +1,83 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapLoops(t *testing.T) {
+	fns, m := compile(t, `package main
+
+func sum(s []int) int {
+	t := 0
+	for _, v := range s {
+		t += v
+	}
+	return t
+}
+
+func runes(s string) int {
+	n := 0
+	for i, r := range s {
+		n += i + int(r)
+	}
+	return n
+}
+
+func count(n int) int {
+	t := 0
+	for i := range n {
+		t += i
+	}
+	return t
+}
+
+func fib(n int) int {
+	a, b := 0, 1
+	for range n {
+		a, b = b, a+b
+	}
+	return a
+}
+
+func seq(n int) func(func(int) bool) {
+	return func(yield func(int) bool) {
+		for i := range n {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+func first(n int) int {
+	t := 0
+	for v := range seq(n) {
+		if v == 3 {
+			break
+		}
+		t += v
+	}
+	return t
+}
+
+func main() {
+	a := [3]int{1, 2, 3}
+	_ = sum(a[:])
+	_ = runes("héllo")
+	_, _, _ = count(5), fib(10), first(10)
+}
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	assert.Regexp(t, `B\.HI \.Lmain\.sum\.inbounds\d+\n`, fns["main.sum"], "indexing the slice is checked")
+	assert.Contains(t, fns["main.sum"], "BL runtime.panicindex")
+	assert.Contains(t, fns["main.runes"], "BL runtime.stringnext")
+	// The edge back into the loop assigns the φ-nodes before branching
+	assert.Regexp(t, `\.Lmain\.count\.edge\d+:\n(\t.*\n)+\tB \.Lmain\.count\.1\n`, fns["main.count"])
+	// a, b = b, a+b swaps through temporaries, stored before any is loaded
+	assert.Regexp(t, `\.Lmain\.fib\.edge\d+:\n(\t.*\n)*?(\tSTR .*\n(\t.*\n)*?){3}(\tLDR .*\n(\t.*\n)*?){3}\tB \.Lmain\.fib\.1\n`, fns["main.fib"])
+	assert.Contains(t, fns["main.first"], "BLR", "the loop body is a yield closure")
+	assert.Contains(t, fns["main.main"], "BL main.sum")
+	_, ok := m.data.Lookup("type.string")
+	assert.True(t, ok, "bounds checks panic with strings")
+}
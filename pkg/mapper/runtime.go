@@ -0,0 +1,33 @@
+package mapper
+
+import (
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+)
+
+// Runtime entry points called by generated code, see pkg/asm/alloc.asm
+const (
+	runtimeAlloc = "runtime.alloc" // x0 size, x1 type descriptor, returns zeroed memory in x0
+)
+
+// newObject allocates a zeroed object of type t on the heap and returns its
+// address in dst
+func (m *SSAMapper) newObject(dst *reg.Register, t types.Type) {
+	saved := m.saveRegisters()
+	m.movImm(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, uint64(m.sizeof(t)))
+	m.loadAddress(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, m.typeDescriptor(t))
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeAlloc},
+		Comment: "new " + typeString(t),
+	})
+	m.emit(ir.Instruction{
+		Op:  op.MOV,
+		Dst: dst,
+		Src: []reg.Operand{reg.NewRegOperand("x0")},
+	})
+	m.restoreRegisters(saved, dst)
+}
@@ -0,0 +1,227 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Stack map table layout, kept in sync with pkg/asm/stackmap.asm. Values
+// never live in callee saved registers across calls, the caller saved ones
+// are spilled to the save area so frame words describe every root
+const (
+	stackMapPC    = 0  // return address of the call
+	stackMapWords = 8  // frame words described by the bitmap
+	stackMapBits  = 16 // bit i is set when [x29, #-8*(i+1)] holds a pointer
+	stackMapSize  = 24
+)
+
+// Runtime symbols backing safepoints, see pkg/asm/sched.asm
+const (
+	runtimeGCWaiting = "runtime.gcwaiting"   // non zero when the collector or scheduler wants to run
+	runtimeSafepoint = "runtime.gcsafepoint" // lets the collector run at a back-edge
+)
+
+// stackMap describes the frame of a call site
+type stackMap struct {
+	pc    string // label following the call
+	words int
+	bits  string
+}
+
+// recordStackMap labels the return address of the call just emitted and
+// records the frame words holding pointers while it runs
+func (m *SSAMapper) recordStackMap() {
+	if !m.gc.collects() {
+		return
+	}
+	words := m.framePointers()
+	if len(words) == 0 {
+		return
+	}
+	pc := m.localLabel("sm")
+	m.emit(ir.Instruction{Labels: []string{pc}})
+	m.stackMaps = append(m.stackMaps, stackMap{pc: pc, words: len(words), bits: m.bitmap(words)})
+}
+
+// framePointers returns the frame words holding pointers at the current
+// instruction, indexed downwards from x29. Only values defined on every path
+// to the instruction are considered, other slots may hold stale data
+func (m *SSAMapper) framePointers() []bool {
+	var words []bool
+	mark := func(below int) {
+		i := below/alloc.WordSize - 1
+		for len(words) <= i {
+			words = append(words, false)
+		}
+		words[i] = true
+	}
+	object := func(t types.Type, mem *alloc.MemoryLocation) {
+		ptrs := make([]bool, mem.Size/alloc.WordSize)
+		m.pointerWords(t, 0, ptrs)
+		for j, ptr := range ptrs {
+			if ptr {
+				mark(mem.Offset + mem.Size - j*alloc.WordSize)
+			}
+		}
+	}
+	for _, v := range m.frameValues() {
+		if !m.defined(v) || m.dead(v) {
+			continue
+		}
+		loc, ok := m.currentIR.Locals[v.Name()]
+		if !ok {
+			continue
+		}
+		if r, ok := v.(*ssa.Range); ok {
+			object(iterType(r.X), loc.GetMemory())
+			continue
+		}
+		if loc.IsMemory() {
+			object(v.Type(), loc.GetMemory())
+			continue
+		}
+		offset, ok := m.saved[*loc.GetRegister()]
+		if !ok || m.isAggregate(v.Type()) || !hasPointers(v.Type()) {
+			continue
+		}
+		if a, ok := v.(*ssa.Alloc); ok && !a.Heap {
+			continue // points into the frame
+		}
+		mark(m.saveArea.Offset + m.saveArea.Size - offset)
+	}
+	for a, mem := range m.frameObjects {
+		if m.defined(a) {
+			object(a.Type().(*types.Pointer).Elem(), mem)
+		}
+	}
+	return words
+}
+
+// frameValues lists the parameters, free variables and instruction results
+// of the current function
+func (m *SSAMapper) frameValues() (values []ssa.Value) {
+	if m.wrapper != nil {
+		return m.wrapper.params
+	}
+	fn := m.currentFunc
+	for _, p := range fn.Params {
+		values = append(values, p)
+	}
+	for _, fv := range fn.FreeVars {
+		values = append(values, fv)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if v, ok := instr.(ssa.Value); ok {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// defined reports whether v has been computed whenever the current
+// instruction executes
+func (m *SSAMapper) defined(v ssa.Value) bool {
+	instr, ok := v.(ssa.Instruction)
+	if !ok {
+		return true // parameters and free variables
+	}
+	if b := instr.Block(); b != m.currentBlock {
+		return b.Dominates(m.currentBlock)
+	}
+	for _, i := range m.currentBlock.Instrs {
+		switch i {
+		case m.currentInstr:
+			return false
+		case instr:
+			return true
+		}
+	}
+	return false
+}
+
+// backEdge reports whether block closes a loop, one of its successors
+// dominates it
+func backEdge(block *ssa.BasicBlock) bool {
+	for _, succ := range block.Succs {
+		if succ.Dominates(block) {
+			return true
+		}
+	}
+	return false
+}
+
+// safepoint polls runtime.gcwaiting and lets the collector or other
+// goroutines run, loops without calls would otherwise never reach a stack map
+// or yield
+func (m *SSAMapper) safepoint() {
+	if !m.gc.collects() && !m.scheduler {
+		return
+	}
+	done := m.localLabel("poll")
+	m.loadAddress(scratchCall, runtimeGCWaiting)
+	m.emit(ir.Instruction{
+		Op:      op.LDR,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewOffsetOperand(scratchCall, 0)},
+		Comment: "safepoint poll",
+	}, ir.Instruction{
+		Op:     op.CBZ,
+		Dst:    scratchCall,
+		Labels: []string{done},
+	})
+	saved := m.saveRegisters()
+	m.emitCall(ir.Instruction{Op: op.BL, Labels: []string{runtimeSafepoint}})
+	m.restoreRegisters(saved)
+	m.emit(ir.Instruction{Labels: []string{done}})
+}
+
+// linkStackMaps emits go.stackmaps, the table runtime.scanstack searches by
+// return address
+func (m *SSAMapper) linkStackMaps() {
+	if !m.gc.collects() {
+		return
+	}
+	table := &ir.Global{
+		Label:   "go.stackmaps",
+		Section: ir.SectionRodata,
+		Size:    alloc.WordSize + len(m.stackMaps)*stackMapSize,
+		Align:   8,
+		Comment: "stack maps for runtime.scanstack",
+	}
+	table.Set(0, 8, fmt.Sprint(len(m.stackMaps)))
+	for i, sm := range m.stackMaps {
+		entry := alloc.WordSize + i*stackMapSize
+		table.Set(entry+stackMapPC, 8, sm.pc)
+		table.Set(entry+stackMapWords, 8, fmt.Sprint(sm.words))
+		table.Set(entry+stackMapBits, 8, sm.bits)
+	}
+	m.data.Add(table)
+}
+
+// linkGlobalRoots emits go.gcroots, the addresses of the global words
+// holding pointers that runtime.scanglobals reports as roots
+func (m *SSAMapper) linkGlobalRoots() {
+	if !m.gc.collects() {
+		return
+	}
+	table := &ir.Global{
+		Label:   "go.gcroots",
+		Section: ir.SectionRodata,
+		Size:    alloc.WordSize * (1 + len(m.globalRoots)),
+		Align:   8,
+		Comment: "global roots for runtime.scanglobals",
+	}
+	table.Set(0, 8, fmt.Sprint(len(m.globalRoots)))
+	for i, root := range m.globalRoots {
+		table.Set(alloc.WordSize*(i+1), 8, root)
+	}
+	m.data.Add(table)
+}
@@ -0,0 +1,56 @@
+package mapper
+
+import (
+	"math/bits"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapStackMaps(t *testing.T) {
+	fns, m := compile(t, `package main
+
+type node struct{ next *node }
+
+var done bool
+
+func spin() {
+	for {
+		if done {
+			return
+		}
+	}
+}
+
+func keep(p *node) *node {
+	q := new(node)
+	q.next = p
+	return p
+}
+
+func main() {}
+`)
+	keep := fns["main.keep"]
+	assert.Contains(t, keep, "BL runtime.alloc\n\n.Lmain.keep.sm", "return address labelled")
+
+	spin := fns["main.spin"]
+	assert.Contains(t, spin, "runtime.gcwaiting", "back-edge polls")
+	assert.Contains(t, spin, "BL runtime.gcsafepoint")
+
+	table, ok := m.data.Lookup("go.stackmaps")
+	require.True(t, ok)
+	// p is spilled to the save area around the allocation, the only pointer
+	// of the frame
+	match := regexp.MustCompile(`\.quad 1\n\t\.quad \.Lmain\.keep\.sm\d+\n\t\.quad \d+\n\t\.quad gcbits\.(\w+)\n`).FindStringSubmatch(table.String(false))
+	require.NotNil(t, match, "a single call site")
+	set := 0
+	for _, digit := range match[1] {
+		v, err := strconv.ParseUint(string(digit), 16, 8)
+		require.NoError(t, err)
+		set += bits.OnesCount64(v)
+	}
+	assert.Equal(t, 1, set, "one frame word holds a pointer")
+}
@@ -1,9 +1,9 @@
 package mapper
 
 import (
-	"fmt"
 	"go/token"
 
+	"github.com/algoboyz/garm/pkg/diag"
 	"github.com/algoboyz/garm/pkg/op"
 )
 
@@ -34,6 +34,6 @@ func (m *SSAMapper) MapToken(tok token.Token) (op.Op, error) {
 	case token.AND_NOT:
 		return op.BIC, nil
 	default:
-		return op.NOP, fmt.Errorf("unsupported token: %s", tok)
+		return op.NOP, unsupported(diag.Operator, "unsupported token: %s", tok)
 	}
 }
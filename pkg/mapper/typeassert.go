@@ -0,0 +1,189 @@
+package mapper
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Runtime routines backing type assertions, see pkg/asm/interface.asm
+const (
+	runtimeGetItab       = "runtime.getitab"       // x0 interface, x1 type -> x0 itab or 0
+	runtimePanicDotType  = "runtime.panicdottype"  // x0 dynamic, x1 asserted, x2 static type
+	runtimePanicDotTypeI = "runtime.panicdottypeI" // x0 dynamic type, x1 asserted interface
+)
+
+// MapTypeAssert lowers x.(T). Concrete targets compare type descriptor
+// pointers, interface targets look the itab up at run time. The comma-ok form
+// yields a (value, ok) tuple in a stack slot, the single result form panics
+func (m *SSAMapper) MapTypeAssert(v *ssa.TypeAssert) error {
+	iface, err := m.load(v.X)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", v.X.Name(), err)
+	}
+	defer m.release(v.X, iface)
+	have, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating dynamic type: %w", err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(have))
+	m.dynamicType(have, iface, v.X.Type())
+
+	target := v.AssertedType
+	comment := fmt.Sprintf("%s = %s.(%s)", v.Name(), v.X.Name(), typeString(target))
+
+	// Results in memory are written through base, scalars are defined in dst
+	var dst, base *reg.Register
+	if v.CommaOk || m.isAggregate(target) {
+		mem, err := m.slot(v)
+		if err != nil {
+			return err
+		}
+		if base, err = m.scratch(); err != nil {
+			return fmt.Errorf("allocating result address: %w", err)
+		}
+		defer m.alloc.Free(alloc.NewRegisterLocation(base))
+		m.slotAddress(base, mem)
+		if v.CommaOk {
+			for offset := 0; offset < mem.Size; offset += alloc.WordSize {
+				m.emit(ir.Instruction{
+					Op:      op.STR,
+					Dst:     reg.XZR,
+					Src:     []reg.Operand{reg.NewOffsetOperand(base, offset)},
+					Comment: "zero " + v.Name(),
+				})
+			}
+		}
+	} else if dst, err = m.define(v); err != nil {
+		return err
+	}
+
+	// Branch to ok when the assertion holds, the fall through handles failure
+	ok := m.localLabel("ok")
+	var tab *reg.Register
+	if types.IsInterface(target) {
+		if tab, err = m.lookupItab(have, target); err != nil {
+			return err
+		}
+		defer m.alloc.Free(alloc.NewRegisterLocation(tab))
+		m.emit(ir.Instruction{Op: op.CBNZ, Dst: tab, Labels: []string{ok}, Comment: comment})
+	} else {
+		m.loadAddress(scratchAddr, m.typeDescriptor(target))
+		m.emit(ir.Instruction{
+			Op:      op.CMP,
+			Dst:     have,
+			Src:     []reg.Operand{reg.NewRegOperand(scratchAddr.String())},
+			Comment: comment,
+		}, ir.Instruction{Op: op.BEQ, Labels: []string{ok}})
+	}
+	done := m.localLabel("done")
+	if v.CommaOk {
+		m.emit(ir.Instruction{Op: op.B, Labels: []string{done}, Comment: "not ok"})
+	} else {
+		m.panicDotType(have, target, v.X.Type())
+	}
+	m.emit(ir.Instruction{Labels: []string{ok}})
+
+	m.emit(ir.Instruction{
+		Op:      op.LDR,
+		Dst:     scratchCall,
+		Src:     []reg.Operand{reg.NewOffsetOperand(iface, ifaceData)},
+		Comment: "data word of " + v.X.Name(),
+	})
+	switch {
+	case tab != nil:
+		m.storeTo(tab, types.Typ[types.Uintptr], base, ifaceTab, comment)
+		m.storeTo(scratchCall, types.Typ[types.Uintptr], base, ifaceData, comment)
+	case dst != nil && isPointerShaped(target):
+		m.move(dst, scratchCall, alloc.WordSize, comment)
+	case dst != nil:
+		m.loadFrom(dst, target, scratchCall, 0, comment)
+	case isPointerShaped(target):
+		m.storeTo(scratchCall, target, base, 0, comment)
+	case m.isAggregate(target):
+		if err := m.copyMem(base, scratchCall, m.sizeof(target), comment); err != nil {
+			return err
+		}
+	default:
+		m.loadFrom(scratchCall, target, scratchCall, 0, comment)
+		m.storeTo(scratchCall, target, base, 0, comment)
+	}
+	if v.CommaOk {
+		m.movImm(scratchCall, 1)
+		m.storeTo(scratchCall, types.Typ[types.Bool], base, m.tupleOffsets(v.Type().(*types.Tuple))[1], "ok")
+	}
+	m.emit(ir.Instruction{Labels: []string{done}})
+	return nil
+}
+
+// dynamicType loads the type descriptor of the value held by an interface,
+// non empty interfaces reach it through the itab. Nil interfaces yield 0
+func (m *SSAMapper) dynamicType(dst, iface *reg.Register, static types.Type) {
+	m.emit(ir.Instruction{
+		Op:      op.LDR,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewOffsetOperand(iface, ifaceTab)},
+		Comment: "dynamic type",
+	})
+	if static.Underlying().(*types.Interface).Empty() {
+		return
+	}
+	isNil := m.localLabel("nil")
+	m.emit(ir.Instruction{
+		Op:     op.CBZ,
+		Dst:    dst,
+		Labels: []string{isNil},
+	}, ir.Instruction{
+		Op:      op.LDR,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewOffsetOperand(dst, itabType)},
+		Comment: "type of itab",
+	}, ir.Instruction{Labels: []string{isNil}})
+}
+
+// lookupItab returns a register holding the first word of an interface of
+// type iface for the dynamic type in have, 0 if the type does not implement it
+func (m *SSAMapper) lookupItab(have *reg.Register, iface types.Type) (*reg.Register, error) {
+	tab, err := m.scratch()
+	if err != nil {
+		return nil, fmt.Errorf("allocating itab: %w", err)
+	}
+	if iface.Underlying().(*types.Interface).Empty() {
+		m.move(tab, have, alloc.WordSize, "type of "+typeString(iface))
+		return tab, nil
+	}
+	m.asserted.Set(iface, true)
+	saved := m.saveRegisters()
+	m.move(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, have, alloc.WordSize, "dynamic type")
+	m.loadAddress(&reg.Register{ID: 0, Class: reg.RegisterClassGPR}, m.typeDescriptor(iface))
+	m.emitCall(ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimeGetItab},
+		Comment: "itab for " + typeString(iface),
+	})
+	m.move(tab, &reg.Register{ID: 0, Class: reg.RegisterClassGPR}, alloc.WordSize, "itab")
+	m.restoreRegisters(saved, tab)
+	return tab, nil
+}
+
+// panicDotType reports a failed single result assertion, it does not return
+func (m *SSAMapper) panicDotType(have *reg.Register, target, static types.Type) {
+	m.emit(ir.Instruction{
+		Op:      op.MOV,
+		Dst:     &reg.Register{ID: 0, Class: reg.RegisterClassGPR},
+		Src:     []reg.Operand{reg.NewRegOperand(have.String())},
+		Comment: "dynamic type",
+	})
+	m.loadAddress(&reg.Register{ID: 1, Class: reg.RegisterClassGPR}, m.typeDescriptor(target))
+	if types.IsInterface(target) {
+		m.emit(ir.Instruction{Op: op.BL, Labels: []string{runtimePanicDotTypeI}})
+		return
+	}
+	m.loadAddress(&reg.Register{ID: 2, Class: reg.RegisterClassGPR}, m.typeDescriptor(static))
+	m.emit(ir.Instruction{Op: op.BL, Labels: []string{runtimePanicDotType}})
+}
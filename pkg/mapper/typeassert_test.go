@@ -0,0 +1,38 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapTypeAssert(t *testing.T) {
+	fns, m := compile(t, `package main
+
+type shape interface{ area() int }
+
+type square int
+
+func (s square) area() int { return int(s) }
+
+func asInt(x any) int { return x.(int) }
+
+func isShape(x any) bool {
+	_, ok := x.(shape)
+	return ok
+}
+
+func main() {
+	asInt(3)
+	isShape(square(1))
+}
+`)
+	assert.Contains(t, fns["main.asInt"], "BL runtime.panicdottype", "failed assertions panic")
+	assert.NotContains(t, fns["main.isShape"], "panicdottype", "comma-ok assertions do not panic")
+	assert.Contains(t, fns["main.isShape"], "BL runtime.getitab", "interface targets look up the itab")
+
+	links, ok := m.Data().Lookup("go.itablinks")
+	require.True(t, ok, "itabs of asserted interfaces are linked")
+	assert.Contains(t, links.String(false), `"go.itab.main.square,main.shape"`)
+}
@@ -0,0 +1,217 @@
+package mapper
+
+import (
+	"encoding/hex"
+	"fmt"
+	"go/types"
+	"hash/fnv"
+	"reflect"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/ir"
+)
+
+// Type descriptor layout shared with the runtime
+const (
+	typeSize     = 0  // .quad size in bytes
+	typeHash     = 8  // .word hash of the type name
+	typeKind     = 12 // .byte reflect.Kind
+	typeAlign    = 13 // .byte alignment
+	typeName     = 16 // string name
+	typePtrData  = 32 // .quad bytes of the prefix holding pointers
+	typeGCData   = 40 // .quad pointer bitmap, 0 when there are no pointers
+	typeKeyAlg   = 48 // .quad key algorithm, 0 when not comparable
+	typeDescSize = 56
+)
+
+// typeString renders a type qualified like symbols eg *main.rect, see
+// symbolPrefix
+func typeString(t types.Type) string {
+	return types.TypeString(t, symbolPrefix)
+}
+
+// typeDescriptor returns the label of the rodata descriptor of t, emitting
+// it on first use. Descriptors are unique per type so their addresses can
+// be compared for type identity
+func (m *SSAMapper) typeDescriptor(t types.Type) string {
+	name := typeString(t)
+	label := "type." + name
+	if _, ok := m.data.Lookup(label); ok {
+		return label
+	}
+	desc := &ir.Global{
+		Label:   label,
+		Section: ir.SectionRodata,
+		Size:    typeDescSize,
+		Align:   8,
+		Comment: "type " + name,
+	}
+	desc.Set(typeSize, 8, fmt.Sprint(m.sizeof(t)))
+	desc.Set(typeHash, 4, fmt.Sprint(typeHashOf(name)))
+	desc.Set(typeKind, 1, fmt.Sprint(uint8(kindOf(t))))
+	desc.Set(typeAlign, 1, fmt.Sprint(m.sizes.Alignof(t)))
+	desc.Set(typeName, 8, m.data.String(name))
+	desc.Set(typeName+8, 8, fmt.Sprint(len(name)))
+	ptrdata, gcdata := m.gcBits(t)
+	desc.Set(typePtrData, 8, fmt.Sprint(ptrdata))
+	desc.Set(typeGCData, 8, gcdata)
+	desc.Set(typeKeyAlg, 8, m.keyAlg(t))
+	m.data.Add(desc)
+	return label
+}
+
+// gcBits returns the length of the prefix of t holding pointers and the label
+// of its pointer bitmap, one bit per word starting at the low bit of the first
+// byte. Bitmaps are shared between types with the same pointer layout
+func (m *SSAMapper) gcBits(t types.Type) (int, string) {
+	words := make([]bool, (m.sizeof(t)+alloc.WordSize-1)/alloc.WordSize)
+	m.pointerWords(t, 0, words)
+	n := len(words)
+	for n > 0 && !words[n-1] {
+		n--
+	}
+	if n == 0 {
+		return 0, "0"
+	}
+	return n * alloc.WordSize, m.bitmap(words[:n])
+}
+
+// bitmap interns a pointer bitmap into rodata and returns its label
+func (m *SSAMapper) bitmap(words []bool) string {
+	bits := make([]byte, (len(words)+7)/8)
+	for i, ptr := range words {
+		if ptr {
+			bits[i/8] |= 1 << (i % 8)
+		}
+	}
+	label := "gcbits." + hex.EncodeToString(bits)
+	g := &ir.Global{
+		Label:   label,
+		Section: ir.SectionRodata,
+		Size:    len(bits),
+		Align:   1,
+	}
+	for i, b := range bits {
+		g.Set(i, 1, fmt.Sprintf("0x%02x", b))
+	}
+	m.data.Add(g)
+	return label
+}
+
+// pointerWords marks the words of a t at offset that the collector has to
+// follow. Interface type words point at rodata and are left out
+func (m *SSAMapper) pointerWords(t types.Type, offset int, words []bool) {
+	if !hasPointers(t) {
+		return
+	}
+	word := offset / alloc.WordSize
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		// strings and unsafe.Pointer, the data pointer comes first
+		words[word] = true
+	case *types.Pointer, *types.Map, *types.Chan, *types.Signature, *types.Slice:
+		words[word] = true
+	case *types.Interface:
+		words[word+ifaceData/alloc.WordSize] = true
+	case *types.Array:
+		size := m.sizeof(u.Elem())
+		for i := range int(u.Len()) {
+			m.pointerWords(u.Elem(), offset+i*size, words)
+		}
+	case *types.Struct:
+		for i := range u.NumFields() {
+			m.pointerWords(u.Field(i).Type(), offset+m.fieldOffset(u, i), words)
+		}
+	case *types.Tuple:
+		m.pointerWords(tupleStruct(u), offset, words)
+	}
+}
+
+// hasPointers reports whether values of t can hold heap pointers
+func hasPointers(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return u.Kind() == types.String || u.Kind() == types.UnsafePointer
+	case *types.Array:
+		return u.Len() > 0 && hasPointers(u.Elem())
+	case *types.Struct:
+		for i := range u.NumFields() {
+			if hasPointers(u.Field(i).Type()) {
+				return true
+			}
+		}
+		return false
+	case *types.Tuple:
+		return hasPointers(tupleStruct(u))
+	}
+	return true
+}
+
+// typeHashOf is the FNV-1a hash of a type name
+func typeHashOf(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32()
+}
+
+// kindOf classifies t the way reflect does
+func kindOf(t types.Type) reflect.Kind {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch u.Kind() {
+		case types.Bool:
+			return reflect.Bool
+		case types.Int:
+			return reflect.Int
+		case types.Int8:
+			return reflect.Int8
+		case types.Int16:
+			return reflect.Int16
+		case types.Int32:
+			return reflect.Int32
+		case types.Int64:
+			return reflect.Int64
+		case types.Uint:
+			return reflect.Uint
+		case types.Uint8:
+			return reflect.Uint8
+		case types.Uint16:
+			return reflect.Uint16
+		case types.Uint32:
+			return reflect.Uint32
+		case types.Uint64:
+			return reflect.Uint64
+		case types.Uintptr:
+			return reflect.Uintptr
+		case types.Float32:
+			return reflect.Float32
+		case types.Float64:
+			return reflect.Float64
+		case types.Complex64:
+			return reflect.Complex64
+		case types.Complex128:
+			return reflect.Complex128
+		case types.String:
+			return reflect.String
+		case types.UnsafePointer:
+			return reflect.UnsafePointer
+		}
+	case *types.Array:
+		return reflect.Array
+	case *types.Chan:
+		return reflect.Chan
+	case *types.Signature:
+		return reflect.Func
+	case *types.Interface:
+		return reflect.Interface
+	case *types.Map:
+		return reflect.Map
+	case *types.Pointer:
+		return reflect.Pointer
+	case *types.Slice:
+		return reflect.Slice
+	case *types.Struct:
+		return reflect.Struct
+	}
+	return reflect.Invalid
+}
@@ -0,0 +1,38 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypeDescriptorGCBits(t *testing.T) {
+	_, m := compile(t, `package main
+
+type node struct {
+	next *node
+	val  int
+	name string
+	vals [2]int
+	e    any
+}
+
+func alloc() *node { return new(node) }
+
+func num() *int { return new(int) }
+
+func main() {}
+`)
+	desc, ok := m.data.Lookup("type.main.node")
+	require.True(t, ok, "descriptor of the allocated type")
+	// next, the string data and the interface data word
+	assert.Contains(t, desc.String(false), ".quad 64\n\t.quad gcbits.85\n", "ptrdata and bitmap")
+	bits, ok := m.data.Lookup("gcbits.85")
+	require.True(t, ok)
+	assert.Equal(t, "gcbits.85:\n\t.byte 0x85\n", bits.String(false))
+
+	desc, ok = m.data.Lookup("type.int")
+	require.True(t, ok)
+	assert.Contains(t, desc.String(false), ".quad 0\n\t.quad 0\n", "no pointers")
+}
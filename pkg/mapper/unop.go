@@ -0,0 +1,83 @@
+package mapper
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+func (m *SSAMapper) MapUnOp(expr *ssa.UnOp) error {
+	if expr.Op == token.ARROW {
+		return m.mapRecv(expr)
+	}
+	x, err := m.load(expr.X)
+	if err != nil {
+		return fmt.Errorf("loading operand: %w", err)
+	}
+	defer m.release(expr.X, x)
+	if expr.Op == token.MUL && m.isAggregate(expr.Type()) {
+		return m.loadAggregate(expr, x)
+	}
+	dst, err := m.define(expr)
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf("%s = %s%s", expr.Name(), expr.Op, expr.X.Name())
+
+	switch expr.Op {
+	case token.MUL:
+		// Load through a pointer eg t0 = *counter
+		m.loadFrom(dst, expr.Type(), x, 0, comment)
+	case token.SUB:
+		neg := op.NEG
+		if isFloat(expr.Type()) {
+			neg = op.FNEG
+		}
+		size := m.sizeof(expr.Type())
+		m.emit(ir.Instruction{
+			Op:      neg,
+			Dst:     dst.Sized(size),
+			Src:     []reg.Operand{reg.NewRegOperand(x.Sized(size).String())},
+			Comment: comment,
+		})
+	case token.NOT:
+		m.emit(ir.Instruction{
+			Op:      op.EOR,
+			Dst:     dst,
+			Src:     []reg.Operand{reg.NewRegOperand(x.String()), reg.NewImmediateOperand("1")},
+			Comment: comment,
+		})
+	case token.XOR:
+		m.emit(ir.Instruction{
+			Op:      op.MVN,
+			Dst:     dst,
+			Src:     []reg.Operand{reg.NewRegOperand(x.String())},
+			Comment: comment,
+		})
+	default:
+		return unsupported(diag.Operator, "unsupported unary operator %s on %s", expr.Op, types.TypeString(expr.X.Type(), nil))
+	}
+	return nil
+}
+
+// loadAggregate copies the aggregate x points to into a stack slot
+func (m *SSAMapper) loadAggregate(expr *ssa.UnOp, x *reg.Register) error {
+	mem, err := m.slot(expr)
+	if err != nil {
+		return err
+	}
+	dst, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating address of %s: %w", expr.Name(), err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(dst))
+	m.slotAddress(dst, mem)
+	return m.copyMem(dst, x, m.sizeof(expr.Type()), fmt.Sprintf("%s = *%s", expr.Name(), expr.X.Name()))
+}
@@ -0,0 +1,80 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Runtime routine the stubs of functions that were not compiled branch to
+const runtimePanicUnsupported = "runtime.panicunsupported"
+
+// unsupportedError is a construct garm does not compile yet. Functions using
+// one are reported as diagnostics and replaced by a stub that panics
+type unsupportedError struct {
+	category diag.Category
+	msg      string
+}
+
+func (e *unsupportedError) Error() string {
+	return e.msg
+}
+
+// unsupported returns the error of a construct garm does not compile yet
+func unsupported(category diag.Category, format string, args ...any) error {
+	return &unsupportedError{category: category, msg: fmt.Sprintf(format, args...)}
+}
+
+// Diagnostics returns the unsupported constructs met so far
+func (m *SSAMapper) Diagnostics() *diag.List {
+	return &m.diags
+}
+
+// diagnose records an unsupported construct of the current function at pos,
+// the position of the function when pos is unknown. Any other error is
+// returned as is unless the function is already known not to compile, its
+// code is dropped anyway
+func (m *SSAMapper) diagnose(pos token.Pos, err error) error {
+	if err == nil {
+		return nil
+	}
+	var u *unsupportedError
+	if !errors.As(err, &u) {
+		if m.unsupported {
+			return nil
+		}
+		return err
+	}
+	if pos == token.NoPos {
+		pos = m.currentFunc.Pos()
+	}
+	m.diags.Errorf(m.prog.Fset.Position(pos), u.category, "%s", u.msg)
+	if m.failed != nil && m.currentInstr != nil {
+		m.failed[m.currentInstr] = true
+	}
+	m.unsupported = true
+	return nil
+}
+
+// stub replaces the code of a function that did not compile by a call to
+// runtime.panicunsupported, the frame is linked so the panic unwinds through
+// the function like any other
+func (m *SSAMapper) stub(fn *ssa.Function) *ir.Function {
+	if m.stubs == nil {
+		m.stubs = make(map[string]bool)
+	}
+	label := m.funcLabel(fn)
+	m.stubs[label] = true
+	stub := ir.NewFunction(label, m.debug)
+	stub.Blocks = append(ir.FuncPrologue(ir.Symbol(label)), ir.Instruction{
+		Op:      op.BL,
+		Labels:  []string{runtimePanicUnsupported},
+		Comment: fn.Name() + " uses unsupported constructs",
+	})
+	return stub
+}
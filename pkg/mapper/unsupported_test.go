@@ -0,0 +1,50 @@
+package mapper
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapUnsupported(t *testing.T) {
+	fns, m := compile(t, `package main
+
+func mk(n int) []int { return make([]int, n) }
+
+func ok(x int) int { return x + 1 }
+
+func main() { println(ok(1)) }
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	assert.Regexp(t, `(?s)^main\.mk:.*BL runtime\.panicunsupported`, strings.TrimSpace(fns["main.mk"]),
+		"functions using unsupported constructs are stubbed by a function that panics")
+	assert.NotContains(t, fns["main.ok"], "panicunsupported")
+
+	diags := m.Diagnostics().All()
+	require.Len(t, diags, 1)
+	assert.Equal(t, "main.go", filepath.Base(diags[0].Pos.Filename))
+	assert.Equal(t, 3, diags[0].Pos.Line)
+	assert.Contains(t, diags[0].String(), "error: unsupported instruction *ssa.MakeSlice")
+	assert.Equal(t, []string{"main.mk"}, m.Report().Unsupported)
+}
+
+func TestMapGeneric(t *testing.T) {
+	fns, m := compile(t, `package main
+
+func larger[T int | float64](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func main() { println(larger(1, 2), larger(1.5, 0.5)) }
+`, func(m *SSAMapper) { m.SetGC(GCNone) })
+	assert.Contains(t, fns, "main.larger[int]", "instantiations are compiled")
+	assert.Contains(t, fns["main.larger[float64]"], "FCMP")
+	assert.NotContains(t, fns, "main.larger", "the generic function is skipped")
+	assert.Contains(t, fns["main.main"], `BL "main.larger[int]"`)
+	assert.Empty(t, m.Diagnostics().All())
+}
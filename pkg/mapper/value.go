@@ -0,0 +1,335 @@
+package mapper
+
+import (
+	"fmt"
+	"go/constant"
+	"go/types"
+
+	"github.com/algoboyz/garm/pkg/alloc"
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"github.com/algoboyz/garm/pkg/op"
+	"github.com/algoboyz/garm/pkg/reg"
+	"golang.org/x/tools/go/ssa"
+)
+
+// load materialises an SSA value into a register. Constants, addresses of
+// globals and function values are rematerialised on every use, everything else must have been
+// assigned a location by the instruction defining it. Values living in a stack
+// slot load as the address of the slot
+func (m *SSAMapper) load(v ssa.Value) (*reg.Register, error) {
+	switch v := v.(type) {
+	case *ssa.Const:
+		if m.isAggregate(v.Type()) {
+			return m.loadAggregateConst(v)
+		}
+		return m.loadConst(v)
+	case *ssa.Global:
+		dst, err := m.scratch()
+		if err != nil {
+			return nil, fmt.Errorf("allocating address of %s: %w", v.Name(), err)
+		}
+		m.loadAddress(dst, m.globalLabel(v))
+		return dst, nil
+	case *ssa.Function:
+		dst, err := m.scratch()
+		if err != nil {
+			return nil, fmt.Errorf("allocating func value %s: %w", v.Name(), err)
+		}
+		m.loadAddress(dst, m.funcValue(v))
+		return dst, nil
+	}
+	loc, err := m.currentIR.Has(v.Name())
+	if err != nil {
+		return nil, err
+	}
+	if loc.IsMemory() {
+		dst, err := m.scratch()
+		if err != nil {
+			return nil, fmt.Errorf("allocating address of %s: %w", v.Name(), err)
+		}
+		m.slotAddress(dst, loc.GetMemory())
+		return dst, nil
+	}
+	return loc.GetRegister(), nil
+}
+
+// release frees the scratch register load handed out for v, registers holding
+// the value itself stay allocated
+func (m *SSAMapper) release(v ssa.Value, r *reg.Register) {
+	if loc, err := m.currentIR.Has(v.Name()); err == nil && loc.IsRegister() && loc.GetRegister() == r {
+		return
+	}
+	m.alloc.Free(alloc.NewRegisterLocation(r))
+}
+
+// scratch allocates a general purpose register for a temporary
+func (m *SSAMapper) scratch() (*reg.Register, error) {
+	loc, err := m.alloc.AllocateRegister(alloc.TypeSet.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	return loc.GetRegister(), nil
+}
+
+// define assigns a register to the result of an instruction
+func (m *SSAMapper) define(v ssa.Value) (*reg.Register, error) {
+	typ, err := m.MapType(v.Name(), v.Type())
+	if err != nil {
+		return nil, err
+	}
+	loc, err := m.alloc.AllocateRegister(typ)
+	if err != nil {
+		return nil, fmt.Errorf("allocating %s: %w", v.Name(), err)
+	}
+	m.currentIR.Locals[v.Name()] = loc
+	return loc.GetRegister(), nil
+}
+
+func (m *SSAMapper) loadConst(c *ssa.Const) (*reg.Register, error) {
+	typ, err := m.MapType(c.Name(), c.Type())
+	if err != nil {
+		return nil, fmt.Errorf("mapping constant %s: %w", c, err)
+	}
+	loc, err := m.alloc.AllocateRegister(typ)
+	if err != nil {
+		return nil, fmt.Errorf("allocating constant %s: %w", c, err)
+	}
+	dst := loc.GetRegister()
+
+	switch {
+	case c.Value == nil && dst.Class == reg.RegisterClassFPR:
+		m.emit(ir.Instruction{
+			Op:      op.FMOV,
+			Dst:     dst.Sized(typ.Size()),
+			Src:     []reg.Operand{reg.NewRegOperand(reg.XZR.Sized(typ.Size()).String())},
+			Comment: "zero " + c.Type().String(),
+		})
+	case c.Value == nil:
+		m.emit(ir.Instruction{
+			Op:      op.MOV,
+			Dst:     dst,
+			Src:     []reg.Operand{reg.NewRegOperand(reg.XZR.String())},
+			Comment: "zero " + c.Type().String(),
+		})
+	case dst.Class == reg.RegisterClassFPR:
+		label := m.data.Float64(c.Float64())
+		if typ.Size() == 4 {
+			label = m.data.Float32(float32(c.Float64()))
+		}
+		m.loadFloat(dst.Sized(typ.Size()), label)
+	case c.Value.Kind() == constant.Bool:
+		var bit uint64
+		if constant.BoolVal(c.Value) {
+			bit = 1
+		}
+		m.movImm(dst, bit)
+	case c.Value.Kind() == constant.Int && isSigned(c.Type()):
+		m.movImm(dst, uint64(c.Int64()))
+	case c.Value.Kind() == constant.Int:
+		m.movImm(dst, c.Uint64())
+	default:
+		return nil, unsupported(diag.Constant, "unsupported constant %s", c)
+	}
+	return dst, nil
+}
+
+// loadAggregateConst materialises an aggregate constant in a fresh stack slot
+// and returns its address, only strings have a non zero representation
+func (m *SSAMapper) loadAggregateConst(c *ssa.Const) (*reg.Register, error) {
+	loc, err := m.alloc.AllocateStack(alloc.MemoryLocation{
+		Name:      c.String(),
+		Size:      alloc.AlignSize(m.sizeof(c.Type()), alloc.WordSize),
+		Alignment: alloc.WordSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("allocating constant %s: %w", c, err)
+	}
+	mem := loc.GetMemory()
+	dst, err := m.scratch()
+	if err != nil {
+		return nil, fmt.Errorf("allocating constant %s: %w", c, err)
+	}
+	m.slotAddress(dst, mem)
+
+	var words []string
+	switch {
+	case c.Value == nil:
+	case c.Value.Kind() == constant.String:
+		words = append(words, m.data.String(constant.StringVal(c.Value)))
+	default:
+		return nil, unsupported(diag.Constant, "unsupported constant %s", c)
+	}
+	for offset := 0; offset < mem.Size; offset += alloc.WordSize {
+		src := reg.XZR
+		switch {
+		case offset == 0 && len(words) > 0:
+			m.loadAddress(scratchAddr, words[0])
+			src = scratchAddr
+		case offset == alloc.WordSize && len(words) > 0:
+			m.movImm(scratchAddr, uint64(len(constant.StringVal(c.Value))))
+			src = scratchAddr
+		}
+		m.emit(ir.Instruction{
+			Op:      op.STR,
+			Dst:     src,
+			Src:     []reg.Operand{reg.NewOffsetOperand(dst, offset)},
+			Comment: c.String(),
+		})
+	}
+	return dst, nil
+}
+
+// movImm loads a 64 bit immediate with a MOVZ followed by a MOVK for every
+// further non zero halfword
+func (m *SSAMapper) movImm(dst *reg.Register, v uint64) {
+	m.emit(ir.Instruction{
+		Op:      op.MOVZ,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewImmediateOperand(fmt.Sprintf("0x%x", v&0xffff))},
+		Comment: fmt.Sprintf("load #%d", int64(v)),
+	})
+	for shift := 16; shift < 64; shift += 16 {
+		half := (v >> shift) & 0xffff
+		if half == 0 {
+			continue
+		}
+		m.emit(ir.Instruction{
+			Op:  op.MOVK,
+			Dst: dst,
+			Src: []reg.Operand{{
+				Type:  reg.OperandShift,
+				Var:   fmt.Sprintf("#0x%x", half),
+				Shift: &reg.Shift{Type: op.LSL, Value: fmt.Sprintf("#%d", shift)},
+			}},
+		})
+	}
+}
+
+// loadAddress materialises the address of a symbol with a page address and
+// the low 12 bits of its offset
+func (m *SSAMapper) loadAddress(dst *reg.Register, label string) {
+	sym := ir.Symbol(label)
+	m.emit(ir.Instruction{
+		Op:      op.ADRP,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewLabelOperand(sym)},
+		Comment: "load page address of " + label,
+	}, ir.Instruction{
+		Op:      op.ADD,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewRegOperand(dst.String()), reg.NewLabelOperand(":lo12:" + sym)},
+		Comment: "add low 12 bits of " + label,
+	})
+}
+
+// loadFloat loads a floating point literal from rodata
+func (m *SSAMapper) loadFloat(dst *reg.Register, label string) {
+	m.loadAddress(scratchAddr, label)
+	m.emit(ir.Instruction{
+		Op:      op.LDR,
+		Dst:     dst,
+		Src:     []reg.Operand{reg.NewOffsetOperand(scratchAddr, 0)},
+		Comment: "load " + label,
+	})
+}
+
+// loadFrom loads a scalar of type typ from base+offset, narrow signed
+// integers are sign extended to the full register
+func (m *SSAMapper) loadFrom(dst *reg.Register, typ types.Type, base *reg.Register, offset int, comment string) {
+	size := m.sizeof(typ)
+	instr := ir.Instruction{
+		Op:      op.LDR,
+		Dst:     dst.Sized(size),
+		Src:     []reg.Operand{reg.NewOffsetOperand(base, offset)},
+		Comment: comment,
+	}
+	if dst.Class == reg.RegisterClassGPR {
+		signed := isSigned(typ)
+		switch {
+		case size == 1 && signed:
+			instr.Op, instr.Dst = op.LDRSB, dst
+		case size == 1:
+			instr.Op = op.LDRB
+		case size == 2 && signed:
+			instr.Op, instr.Dst = op.LDRSH, dst
+		case size == 2:
+			instr.Op = op.LDRH
+		case size == 4 && signed:
+			instr.Op, instr.Dst = op.LDRSW, dst
+		}
+	}
+	m.emit(instr)
+}
+
+// storeTo stores a scalar of type typ to base+offset
+func (m *SSAMapper) storeTo(src *reg.Register, typ types.Type, base *reg.Register, offset int, comment string) {
+	size := m.sizeof(typ)
+	instr := ir.Instruction{
+		Op:      op.STR,
+		Dst:     src.Sized(size),
+		Src:     []reg.Operand{reg.NewOffsetOperand(base, offset)},
+		Comment: comment,
+	}
+	if src.Class == reg.RegisterClassGPR {
+		switch size {
+		case 1:
+			instr.Op = op.STRB
+		case 2:
+			instr.Op = op.STRH
+		}
+	}
+	m.emit(instr)
+}
+
+// copyMem copies size bytes from src to dst in the widest chunks that fit
+func (m *SSAMapper) copyMem(dst, src *reg.Register, size int, comment string) error {
+	tmp, err := m.scratch()
+	if err != nil {
+		return fmt.Errorf("allocating copy register: %w", err)
+	}
+	defer m.alloc.Free(alloc.NewRegisterLocation(tmp))
+	for offset := 0; offset < size; {
+		n := alloc.WordSize
+		for n > size-offset {
+			n /= 2
+		}
+		m.loadFrom(tmp, wordType(n), src, offset, comment)
+		m.storeTo(tmp, wordType(n), dst, offset, comment)
+		offset += n
+	}
+	return nil
+}
+
+// zeroMem clears size bytes at base, size is a multiple of the word size.
+// Larger objects are cleared in a loop
+func (m *SSAMapper) zeroMem(base *reg.Register, size int, comment string) {
+	words := size / alloc.WordSize
+	if words <= zeroUnroll {
+		for i := range words {
+			m.storeTo(reg.XZR, types.Typ[types.Uintptr], base, i*alloc.WordSize, comment)
+		}
+		return
+	}
+	loop := m.localLabel("zero")
+	m.move(scratchCall, base, alloc.WordSize, comment)
+	m.movImm(scratchAddr, uint64(words))
+	m.emit(ir.Instruction{
+		Labels:  []string{loop},
+		Op:      op.STR,
+		Dst:     reg.XZR,
+		Src:     []reg.Operand{reg.NewPostIndexOperand(scratchCall, alloc.WordSize)},
+		Comment: comment,
+	}, ir.Instruction{
+		Op:  op.SUB,
+		Dst: scratchAddr,
+		Src: []reg.Operand{reg.NewRegOperand(scratchAddr.String()), reg.NewImmediateOperand("1")},
+	}, ir.Instruction{
+		Op:     op.CBNZ,
+		Dst:    scratchAddr,
+		Labels: []string{loop},
+	})
+}
+
+// zeroUnroll is the largest number of words zeroMem clears without a loop
+const zeroUnroll = 8
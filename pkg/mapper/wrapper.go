@@ -0,0 +1,94 @@
+package mapper
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/algoboyz/garm/pkg/diag"
+	"github.com/algoboyz/garm/pkg/ir"
+	"golang.org/x/tools/go/ssa"
+)
+
+// builtinWrapper is a function calling a builtin with its parameters, the
+// record of a deferred or started builtin points at it like Go's
+// deferwrap and gowrap functions
+type builtinWrapper struct {
+	label  string
+	fn     *ssa.Builtin
+	params []ssa.Value
+}
+
+// wrapperParam stands for a parameter of a builtin wrapper, it has no SSA
+// function to belong to
+type wrapperParam struct {
+	name string
+	typ  types.Type
+}
+
+func (p *wrapperParam) Name() string                  { return p.name }
+func (p *wrapperParam) String() string                { return p.name }
+func (p *wrapperParam) Type() types.Type              { return p.typ }
+func (p *wrapperParam) Parent() *ssa.Function         { return nil }
+func (p *wrapperParam) Referrers() *[]ssa.Instruction { return nil }
+func (p *wrapperParam) Pos() token.Pos                { return token.NoPos }
+
+// wrapBuiltin returns the label of a wrapper calling fn with arguments like
+// args, it is compiled once the package is mapped. kind names the wrapper
+// after the statement, deferwrap or gowrap
+func (m *SSAMapper) wrapBuiltin(fn *ssa.Builtin, args []ssa.Value, kind string) (string, error) {
+	switch fn.Name() {
+	case "print", "println":
+		for _, arg := range args {
+			if _, ok := printRoutine(arg.Type()); !ok {
+				return "", unsupported(diag.Builtin, "unsupported argument of %s: %s", fn.Name(), typeString(arg.Type()))
+			}
+		}
+	case "close", "delete", "recover":
+	default:
+		return "", unsupported(diag.Builtin, "unsupported call to builtin %s", fn.Name())
+	}
+	prefix := m.currentIR.Label + "." + kind
+	n := 1
+	for _, w := range m.wrappers {
+		if strings.HasPrefix(w.label, prefix) {
+			n++
+		}
+	}
+	w := builtinWrapper{label: fmt.Sprintf("%s%d", prefix, n), fn: fn}
+	for i, arg := range args {
+		w.params = append(w.params, &wrapperParam{name: fmt.Sprintf("a%d", i), typ: arg.Type()})
+	}
+	m.wrappers = append(m.wrappers, w)
+	return w.label, nil
+}
+
+// mapWrapper compiles a builtin wrapper, its parameters are the only values
+// of the frame
+func (m *SSAMapper) mapWrapper(w builtinWrapper) (*ir.Function, error) {
+	m.reset(nil, w.label)
+	m.wrapper = &w
+	m.prologue()
+	var regs abi
+	for _, p := range w.params {
+		if err := m.moveIn(p, &regs, "parameter "+p.Name()); err != nil {
+			return nil, fmt.Errorf("mapping parameter %s: %w", p.Name(), err)
+		}
+	}
+	// recover is not called by the deferred function but deferred itself,
+	// it never stops a panic
+	if w.fn.Name() != "recover" {
+		call := &ssa.Call{Call: ssa.CallCommon{Value: w.fn, Args: w.params}}
+		if err := m.mapBuiltin(call, w.fn); err != nil {
+			return nil, err
+		}
+	}
+	m.currentIR.Blocks = append(m.currentIR.Blocks, m.epilogue()...)
+	m.finishFrame()
+	var err error
+	if m.currentIR.Blocks, err = ir.ExpandMacros(m.currentIR.Blocks); err != nil {
+		return nil, fmt.Errorf("expanding macros: %w", err)
+	}
+	return m.currentIR, nil
+}
@@ -1,5 +1,7 @@
 package op
 
+import "strings"
+
 type Op string
 
 // ARM64 instruction set
@@ -27,8 +29,16 @@ const (
 	LDRH Op = "LDRH"
 	// Store halfword eg [0x1234] = R0
 	STRH Op = "STRH"
+	// Load signed byte eg R0 = sign extend [0xFF]
+	LDRSB Op = "LDRSB"
+	// Load signed halfword eg R0 = sign extend [0x1234]
+	LDRSH Op = "LDRSH"
+	// Load signed word eg X0 = sign extend [0x1234]
+	LDRSW Op = "LDRSW"
 	// Conditional select eg R0 = R1 if condition else R0
 	CSEL Op = "CSEL"
+	// Conditional set eg R0 = 1 if condition else 0
+	CSET Op = "CSET"
 	// Prefetch memory eg [0x1234]
 	PRFM Op = "PRFM"
 	// Address of Page
@@ -40,6 +50,14 @@ const (
 	LDXR Op = "LDXR"
 	// Store Exclusive Register (atomic write)
 	STXR Op = "STXR"
+	// Load-Acquire Exclusive Register
+	LDAXR Op = "LDAXR"
+	// Store-Release Exclusive Register, the status register is 0 on success
+	STLXR Op = "STLXR"
+	// Load-Acquire Register
+	LDAR Op = "LDAR"
+	// Store-Release Register
+	STLR Op = "STLR"
 
 	// Register instructions
 
@@ -65,6 +83,14 @@ const (
 	// Swap
 	SWP Op = "SWP" // eg R0 = [R1]; [R1] = R2
 
+	// Sequentially consistent variants of the ARMv8.1 LSE instructions
+
+	LDADDAL Op = "LDADDAL" // eg R1 = [R2]; [R2] += R0
+	LDCLRAL Op = "LDCLRAL" // eg R1 = [R2]; [R2] &^= R0
+	LDSETAL Op = "LDSETAL" // eg R1 = [R2]; [R2] |= R0
+	SWPAL   Op = "SWPAL"   // eg R1 = [R2]; [R2] = R0
+	CASAL   Op = "CASAL"   // eg if [R2] == R0 { [R2] = R1 }; R0 = old [R2]
+
 	// Conditional instructions
 
 	// Compare registers
@@ -160,6 +186,35 @@ const (
 	SDIV Op = "SDIV" // Signed divide and check for divide by zero
 	UDIV Op = "UDIV" // Unsigned divide and check for divide by zero
 
+	// Floating-point instructions
+	FMOV   Op = "FMOV"   // Floating-point move eg D0 = D1 or D0 = X1 bits
+	FADD   Op = "FADD"   // Floating-point add eg D0 = D1 + D2
+	FSUB   Op = "FSUB"   // Floating-point subtract eg D0 = D1 - D2
+	FMUL   Op = "FMUL"   // Floating-point multiply eg D0 = D1 * D2
+	FDIV   Op = "FDIV"   // Floating-point divide eg D0 = D1 / D2
+	FNEG   Op = "FNEG"   // Floating-point negate eg D0 = -D1
+	FABS   Op = "FABS"   // Floating-point absolute value eg D0 = |D1|
+	FSQRT  Op = "FSQRT"  // Floating-point square root eg D0 = sqrt(D1)
+	FMADD  Op = "FMADD"  // Floating-point fused multiply-add eg D0 = D1 * D2 + D3
+	FMIN   Op = "FMIN"   // Floating-point minimum, NaN when either is NaN
+	FMAX   Op = "FMAX"   // Floating-point maximum, NaN when either is NaN
+	FRINTM Op = "FRINTM" // Floating-point round toward minus infinity eg floor(D1)
+	FRINTP Op = "FRINTP" // Floating-point round toward plus infinity eg ceil(D1)
+	FRINTZ Op = "FRINTZ" // Floating-point round toward zero eg trunc(D1)
+	FRINTA Op = "FRINTA" // Floating-point round to nearest, ties away from zero
+	FCMP   Op = "FCMP"   // Floating-point compare eg D0 == D1
+	SCVTF  Op = "SCVTF"  // Signed integer to floating-point eg D0 = float(X1)
+	FCVTZS Op = "FCVTZS" // Floating-point to signed integer rounding toward zero eg X0 = int(D1)
+	UCVTF  Op = "UCVTF"  // Unsigned integer to floating-point eg D0 = float(X1)
+	FCVTZU Op = "FCVTZU" // Floating-point to unsigned integer rounding toward zero eg X0 = uint(D1)
+
+	// Sign and zero extension
+	SXTB Op = "SXTB" // Sign extend byte eg X0 = int64(int8(W1))
+	SXTH Op = "SXTH" // Sign extend halfword eg X0 = int64(int16(W1))
+	SXTW Op = "SXTW" // Sign extend word eg X0 = int64(int32(W1))
+	UXTB Op = "UXTB" // Zero extend byte eg W0 = uint8(W1)
+	UXTH Op = "UXTH" // Zero extend halfword eg W0 = uint16(W1)
+
 	// Saturation Arithmetic Instructions
 	QADD    Op = "QADD"    // Saturating add eg R0 = R1 + R2
 	QSUB    Op = "QSUB"    // Saturating subtract eg R0 = R1 - R2
@@ -180,6 +235,8 @@ const (
 	BICS Op = "BICS" // Bitwise AND NOT with flags eg R0 = R1 &^ R2 | flags
 	MVN  Op = "MVN"  // Move NOT (bitwise NOT) eg R0 = ^R1
 	CLZ  Op = "CLZ"  // Count leading zeros
+	RBIT Op = "RBIT" // Reverse bits
+	CNT  Op = "CNT"  // Count set bits of each vector byte
 
 	// Shift instructions
 	ASR Op = "ASR" // Arithmetic shift right
@@ -571,6 +628,7 @@ func (op Op) IsBranch() bool {
 	case B, BL, BEQ, BNE, BGT, BLT, BGE, BLE, CBZ, CBNZ, TBZ, TBNZ, BAL, BR, BLR, RET:
 		return true
 	default:
-		return false
+		// Remaining conditions eg B.HS
+		return strings.HasPrefix(string(op), "B.")
 	}
 }
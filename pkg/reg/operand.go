@@ -116,6 +116,30 @@ func NewMemOperand(reg *Register, offset int, postIdx ...bool) Operand {
 	}
 }
 
+// NewOffsetOperand addresses memory at base + offset without write-back eg [x0, #8]
+func NewOffsetOperand(reg *Register, offset int) Operand {
+	mem := &MemoryOperand{BaseRegister: reg}
+	if offset != 0 {
+		mem.Offset = fmt.Sprint(offset)
+	}
+	return Operand{
+		Type:   OperandMemory,
+		Memory: mem,
+	}
+}
+
+// NewPostIndexOperand addresses memory at base and then advances base by offset eg [sp], #16
+func NewPostIndexOperand(reg *Register, offset int) Operand {
+	return Operand{
+		Type: OperandMemory,
+		Memory: &MemoryOperand{
+			BaseRegister: reg,
+			Offset:       fmt.Sprint(offset),
+			Post:         true,
+		},
+	}
+}
+
 type MemoryOperand struct {
 	BaseRegister *Register
 	Offset       string
@@ -1,6 +1,10 @@
 package reg
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // RegisterClass represents different types of ARM64 registers
 type RegisterClass uint8
@@ -18,6 +22,8 @@ var (
 	FP = &Register{ID: 29, Class: FramePointer}
 	LR = &Register{ID: 30, Class: LinkRegister}
 	SP = &Register{Name: "sp", Class: StackPointer}
+	// Zero register reads as 0 and discards writes
+	XZR = &Register{ID: 31, Name: "xzr", Class: RegisterClassGPR}
 )
 
 // Register represents an actual ARM64 register
@@ -48,3 +54,59 @@ func (r *Register) String() string {
 		return fmt.Sprintf("unknown%d", r.ID)
 	}
 }
+
+// Sized returns the view of the register matching an operand size in bytes
+// eg w0 for 32 bit integers or s0 for single precision floats
+func (r *Register) Sized(size int) *Register {
+	switch {
+	case r.Name == XZR.Name && size <= 4:
+		return &Register{ID: r.ID, Name: "wzr", Class: r.Class}
+	case r.Class == RegisterClassGPR && size <= 4:
+		return &Register{ID: r.ID, Name: fmt.Sprintf("w%d", r.ID), Class: r.Class}
+	case r.Class == RegisterClassFPR && size <= 4:
+		return &Register{ID: r.ID, Name: fmt.Sprintf("s%d", r.ID), Class: r.Class}
+	}
+	return r
+}
+
+// Parse returns the register named by an assembly operand eg x0, w1, d2, sp
+func Parse(name string) (*Register, bool) {
+	name = strings.ToLower(name)
+	switch name {
+	case "sp", "wsp":
+		return SP, true
+	case "xzr":
+		return XZR, true
+	case "wzr":
+		return XZR.Sized(4), true
+	case "fp":
+		return FP, true
+	case "lr":
+		return LR, true
+	}
+	if len(name) < 2 {
+		return nil, false
+	}
+	id, err := strconv.ParseUint(name[1:], 10, 8)
+	if err != nil || id > 31 || name[1:] != strconv.FormatUint(id, 10) {
+		return nil, false
+	}
+	r := &Register{ID: uint8(id)}
+	switch name[0] {
+	case 'x', 'w':
+		if id > 30 {
+			return nil, false
+		}
+		r.Class = RegisterClassGPR
+	case 'd', 's':
+		r.Class = RegisterClassFPR
+	case 'v':
+		r.Class = RegisterClassVec
+	default:
+		return nil, false
+	}
+	if name[0] == 'w' || name[0] == 's' {
+		return r.Sized(4), true
+	}
+	return r, true
+}